@@ -34,7 +34,7 @@ func billUser(user users.User) {
 	}
 
 	for _, container := range allContainers {
-		containerStatus, err := containers.GetStatusForContainer(container)
+		containerStatus, err := containers.GetStatusForContainer(&container)
 		if err != nil {
 			criticalLogger.Errorf("Could not get status for container %d: %s", container.Id, err)
 			continue
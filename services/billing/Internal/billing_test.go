@@ -0,0 +1,183 @@
+package billingint
+
+import (
+	"bitbucket.org/smaug-hosting/services/cache"
+	"bitbucket.org/smaug-hosting/services/container-service/containers"
+	"bitbucket.org/smaug-hosting/services/container-service/orchestrator"
+	"bitbucket.org/smaug-hosting/services/container-service/orchestrator/spec"
+	"bitbucket.org/smaug-hosting/services/container-service/software"
+	"bitbucket.org/smaug-hosting/services/container-service/tiers"
+	"bitbucket.org/smaug-hosting/services/database"
+	"bitbucket.org/smaug-hosting/services/idp/users"
+	"fmt"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+	"github.com/jmoiron/sqlx"
+	"os"
+	"testing"
+	"time"
+)
+
+// Billing runs against the in-memory orchestrator, so that whether a whelp is charged for depends on what is
+// actually running rather than on what the database says.
+
+const testUserId = 7
+
+func TestMain(m *testing.M) {
+	software.Setup("../../container-service/software/conf/catalog.json")
+	tiers.Setup("../../container-service/tiers/conf/tiers.json")
+	os.Exit(m.Run())
+}
+
+func setupBillingTest(t *testing.T) (sqlmock.Sqlmock, func()) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Could not mock database: %s", err)
+	}
+	database.Connection = sqlx.NewDb(db, "mysql")
+
+	redisServer, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Could not start fake redis: %s", err)
+	}
+	cache.Client = redis.NewClient(&redis.Options{Addr: redisServer.Addr()})
+
+	containers.Runtime, err = orchestrator.GetOrchestratorInstance(orchestrator.BackendMemory, nil)
+	if err != nil {
+		t.Fatalf("Could not set up orchestrator: %s", err)
+	}
+
+	return mock, func() {
+		_ = cache.Client.Close()
+		redisServer.Close()
+		_ = db.Close()
+	}
+}
+
+func serviceName(id int64) string {
+	return fmt.Sprintf("whelp-minecraft-%d-1-%d", testUserId, id)
+}
+
+// createService starts a service for the container, with the given number of replicas.
+func createService(t *testing.T, id int64, replicas uint64) {
+	err := containers.Runtime.Create(spec.ServiceSpec{
+		Name:     serviceName(id),
+		Image:    "itzg/minecraft-server:20190824",
+		Replicas: &replicas,
+	})
+	if err != nil {
+		t.Fatalf("Could not create service: %s", err)
+	}
+}
+
+func containerRows(states map[int64]containers.State) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{
+		"name", "tier", "software", "user_id", "id", "state", "last_error", "state_changed_at", "created_at",
+		"console_password", "config", "service_name", "auto_stop_idle", "wake_on_connect", "subdomain", "image",
+		"version", "flavour",
+	})
+	for _, id := range []int64{42, 43} {
+		state, ok := states[id]
+		if !ok {
+			continue
+		}
+		rows.AddRow("survival", 1, "minecraft", testUserId, id, string(state), "", time.Time{}, time.Time{},
+			"hunter2", []byte("{}"), "", false, false, nil, "itzg/minecraft-server:20190824", "", "")
+	}
+	return rows
+}
+
+func expectPrice(mock sqlmock.Sqlmock, amount int64) {
+	mock.ExpectQuery("FROM prices WHERE software = ").
+		WithArgs("minecraft", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"amount", "software", "tier"}).AddRow(amount, "minecraft", 1))
+}
+
+func expectSave(mock sqlmock.Sqlmock, balance int64) {
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE verified_users SET").
+		WithArgs(balance, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), testUserId).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
+
+func testUser(balance int64) users.User {
+	return users.User{Id: testUserId, Email: "player@example.com", Verified: true, Balance: balance}
+}
+
+func TestBillUserChargesRunningContainers(t *testing.T) {
+	mock, teardown := setupBillingTest(t)
+	defer teardown()
+
+	createService(t, 42, 1)
+	createService(t, 43, 0)
+
+	owned := map[int64]containers.State{42: containers.StateRunning, 43: containers.StateStopped}
+	mock.ExpectQuery("FROM containers WHERE user_id").
+		WithArgs(testUserId).
+		WillReturnRows(containerRows(owned))
+	// only the running whelp is priced
+	expectPrice(mock, 100)
+	expectSave(mock, 900)
+
+	billUser(testUser(1000))
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBillUserSkipsContainersWithoutService(t *testing.T) {
+	mock, teardown := setupBillingTest(t)
+	defer teardown()
+
+	// the database thinks it is running, but there is nothing to bill for
+	mock.ExpectQuery("FROM containers WHERE user_id").
+		WithArgs(testUserId).
+		WillReturnRows(containerRows(map[int64]containers.State{42: containers.StateRunning}))
+	expectSave(mock, 1000)
+
+	billUser(testUser(1000))
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBillUserStopsContainersWhenOutOfBalance(t *testing.T) {
+	mock, teardown := setupBillingTest(t)
+	defer teardown()
+
+	createService(t, 42, 1)
+	createService(t, 43, 0)
+
+	// the containers are stopped in the background while the user is being saved
+	mock.MatchExpectationsInOrder(false)
+	owned := map[int64]containers.State{42: containers.StateRunning, 43: containers.StateStopped}
+	mock.ExpectQuery("FROM containers WHERE user_id").
+		WithArgs(testUserId).
+		WillReturnRows(containerRows(owned))
+	expectPrice(mock, 100)
+	expectSave(mock, -50)
+	mock.ExpectQuery("FROM containers WHERE user_id").
+		WithArgs(testUserId).
+		WillReturnRows(containerRows(owned))
+	mock.ExpectExec("UPDATE containers SET").
+		WithArgs("", string(containers.StateStopping), sqlmock.AnyArg(), 42, string(containers.StateRunning)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	billUser(testUser(50))
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		status, err := containers.Runtime.Status(serviceName(42))
+		if err == nil && !status.Up && mock.ExpectationsWereMet() == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for the container to be stopped: %v", mock.ExpectationsWereMet())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
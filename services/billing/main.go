@@ -4,6 +4,11 @@ import (
 	"bitbucket.org/smaug-hosting/services/billing/Internal"
 	"bitbucket.org/smaug-hosting/services/billing/billing"
 	"bitbucket.org/smaug-hosting/services/billing/transactions"
+	"bitbucket.org/smaug-hosting/services/cache"
+	"bitbucket.org/smaug-hosting/services/container-service/containers"
+	"bitbucket.org/smaug-hosting/services/container-service/orchestrator"
+	"bitbucket.org/smaug-hosting/services/container-service/software"
+	"bitbucket.org/smaug-hosting/services/container-service/tiers"
 	"bitbucket.org/smaug-hosting/services/database"
 	"bitbucket.org/smaug-hosting/services/idp/tokens"
 	"bitbucket.org/smaug-hosting/services/idp/users"
@@ -24,6 +29,18 @@ import (
 func main() {
 	logging.Setup()
 	database.Setup()
+	cache.Setup()
+	// billing stops containers for out-of-balance users, which needs to know how the container was built.  The
+	// defaults only work when run from the source tree, deployments point SOFTWARE_CATALOG_FILE and TIERS_FILE at
+	// wherever container-service's files have been copied to
+	software.Setup("../container-service/software/conf/catalog.json")
+	tiers.Setup("../container-service/tiers/conf/tiers.json")
+
+	runtime, err := orchestrator.GetOrchestratorInstanceFromEnv()
+	if err != nil {
+		logrus.Fatalf("Could not set up orchestrator: %s", err)
+	}
+	containers.Runtime = runtime
 
 	ws := libws.SetupWebsocket("/ws")
 
@@ -158,7 +175,7 @@ func main() {
 			// the time of the next clock second
 			nextRun := time.Now().Truncate(time.Second).Add(time.Second)
 			time.Sleep(time.Until(nextRun))
-			for _, client := range ws.AllClients {
+			for _, client := range ws.Clients() {
 
 				claims := tokens.TokenClaims{}
 				logrus.Tracef("Sending balance to client: %+v", client)
@@ -0,0 +1,179 @@
+package backups
+
+import (
+	"bitbucket.org/smaug-hosting/services/container-service/containers"
+	"bitbucket.org/smaug-hosting/services/idp/collaborators"
+	"bitbucket.org/smaug-hosting/services/idp/tokens"
+	"bitbucket.org/smaug-hosting/services/libhttp"
+	"database/sql"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+)
+
+// backupForRequest fetches the backup named in the request path, sending an error response (and returning false)
+// if it doesn't exist or doesn't belong to the caller.  Backups of the container the request is about (if any) are
+// also open to its collaborators, whose access to it has already been checked.
+func backupForRequest(container *containers.Container, response http.ResponseWriter, request *http.Request) (*Backup, bool) {
+	claims := request.Context().Value("token_claims").(tokens.TokenClaims)
+	backupId, err := strconv.ParseInt(request.Context().Value("backupId").(string), 10, 64)
+	if err != nil {
+		libhttp.SendError(http.StatusBadRequest, "Invalid backup id", response)
+		return nil, false
+	}
+
+	backup, err := BackupRepository{}.FindById(backupId)
+	if err == sql.ErrNoRows {
+		libhttp.SendError(http.StatusNotFound, "Backup not found", response)
+		return nil, false
+	} else if err != nil {
+		logrus.Errorf("Could not fetch backup from db: %s", err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not fetch backup", response)
+		return nil, false
+	}
+
+	if container != nil && backup.ContainerId == container.Id {
+		return backup, true
+	}
+
+	if backup.UserId != claims.UserId {
+		logrus.Warnf("User %d tried to access backup %d belonging to someone else", claims.UserId, backupId)
+		libhttp.SendError(http.StatusUnauthorized, "You can only manage your own backups", response)
+		return nil, false
+	}
+
+	return backup, true
+}
+
+func HandlePostBackup(response http.ResponseWriter, request *http.Request) {
+	container, ok := containers.ContainerForRequest(response, request, collaborators.PermAdmin)
+	if !ok {
+		return
+	}
+
+	backup, err := CreateBackup(*container)
+	if err == ErrContainerBusy {
+		libhttp.SendError(http.StatusConflict, fmt.Sprintf("Could not back up a container while it is %s", container.State), response)
+		return
+	} else if err != nil {
+		logrus.Errorf("Could not create backup of container %d: %s", container.Id, err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not create backup", response)
+		return
+	}
+
+	// the backup is taken in the background, clients poll the backup list to see it complete
+	libhttp.SendJsonWithStatus(http.StatusAccepted, backup, response)
+}
+
+func HandleGetContainerBackups(response http.ResponseWriter, request *http.Request) {
+	container, ok := containers.ContainerForRequest(response, request, collaborators.PermView)
+	if !ok {
+		return
+	}
+
+	backups, err := BackupRepository{}.GetBackupsForContainer(container.Id)
+	if err != nil {
+		logrus.Errorf("Could not fetch backups for container %d: %s", container.Id, err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not fetch backups", response)
+		return
+	}
+
+	libhttp.SendJson(backups, response)
+}
+
+func HandleGetBackups(response http.ResponseWriter, request *http.Request) {
+	claims := request.Context().Value("token_claims").(tokens.TokenClaims)
+
+	backups, err := BackupRepository{}.GetBackupsForUser(claims.UserId)
+	if err != nil {
+		logrus.Errorf("Could not fetch backups for user %d: %s", claims.UserId, err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not fetch backups", response)
+		return
+	}
+
+	libhttp.SendJson(backups, response)
+}
+
+// HandlePostRestore restores a backup into the container in the path, which need not be the one it was taken from.
+func HandlePostRestore(response http.ResponseWriter, request *http.Request) {
+	container, ok := containers.ContainerForRequest(response, request, collaborators.PermAdmin)
+	if !ok {
+		return
+	}
+
+	backup, ok := backupForRequest(container, response, request)
+	if !ok {
+		return
+	}
+
+	err := RestoreBackup(*backup, *container)
+	if containers.IsContainerLocked(err) {
+		libhttp.SendError(http.StatusConflict, fmt.Sprintf("Could not restore into the container: %s", err), response)
+		return
+	}
+
+	switch err {
+	case nil:
+		// empty 200 response if all went well
+		libhttp.SendJson(struct{}{}, response)
+	case ErrBackupIncomplete:
+		libhttp.SendError(http.StatusConflict, fmt.Sprintf("Could not restore a backup that is %s", backup.Status), response)
+	case ErrSoftwareMismatch:
+		libhttp.SendError(http.StatusBadRequest, fmt.Sprintf("A %s backup cannot be restored into a %s container", backup.Software, container.Software), response)
+	case ErrContainerNotStopped:
+		libhttp.SendError(http.StatusConflict, "Backups can only be restored into a stopped container", response)
+	case ErrBackupNotStored:
+		logrus.Errorf("Backup %d is missing from storage", backup.Id)
+		libhttp.SendError(http.StatusGone, "This backup is no longer available", response)
+	default:
+		logrus.Errorf("Could not restore backup %d into container %d: %s", backup.Id, container.Id, err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not restore backup", response)
+	}
+}
+
+func HandleDeleteContainerBackup(response http.ResponseWriter, request *http.Request) {
+	container, ok := containers.ContainerForRequest(response, request, collaborators.PermAdmin)
+	if !ok {
+		return
+	}
+
+	backup, ok := backupForRequest(container, response, request)
+	if !ok {
+		return
+	}
+
+	if backup.ContainerId != container.Id {
+		libhttp.SendError(http.StatusNotFound, "Backup not found", response)
+		return
+	}
+
+	deleteBackup(*backup, response)
+}
+
+// HandleDeleteBackup deletes a backup by id alone, so that backups of since-deleted containers can be cleaned up.
+func HandleDeleteBackup(response http.ResponseWriter, request *http.Request) {
+	backup, ok := backupForRequest(nil, response, request)
+	if !ok {
+		return
+	}
+
+	deleteBackup(*backup, response)
+}
+
+func deleteBackup(backup Backup, response http.ResponseWriter) {
+	if backup.Status == StatusPending {
+		libhttp.SendError(http.StatusConflict, "Could not delete a backup that is still being taken", response)
+		return
+	}
+
+	err := DeleteBackup(backup)
+	if err != nil {
+		logrus.Errorf("Could not delete backup %d: %s", backup.Id, err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not delete backup", response)
+		return
+	}
+
+	// empty 200 response if all went well
+	libhttp.SendJson(struct{}{}, response)
+}
@@ -0,0 +1,23 @@
+package backups
+
+import "time"
+
+type Status string
+
+const (
+	StatusPending  Status = "pending"
+	StatusComplete Status = "complete"
+	StatusFailed   Status = "failed"
+)
+
+type Backup struct {
+	Id          int64     `json:"id"`
+	ContainerId int64     `json:"container_id" db:"container_id"`
+	UserId      int64     `json:"-" db:"user_id"`
+	Software    string    `json:"software"`
+	Status      Status    `json:"status"`
+	Error       string    `json:"error"`
+	Size        int64     `json:"size"`
+	StorageKey  string    `json:"-" db:"storage_key"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
@@ -0,0 +1,182 @@
+package backups
+
+import (
+	"bitbucket.org/smaug-hosting/services/database"
+	"github.com/Masterminds/squirrel"
+	"time"
+)
+
+type BackupRepository struct{}
+
+const tableName = "backups"
+
+var backupColumns = []string{"id", "container_id", "user_id", "software", "status", "error", "size", "storage_key", "created_at"}
+
+func (br BackupRepository) FindById(id int64) (*Backup, error) {
+	sql, params, err := squirrel.Select(backupColumns...).From(tableName).Where("id = ?", id).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	b := new(Backup)
+
+	err = database.Connection.QueryRowx(sql, params...).StructScan(b)
+
+	return b, err
+}
+
+// Save records a new pending backup.
+func (br BackupRepository) Save(backup Backup) (Backup, error) {
+	var result Backup // only used if we fail
+
+	backup.Status = StatusPending
+	backup.CreatedAt = time.Now()
+
+	sql, params, err := squirrel.
+		Insert(tableName).
+		SetMap(map[string]interface{}{
+			"container_id": backup.ContainerId,
+			"user_id":      backup.UserId,
+			"software":     backup.Software,
+			"status":       backup.Status,
+			"error":        backup.Error,
+			"size":         backup.Size,
+			"storage_key":  backup.StorageKey,
+			"created_at":   backup.CreatedAt,
+		}).
+		ToSql()
+
+	if err != nil {
+		return result, err
+	}
+
+	res, err := database.Connection.Exec(sql, params...)
+	if err != nil {
+		return result, err
+	}
+
+	backup.Id, err = res.LastInsertId()
+
+	return backup, err
+}
+
+func (br BackupRepository) update(backup *Backup, values map[string]interface{}) error {
+	sql, params, err := squirrel.Update(tableName).SetMap(values).Where("id = ?", backup.Id).ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = database.Connection.Exec(sql, params...)
+
+	return err
+}
+
+func (br BackupRepository) Complete(backup *Backup, storageKey string, size int64) error {
+	err := br.update(backup, map[string]interface{}{
+		"status":      StatusComplete,
+		"storage_key": storageKey,
+		"size":        size,
+	})
+	if err != nil {
+		return err
+	}
+
+	backup.Status = StatusComplete
+	backup.StorageKey = storageKey
+	backup.Size = size
+
+	return nil
+}
+
+func (br BackupRepository) Fail(backup *Backup, message string) error {
+	err := br.update(backup, map[string]interface{}{
+		"status": StatusFailed,
+		"error":  message,
+	})
+	if err != nil {
+		return err
+	}
+
+	backup.Status = StatusFailed
+	backup.Error = message
+
+	return nil
+}
+
+// FailPendingBefore fails every backup still pending that was started before the cutoff, returning how many there
+// were.
+func (br BackupRepository) FailPendingBefore(cutoff time.Time, message string) (int64, error) {
+	sql, params, err := squirrel.
+		Update(tableName).
+		SetMap(map[string]interface{}{
+			"status": StatusFailed,
+			"error":  message,
+		}).
+		Where(squirrel.Eq{"status": StatusPending}).
+		Where(squirrel.Lt{"created_at": cutoff}).
+		ToSql()
+
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := database.Connection.Exec(sql, params...)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+func (br BackupRepository) Delete(backup Backup) error {
+	sql, params, err := squirrel.Delete(tableName).Where("id = ?", backup.Id).ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = database.Connection.Exec(sql, params...)
+
+	return err
+}
+
+// SetUserForContainer hands all of the container's backups over to the user, for when the container changes hands.
+// Their storage keys stay as they are.
+func (br BackupRepository) SetUserForContainer(containerId int64, userId int64) error {
+	sql, params, err := squirrel.Update(tableName).Set("user_id", userId).Where("container_id = ?", containerId).ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = database.Connection.Exec(sql, params...)
+
+	return err
+}
+
+// GetBackupsForContainer lists the container's backups, newest first.
+func (br BackupRepository) GetBackupsForContainer(containerId int64) ([]Backup, error) {
+	return br.selectWhere(squirrel.Eq{"container_id": containerId})
+}
+
+// GetBackupsForUser lists all of the user's backups, newest first, including those of containers since deleted.
+func (br BackupRepository) GetBackupsForUser(userId int64) ([]Backup, error) {
+	return br.selectWhere(squirrel.Eq{"user_id": userId})
+}
+
+func (br BackupRepository) selectWhere(pred interface{}) ([]Backup, error) {
+	sql, params, err := squirrel.
+		Select(backupColumns...).
+		From(tableName).
+		Where(pred).
+		OrderBy("created_at DESC", "id DESC").
+		ToSql()
+
+	if err != nil {
+		return nil, err
+	}
+
+	backups := make([]Backup, 0)
+
+	err = database.Connection.Select(&backups, sql, params...)
+
+	return backups, err
+}
@@ -0,0 +1,41 @@
+package backups
+
+import (
+	"bitbucket.org/smaug-hosting/services/container-service/containers"
+	"database/sql"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"net/http"
+)
+
+// Restorer lets new containers be created from a backup, see containers.BackupRestorer.
+type Restorer struct{}
+
+func (r Restorer) CheckRestore(userId int64, backupId int64, software string) (int, string) {
+	backup, err := BackupRepository{}.FindById(backupId)
+	if err == sql.ErrNoRows || (err == nil && backup.UserId != userId) {
+		return http.StatusNotFound, "Backup not found"
+	} else if err != nil {
+		logrus.Errorf("Could not fetch backup from db: %s", err)
+		return http.StatusInternalServerError, "Could not fetch backup"
+	}
+
+	if backup.Status != StatusComplete {
+		return http.StatusConflict, fmt.Sprintf("Could not restore a backup that is %s", backup.Status)
+	}
+
+	if backup.Software != software {
+		return http.StatusBadRequest, fmt.Sprintf("A %s backup cannot be restored into a %s container", backup.Software, software)
+	}
+
+	return 0, ""
+}
+
+func (r Restorer) RestoreInto(backupId int64, c containers.Container) error {
+	backup, err := BackupRepository{}.FindById(backupId)
+	if err != nil {
+		return err
+	}
+
+	return RestoreVolume(*backup, containers.DataVolumeForContainer(c))
+}
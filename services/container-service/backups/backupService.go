@@ -0,0 +1,269 @@
+package backups
+
+import (
+	"bitbucket.org/smaug-hosting/services/container-service/containers"
+	"bitbucket.org/smaug-hosting/services/metrics"
+	"bitbucket.org/smaug-hosting/services/micro"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"strconv"
+	"time"
+)
+
+// how long a backup may stay pending before we give up on it, the replica taking it must have gone away part way
+// through (backups being taken by the other replicas can only be told apart from those by their age)
+const pendingBackupTimeout = 6 * time.Hour
+
+var ErrContainerBusy = errors.New("container is being provisioned or deleted")
+var ErrContainerNotStopped = errors.New("container must be stopped to restore a backup")
+var ErrBackupIncomplete = errors.New("backup is not complete")
+var ErrSoftwareMismatch = errors.New("backup was taken from different software")
+
+// CreateBackup records a pending backup of the container's data volume and takes it in the background.  The
+// volume is archived as-is, so backups of a running container capture whatever the server has flushed to disk.
+func CreateBackup(c containers.Container) (Backup, error) {
+	backup, err := saveBackup(c)
+	if err != nil {
+		return backup, err
+	}
+
+	go takeBackup(backup, containers.DataVolumeForContainer(c))
+
+	return backup, nil
+}
+
+// BackupNow takes a backup of the container's data volume and only returns once it is complete, for when something
+// is about to happen that the backup has to be there to undo.
+func BackupNow(c containers.Container) (Backup, error) {
+	backup, err := saveBackup(c)
+	if err != nil {
+		return backup, err
+	}
+
+	return takeBackup(backup, containers.DataVolumeForContainer(c))
+}
+
+// saveBackup records a pending backup of the container, as long as it has a volume that can be backed up.
+func saveBackup(c containers.Container) (Backup, error) {
+	if c.State == containers.StateProvisioning || c.State == containers.StateDeleting {
+		return Backup{}, ErrContainerBusy
+	}
+
+	return BackupRepository{}.Save(Backup{
+		ContainerId: c.Id,
+		UserId:      c.UserId,
+		Software:    c.Software,
+	})
+}
+
+func storageKeyForBackup(b Backup) string {
+	return fmt.Sprintf("%d/%d/%d.tar.gz", b.UserId, b.ContainerId, b.Id)
+}
+
+func takeBackup(backup Backup, volume string) (Backup, error) {
+	key := storageKeyForBackup(backup)
+
+	size, err := archiveVolume(volume, key)
+	if err != nil {
+		logrus.Errorf("Could not back up volume %s for container %d: %s", volume, backup.ContainerId, err)
+		metrics.Increment("backups.failed")
+
+		// don't leave a partial archive lying around
+		if err := Store.Delete(key); err != nil {
+			logrus.Warnf("Could not clean up failed backup %d: %s", backup.Id, err)
+		}
+
+		failErr := BackupRepository{}.Fail(&backup, err.Error())
+		if failErr != nil {
+			logrus.Errorf("Could not record failure of backup %d: %s", backup.Id, failErr)
+		}
+		return backup, err
+	}
+
+	err = BackupRepository{}.Complete(&backup, key, size)
+	if err != nil {
+		logrus.Errorf("Could not record completion of backup %d: %s", backup.Id, err)
+		return backup, err
+	}
+	metrics.Increment("backups.taken")
+
+	applyRetention(backup.ContainerId)
+
+	return backup, nil
+}
+
+// archiveVolume streams a gzipped tar of the volume into storage under the key.
+func archiveVolume(volume string, key string) (int64, error) {
+	archive, err := containers.Runtime.ArchiveVolume(volume)
+	if err != nil {
+		return 0, err
+	}
+	defer archive.Close()
+
+	reader, writer := io.Pipe()
+	go func() {
+		gz := gzip.NewWriter(writer)
+		_, err := io.Copy(gz, archive)
+		if err == nil {
+			err = gz.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+
+	size, err := Store.Put(key, reader)
+	// unblocks the compressing goroutine if storage gave up part way through
+	reader.CloseWithError(err)
+
+	return size, err
+}
+
+// RestoreBackup replaces the target container's data with the contents of the backup.  The target may be the
+// container the backup was taken from or any other stopped container running the same software.  The container is
+// locked until the restore is done, so that it can't be started (or changed any other way) part way through.
+func RestoreBackup(backup Backup, target containers.Container) error {
+	if backup.Status != StatusComplete {
+		return ErrBackupIncomplete
+	}
+
+	if backup.Software != target.Software {
+		return ErrSoftwareMismatch
+	}
+
+	unlock, err := containers.LockContainer(target.Id, "restoring a backup")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// it may have been started since it was fetched, before we had the lock
+	current, err := containers.ContainerRepository{}.FindById(target.Id)
+	if err != nil {
+		return err
+	}
+
+	if current.State != containers.StateStopped {
+		return ErrContainerNotStopped
+	}
+
+	return RestoreVolume(backup, containers.DataVolumeForContainer(*current))
+}
+
+// RestoreVolume replaces the contents of the volume with the backup, without any of RestoreBackup's checks that
+// the volume is in a fit state to take it.
+func RestoreVolume(backup Backup, volume string) error {
+	stored, err := Store.Get(backup.StorageKey)
+	if err != nil {
+		return err
+	}
+	defer stored.Close()
+
+	archive, err := gzip.NewReader(stored)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	err = containers.Runtime.RestoreVolume(volume, archive)
+	if err != nil {
+		return err
+	}
+
+	metrics.Increment("backups.restored")
+
+	return nil
+}
+
+// DeleteBackup removes the backup from storage and then forgets about it.
+func DeleteBackup(backup Backup) error {
+	if backup.StorageKey != "" {
+		err := Store.Delete(backup.StorageKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	return BackupRepository{}.Delete(backup)
+}
+
+func getRetention() (int, time.Duration) {
+	count, err := strconv.Atoi(µ.GetEnvDefault("BACKUP_RETENTION_COUNT", "5"))
+	if err != nil || count < 1 {
+		logrus.Warnf("Invalid BACKUP_RETENTION_COUNT, keeping 5 backups per container")
+		count = 5
+	}
+
+	days, err := strconv.Atoi(µ.GetEnvDefault("BACKUP_RETENTION_DAYS", "30"))
+	if err != nil || days < 1 {
+		logrus.Warnf("Invalid BACKUP_RETENTION_DAYS, keeping backups for 30 days")
+		days = 30
+	}
+
+	return count, time.Duration(days) * 24 * time.Hour
+}
+
+// applyRetention prunes the container's backups down to the newest BACKUP_RETENTION_COUNT, dropping any older than
+// BACKUP_RETENTION_DAYS.  The newest complete backup is always kept, however old it is.
+func applyRetention(containerId int64) {
+	count, maxAge := getRetention()
+
+	backups, err := BackupRepository{}.GetBackupsForContainer(containerId)
+	if err != nil {
+		logrus.Errorf("Could not list backups for container %d to apply retention: %s", containerId, err)
+		return
+	}
+
+	kept := 0
+	for _, b := range backups {
+		if b.Status == StatusPending {
+			continue
+		}
+
+		if b.Status == StatusComplete && kept == 0 {
+			kept++
+			continue
+		}
+
+		if b.Status == StatusComplete && kept < count && time.Since(b.CreatedAt) < maxAge {
+			kept++
+			continue
+		}
+
+		if b.Status == StatusFailed && time.Since(b.CreatedAt) < maxAge {
+			// failures are kept around for a while so the user can see what went wrong
+			continue
+		}
+
+		err = DeleteBackup(b)
+		if err != nil {
+			logrus.Errorf("Could not prune backup %d: %s", b.Id, err)
+			continue
+		}
+		metrics.Increment("backups.pruned")
+	}
+}
+
+// StartPendingBackupSweeper fails the backups that have been pending for too long, straight away and then every
+// hour, so that the ones left behind by a restart can be deleted and pruned like any other failed backup.
+func StartPendingBackupSweeper() {
+	go func() {
+		for {
+			failStaleBackups()
+			time.Sleep(time.Hour)
+		}
+	}()
+}
+
+func failStaleBackups() {
+	failed, err := BackupRepository{}.FailPendingBefore(time.Now().Add(-pendingBackupTimeout), "interrupted before it was complete")
+	if err != nil {
+		logrus.Errorf("Could not fail stale pending backups: %s", err)
+		return
+	}
+
+	if failed > 0 {
+		logrus.Warnf("Failed %d backups that had been pending for over %s", failed, pendingBackupTimeout)
+	}
+}
@@ -0,0 +1,129 @@
+package backups
+
+import (
+	"bitbucket.org/smaug-hosting/services/micro"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Storage is somewhere backup archives can be kept.  Keys are slash-separated paths generated by this package.
+type Storage interface {
+	// Put stores everything read from data under the key, returning the number of bytes stored
+	Put(key string, data io.Reader) (int64, error)
+	Get(key string) (io.ReadCloser, error)
+	// Delete removes the key, succeeding if it was already gone
+	Delete(key string) error
+}
+
+type StorageType string
+
+const (
+	StorageLocal StorageType = "local"
+	StorageS3    StorageType = "s3"
+)
+
+var ErrStorageBackendUnavailable = errors.New("storage backend unavailable")
+var ErrBackupNotStored = errors.New("backup is not in storage")
+var ErrInvalidKey = errors.New("invalid storage key")
+
+// Store is where backups are kept.  It must be set (usually from main) before any backups are taken or restored.
+var Store Storage
+
+func GetStorageInstance(storageType StorageType, args map[string]string) (Storage, error) {
+	switch storageType {
+	case StorageLocal:
+		return newLocalStorage(args["dir"])
+	default:
+		// S3-compatible storage will slot in here, until then we'd rather fail at startup than lose backups
+		return nil, ErrStorageBackendUnavailable
+	}
+}
+
+func GetStorageInstanceFromEnv() (Storage, error) {
+	return GetStorageInstance(StorageType(µ.GetEnvDefault("BACKUP_STORAGE", string(StorageLocal))), map[string]string{
+		"dir": µ.GetEnvDefault("BACKUP_DIR", "/var/lib/smaug/backups"),
+	})
+}
+
+type localStorage struct {
+	dir string
+}
+
+func newLocalStorage(dir string) (*localStorage, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	return &localStorage{dir: dir}, nil
+}
+
+func (l *localStorage) path(key string) (string, error) {
+	p := filepath.Join(l.dir, filepath.FromSlash(key))
+	if !strings.HasPrefix(p, filepath.Clean(l.dir)+string(filepath.Separator)) {
+		return "", ErrInvalidKey
+	}
+	return p, nil
+}
+
+func (l *localStorage) Put(key string, data io.Reader) (int64, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return 0, err
+	}
+
+	err = os.MkdirAll(filepath.Dir(p), 0700)
+	if err != nil {
+		return 0, err
+	}
+
+	// write to a temporary file first so that a half-written archive never appears under the key
+	tmp, err := ioutil.TempFile(filepath.Dir(p), ".partial-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, data)
+	if err != nil {
+		tmp.Close()
+		return n, err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return n, err
+	}
+
+	return n, os.Rename(tmp.Name(), p)
+}
+
+func (l *localStorage) Get(key string) (io.ReadCloser, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, ErrBackupNotStored
+	}
+	return f, err
+}
+
+func (l *localStorage) Delete(key string) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(p)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
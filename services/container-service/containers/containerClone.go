@@ -0,0 +1,137 @@
+package containers
+
+import (
+	"bitbucket.org/smaug-hosting/services/cache"
+	"bitbucket.org/smaug-hosting/services/container-service/software"
+	"bitbucket.org/smaug-hosting/services/idp/collaborators"
+	"bitbucket.org/smaug-hosting/services/idp/tokens"
+	"bitbucket.org/smaug-hosting/services/libhttp"
+	"bitbucket.org/smaug-hosting/services/metrics"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"time"
+)
+
+// how long a clone's data may take to copy before the reconciler treats it like any other provisioning container
+const cloneCopyTimeout = 6 * time.Hour
+
+func cloningKey(containerId int64) string {
+	return fmt.Sprintf("cloning.%d", containerId)
+}
+
+// isCloning reports whether the container's data is still being copied over from the container it is a clone of.
+func isCloning(containerId int64) bool {
+	count, err := cache.Client.Exists(cloningKey(containerId)).Result()
+	if err != nil {
+		// assume it is, leaving it alone is the safer mistake
+		logrus.Errorf("Could not check whether container %d is being cloned: %s", containerId, err)
+		return true
+	}
+	return count > 0
+}
+
+type CloneContainerRequest struct {
+	// defaults to the source container's name with " (copy)" on the end
+	Name string `json:"name"`
+}
+
+// HandlePostCloneContainer creates a copy of a container, with the same software, tier, config and release, and a
+// copy of its data.  The copy belongs to (and is billed to) whoever made it, and has to pass the same checks as any
+// other new container.
+func HandlePostCloneContainer(response http.ResponseWriter, request *http.Request) {
+	source, ok := ContainerForRequest(response, request, collaborators.PermAdmin)
+	if !ok {
+		return
+	}
+
+	if source.State == StateProvisioning || source.State == StateDeleting || source.State == StateFailed {
+		libhttp.SendError(http.StatusConflict, fmt.Sprintf("Could not clone container while it is %s", source.State), response)
+		return
+	}
+
+	body := CloneContainerRequest{}
+	err := libhttp.UnmarshalBody(request, response, &body)
+	if err != nil {
+		return
+	}
+
+	name := body.Name
+	if name == "" {
+		name = fmt.Sprintf("%s (copy)", source.Name)
+	}
+
+	claims := request.Context().Value("token_claims").(tokens.TokenClaims)
+	autoStopIdle := source.AutoStopIdle
+
+	clone, ok := saveNewContainer(claims.UserId, CreateContainerRequest{
+		Name:         name,
+		Software:     source.Software,
+		Tier:         source.Tier,
+		Config:       source.Config,
+		AutoStopIdle: &autoStopIdle,
+	}, &source.Release, response)
+	if !ok {
+		return
+	}
+
+	err = cache.Client.Set(cloningKey(clone.Id), source.Id, cloneCopyTimeout).Err()
+	if err != nil {
+		logrus.Errorf("Could not mark container %d as being cloned: %s", clone.Id, err)
+	}
+
+	// asynchronously copy the data over, then bring the clone live
+	go cloneContainer(*source, clone)
+
+	clone.Status = statusFromState(clone.State)
+
+	libhttp.SendJsonWithStatus(http.StatusCreated, clone, response)
+}
+
+// cloneContainer copies the source's volumes into those of the clone, then spins the clone up.  Like backups, the
+// data of a running source is copied as-is, so the clone gets whatever the server has flushed to disk.
+func cloneContainer(source Container, clone Container) {
+	defer func() {
+		err := cache.Client.Del(cloningKey(clone.Id)).Err()
+		if err != nil {
+			logrus.Errorf("Could not clear cloning mark of container %d: %s", clone.Id, err)
+		}
+	}()
+
+	err := copyVolumes(source, clone)
+	if err != nil {
+		logrus.Errorf("Could not copy data of container %d into its clone %d: %s", source.Id, clone.Id, err)
+		metrics.Increment("clones.failed")
+		transitionState(&clone, StateFailed, fmt.Sprintf("could not copy data from container %d", source.Id))
+		return
+	}
+
+	metrics.Increment("clones.created")
+	spinUpContainer(clone)
+}
+
+func copyVolumes(source Container, clone Container) error {
+	sw, err := software.SoftwareRepository{}.FindByName(source.Software)
+	if err != nil {
+		return err
+	}
+
+	for i, m := range sw.Mounts {
+		err = copyVolume(getVolumeForMount(source, i, m), getVolumeForMount(clone, i, m))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func copyVolume(from string, to string) error {
+	archive, err := Runtime.ArchiveVolume(from)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	return Runtime.RestoreVolume(to, archive)
+}
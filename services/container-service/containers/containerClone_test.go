@@ -0,0 +1,56 @@
+package containers
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"net/http"
+	"testing"
+)
+
+func TestHandlePostCloneContainer(t *testing.T) {
+	mock, teardown := setupHandlerTest(t)
+	defer teardown()
+
+	source := testContainer(42, StateStopped)
+	expectFindContainer(mock, source)
+	mock.ExpectQuery("FROM prices WHERE software = ").
+		WithArgs("minecraft", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"amount", "software", "tier"}).AddRow(100, "minecraft", 1))
+	expectUser(mock)
+	expectQuotaCheck(mock, source)
+	expectWithinQuota(mock, func() {
+		mock.ExpectExec("INSERT INTO containers").WillReturnResult(sqlmock.NewResult(43, 1))
+	}, source)
+
+	recorder := serveHandler(HandlePostCloneContainer, "POST", "42", `{}`)
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("Cloning a container gave %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	// the clone is brought up once its data has been copied over
+	waitFor(t, "the clone to be spun up", func() bool {
+		status, err := Runtime.Status("whelp-minecraft-7-1-43")
+		return err == nil && status.Up
+	})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHandlePostCloneContainerNeedsAdmin(t *testing.T) {
+	mock, teardown := setupHandlerTest(t)
+	defer teardown()
+
+	c := testContainer(42, StateStopped)
+	expectFindContainer(mock, c)
+	expectCollaborator(mock, c, "view")
+
+	recorder := serveHandlerAs(testCollaboratorId, HandlePostCloneContainer, "POST", "42", `{}`)
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("Cloning a container as a view collaborator gave %d, want %d", recorder.Code, http.StatusForbidden)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
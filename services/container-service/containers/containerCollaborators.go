@@ -0,0 +1,254 @@
+package containers
+
+import (
+	"bitbucket.org/smaug-hosting/services/idp/collaborators"
+	"bitbucket.org/smaug-hosting/services/idp/email"
+	"bitbucket.org/smaug-hosting/services/idp/tokens"
+	"bitbucket.org/smaug-hosting/services/idp/users"
+	"bitbucket.org/smaug-hosting/services/libhttp"
+	"database/sql"
+	"github.com/docker/docker/pkg/stringutils"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// PermissionsForUser returns what the user may do with the container: everything for its owner, whatever they
+// were granted for a collaborator who has accepted their invite, and nothing for anyone else.
+func PermissionsForUser(c Container, userId int64) (collaborators.Permissions, error) {
+	if c.UserId == userId {
+		return collaborators.Permissions{collaborators.PermOwner}, nil
+	}
+
+	collaborator, err := collaborators.CollaboratorRepository{}.FindForContainerAndUser(c.Id, userId)
+	if err == sql.ErrNoRows {
+		return collaborators.Permissions{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return collaborator.Permissions, nil
+}
+
+// getContainersSharedWithUser fetches the containers the user collaborates on, along with what they may do with each.
+func getContainersSharedWithUser(userId int64) ([]Container, error) {
+	shared, err := collaborators.CollaboratorRepository{}.GetAcceptedForUser(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions := make(map[int64]collaborators.Permissions, len(shared))
+	ids := make([]int64, 0, len(shared))
+	for _, collaborator := range shared {
+		permissions[collaborator.ContainerId] = collaborator.Permissions
+		ids = append(ids, collaborator.ContainerId)
+	}
+
+	containers, err := ContainerRepository{}.FindByIds(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range containers {
+		containers[i].Permissions = permissions[containers[i].Id]
+	}
+
+	return containers, nil
+}
+
+type InviteCollaboratorRequest struct {
+	Email       string   `json:"email"`
+	Permissions []string `json:"permissions"`
+}
+
+type UpdateCollaboratorRequest struct {
+	Permissions []string `json:"permissions"`
+}
+
+// collaboratorForRequest fetches the collaborator named in the request path, sending an error response (and
+// returning false) if they aren't a collaborator on the container.
+func collaboratorForRequest(container Container, response http.ResponseWriter, request *http.Request) (*collaborators.Collaborator, bool) {
+	collaboratorId, err := strconv.ParseInt(request.Context().Value("collaboratorId").(string), 10, 64)
+	if err != nil {
+		libhttp.SendError(http.StatusBadRequest, "Invalid collaborator id", response)
+		return nil, false
+	}
+
+	collaborator, err := collaborators.CollaboratorRepository{}.FindById(collaboratorId)
+	if err == sql.ErrNoRows || (err == nil && collaborator.ContainerId != container.Id) {
+		libhttp.SendError(http.StatusNotFound, "Collaborator not found", response)
+		return nil, false
+	} else if err != nil {
+		logrus.Errorf("Could not fetch collaborator from db: %s", err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not fetch collaborator", response)
+		return nil, false
+	}
+
+	return collaborator, true
+}
+
+// HandlePostCollaborator invites someone to help run the container by email.  They get access once they accept the
+// invite through the IDP; the container's usage is still billed to its owner.
+func HandlePostCollaborator(response http.ResponseWriter, request *http.Request) {
+	container, ok := ContainerForRequest(response, request, collaborators.PermAdmin)
+	if !ok {
+		return
+	}
+
+	claims := request.Context().Value("token_claims").(tokens.TokenClaims)
+
+	body := InviteCollaboratorRequest{}
+	err := libhttp.UnmarshalBody(request, response, &body)
+	if err != nil {
+		return
+	}
+
+	address := strings.TrimSpace(body.Email)
+	if !strings.Contains(address, "@") {
+		libhttp.SendError(http.StatusBadRequest, "Please provide the email address to invite", response)
+		return
+	}
+
+	permissions, err := collaborators.ParsePermissions(body.Permissions)
+	if err != nil {
+		libhttp.SendError(http.StatusBadRequest, err.Error(), response)
+		return
+	}
+
+	owner, err := users.UserRepository{}.Find(container.UserId)
+	if err != nil || owner == nil {
+		logrus.Errorf("Could not fetch owner of container %d: %s", container.Id, err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not invite collaborator", response)
+		return
+	}
+
+	if strings.EqualFold(owner.Email, address) {
+		libhttp.SendError(http.StatusBadRequest, "The container's owner can't be invited to it", response)
+		return
+	}
+
+	inviter := owner
+	if claims.UserId != owner.Id {
+		inviter, err = users.UserRepository{}.Find(claims.UserId)
+		if err != nil || inviter == nil {
+			logrus.Errorf("Could not fetch user %d to invite collaborator: %s", claims.UserId, err)
+			libhttp.SendError(http.StatusInternalServerError, "Could not invite collaborator", response)
+			return
+		}
+	}
+
+	collaborator, err := collaborators.CollaboratorRepository{}.Save(collaborators.Collaborator{
+		ContainerId: container.Id,
+		Email:       address,
+		Permissions: permissions,
+		InviteToken: stringutils.GenerateRandomAlphaOnlyString(64),
+		InvitedBy:   claims.UserId,
+	})
+	if err == collaborators.ErrAlreadyInvited {
+		libhttp.SendError(http.StatusConflict, "That email address has already been invited to this container", response)
+		return
+	} else if err != nil {
+		logrus.Errorf("Could not save collaborator for container %d: %s", container.Id, err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not invite collaborator", response)
+		return
+	}
+
+	err = email.SendInviteEmail(address, inviter.Email, container.Name, collaborator.InviteToken)
+	if err != nil {
+		logrus.Errorf("Could not send invite email for container %d: %s", container.Id, err)
+
+		// an invite nobody was told about is no use, so let it be sent again
+		deleteErr := collaborators.CollaboratorRepository{}.Delete(collaborator)
+		if deleteErr != nil {
+			logrus.Errorf("Could not remove unsent invite %d: %s", collaborator.Id, deleteErr)
+		}
+
+		libhttp.SendError(http.StatusBadGateway, "Could not send the invite email, please try again", response)
+		return
+	}
+
+	libhttp.SendJsonWithStatus(http.StatusCreated, collaborator, response)
+}
+
+func HandleGetCollaborators(response http.ResponseWriter, request *http.Request) {
+	container, ok := ContainerForRequest(response, request, collaborators.PermAdmin)
+	if !ok {
+		return
+	}
+
+	list, err := collaborators.CollaboratorRepository{}.GetCollaboratorsForContainer(container.Id)
+	if err != nil {
+		logrus.Errorf("Could not fetch collaborators for container %d: %s", container.Id, err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not fetch collaborators", response)
+		return
+	}
+
+	libhttp.SendJson(list, response)
+}
+
+// HandlePatchCollaborator replaces the permissions a collaborator holds on the container.
+func HandlePatchCollaborator(response http.ResponseWriter, request *http.Request) {
+	container, ok := ContainerForRequest(response, request, collaborators.PermAdmin)
+	if !ok {
+		return
+	}
+
+	collaborator, ok := collaboratorForRequest(*container, response, request)
+	if !ok {
+		return
+	}
+
+	body := UpdateCollaboratorRequest{}
+	err := libhttp.UnmarshalBody(request, response, &body)
+	if err != nil {
+		return
+	}
+
+	permissions, err := collaborators.ParsePermissions(body.Permissions)
+	if err != nil {
+		libhttp.SendError(http.StatusBadRequest, err.Error(), response)
+		return
+	}
+
+	err = collaborators.CollaboratorRepository{}.SetPermissions(collaborator, permissions)
+	if err != nil {
+		logrus.Errorf("Could not change permissions of collaborator %d: %s", collaborator.Id, err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not change permissions", response)
+		return
+	}
+
+	libhttp.SendJson(collaborator, response)
+}
+
+// HandleDeleteCollaborator takes away a collaborator's access to the container (or withdraws their invite).  Any
+// collaborator may also remove themselves.
+func HandleDeleteCollaborator(response http.ResponseWriter, request *http.Request) {
+	container, ok := ContainerForRequest(response, request, collaborators.PermView)
+	if !ok {
+		return
+	}
+
+	collaborator, ok := collaboratorForRequest(*container, response, request)
+	if !ok {
+		return
+	}
+
+	claims := request.Context().Value("token_claims").(tokens.TokenClaims)
+	leaving := collaborator.UserId != nil && *collaborator.UserId == claims.UserId
+
+	if !leaving && !container.Permissions.Allows(collaborators.PermAdmin) {
+		libhttp.SendError(http.StatusForbidden, "You need the admin permission on this container to do that", response)
+		return
+	}
+
+	err := collaborators.CollaboratorRepository{}.Delete(*collaborator)
+	if err != nil {
+		logrus.Errorf("Could not remove collaborator %d: %s", collaborator.Id, err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not remove collaborator", response)
+		return
+	}
+
+	// empty 200 response if all went well
+	libhttp.SendJson(struct{}{}, response)
+}
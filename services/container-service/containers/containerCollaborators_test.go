@@ -0,0 +1,19 @@
+package containers
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"time"
+)
+
+// the user the test user's containers are shared with
+const testCollaboratorId = 8
+
+// expectCollaborator expects the collaborator to be found on the container with the given permissions.
+func expectCollaborator(mock sqlmock.Sqlmock, c Container, permissions string) {
+	acceptedAt := time.Now()
+	mock.ExpectQuery("FROM collaborators WHERE container_id = \\? AND user_id = \\?").
+		WithArgs(c.Id, testCollaboratorId).
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "container_id", "email", "user_id", "permissions", "invite_token", "invited_by", "created_at", "accepted_at",
+		}).AddRow(3, c.Id, "friend@example.com", testCollaboratorId, []byte(permissions), "", testUserId, acceptedAt, &acceptedAt))
+}
@@ -0,0 +1,162 @@
+package containers
+
+import (
+	"bitbucket.org/smaug-hosting/services/container-service/software"
+	"bitbucket.org/smaug-hosting/services/container-service/tiers"
+	"bitbucket.org/smaug-hosting/services/idp/collaborators"
+	"bitbucket.org/smaug-hosting/services/libhttp"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+)
+
+// ContainerConfig holds the settings a user has chosen for their container, keyed by config option name.  It is
+// stored as a json document alongside the container.
+type ContainerConfig map[string]interface{}
+
+var ErrConfigNotApplied = errors.New("config saved but could not be applied")
+
+func (c *ContainerConfig) Scan(src interface{}) error {
+	*c = make(ContainerConfig)
+	if src == nil {
+		return nil
+	}
+
+	b, ok := src.([]uint8)
+	if !ok {
+		return fmt.Errorf("cannot scan %T into container config", src)
+	}
+	if len(b) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(b, c)
+}
+
+func (c ContainerConfig) Value() (driver.Value, error) {
+	if c == nil {
+		return "{}", nil
+	}
+
+	b, err := json.Marshal(c)
+	return string(b), err
+}
+
+// configLimitsForTier supplies the limits config options can refer to, so that e.g. a player cap can't be raised
+// past what the tier is sized for.
+func configLimitsForTier(tier tiers.Tier) map[string]int64 {
+	return map[string]int64{
+		"tier_max_players": int64(tier.MaxPlayers),
+		"tier_memory_mb":   tier.MemoryMB,
+		"tier_heap_mb":     tier.HeapMB(),
+	}
+}
+
+func validateConfig(c Container, config ContainerConfig) error {
+	sw, err := software.SoftwareRepository{}.FindByName(c.Software)
+	if err != nil {
+		return err
+	}
+
+	tier, err := tiers.TierRepository{}.FindByTier(c.Tier)
+	if err != nil {
+		return err
+	}
+
+	return sw.ValidateConfig(config, configLimitsForTier(tier))
+}
+
+// hasEnv reports whether env (in KEY=VALUE form) already sets the named variable.
+func hasEnv(env []string, name string) bool {
+	for _, e := range env {
+		if strings.HasPrefix(e, name+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// UpdateContainerConfig validates and saves the container's new config.  A container that is up gets a rolling
+// update to pick the change up straight away, any other container picks it up the next time it starts.
+func UpdateContainerConfig(c *Container, config ContainerConfig) error {
+	err := validateConfig(*c, config)
+	if err != nil {
+		return err
+	}
+
+	err = ContainerRepository{}.SetConfig(c, config)
+	if err != nil {
+		return err
+	}
+
+	if c.State != StateRunning && c.State != StateStarting {
+		return nil
+	}
+
+	err = scaleContainer(*c, 1)
+	if err != nil {
+		logrus.Errorf("Could not apply new config to container %d: %s", c.Id, err)
+		return ErrConfigNotApplied
+	}
+
+	return nil
+}
+
+type ConfigResponse struct {
+	Config ContainerConfig         `json:"config"`
+	Schema []software.ConfigOption `json:"schema"`
+}
+
+func HandleGetContainerConfig(response http.ResponseWriter, request *http.Request) {
+	container, ok := ContainerForRequest(response, request, collaborators.PermView)
+	if !ok {
+		return
+	}
+
+	sw, err := software.SoftwareRepository{}.FindByName(container.Software)
+	if err != nil {
+		logrus.Errorf("Could not find software %s for container %d: %s", container.Software, container.Id, err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not fetch config", response)
+		return
+	}
+
+	libhttp.SendJson(ConfigResponse{Config: container.Config, Schema: sw.ConfigSchema}, response)
+}
+
+// HandlePutContainerConfig replaces the container's whole config; any setting left out goes back to its default.
+func HandlePutContainerConfig(response http.ResponseWriter, request *http.Request) {
+	container, ok := ContainerForRequest(response, request, collaborators.PermAdmin)
+	if !ok {
+		return
+	}
+
+	if container.State == StateDeleting {
+		libhttp.SendError(http.StatusConflict, "Could not change the config of a container that is being deleted", response)
+		return
+	}
+
+	config := ContainerConfig{}
+	err := libhttp.UnmarshalBody(request, response, &config)
+	if err != nil {
+		return
+	}
+
+	err = UpdateContainerConfig(container, config)
+	if configErr, ok := err.(software.ConfigError); ok {
+		libhttp.SendError(http.StatusBadRequest, configErr.Error(), response)
+		return
+	} else if err == ErrConfigNotApplied {
+		libhttp.SendError(http.StatusBadGateway, "Your settings were saved but could not be applied, they will take effect when the container next starts", response)
+		return
+	} else if err != nil {
+		logrus.Errorf("Could not update config of container %d: %s", container.Id, err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not update config", response)
+		return
+	}
+
+	libhttp.SendJson(container.Config, response)
+}
@@ -0,0 +1,89 @@
+package containers
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestServiceSpecHeap(t *testing.T) {
+	_, teardown := setupHandlerTest(t)
+	defer teardown()
+
+	cases := []struct {
+		tier   int
+		config ContainerConfig
+		want   string
+	}{
+		// most of the tier's memory, leaving the rest for the JVM
+		{0, nil, "MEMORY=768M"},
+		{1, nil, "MEMORY=1536M"},
+		// unless the user chose their own
+		{1, ContainerConfig{"memory_mb": 1024.0}, "MEMORY=1024M"},
+	}
+
+	for _, c := range cases {
+		container := testContainer(42, StateStopped)
+		container.Tier = c.tier
+		container.Config = c.config
+
+		serviceSpec, err := getServiceSpecForContainer(container, nil)
+		if err != nil {
+			t.Fatalf("Could not build service spec: %s", err)
+		}
+
+		found := 0
+		for _, env := range serviceSpec.Env {
+			if env == c.want {
+				found++
+			} else if hasEnv([]string{env}, "MEMORY") {
+				t.Errorf("Tier %d with config %v got %s, want %s", c.tier, c.config, env, c.want)
+			}
+		}
+		if found != 1 {
+			t.Errorf("Tier %d with config %v set %s %d times, want once", c.tier, c.config, c.want, found)
+		}
+	}
+}
+
+func TestValidateConfigCapsHeapBelowTierMemory(t *testing.T) {
+	container := testContainer(42, StateStopped)
+	container.Tier = 0
+
+	// config comes from json, so numbers are float64s.  The whole of tier 0's memory would leave nothing for the JVM
+	err := validateConfig(container, ContainerConfig{"memory_mb": 1024.0})
+	if err == nil {
+		t.Errorf("Allowed a heap the size of the tier's memory")
+	}
+
+	err = validateConfig(container, ContainerConfig{"memory_mb": 768.0})
+	if err != nil {
+		t.Errorf("Refused a heap within the tier's headroom: %s", err)
+	}
+}
+
+func TestValidateConfigMotd(t *testing.T) {
+	container := testContainer(42, StateStopped)
+
+	cases := []struct {
+		motd  string
+		valid bool
+	}{
+		{"A Minecraft Server", true},
+		// colour codes and anything else that isn't ascii count as one character each
+		{"§a" + strings.Repeat("é", 57), true},
+		{"§a" + strings.Repeat("é", 58), false},
+		// control characters would end up in the server's properties file
+		{"first line\nsecond line", false},
+		{"tab\tseparated", false},
+		{"bell\x07", false},
+	}
+
+	for _, c := range cases {
+		err := validateConfig(container, ContainerConfig{"motd": c.motd})
+		if c.valid && err != nil {
+			t.Errorf("Refused motd %q: %s", c.motd, err)
+		} else if !c.valid && err == nil {
+			t.Errorf("Allowed motd %q", c.motd)
+		}
+	}
+}
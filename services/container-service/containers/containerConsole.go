@@ -0,0 +1,122 @@
+package containers
+
+import (
+	"bitbucket.org/smaug-hosting/services/container-service/rcon"
+	"bitbucket.org/smaug-hosting/services/container-service/software"
+	"bitbucket.org/smaug-hosting/services/idp/collaborators"
+	"bitbucket.org/smaug-hosting/services/libhttp"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const consoleTimeout = 5 * time.Second
+
+var ErrNoConsole = errors.New("software has no remote console")
+
+func generateConsolePassword() (string, error) {
+	b := make([]byte, 24)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// ensureConsolePassword gives containers created before we had consoles a password of their own.
+func ensureConsolePassword(c *Container) error {
+	if c.ConsolePassword != "" {
+		return nil
+	}
+
+	password, err := generateConsolePassword()
+	if err != nil {
+		return err
+	}
+
+	return ContainerRepository{}.SetConsolePassword(c, password)
+}
+
+// ExecuteConsoleCommand runs a command on the container's remote console and returns its output.
+func ExecuteConsoleCommand(c Container, command string) (string, error) {
+	sw, err := software.SoftwareRepository{}.FindByName(c.Software)
+	if err != nil {
+		return "", err
+	}
+
+	if sw.Console == nil || sw.Console.Protocol != "rcon" {
+		return "", ErrNoConsole
+	}
+
+	port, _ := sw.FindPort(sw.Console.PortName)
+
+	// the console is never published, so it is reached over the network we share with the container
+	ip, target, err := Runtime.InternalEndpoint(getServiceIdForContainer(c), port.Target)
+	if err != nil {
+		return "", err
+	}
+
+	client, err := rcon.Dial(net.JoinHostPort(ip, strconv.Itoa(int(target))), c.ConsolePassword, consoleTimeout)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+
+	return client.Execute(command)
+}
+
+type ConsoleRequest struct {
+	Command string `json:"command"`
+}
+
+type ConsoleResponse struct {
+	Output string `json:"output"`
+}
+
+func HandlePostConsoleCommand(response http.ResponseWriter, request *http.Request) {
+	container, ok := ContainerForRequest(response, request, collaborators.PermConsole)
+	if !ok {
+		return
+	}
+
+	body := ConsoleRequest{}
+	err := libhttp.UnmarshalBody(request, response, &body)
+	if err != nil {
+		return
+	}
+
+	if body.Command == "" {
+		libhttp.SendError(http.StatusBadRequest, "Please provide a command", response)
+		return
+	}
+
+	if container.State != StateRunning {
+		libhttp.SendError(http.StatusConflict, fmt.Sprintf("Could not run a command while the container is %s", container.State), response)
+		return
+	}
+
+	output, err := ExecuteConsoleCommand(*container, body.Command)
+	if err == ErrNoConsole {
+		libhttp.SendError(http.StatusBadRequest, "This software has no remote console", response)
+		return
+	} else if err == rcon.ErrCommandTooLong {
+		libhttp.SendError(http.StatusBadRequest, "That command is too long", response)
+		return
+	} else if err == rcon.ErrAuthFailed {
+		// most likely a container created before it had a console password, which picks it up on its next start
+		libhttp.SendError(http.StatusConflict, "The console is not available until the container is restarted", response)
+		return
+	} else if err != nil {
+		logrus.Errorf("Could not run console command on container %d: %s", container.Id, err)
+		libhttp.SendError(http.StatusBadGateway, "Could not reach the container's console", response)
+		return
+	}
+
+	libhttp.SendJson(ConsoleResponse{Output: output}, response)
+}
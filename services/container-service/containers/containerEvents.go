@@ -0,0 +1,179 @@
+package containers
+
+import (
+	"bitbucket.org/smaug-hosting/services/container-service/orchestrator/spec"
+	"bitbucket.org/smaug-hosting/services/idp/collaborators"
+	"bitbucket.org/smaug-hosting/services/idp/tokens"
+	"bitbucket.org/smaug-hosting/services/libws"
+	"database/sql"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how long to wait before subscribing to the orchestrator's events again after the stream ends
+const eventResubscribeDelay = 5 * time.Second
+
+// StatusChange tells whoever can see a container that its state or status has changed.
+type StatusChange struct {
+	ContainerId int64           `json:"container_id"`
+	UserId      int64           `json:"-"`
+	State       State           `json:"state"`
+	Status      ContainerStatus `json:"status"`
+}
+
+// StatusChangeHandler, if set, is called with every change to a container's state or status.  It must not block.
+var StatusChangeHandler func(change StatusChange)
+
+var lastPublished = struct {
+	sync.Mutex
+	statuses map[int64]StatusChange
+}{statuses: make(map[int64]StatusChange)}
+
+// publishStatus hands the container's status to the StatusChangeHandler, unless it is the same as last time.
+func publishStatus(c Container, status ContainerStatus) {
+	if StatusChangeHandler == nil {
+		return
+	}
+
+	change := StatusChange{
+		ContainerId: c.Id,
+		UserId:      c.UserId,
+		State:       c.State,
+		Status:      ContainerStatus{Up: status.Up, State: status.State},
+	}
+
+	lastPublished.Lock()
+	if lastPublished.statuses[c.Id] == change {
+		lastPublished.Unlock()
+		return
+	}
+	lastPublished.statuses[c.Id] = change
+	lastPublished.Unlock()
+
+	StatusChangeHandler(change)
+}
+
+// forgetPublishedStatus drops what was last published for a container that has gone, so that the record of it
+// doesn't outlive it.
+func forgetPublishedStatus(containerId int64) {
+	lastPublished.Lock()
+	delete(lastPublished.statuses, containerId)
+	lastPublished.Unlock()
+}
+
+// containerIdFromServiceName picks the container id off the end of a service name.  Names are pinned once anything
+// they are built from changes, but the id always stays on the end.
+func containerIdFromServiceName(name string) (int64, bool) {
+	i := strings.LastIndex(name, "-")
+	if i < 0 {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(name[i+1:], 10, 64)
+	return id, err == nil
+}
+
+// StartEventWatcher follows the orchestrator's events for whelp services, publishing the status of the container
+// behind each as things happen to it, so that clients don't have to poll.
+func StartEventWatcher() {
+	go func() {
+		for {
+			events, errs := Runtime.Events(servicePrefix)
+			watchEvents(events, errs)
+			time.Sleep(eventResubscribeDelay)
+		}
+	}()
+}
+
+func watchEvents(events <-chan spec.Event, errs <-chan error) {
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			handleEvent(event)
+		case err := <-errs:
+			logrus.Warnf("Orchestrator event stream ended, resubscribing: %s", err)
+			return
+		}
+	}
+}
+
+func handleEvent(event spec.Event) {
+	containerId, ok := containerIdFromServiceName(event.Service)
+	if !ok {
+		return
+	}
+
+	c, err := ContainerRepository{}.FindById(containerId)
+	if err == sql.ErrNoRows {
+		// e.g. the removal of the service of a container deleted by another replica
+		forgetPublishedStatus(containerId)
+		return
+	} else if err != nil {
+		logrus.Errorf("Could not fetch container %d for event %s: %s", containerId, event.Action, err)
+		return
+	}
+
+	if getServiceIdForContainer(*c) != event.Service {
+		// not one of ours after all
+		return
+	}
+
+	// also brings the persisted state up to date, which publishes the change itself if there is one
+	status, err := GetStatusForContainer(c)
+	if err != nil {
+		return
+	}
+
+	publishStatus(*c, status)
+}
+
+// BroadcastStatusChanges sends every status change to the websocket connections of the container's owner and
+// collaborators.
+func BroadcastStatusChanges(ws libws.WebSocket) {
+	StatusChangeHandler = func(change StatusChange) {
+		// looking up who may see the change mustn't hold up whoever changed the state
+		go broadcastStatusChange(ws, change)
+	}
+}
+
+func broadcastStatusChange(ws libws.WebSocket, change StatusChange) {
+	viewers := map[int64]bool{change.UserId: true}
+
+	shared, err := collaborators.CollaboratorRepository{}.GetCollaboratorsForContainer(change.ContainerId)
+	if err != nil {
+		logrus.Errorf("Could not fetch collaborators of container %d to broadcast its status: %s", change.ContainerId, err)
+	}
+	for _, collaborator := range shared {
+		if collaborator.UserId != nil && collaborator.Permissions.Allows(collaborators.PermView) {
+			viewers[*collaborator.UserId] = true
+		}
+	}
+
+	for _, client := range ws.Clients() {
+		claims := tokens.TokenClaims{}
+		err := tokens.ParseToken(client.Session.Token, &claims)
+		if err != nil {
+			logrus.Debugf("Websocket client with invalid token found: %s", err)
+			continue
+		}
+
+		if !viewers[claims.UserId] {
+			continue
+		}
+
+		ws.Send() <- libws.WSMessage{
+			Subject: "container_status",
+			Body: map[string]interface{}{
+				"container_id": change.ContainerId,
+				"state":        change.State,
+				"status":       change.Status,
+			},
+			Connection: client.Connection,
+		}
+	}
+}
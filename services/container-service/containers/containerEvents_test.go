@@ -0,0 +1,43 @@
+package containers
+
+import (
+	"bitbucket.org/smaug-hosting/services/container-service/orchestrator/spec"
+	"database/sql"
+	"testing"
+)
+
+func publishedStatusCount() int {
+	lastPublished.Lock()
+	defer lastPublished.Unlock()
+	return len(lastPublished.statuses)
+}
+
+func TestHandleEventForgetsDeletedContainer(t *testing.T) {
+	mock, teardown := setupHandlerTest(t)
+	defer teardown()
+
+	published := 0
+	StatusChangeHandler = func(change StatusChange) { published++ }
+	defer func() { StatusChangeHandler = nil }()
+
+	c := testContainer(42, StateStopped)
+	publishStatus(c, statusFromState(c.State))
+	publishStatus(c, statusFromState(c.State))
+	if published != 1 || publishedStatusCount() != 1 {
+		t.Fatalf("Published %d changes and remembered %d, want the same status published once", published, publishedStatusCount())
+	}
+
+	// the container has since been deleted, as far as this replica can tell only from its service going away
+	mock.ExpectQuery("FROM containers WHERE id = ?").
+		WithArgs(c.Id).
+		WillReturnError(sql.ErrNoRows)
+	handleEvent(spec.Event{Service: getServiceIdForContainer(c), Action: "remove"})
+
+	if count := publishedStatusCount(); count != 0 {
+		t.Errorf("Still remembering %d statuses after the container went", count)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,334 @@
+package containers
+
+import (
+	"bitbucket.org/smaug-hosting/services/container-service/orchestrator/spec"
+	"bitbucket.org/smaug-hosting/services/idp/collaborators"
+	"bitbucket.org/smaug-hosting/services/libhttp"
+	µ "bitbucket.org/smaug-hosting/services/micro"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The file manager works on the container's data volume through the orchestrator, so it can never see anything
+// outside of it.  Files can always be read, but only changed while the container is stopped, as the game server
+// would otherwise be writing to them at the same time.
+
+var errInvalidPath = errors.New("invalid path")
+
+type FileEntry struct {
+	Name     string    `json:"name"`
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	Dir      bool      `json:"dir"`
+}
+
+type MoveFileRequest struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// cleanVolumePath makes the path absolute within the volume, so that any ".." stops at its root.
+func cleanVolumePath(p string) (string, error) {
+	if strings.ContainsRune(p, 0) {
+		return "", errInvalidPath
+	}
+	return path.Clean("/" + p), nil
+}
+
+// isWithin reports whether p is dir or anything below it.
+func isWithin(p string, dir string) bool {
+	return p == dir || strings.HasPrefix(p, strings.TrimSuffix(dir, "/")+"/")
+}
+
+func getUploadMaxBytes() int64 {
+	mb, err := strconv.ParseInt(µ.GetEnvDefault("FILE_UPLOAD_MAX_MB", "100"), 10, 64)
+	if err != nil || mb < 1 {
+		logrus.Errorf("Could not parse FILE_UPLOAD_MAX_MB, using 100")
+		mb = 100
+	}
+	return mb * 1024 * 1024
+}
+
+// pathForRequest reads the path query parameter, which defaults to the root of the volume when allowed.
+func pathForRequest(response http.ResponseWriter, request *http.Request, allowRoot bool) (string, bool) {
+	p, err := cleanVolumePath(request.URL.Query().Get("path"))
+	if err != nil {
+		libhttp.SendError(http.StatusBadRequest, "Invalid path", response)
+		return "", false
+	}
+
+	if p == "/" && !allowRoot {
+		libhttp.SendError(http.StatusBadRequest, "Please provide the path of a file", response)
+		return "", false
+	}
+
+	return p, true
+}
+
+// writableContainerForRequest is ContainerForRequest for requests that change files.  The container is checked again
+// once it is locked for the change (see lockForFileChange), this just turns requests away early.
+func writableContainerForRequest(response http.ResponseWriter, request *http.Request) (*Container, bool) {
+	container, ok := ContainerForRequest(response, request, collaborators.PermFiles)
+	if !ok {
+		return nil, false
+	}
+
+	if container.State != StateStopped {
+		libhttp.SendError(http.StatusConflict, fmt.Sprintf("Files can only be changed while the container is stopped, it is %s", container.State), response)
+		return nil, false
+	}
+
+	return container, true
+}
+
+// lockForFileChange locks the container for as long as its files are being changed, so that it can't be started
+// (or restored into, or upgraded) part way through, and then makes sure it is still stopped.  If it can't, it sends
+// an error response and returns false, otherwise the returned unlock has to be called once the change is made.
+func lockForFileChange(container *Container, response http.ResponseWriter) (func(), bool) {
+	unlock, err := LockContainer(container.Id, "changing files")
+	if IsContainerLocked(err) {
+		libhttp.SendError(http.StatusConflict, fmt.Sprintf("Could not change files: %s", err), response)
+		return nil, false
+	} else if err != nil {
+		logrus.Errorf("Could not lock container %d to change its files: %s", container.Id, err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not access the container's files", response)
+		return nil, false
+	}
+
+	// it may have been started since it was fetched, before we had the lock
+	current, err := ContainerRepository{}.FindById(container.Id)
+	if err != nil {
+		unlock()
+		logrus.Errorf("Could not fetch container %d to change its files: %s", container.Id, err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not access the container's files", response)
+		return nil, false
+	}
+
+	if current.State != StateStopped {
+		unlock()
+		libhttp.SendError(http.StatusConflict, fmt.Sprintf("Files can only be changed while the container is stopped, it is %s", current.State), response)
+		return nil, false
+	}
+
+	return unlock, true
+}
+
+// sendFileError responds for the errors the orchestrator's file operations are expected to return.
+func sendFileError(err error, container *Container, response http.ResponseWriter) {
+	switch err {
+	case spec.ErrFileNotFound:
+		libhttp.SendError(http.StatusNotFound, "No such file or directory", response)
+	case spec.ErrFileExists:
+		libhttp.SendError(http.StatusConflict, "A file with that name already exists", response)
+	case spec.ErrNotADirectory:
+		libhttp.SendError(http.StatusBadRequest, "That is not a directory", response)
+	case spec.ErrIsADirectory:
+		libhttp.SendError(http.StatusBadRequest, "That is a directory", response)
+	default:
+		logrus.Errorf("Could not access files of container %d: %s", container.Id, err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not access the container's files", response)
+	}
+}
+
+// HandleGetContainerFiles lists a directory of the container's data volume.
+func HandleGetContainerFiles(response http.ResponseWriter, request *http.Request) {
+	container, ok := ContainerForRequest(response, request, collaborators.PermFiles)
+	if !ok {
+		return
+	}
+
+	dir, ok := pathForRequest(response, request, true)
+	if !ok {
+		return
+	}
+
+	files, err := Runtime.ListVolumeFiles(DataVolumeForContainer(*container), dir)
+	if err != nil {
+		sendFileError(err, container, response)
+		return
+	}
+
+	entries := make([]FileEntry, 0, len(files))
+	for _, f := range files {
+		entries = append(entries, FileEntry{
+			Name:     f.Name,
+			Path:     path.Join(dir, f.Name),
+			Size:     f.Size,
+			Modified: f.ModTime,
+			Dir:      f.Dir,
+		})
+	}
+
+	libhttp.SendJson(entries, response)
+}
+
+// HandleGetContainerFileContent downloads a single file from the container's data volume.
+func HandleGetContainerFileContent(response http.ResponseWriter, request *http.Request) {
+	container, ok := ContainerForRequest(response, request, collaborators.PermFiles)
+	if !ok {
+		return
+	}
+
+	file, ok := pathForRequest(response, request, false)
+	if !ok {
+		return
+	}
+
+	content, err := Runtime.ReadVolumeFile(DataVolumeForContainer(*container), file)
+	if err != nil {
+		sendFileError(err, container, response)
+		return
+	}
+	defer content.Close()
+
+	response.Header().Set("Content-Type", "application/octet-stream")
+	response.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(file)))
+	response.WriteHeader(http.StatusOK)
+
+	_, err = io.Copy(response, content)
+	if err != nil {
+		logrus.Warnf("Could not send file %s of container %d: %s", file, container.Id, err)
+	}
+}
+
+// HandlePutContainerFileContent creates or replaces a file in the container's data volume with the request body.
+func HandlePutContainerFileContent(response http.ResponseWriter, request *http.Request) {
+	container, ok := writableContainerForRequest(response, request)
+	if !ok {
+		return
+	}
+
+	file, ok := pathForRequest(response, request, false)
+	if !ok {
+		return
+	}
+
+	maxBytes := getUploadMaxBytes()
+	if request.ContentLength > maxBytes {
+		libhttp.SendError(http.StatusRequestEntityTooLarge, fmt.Sprintf("Files can be at most %d MB", maxBytes/1024/1024), response)
+		return
+	}
+
+	// spool the upload to disk first, the orchestrator needs to know its size up front and a client that drops
+	// off half way shouldn't leave half a file behind
+	spool, err := ioutil.TempFile("", "upload")
+	if err != nil {
+		logrus.Errorf("Could not create temporary file for upload: %s", err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not store the upload", response)
+		return
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	size, err := io.Copy(spool, io.LimitReader(request.Body, maxBytes+1))
+	if err != nil {
+		libhttp.SendError(http.StatusBadRequest, "Could not read request body", response)
+		return
+	}
+	if size > maxBytes {
+		libhttp.SendError(http.StatusRequestEntityTooLarge, fmt.Sprintf("Files can be at most %d MB", maxBytes/1024/1024), response)
+		return
+	}
+
+	_, err = spool.Seek(0, io.SeekStart)
+	if err != nil {
+		logrus.Errorf("Could not rewind upload: %s", err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not store the upload", response)
+		return
+	}
+
+	// only locked once the upload is in, however long the client takes to send it
+	unlock, ok := lockForFileChange(container, response)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	err = Runtime.WriteVolumeFile(DataVolumeForContainer(*container), file, spool, size)
+	if err != nil {
+		sendFileError(err, container, response)
+		return
+	}
+
+	logrus.Infof("Wrote %s (%d bytes) to container %d", file, size, container.Id)
+	libhttp.SendJson(struct{}{}, response)
+}
+
+// HandlePostContainerFileMove renames or moves a file or directory within the container's data volume.
+func HandlePostContainerFileMove(response http.ResponseWriter, request *http.Request) {
+	container, ok := writableContainerForRequest(response, request)
+	if !ok {
+		return
+	}
+
+	body := MoveFileRequest{}
+	err := libhttp.UnmarshalBody(request, response, &body)
+	if err != nil {
+		return
+	}
+
+	from, fromErr := cleanVolumePath(body.From)
+	to, toErr := cleanVolumePath(body.To)
+	if fromErr != nil || toErr != nil || body.From == "" || body.To == "" || from == "/" || to == "/" {
+		libhttp.SendError(http.StatusBadRequest, "Please provide the paths to move from and to", response)
+		return
+	}
+
+	if isWithin(to, from) {
+		libhttp.SendError(http.StatusBadRequest, "Could not move a directory into itself", response)
+		return
+	}
+
+	unlock, ok := lockForFileChange(container, response)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	err = Runtime.MoveVolumeFile(DataVolumeForContainer(*container), from, to)
+	if err != nil {
+		sendFileError(err, container, response)
+		return
+	}
+
+	logrus.Infof("Moved %s to %s in container %d", from, to, container.Id)
+	libhttp.SendJson(struct{}{}, response)
+}
+
+// HandleDeleteContainerFile removes a file, or a directory and everything in it, from the container's data volume.
+func HandleDeleteContainerFile(response http.ResponseWriter, request *http.Request) {
+	container, ok := writableContainerForRequest(response, request)
+	if !ok {
+		return
+	}
+
+	file, ok := pathForRequest(response, request, false)
+	if !ok {
+		return
+	}
+
+	unlock, ok := lockForFileChange(container, response)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	err := Runtime.RemoveVolumeFile(DataVolumeForContainer(*container), file)
+	if err != nil {
+		sendFileError(err, container, response)
+		return
+	}
+
+	logrus.Infof("Deleted %s from container %d", file, container.Id)
+	libhttp.SendJson(struct{}{}, response)
+}
@@ -0,0 +1,123 @@
+package containers
+
+import (
+	"bitbucket.org/smaug-hosting/services/container-service/orchestrator/spec"
+	"bitbucket.org/smaug-hosting/services/idp/tokens"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func serveFileHandler(handler http.HandlerFunc, method string, file string, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, "/containers/42/files/content/?path="+file, strings.NewReader(body))
+	ctx := context.WithValue(request.Context(), "token_claims", tokens.TokenClaims{UserId: testUserId})
+	ctx = context.WithValue(ctx, "containerId", "42")
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, request.WithContext(ctx))
+	return recorder
+}
+
+func readTestFile(t *testing.T, c Container, file string) (string, error) {
+	content, err := Runtime.ReadVolumeFile(DataVolumeForContainer(c), file)
+	if err != nil {
+		return "", err
+	}
+	defer content.Close()
+
+	data, err := ioutil.ReadAll(content)
+	if err != nil {
+		t.Fatalf("Could not read %s: %s", file, err)
+	}
+	return string(data), nil
+}
+
+func TestHandlePutContainerFileContent(t *testing.T) {
+	mock, teardown := setupHandlerTest(t)
+	defer teardown()
+
+	c := testContainer(42, StateStopped)
+	// once for the request, and again once the container is locked
+	expectFindContainer(mock, c)
+	expectFindContainer(mock, c)
+
+	recorder := serveFileHandler(HandlePutContainerFileContent, "PUT", "/server.properties", "motd=hello")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Writing a file gave %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	content, err := readTestFile(t, c, "/server.properties")
+	if err != nil || content != "motd=hello" {
+		t.Errorf("File holds %q (%v) after writing it, want %q", content, err, "motd=hello")
+	}
+
+	if isLocked(c.Id) {
+		t.Error("Container is still locked after writing the file")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHandlePutContainerFileContentLocked(t *testing.T) {
+	mock, teardown := setupHandlerTest(t)
+	defer teardown()
+
+	c := testContainer(42, StateStopped)
+	expectFindContainer(mock, c)
+
+	unlock, err := LockContainer(c.Id, "restoring a backup")
+	if err != nil {
+		t.Fatalf("Could not lock container: %s", err)
+	}
+	defer unlock()
+
+	recorder := serveFileHandler(HandlePutContainerFileContent, "PUT", "/server.properties", "motd=hello")
+	if recorder.Code != http.StatusConflict {
+		t.Fatalf("Writing a file of a locked container gave %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	if _, err := readTestFile(t, c, "/server.properties"); err != spec.ErrFileNotFound {
+		t.Errorf("Reading the file that was refused gave %v, want %v", err, spec.ErrFileNotFound)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHandleDeleteContainerFileStartedMeanwhile(t *testing.T) {
+	mock, teardown := setupHandlerTest(t)
+	defer teardown()
+
+	c := testContainer(42, StateStopped)
+	err := Runtime.WriteVolumeFile(DataVolumeForContainer(c), "/world/level.dat", strings.NewReader("level"), 5)
+	if err != nil {
+		t.Fatalf("Could not write file: %s", err)
+	}
+
+	// stopped when the request came in, but started by the time the lock was taken
+	expectFindContainer(mock, c)
+	expectFindContainer(mock, testContainer(42, StateStarting))
+
+	recorder := serveFileHandler(HandleDeleteContainerFile, "DELETE", "/world", "")
+	if recorder.Code != http.StatusConflict {
+		t.Fatalf("Deleting files of a container that was started gave %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	if _, err := readTestFile(t, c, "/world/level.dat"); err != nil {
+		t.Errorf("Reading the file that wasn't to be deleted gave %v", err)
+	}
+
+	if isLocked(c.Id) {
+		t.Error("Container is still locked after refusing to delete the files")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
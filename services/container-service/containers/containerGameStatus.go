@@ -0,0 +1,135 @@
+package containers
+
+import (
+	"bitbucket.org/smaug-hosting/services/cache"
+	"bitbucket.org/smaug-hosting/services/container-service/slp"
+	"bitbucket.org/smaug-hosting/services/container-service/software"
+	µ "bitbucket.org/smaug-hosting/services/micro"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis"
+	"github.com/sirupsen/logrus"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const gameStatusTimeout = 3 * time.Second
+
+var ErrNoStatusProbe = errors.New("software has no status protocol")
+
+// GameStatus is how the game server itself says it is doing, as opposed to what the orchestrator says.
+type GameStatus struct {
+	PlayersOnline int    `json:"players_online"`
+	PlayersMax    int    `json:"players_max"`
+	Version       string `json:"version"`
+	Motd          string `json:"motd"`
+	LatencyMs     int64  `json:"latency_ms"`
+}
+
+// queryGameStatus asks the container's game server for its status over the software's status protocol.
+func queryGameStatus(c Container) (GameStatus, error) {
+	var status GameStatus
+
+	sw, err := software.SoftwareRepository{}.FindByName(c.Software)
+	if err != nil {
+		return status, err
+	}
+
+	if sw.Status == nil || sw.Status.Protocol != software.StatusProtocolMinecraft {
+		return status, ErrNoStatusProbe
+	}
+
+	port, _ := sw.FindPort(sw.Status.PortName)
+
+	ip, published, err := Runtime.EndpointForPort(getServiceIdForContainer(c), port.Target)
+	if err != nil {
+		return status, err
+	}
+
+	ping, err := slp.Ping(net.JoinHostPort(ip, strconv.Itoa(int(published))), gameStatusTimeout)
+	if err != nil {
+		return status, err
+	}
+
+	status.PlayersOnline = ping.Players.Online
+	status.PlayersMax = ping.Players.Max
+	status.Version = ping.Version.Name
+	status.Motd = ping.Motd
+	status.LatencyMs = ping.Latency.Milliseconds()
+
+	return status, nil
+}
+
+func gameStatusKey(containerId int64) string {
+	return fmt.Sprintf("gamestatus.%d", containerId)
+}
+
+func gameStatusCacheTTL() time.Duration {
+	ttl, err := time.ParseDuration(µ.GetEnvDefault("GAME_STATUS_CACHE_TTL", "15s"))
+	if err != nil {
+		logrus.Errorf("Could not parse GAME_STATUS_CACHE_TTL: %s", err)
+		return 15 * time.Second
+	}
+	return ttl
+}
+
+// cacheGameStatus remembers the result of a status query for a short while.  A nil status records that the server
+// couldn't be asked, so that one which is still starting up isn't pinged on every request either.
+func cacheGameStatus(containerId int64, status *GameStatus) {
+	value, err := json.Marshal(status)
+	if err != nil {
+		logrus.Errorf("Could not encode game status of container %d: %s", containerId, err)
+		return
+	}
+
+	err = cache.Client.Set(gameStatusKey(containerId), value, gameStatusCacheTTL()).Err()
+	if err != nil {
+		logrus.Errorf("Could not cache game status of container %d: %s", containerId, err)
+	}
+}
+
+// getGameStatus returns the container's game status, from the cache if it was asked recently.  It returns nil if
+// the server couldn't tell us.
+func getGameStatus(c Container) *GameStatus {
+	cached, err := cache.Client.Get(gameStatusKey(c.Id)).Bytes()
+	if err == nil {
+		var status *GameStatus
+		if err := json.Unmarshal(cached, &status); err == nil {
+			return status
+		}
+	} else if err != redis.Nil {
+		logrus.Errorf("Could not read cached game status of container %d: %s", c.Id, err)
+	}
+
+	var status *GameStatus
+	queried, err := queryGameStatus(c)
+	if err == nil {
+		status = &queried
+	} else if err != ErrNoStatusProbe {
+		logrus.Debugf("Could not get game status of container %d: %s", c.Id, err)
+	}
+
+	cacheGameStatus(c.Id, status)
+
+	return status
+}
+
+// addGameStatuses fills in the game status of every container that is up, asking the servers in parallel so that
+// one slow server doesn't hold up the rest.
+func addGameStatuses(containers []Container) {
+	var wg sync.WaitGroup
+	for i := range containers {
+		if !containers[i].Status.Up {
+			continue
+		}
+		wg.Add(1)
+		go func(c *Container) {
+			defer wg.Done()
+			c.Status.Game = getGameStatus(*c)
+		}(&containers[i])
+	}
+	wg.Wait()
+}
@@ -1,18 +1,20 @@
 package containers
 
 import (
-	"bitbucket.org/smaug-hosting/services/billing/pricing"
-	"bitbucket.org/smaug-hosting/services/database"
+	"bitbucket.org/smaug-hosting/services/container-service/orchestrator/spec"
+	"bitbucket.org/smaug-hosting/services/container-service/software"
+	"bitbucket.org/smaug-hosting/services/idp/collaborators"
 	"bitbucket.org/smaug-hosting/services/idp/tokens"
-	"bitbucket.org/smaug-hosting/services/idp/users"
 	"bitbucket.org/smaug-hosting/services/libhttp"
+	"database/sql"
 	"encoding/json"
-	"github.com/Masterminds/squirrel"
+	"fmt"
 	"github.com/sirupsen/logrus"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"strconv"
+	"strings"
 )
 
 func HandleGetContainers(response http.ResponseWriter, request *http.Request) {
@@ -24,59 +26,101 @@ func HandleGetContainers(response http.ResponseWriter, request *http.Request) {
 		libhttp.SendError(http.StatusInternalServerError, "Could not fetch containers for user", response)
 		return
 	}
+	for i := range containers {
+		containers[i].Permissions = collaborators.Permissions{collaborators.PermOwner}
+	}
+
+	shared, err := getContainersSharedWithUser(claims.UserId)
+	if err != nil {
+		logrus.Errorf("Could not get containers shared with user: %s", err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not fetch containers for user", response)
+		return
+	}
+	containers = append(containers, shared...)
 
-	for i, c := range containers {
+	for i := range containers {
+		c := &containers[i]
 		c.Status, err = GetStatusForContainer(c)
 		if err != nil {
-			// hard-code status to be predictable if any error occurred while fetching the status
-			c.Status.Up = false
-			c.Status.State = "unknown"
+			// fall back on the last state we persisted if the orchestrator couldn't tell us anything
+			c.Status = statusFromState(c.State)
 		}
 		if c.Status.Up {
-			c.IP, c.Port, err = GetIpAndPortForContainer(c)
+			c.IP, c.Port, err = GetIpAndPortForContainer(*c)
 		}
-		containers[i] = c
+		c.Hostname = HostnameForContainer(*c)
 	}
 
+	addGameStatuses(containers)
+
 	libhttp.SendJson(containers, response)
 }
 
-func HandleStopContainer(response http.ResponseWriter, request *http.Request) {
+// ContainerForRequest fetches the container named in the request path, sending an error response (and returning
+// false) if it doesn't exist or the caller doesn't hold the permission on it.
+func ContainerForRequest(response http.ResponseWriter, request *http.Request, permission collaborators.Permission) (*Container, bool) {
 	claims := request.Context().Value("token_claims").(tokens.TokenClaims)
 	containerId := request.Context().Value("containerId").(string)
 
-	sql, params, err := squirrel.Select("user_id", "id", "software", "tier").From(tableName).Where("id = ?", containerId).ToSql()
+	container, status, message := loadContainerForUser(claims.UserId, containerId, permission)
+	if container == nil {
+		libhttp.SendError(status, message, response)
+		return nil, false
+	}
+
+	return container, true
+}
 
+// loadContainerForUser fetches the container with the given id as long as the user holds the permission on it, as
+// its owner or a collaborator.  If it can't, it returns a nil container along with the HTTP status and message
+// describing why.
+func loadContainerForUser(userId int64, containerId string, permission collaborators.Permission) (*Container, int, string) {
+	containerIdInt64, err := strconv.ParseInt(containerId, 10, 64)
 	if err != nil {
-		logrus.Debugf("Could not fetch container from db: %s", err)
-		libhttp.SendError(http.StatusInternalServerError, "Could not stop container", response)
-		return
+		logrus.Debugf("Could not parse container id: %s", err)
+		return nil, http.StatusBadRequest, "Invalid container id"
 	}
 
-	row := database.Connection.QueryRowx(sql, params...)
-	if row.Err() != nil {
+	container, err := ContainerRepository{}.FindById(containerIdInt64)
+	if err == sql.ErrNoRows {
+		return nil, http.StatusNotFound, "Container not found"
+	} else if err != nil {
 		logrus.Errorf("Could not fetch container from db: %s", err)
-		libhttp.SendError(http.StatusInternalServerError, "Could not stop container", response)
-		return
+		return nil, http.StatusInternalServerError, "Could not fetch container"
 	}
 
-	container := Container{}
-
-	err = row.StructScan(&container)
+	container.Permissions, err = PermissionsForUser(*container, userId)
 	if err != nil {
-		logrus.Errorf("Could not scan container into struct: %s", err)
-		libhttp.SendError(http.StatusInternalServerError, "Could not stop container", response)
-		return
+		logrus.Errorf("Could not fetch permissions of user %d on container %d: %s", userId, container.Id, err)
+		return nil, http.StatusInternalServerError, "Could not fetch container"
+	}
+
+	if len(container.Permissions) == 0 {
+		logrus.Warnf("User %d tried to access container %s, which isn't theirs or shared with them", userId, containerId)
+		return nil, http.StatusUnauthorized, "You can only manage your own containers and those shared with you"
 	}
 
-	if container.UserId != claims.UserId {
-		logrus.Warnf("Tried to delete a container that doesn't belong to him: %d (target container=%d)", claims.UserId, containerId)
-		libhttp.SendError(http.StatusUnauthorized, "You can only stop your own containers", response)
+	if !container.Permissions.Allows(permission) {
+		return nil, http.StatusForbidden, fmt.Sprintf("You need the %s permission on this container to do that", permission)
+	}
+
+	return container, 0, ""
+}
+
+func HandleStopContainer(response http.ResponseWriter, request *http.Request) {
+	container, ok := ContainerForRequest(response, request, collaborators.PermStartStop)
+	if !ok {
 		return
 	}
 
-	err = StopContainer(container)
-	if err != nil {
+	err := StopContainer(*container)
+	if err == ErrIllegalTransition || err == ErrStateConflict {
+		libhttp.SendError(http.StatusConflict, fmt.Sprintf("Could not stop container while it is %s", container.State), response)
+		return
+	} else if IsContainerLocked(err) {
+		libhttp.SendError(http.StatusConflict, fmt.Sprintf("Could not stop container: %s", err), response)
+		return
+	} else if err != nil {
 		logrus.Errorf("Could not stop container: %s", err)
 		libhttp.SendError(http.StatusInternalServerError, "Could not stop container", response)
 		return
@@ -90,6 +134,16 @@ type CreateContainerRequest struct {
 	Name     string
 	Software string
 	Tier     int
+	Config   ContainerConfig
+	// the software's defaults when left out
+	Version string `json:"version"`
+	Flavour string `json:"flavour"`
+	// defaults to true when left out
+	AutoStopIdle *bool `json:"auto_stop_idle"`
+	// everything but the name comes from the template when one is given
+	TemplateId *int64 `json:"template_id"`
+	// the new container starts out with the data of the backup, which has to be of the same software
+	BackupId *int64 `json:"backup_id"`
 }
 
 func HandlePostContainer(response http.ResponseWriter, request *http.Request) {
@@ -104,95 +158,361 @@ func HandlePostContainer(response http.ResponseWriter, request *http.Request) {
 
 	claims := request.Context().Value("token_claims").(tokens.TokenClaims)
 
-	price, err := pricing.PricingRepository{}.FindPriceBySoftwareAndTier(body.Software, body.Tier)
-	if err != nil {
-		logrus.Errorf("Could not fetch price from database: %s", err)
-		libhttp.SendError(http.StatusInternalServerError, "Could not fetch price for new container", response)
-		return
+	if body.TemplateId != nil {
+		template, ok := templateForUser(claims.UserId, *body.TemplateId, response)
+		if !ok {
+			return
+		}
+		backupId := body.BackupId
+		body = template.asRequest(body.Name)
+		body.BackupId = backupId
 	}
 
-	user, err := users.UserRepository{}.Find(claims.UserId)
-	if err != nil {
-		logrus.Errorf("Could not fetch user: %s", err)
-		libhttp.SendError(http.StatusInternalServerError, "Could not fetch user details for checking balance", response)
-		return
+	if body.BackupId != nil {
+		if Backups == nil {
+			libhttp.SendError(http.StatusBadRequest, "Containers can't be created from backups here", response)
+			return
+		}
+		if status, message := Backups.CheckRestore(claims.UserId, *body.BackupId, body.Software); status != 0 {
+			libhttp.SendError(status, message, response)
+			return
+		}
 	}
 
-	if !user.Verified {
-		libhttp.SendError(http.StatusForbidden, "You must verify your email address before you can spin up whelps", response)
+	container, ok := saveNewContainer(claims.UserId, body, nil, response)
+	if !ok {
 		return
 	}
 
-	if user.Balance <= price.Amount {
-		libhttp.SendError(http.StatusPaymentRequired, "You do not have sufficient funds to complete this request", response)
-		return
+	if body.BackupId != nil {
+		// nothing else may touch it until its data is in place
+		unlock, err := LockContainer(container.Id, "restoring a backup")
+		if err != nil {
+			logrus.Errorf("Could not lock new container %d to restore backup %d into it: %s", container.Id, *body.BackupId, err)
+			transitionState(&container, StateFailed, "could not restore backup")
+			libhttp.SendError(http.StatusInternalServerError, "Could not restore backup", response)
+			return
+		}
+
+		// asynchronously restore the backup, then bring the container live
+		go restoreNewContainer(container, *body.BackupId, unlock)
+	} else {
+		// asynchronously start spinning up the container to bring it live
+		go spinUpContainer(container)
 	}
 
-	container, err := ContainerRepository{}.Save(Container{
-		Name:     body.Name,
-		Tier:     body.Tier,
-		Software: body.Software,
-		UserId:   claims.UserId,
-	})
+	container.Status = statusFromState(container.State)
+
+	libhttp.SendJsonWithStatus(http.StatusCreated, container, response)
+}
 
-	// asynchronously start spinning up the container to bring it live
-	go spinUpContainer(container)
+// saveNewContainer runs the checks every new container has to pass (known software and tier, valid config, an owner
+// who is verified, can afford it and has room for it in their quota) and saves it, ready to be spun up.  If any of
+// them fail it sends an error response and returns false.  A nil release means the one chosen in the request.
+func saveNewContainer(userId int64, body CreateContainerRequest, release *Release, response http.ResponseWriter) (Container, bool) {
+	sw, err := software.SoftwareRepository{}.FindByName(body.Software)
+	if err == software.ErrSoftwareNotFound {
+		libhttp.SendError(http.StatusBadRequest, "Unknown software", response)
+		return Container{}, false
+	} else if err != nil {
+		logrus.Errorf("Could not look up software in catalog: %s", err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not look up software", response)
+		return Container{}, false
+	}
+
+	if !sw.AllowsTier(body.Tier) {
+		libhttp.SendError(http.StatusBadRequest, "That tier is not available for this software", response)
+		return Container{}, false
+	}
+
+	err = validateConfig(Container{Software: body.Software, Tier: body.Tier}, body.Config)
+	if configErr, ok := err.(software.ConfigError); ok {
+		libhttp.SendError(http.StatusBadRequest, configErr.Error(), response)
+		return Container{}, false
+	} else if err != nil {
+		logrus.Errorf("Could not validate config for new container: %s", err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not validate config", response)
+		return Container{}, false
+	}
 
+	if status, message := checkUserCanAfford(userId, body.Software, body.Tier); status != 0 {
+		libhttp.SendError(status, message, response)
+		return Container{}, false
+	}
+
+	// new containers are spun up straight away, so they need room to run as well
+	if status, message := checkQuota(Container{UserId: userId, Tier: body.Tier}, true); status != 0 {
+		libhttp.SendError(status, message, response)
+		return Container{}, false
+	}
+
+	if release == nil {
+		// last of the checks, as it pulls the image
+		newRelease, err := NewRelease(body.Software, body.Version, body.Flavour)
+		if configErr, ok := err.(software.ConfigError); ok {
+			libhttp.SendError(http.StatusBadRequest, configErr.Error(), response)
+			return Container{}, false
+		} else if err == spec.ErrImageNotFound {
+			logrus.WithField("severity", "CRITICAL").Errorf("Image for %s is not available: %s", body.Software, err)
+			libhttp.SendError(http.StatusServiceUnavailable, "This software is not available right now, please try again later", response)
+			return Container{}, false
+		} else if err != nil {
+			logrus.Errorf("Could not work out release for new container: %s", err)
+			libhttp.SendError(http.StatusInternalServerError, "Could not create container", response)
+			return Container{}, false
+		}
+		release = &newRelease
+	}
+
+	consolePassword, err := generateConsolePassword()
 	if err != nil {
-		logrus.Errorf("Could not save new container: %s", err)
+		logrus.Errorf("Could not generate console password: %s", err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not create container", response)
+		return Container{}, false
+	}
+
+	newContainer := Container{
+		Name:            body.Name,
+		Tier:            body.Tier,
+		Software:        body.Software,
+		UserId:          userId,
+		ConsolePassword: consolePassword,
+		Config:          body.Config,
+		AutoStopIdle:    body.AutoStopIdle == nil || *body.AutoStopIdle,
+		Release:         *release,
+	}
+
+	// the quota was checked above, but someone else may have taken the last slot while the image was pulled
+	var container Container
+	var saveErr error
+	err = WithinQuota(newContainer, true, func() error {
+		container, saveErr = ContainerRepository{}.Save(newContainer)
+		return saveErr
+	})
+
+	if saveErr != nil {
+		logrus.Errorf("Could not save new container: %s", saveErr)
 		libhttp.SendError(http.StatusInternalServerError, "Could not save new container to database", response)
-		return
+		return Container{}, false
+	} else if err != nil {
+		status, message := quotaError(newContainer, err)
+		libhttp.SendError(status, message, response)
+		return Container{}, false
 	}
 
-	libhttp.SendJsonWithStatus(http.StatusCreated, container, response)
+	return container, true
 }
 
-func ParseBody(body io.ReadCloser, target *CreateContainerRequest) error {
-	res, err := ioutil.ReadAll(body)
-	if err != nil {
-		return err
+// checkUserCanAfford wraps CheckUserCanAfford for handlers, returning the HTTP status and message to respond with
+// if the user can't run the software on the tier.
+func checkUserCanAfford(userId int64, softwareName string, tier int) (int, string) {
+	err := CheckUserCanAfford(userId, softwareName, tier)
+	switch err {
+	case nil:
+		return 0, ""
+	case ErrUnknownUser:
+		return http.StatusForbidden, "This account no longer exists"
+	case ErrUnverifiedUser:
+		return http.StatusForbidden, "You must verify your email address before you can spin up whelps"
+	case ErrInsufficientFunds:
+		return http.StatusPaymentRequired, "You do not have sufficient funds to complete this request"
+	default:
+		logrus.Errorf("Could not check whether user %d can afford tier %d of %s: %s", userId, tier, softwareName, err)
+		return http.StatusInternalServerError, "Could not check your balance"
 	}
-	return json.Unmarshal(res, target)
 }
 
-func HandleStartContainer(response http.ResponseWriter, request *http.Request) {
-	claims := request.Context().Value("token_claims").(tokens.TokenClaims)
-	containerId := request.Context().Value("containerId").(string)
+// checkQuota wraps CheckQuota for handlers, returning the HTTP status and message to respond with if the container
+// doesn't fit within its owner's quota or the platform's capacity.
+func checkQuota(c Container, up bool) (int, string) {
+	return quotaError(c, CheckQuota(c, up))
+}
+
+// quotaError returns the HTTP status and message to respond with for an error from CheckQuota (or WithinQuota).
+func quotaError(c Container, err error) (int, string) {
+	switch err {
+	case nil:
+		return 0, ""
+	case ErrTierNotAllowed:
+		return http.StatusForbidden, fmt.Sprintf("Your quota doesn't allow containers on tier %d", c.Tier)
+	case ErrTooManyContainers:
+		return http.StatusForbidden, "You already have as many containers as your quota allows"
+	case ErrTooManyRunning:
+		return http.StatusConflict, "You already have as many containers running as your quota allows, stop one first"
+	case ErrAtCapacity:
+		return http.StatusServiceUnavailable, "We're at capacity right now, please try again later"
+	case ErrQuotaBusy:
+		return http.StatusServiceUnavailable, "We're busy right now, please try again shortly"
+	default:
+		logrus.Errorf("Could not check quota of user %d for container %d: %s", c.UserId, c.Id, err)
+		return http.StatusInternalServerError, "Could not check your quota"
+	}
+}
+
+// PatchContainerRequest holds the fields being changed; any left out stay as they are.  An empty subdomain takes the
+// container's away.
+type PatchContainerRequest struct {
+	Name          *string `json:"name"`
+	Tier          *int    `json:"tier"`
+	AutoStopIdle  *bool   `json:"auto_stop_idle"`
+	WakeOnConnect *bool   `json:"wake_on_connect"`
+	Subdomain     *string `json:"subdomain"`
+}
 
-	sql, params, err := squirrel.Select("user_id", "id", "software", "tier").From(tableName).Where("id = ?", containerId).ToSql()
+func HandlePatchContainer(response http.ResponseWriter, request *http.Request) {
+	container, ok := ContainerForRequest(response, request, collaborators.PermAdmin)
+	if !ok {
+		return
+	}
 
+	body := PatchContainerRequest{}
+	err := libhttp.UnmarshalBody(request, response, &body)
 	if err != nil {
-		logrus.Debugf("Could not fetch container from db: %s", err)
-		libhttp.SendError(http.StatusInternalServerError, "Could not stop container", response)
 		return
 	}
 
-	row := database.Connection.QueryRowx(sql, params...)
-	if row.Err() != nil {
-		logrus.Errorf("Could not fetch container from db: %s", err)
-		libhttp.SendError(http.StatusInternalServerError, "Could not stop container", response)
+	if body.Name != nil && *body.Name == "" {
+		libhttp.SendError(http.StatusBadRequest, "Please provide a name", response)
 		return
 	}
 
-	container := Container{}
+	// everything is checked before anything is changed, so that a request that fails changes nothing
+	settings := *container
+	if body.Name != nil {
+		settings.Name = *body.Name
+	}
+	if body.AutoStopIdle != nil {
+		settings.AutoStopIdle = *body.AutoStopIdle
+	}
+	if body.WakeOnConnect != nil {
+		settings.WakeOnConnect = *body.WakeOnConnect
+	}
+	if body.Subdomain != nil && *body.Subdomain == "" {
+		settings.Subdomain = nil
+	} else if body.Subdomain != nil {
+		subdomain := strings.ToLower(*body.Subdomain)
+		if status, message := checkSubdomain(*container, subdomain); status != 0 {
+			libhttp.SendError(status, message, response)
+			return
+		}
 
-	err = row.StructScan(&container)
-	if err != nil {
-		logrus.Errorf("Could not scan container into struct: %s", err)
-		libhttp.SendError(http.StatusInternalServerError, "Could not stop container", response)
-		return
+		settings.Subdomain = &subdomain
+	}
+
+	changingTier := body.Tier != nil && *body.Tier != container.Tier
+	if changingTier {
+		// the tier sets the price, so only whoever pays for the container gets to change it
+		if !container.Permissions.Allows(collaborators.PermOwner) {
+			libhttp.SendError(http.StatusForbidden, "Only the container's owner can change its tier", response)
+			return
+		}
+
+		if container.State != StateRunning && container.State != StateStopped {
+			libhttp.SendError(http.StatusConflict, fmt.Sprintf("Could not change tier while the container is %s", container.State), response)
+			return
+		}
+
+		sw, err := software.SoftwareRepository{}.FindByName(container.Software)
+		if err != nil {
+			logrus.Errorf("Could not look up software in catalog: %s", err)
+			libhttp.SendError(http.StatusInternalServerError, "Could not look up software", response)
+			return
+		}
+
+		if !sw.AllowsTier(*body.Tier) {
+			libhttp.SendError(http.StatusBadRequest, "That tier is not available for this software", response)
+			return
+		}
+
+		// settings such as the player cap may be over the new tier's limits
+		moved := *container
+		moved.Tier = *body.Tier
+		err = validateConfig(moved, container.Config)
+		if configErr, ok := err.(software.ConfigError); ok {
+			libhttp.SendError(http.StatusBadRequest, fmt.Sprintf("Your settings don't fit the new tier (%s)", configErr), response)
+			return
+		} else if err != nil {
+			logrus.Errorf("Could not validate config for tier change: %s", err)
+			libhttp.SendError(http.StatusInternalServerError, "Could not validate config", response)
+			return
+		}
+
+		if status, message := checkUserCanAfford(container.UserId, container.Software, *body.Tier); status != 0 {
+			libhttp.SendError(status, message, response)
+			return
+		}
+
+		if status, message := checkQuota(moved, container.State == StateRunning); status != 0 {
+			libhttp.SendError(status, message, response)
+			return
+		}
 	}
 
-	if container.UserId != claims.UserId {
-		logrus.Warnf("Tried to delete a container that doesn't belong to him: %d (target container=%d)", claims.UserId, containerId)
-		libhttp.SendError(http.StatusUnauthorized, "You can only stop your own containers", response)
+	previous := *container
+
+	err = ContainerRepository{}.SaveSettings(container, settings)
+	if err == ErrSubdomainTaken {
+		libhttp.SendError(http.StatusConflict, "That subdomain is already taken", response)
+		return
+	} else if err != nil {
+		logrus.Errorf("Could not save settings of container %d: %s", container.Id, err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not save settings", response)
 		return
 	}
 
-	err = startContainer(container)
+	if changingTier {
+		err = changeTier(container, *body.Tier)
+		if err != nil {
+			// changeTier puts the old tier back itself, the other settings are put back here
+			rollbackErr := ContainerRepository{}.SaveSettings(container, previous)
+			if rollbackErr != nil {
+				logrus.WithField("severity", "CRITICAL").Errorf("Could not put back settings of container %d: %s", container.Id, rollbackErr)
+			}
+		}
+		if err == ErrTierNotApplied {
+			libhttp.SendError(http.StatusBadGateway, "Could not move the container onto the new tier, it has been left on its old one", response)
+			return
+		} else if err != nil {
+			logrus.Errorf("Could not change tier of container %d: %s", container.Id, err)
+			libhttp.SendError(http.StatusInternalServerError, "Could not change tier", response)
+			return
+		}
+	}
+
+	container.Status = statusFromState(container.State)
+	container.Hostname = HostnameForContainer(*container)
+
+	libhttp.SendJson(container, response)
+}
+
+func ParseBody(body io.ReadCloser, target *CreateContainerRequest) error {
+	res, err := ioutil.ReadAll(body)
 	if err != nil {
-		logrus.Errorf("Could not stop container: %s", err)
-		libhttp.SendError(http.StatusInternalServerError, "Could not stop container", response)
+		return err
+	}
+	return json.Unmarshal(res, target)
+}
+
+func HandleStartContainer(response http.ResponseWriter, request *http.Request) {
+	container, ok := ContainerForRequest(response, request, collaborators.PermStartStop)
+	if !ok {
+		return
+	}
+
+	err := StartContainer(*container)
+	if err == ErrIllegalTransition || err == ErrStateConflict {
+		libhttp.SendError(http.StatusConflict, fmt.Sprintf("Could not start container while it is %s", container.State), response)
+		return
+	} else if IsContainerLocked(err) {
+		libhttp.SendError(http.StatusConflict, fmt.Sprintf("Could not start container: %s", err), response)
+		return
+	} else if IsQuotaError(err) {
+		status, message := quotaError(*container, err)
+		libhttp.SendError(status, message, response)
+		return
+	} else if err != nil {
+		logrus.Errorf("Could not start container: %s", err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not start container", response)
 		return
 	}
 
@@ -201,26 +521,14 @@ func HandleStartContainer(response http.ResponseWriter, request *http.Request) {
 }
 
 func HandleDeleteContainer(response http.ResponseWriter, request *http.Request) {
-	claims := request.Context().Value("token_claims").(tokens.TokenClaims)
-	containerId := request.Context().Value("containerId").(string)
-
-	containerIdInt64, err := strconv.ParseInt(containerId, 10, 64)
-	if err != nil {
-		logrus.Errorf("Could not parse container id: %s", err)
-		libhttp.SendError(http.StatusInternalServerError, "could not delete container, invalid id", response)
+	container, ok := ContainerForRequest(response, request, collaborators.PermOwner)
+	if !ok {
 		return
 	}
 
-	container, err := ContainerRepository{}.FindById(containerIdInt64)
-	if err != nil {
-		logrus.Errorf("Could not fetch whelp for deletion: %s", err)
-		libhttp.SendError(http.StatusInternalServerError, "could not fetch whelp for deletion", response)
-		return
-	}
+	status, err := GetStatusForContainer(container)
 
-	status, err := GetStatusForContainer(*container)
-
-	if err != nil {
+	if err != nil && err != spec.ErrServiceNotFound {
 		logrus.Errorf("Could not determine status of container: %s", err)
 		libhttp.SendError(http.StatusInternalServerError, "could not determine if whelp is ready for deletion", response)
 		return
@@ -231,29 +539,28 @@ func HandleDeleteContainer(response http.ResponseWriter, request *http.Request)
 		return
 	}
 
-	sql, params, err := squirrel.Delete(tableName).Where("user_id = ? AND id = ?", claims.UserId, containerId).ToSql()
-	if err != nil {
-		logrus.Errorf("Could not delete container: %s", err)
+	unlock, err := LockContainer(container.Id, "deleting")
+	if IsContainerLocked(err) {
+		libhttp.SendError(http.StatusConflict, fmt.Sprintf("Could not delete container: %s", err), response)
+		return
+	} else if err != nil {
+		logrus.Errorf("Could not lock container for deletion: %s", err)
 		libhttp.SendError(http.StatusInternalServerError, "could not delete container", response)
 		return
 	}
-
-	result, err := database.Connection.Exec(sql, params...)
-	if err != nil {
-		logrus.Errorf("Could not delete container: %s", err)
+	// once it is deleting nothing else will touch it, so the lock only has to cover the transition
+	err = ContainerRepository{}.TransitionState(container, StateDeleting, "")
+	unlock()
+	if err == ErrIllegalTransition || err == ErrStateConflict {
+		libhttp.SendError(http.StatusConflict, fmt.Sprintf("Could not delete container while it is %s", container.State), response)
+		return
+	} else if err != nil {
+		logrus.Errorf("Could not mark container for deletion: %s", err)
 		libhttp.SendError(http.StatusInternalServerError, "could not delete container", response)
 		return
 	}
 
-	if rows, err := result.RowsAffected(); rows < 1 {
-		if err != nil {
-			logrus.Errorf("Could not verify container was deleted: %s", err)
-			libhttp.SendError(http.StatusInternalServerError, "could not verify container was deleted (please try again)", response)
-			return
-		}
-		logrus.Warnf("Duplicate delete on container: %d", containerId)
-	}
-
+	// the row is only removed once the service has gone, until then the container shows as deleting
 	go removeContainer(*container)
 
 	// empty 200 response if everything went well
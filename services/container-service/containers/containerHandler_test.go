@@ -0,0 +1,496 @@
+package containers
+
+import (
+	"bitbucket.org/smaug-hosting/services/cache"
+	"bitbucket.org/smaug-hosting/services/container-service/orchestrator"
+	"bitbucket.org/smaug-hosting/services/container-service/orchestrator/spec"
+	"bitbucket.org/smaug-hosting/services/container-service/software"
+	"bitbucket.org/smaug-hosting/services/container-service/tiers"
+	"bitbucket.org/smaug-hosting/services/database"
+	"bitbucket.org/smaug-hosting/services/idp/tokens"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+	"github.com/jmoiron/sqlx"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The handlers run against the in-memory orchestrator, a fake redis and a mocked database, whose expectations spell
+// out the queries each of them is meant to make.
+
+const testUserId = 7
+
+func TestMain(m *testing.M) {
+	software.Setup("../software/conf/catalog.json")
+	tiers.Setup("../tiers/conf/tiers.json")
+	os.Exit(m.Run())
+}
+
+func setupHandlerTest(t *testing.T) (sqlmock.Sqlmock, func()) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Could not mock database: %s", err)
+	}
+	database.Connection = sqlx.NewDb(db, "mysql")
+
+	redisServer, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Could not start fake redis: %s", err)
+	}
+	cache.Client = redis.NewClient(&redis.Options{Addr: redisServer.Addr()})
+
+	Runtime, err = orchestrator.GetOrchestratorInstance(orchestrator.BackendMemory, nil)
+	if err != nil {
+		t.Fatalf("Could not set up orchestrator: %s", err)
+	}
+
+	return mock, func() {
+		_ = cache.Client.Close()
+		redisServer.Close()
+		_ = db.Close()
+	}
+}
+
+func testContainer(id int64, state State) Container {
+	return Container{
+		Id:              id,
+		Name:            "survival",
+		Tier:            1,
+		Software:        "minecraft",
+		UserId:          testUserId,
+		State:           state,
+		ConsolePassword: "hunter2",
+		Release:         Release{Image: "itzg/minecraft-server:20190824"},
+	}
+}
+
+func containerRows(containers ...Container) *sqlmock.Rows {
+	rows := sqlmock.NewRows(containerColumns)
+	for _, c := range containers {
+		rows.AddRow([]driver.Value{
+			c.Name, c.Tier, c.Software, c.UserId, c.Id, string(c.State), c.LastError, c.StateChangedAt, c.CreatedAt,
+			c.ConsolePassword, []byte("{}"), c.ServiceName, c.AutoStopIdle, c.WakeOnConnect, nil, c.Image, c.Version,
+			c.Flavour,
+		}...)
+	}
+	return rows
+}
+
+// createTestService creates the container's service, with the given number of replicas, as if it had been spun up
+// before the test.
+func createTestService(t *testing.T, c Container, replicas uint64) {
+	serviceSpec, err := getServiceSpecForContainer(c, &replicas)
+	if err != nil {
+		t.Fatalf("Could not build service spec: %s", err)
+	}
+	err = Runtime.Create(serviceSpec)
+	if err != nil {
+		t.Fatalf("Could not create service: %s", err)
+	}
+}
+
+func expectUser(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery("FROM verified_users WHERE id = ?").
+		WithArgs(testUserId).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "password_hash", "roles", "balance"}).
+			AddRow(testUserId, "player@example.com", []byte("hash"), []byte("user"), 1000000))
+}
+
+var quotaColumns = []string{"user_id", "max_containers", "max_running", "max_tier"}
+
+// expectQuotaCheck expects CheckQuota to find the user on their roles' quota, owning the given containers.
+func expectQuotaCheck(mock sqlmock.Sqlmock, owned ...Container) {
+	mock.ExpectQuery("FROM quotas WHERE user_id = ?").
+		WithArgs(testUserId).
+		WillReturnRows(sqlmock.NewRows(quotaColumns))
+	expectUser(mock)
+	mock.ExpectQuery("FROM containers WHERE user_id").
+		WithArgs(testUserId).
+		WillReturnRows(containerRows(owned...))
+}
+
+// expectWithinQuota expects WithinQuota to take the quota lock, check the quota and make its change.
+func expectWithinQuota(mock sqlmock.Sqlmock, change func(), owned ...Container) {
+	mock.ExpectQuery("SELECT GET_LOCK").
+		WithArgs("whelp_quota_7", quotaLockTimeoutSeconds).
+		WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(1))
+	expectQuotaCheck(mock, owned...)
+	change()
+	mock.ExpectQuery("SELECT RELEASE_LOCK").
+		WithArgs("whelp_quota_7").
+		WillReturnRows(sqlmock.NewRows([]string{"released"}).AddRow(1))
+}
+
+func expectFindContainer(mock sqlmock.Sqlmock, c Container) {
+	mock.ExpectQuery("FROM containers WHERE id = ?").
+		WithArgs(c.Id).
+		WillReturnRows(containerRows(c))
+}
+
+func expectTransition(mock sqlmock.Sqlmock, c Container, target State) {
+	mock.ExpectExec("UPDATE containers SET").
+		WithArgs("", string(target), sqlmock.AnyArg(), c.Id, c.State).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func serveHandler(handler http.HandlerFunc, method string, containerId string, body string) *httptest.ResponseRecorder {
+	return serveHandlerAs(testUserId, handler, method, containerId, body)
+}
+
+// serveHandlerAs serves the request as some other user, such as a collaborator on the test user's container.
+func serveHandlerAs(userId int64, handler http.HandlerFunc, method string, containerId string, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, "/containers/", strings.NewReader(body))
+	ctx := context.WithValue(request.Context(), "token_claims", tokens.TokenClaims{UserId: userId})
+	ctx = context.WithValue(ctx, "containerId", containerId)
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, request.WithContext(ctx))
+	return recorder
+}
+
+// waitFor polls until the condition holds, for whatever the handler left running in the background.
+func waitFor(t *testing.T, what string, condition func() bool) {
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHandlePostContainer(t *testing.T) {
+	mock, teardown := setupHandlerTest(t)
+	defer teardown()
+
+	mock.ExpectQuery("FROM prices WHERE software = ").
+		WithArgs("minecraft", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"amount", "software", "tier"}).AddRow(100, "minecraft", 1))
+	expectUser(mock)
+	expectQuotaCheck(mock)
+	expectWithinQuota(mock, func() {
+		mock.ExpectExec("INSERT INTO containers").WillReturnResult(sqlmock.NewResult(42, 1))
+	})
+
+	recorder := serveHandler(HandlePostContainer, "POST", "", `{"name": "survival", "software": "minecraft", "tier": 1}`)
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("Creating a container gave %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	created := Container{}
+	err := json.Unmarshal(recorder.Body.Bytes(), &created)
+	if err != nil {
+		t.Fatalf("Could not parse created container: %s", err)
+	}
+	if created.Id != 42 || created.State != StateProvisioning {
+		t.Errorf("Created container %d in state %s, want 42 in state %s", created.Id, created.State, StateProvisioning)
+	}
+
+	// the service is created once the response has gone
+	name := "whelp-minecraft-7-1-42"
+	waitFor(t, "the service to be created", func() bool {
+		status, err := Runtime.Status(name)
+		return err == nil && status.Up
+	})
+
+	_, port, err := Runtime.Endpoint(name)
+	if err != nil || port == 0 {
+		t.Errorf("Service was published on port %d (%v), want an allocated port", port, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHandleStartContainer(t *testing.T) {
+	mock, teardown := setupHandlerTest(t)
+	defer teardown()
+
+	c := testContainer(42, StateStopped)
+	createTestService(t, c, 0)
+
+	expectFindContainer(mock, c)
+	expectWithinQuota(mock, func() {
+		expectTransition(mock, c, StateStarting)
+	}, c)
+
+	recorder := serveHandler(HandleStartContainer, "POST", "42", "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Starting a container gave %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	status, err := Runtime.Status(getServiceIdForContainer(c))
+	if err != nil || !status.Up {
+		t.Errorf("Service is %+v (%v) after starting, want it up", status, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHandleStartContainerOverQuota(t *testing.T) {
+	mock, teardown := setupHandlerTest(t)
+	defer teardown()
+
+	_ = os.Setenv("QUOTA_USER_MAX_RUNNING", "1")
+	defer os.Unsetenv("QUOTA_USER_MAX_RUNNING")
+
+	c := testContainer(42, StateStopped)
+	running := testContainer(43, StateRunning)
+	createTestService(t, c, 0)
+
+	expectFindContainer(mock, c)
+	// the quota turns it away before anything is changed
+	expectWithinQuota(mock, func() {}, c, running)
+
+	recorder := serveHandler(HandleStartContainer, "POST", "42", "")
+	if recorder.Code != http.StatusConflict {
+		t.Fatalf("Starting a container over quota gave %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	status, err := Runtime.Status(getServiceIdForContainer(c))
+	if err != nil || status.Up {
+		t.Errorf("Service is %+v (%v) after being refused, want it still down", status, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHandleStopContainer(t *testing.T) {
+	mock, teardown := setupHandlerTest(t)
+	defer teardown()
+
+	c := testContainer(42, StateRunning)
+	createTestService(t, c, 1)
+
+	expectFindContainer(mock, c)
+	expectTransition(mock, c, StateStopping)
+
+	recorder := serveHandler(HandleStopContainer, "POST", "42", "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Stopping a container gave %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	status, err := Runtime.Status(getServiceIdForContainer(c))
+	if err != nil || status.Up {
+		t.Errorf("Service is %+v (%v) after stopping, want it down", status, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHandleDeleteContainer(t *testing.T) {
+	mock, teardown := setupHandlerTest(t)
+	defer teardown()
+
+	c := testContainer(42, StateStopped)
+	createTestService(t, c, 0)
+
+	expectFindContainer(mock, c)
+	expectTransition(mock, c, StateDeleting)
+	// the rows only go once the service has
+	mock.ExpectExec("DELETE FROM collaborators WHERE container_id = ?").
+		WithArgs(c.Id).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM containers WHERE id = ?").
+		WithArgs(c.Id).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	recorder := serveHandler(HandleDeleteContainer, "DELETE", "42", "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Deleting a container gave %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	waitFor(t, "the container to be removed", func() bool {
+		return mock.ExpectationsWereMet() == nil
+	})
+
+	_, err := Runtime.Status(getServiceIdForContainer(c))
+	if err != spec.ErrServiceNotFound {
+		t.Errorf("Service status after deleting gave %v, want %v", err, spec.ErrServiceNotFound)
+	}
+}
+
+func TestHandleDeleteContainerStillUp(t *testing.T) {
+	mock, teardown := setupHandlerTest(t)
+	defer teardown()
+
+	c := testContainer(42, StateRunning)
+	createTestService(t, c, 1)
+
+	expectFindContainer(mock, c)
+
+	recorder := serveHandler(HandleDeleteContainer, "DELETE", "42", "")
+	if recorder.Code == http.StatusOK {
+		t.Fatalf("Deleted a container that is still up")
+	}
+
+	if _, err := Runtime.Status(getServiceIdForContainer(c)); err != nil {
+		t.Errorf("Service status after refusing to delete gave %v, want it still there", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHandleStartContainerLocked(t *testing.T) {
+	mock, teardown := setupHandlerTest(t)
+	defer teardown()
+
+	c := testContainer(42, StateStopped)
+	createTestService(t, c, 0)
+
+	unlock, err := LockContainer(c.Id, "restoring a backup")
+	if err != nil {
+		t.Fatalf("Could not lock container: %s", err)
+	}
+
+	// nothing is changed while something else holds the lock
+	expectFindContainer(mock, c)
+
+	recorder := serveHandler(HandleStartContainer, "POST", "42", "")
+	if recorder.Code != http.StatusConflict || !strings.Contains(recorder.Body.String(), "restoring a backup") {
+		t.Fatalf("Starting a locked container gave %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	// and once it is let go, the container can be started again
+	unlock()
+	expectFindContainer(mock, c)
+	expectWithinQuota(mock, func() {
+		expectTransition(mock, c, StateStarting)
+	}, c)
+
+	recorder = serveHandler(HandleStartContainer, "POST", "42", "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Starting a container after unlocking it gave %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHandleDeleteContainerLocked(t *testing.T) {
+	mock, teardown := setupHandlerTest(t)
+	defer teardown()
+
+	c := testContainer(42, StateStopped)
+	createTestService(t, c, 0)
+
+	unlock, err := LockContainer(c.Id, "upgrading")
+	if err != nil {
+		t.Fatalf("Could not lock container: %s", err)
+	}
+	defer unlock()
+
+	expectFindContainer(mock, c)
+
+	recorder := serveHandler(HandleDeleteContainer, "DELETE", "42", "")
+	if recorder.Code != http.StatusConflict {
+		t.Fatalf("Deleting a locked container gave %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// fakeRestorer stands in for the backups package, which depends on this one.
+type fakeRestorer struct {
+	restored chan int64
+}
+
+func (f fakeRestorer) CheckRestore(userId int64, backupId int64, software string) (int, string) {
+	if backupId != 9 || userId != testUserId {
+		return http.StatusNotFound, "Backup not found"
+	}
+	return 0, ""
+}
+
+func (f fakeRestorer) RestoreInto(backupId int64, c Container) error {
+	if !isLocked(c.Id) {
+		return fmt.Errorf("container %d was restored into without being locked", c.Id)
+	}
+	f.restored <- backupId
+	return nil
+}
+
+func TestHandlePostContainerFromBackup(t *testing.T) {
+	mock, teardown := setupHandlerTest(t)
+	defer teardown()
+
+	restored := make(chan int64, 1)
+	Backups = fakeRestorer{restored: restored}
+	defer func() { Backups = nil }()
+
+	mock.ExpectQuery("FROM prices WHERE software = ").
+		WithArgs("minecraft", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"amount", "software", "tier"}).AddRow(100, "minecraft", 1))
+	expectUser(mock)
+	expectQuotaCheck(mock)
+	expectWithinQuota(mock, func() {
+		mock.ExpectExec("INSERT INTO containers").WillReturnResult(sqlmock.NewResult(42, 1))
+	})
+
+	recorder := serveHandler(HandlePostContainer, "POST", "", `{"name": "survival", "software": "minecraft", "tier": 1, "backup_id": 9}`)
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("Creating a container from a backup gave %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	select {
+	case backupId := <-restored:
+		if backupId != 9 {
+			t.Errorf("Restored backup %d, want 9", backupId)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the backup to be restored")
+	}
+
+	// the service is only created once the data is in place
+	waitFor(t, "the service to be created", func() bool {
+		status, err := Runtime.Status("whelp-minecraft-7-1-42")
+		return err == nil && status.Up
+	})
+
+	if isLocked(42) {
+		t.Error("Container is still locked after its backup was restored")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHandlePostContainerFromOthersBackup(t *testing.T) {
+	mock, teardown := setupHandlerTest(t)
+	defer teardown()
+
+	Backups = fakeRestorer{}
+	defer func() { Backups = nil }()
+
+	// turned away before anything is looked up or saved
+	recorder := serveHandler(HandlePostContainer, "POST", "", `{"name": "survival", "software": "minecraft", "tier": 1, "backup_id": 10}`)
+	if recorder.Code != http.StatusNotFound {
+		t.Fatalf("Creating a container from someone else's backup gave %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
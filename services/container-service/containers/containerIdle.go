@@ -0,0 +1,161 @@
+package containers
+
+import (
+	"bitbucket.org/smaug-hosting/services/cache"
+	"bitbucket.org/smaug-hosting/services/idp/email"
+	"bitbucket.org/smaug-hosting/services/idp/users"
+	"bitbucket.org/smaug-hosting/services/metrics"
+	µ "bitbucket.org/smaug-hosting/services/micro"
+	"fmt"
+	"github.com/go-redis/redis"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"time"
+)
+
+func idleKey(containerId int64) string {
+	return fmt.Sprintf("idle.%d", containerId)
+}
+
+// StartIdleDetector periodically pings every running container and stops any that have had nobody online for
+// IDLE_STOP_AFTER, unless their owner has opted out.  Setting IDLE_STOP_AFTER to 0 turns this off.
+func StartIdleDetector() {
+	stopAfter, err := time.ParseDuration(µ.GetEnvDefault("IDLE_STOP_AFTER", "30m"))
+	if err != nil {
+		stopAfter = 30 * time.Minute
+		logrus.Errorf("Could not parse duration for idle detector: %s", err)
+	}
+
+	if stopAfter <= 0 {
+		logrus.Infof("Idle detector is turned off")
+		return
+	}
+
+	interval, err := time.ParseDuration(µ.GetEnvDefault("IDLE_CHECK_INTERVAL", "1m"))
+	if err != nil {
+		interval = time.Minute
+		logrus.Errorf("Could not parse check interval for idle detector: %s", err)
+	}
+
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		for range ticker.C {
+			detectIdle(stopAfter, interval)
+		}
+	}()
+}
+
+func detectIdle(stopAfter time.Duration, interval time.Duration) {
+	allContainers, err := ContainerRepository{}.All()
+	if err != nil {
+		logrus.Errorf("Could not fetch containers to check for idleness: %s", err)
+		return
+	}
+
+	for _, c := range allContainers {
+		if c.State != StateRunning || !c.AutoStopIdle {
+			continue
+		}
+
+		status, err := queryGameStatus(c)
+		if err == ErrNoStatusProbe {
+			continue
+		} else if err != nil {
+			// could be anything from a server that is still starting up to a network blip, neither of which tells
+			// us whether anybody is playing
+			logrus.Debugf("Could not get game status of container %d: %s", c.Id, err)
+			continue
+		}
+
+		// saves the next container listing from asking again
+		cacheGameStatus(c.Id, &status)
+
+		if status.PlayersOnline > 0 {
+			clearIdleSince(c.Id)
+			continue
+		}
+
+		idleSince, err := markIdle(c.Id, stopAfter+2*interval)
+		if err != nil {
+			logrus.Errorf("Could not record idleness of container %d: %s", c.Id, err)
+			continue
+		}
+
+		if time.Since(idleSince) >= stopAfter {
+			stopIdleContainer(c, time.Since(idleSince))
+		}
+	}
+}
+
+// markIdle records that the container has nobody online, returning when it was first seen that way.  The record
+// expires on its own (a little after the container would have been stopped) so that a stale one can never stop a
+// container the moment it next comes up.
+func markIdle(containerId int64, expiry time.Duration) (time.Time, error) {
+	key := idleKey(containerId)
+	now := time.Now()
+
+	_, err := cache.Client.SetNX(key, now.Unix(), expiry).Result()
+	if err != nil {
+		return now, err
+	}
+
+	since, err := cache.Client.Get(key).Result()
+	if err == redis.Nil {
+		// expired in between, so it is idle as of now
+		return now, nil
+	} else if err != nil {
+		return now, err
+	}
+
+	unix, err := strconv.ParseInt(since, 10, 64)
+	if err != nil {
+		return now, err
+	}
+
+	return time.Unix(unix, 0), nil
+}
+
+func clearIdleSince(containerId int64) {
+	err := cache.Client.Del(idleKey(containerId)).Err()
+	if err != nil {
+		logrus.Errorf("Could not clear idleness of container %d: %s", containerId, err)
+	}
+}
+
+func stopIdleContainer(c Container, idleFor time.Duration) {
+	// only whichever replica manages to delete the record goes on to stop the container, so owners don't get
+	// several emails about it
+	deleted, err := cache.Client.Del(idleKey(c.Id)).Result()
+	if err != nil || deleted == 0 {
+		return
+	}
+
+	logrus.Infof("Stopping container %d after %s with nobody online", c.Id, idleFor.Round(time.Minute))
+
+	err = StopContainer(c)
+	if IsContainerLocked(err) {
+		// it will still be idle next time round, if whatever is going on leaves it that way
+		logrus.Infof("Not stopping idle container %d: %s", c.Id, err)
+		return
+	} else if err != nil {
+		logrus.Errorf("Could not stop idle container %d: %s", c.Id, err)
+		return
+	}
+	metrics.Increment("idle.stopped")
+
+	user, err := users.UserRepository{}.Find(c.UserId)
+	if err != nil {
+		logrus.Errorf("Could not fetch owner of idle container %d to notify them: %s", c.Id, err)
+		return
+	}
+	if user == nil {
+		logrus.Warnf("Owner %d of idle container %d no longer exists, not notifying anyone", c.UserId, c.Id)
+		return
+	}
+
+	err = email.SendIdleStopEmail(user.Email, c.Name, idleFor)
+	if err != nil {
+		logrus.Errorf("Could not notify user %d that container %d was stopped: %s", user.Id, c.Id, err)
+	}
+}
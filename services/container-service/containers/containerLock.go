@@ -0,0 +1,101 @@
+package containers
+
+import (
+	"bitbucket.org/smaug-hosting/services/cache"
+	"fmt"
+	"github.com/go-redis/redis"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+// how long a lock outlives the container-service replica holding it, should that die part way through (a restore
+// or upgrade of a big world can take a while, so this is generous)
+const containerLockTTL = 6 * time.Hour
+
+func lockKey(containerId int64) string {
+	return fmt.Sprintf("containers.lock.%d", containerId)
+}
+
+// ContainerLockedError is returned when something else is in the middle of changing the container.
+type ContainerLockedError struct {
+	// what whoever holds the lock is doing, e.g. "restoring a backup"
+	Operation string
+}
+
+func (e ContainerLockedError) Error() string {
+	if e.Operation == "" {
+		return "container is busy"
+	}
+	return fmt.Sprintf("container is busy %s", e.Operation)
+}
+
+// IsContainerLocked reports whether the error is a ContainerLockedError.
+func IsContainerLocked(err error) bool {
+	_, ok := err.(ContainerLockedError)
+	return ok
+}
+
+// only deletes the lock if it is still the one we took, it may have expired and been taken by someone else since
+// KEYS: lock.  ARGV: value it was taken with
+var unlockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// LockContainer keeps everything else that takes the container's lock (starting, stopping or deleting it, restoring
+// a backup into it, changing its files and upgrading it) off the container until the returned unlock is called.  If
+// something else already holds it, a ContainerLockedError saying what is returned.
+func LockContainer(containerId int64, operation string) (func(), error) {
+	// the value is unique to this holder, so that unlocking can't release somebody else's lock
+	value := uuid.New().String() + " " + operation
+
+	locked, err := cache.Client.SetNX(lockKey(containerId), value, containerLockTTL).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	if !locked {
+		operation, err := LockedFor(containerId)
+		if err != nil {
+			return nil, err
+		}
+		return nil, ContainerLockedError{Operation: operation}
+	}
+
+	return func() {
+		err := unlockScript.Run(cache.Client, []string{lockKey(containerId)}, value).Err()
+		if err != nil {
+			logrus.Errorf("Could not release lock of container %d: %s", containerId, err)
+		}
+	}, nil
+}
+
+// LockedFor returns what whoever holds the container's lock is doing, or nothing if it isn't locked.
+func LockedFor(containerId int64) (string, error) {
+	holder, err := cache.Client.Get(lockKey(containerId)).Result()
+	if err == redis.Nil {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	if parts := strings.SplitN(holder, " ", 2); len(parts) == 2 {
+		return parts[1], nil
+	}
+	return "", nil
+}
+
+// isLocked reports whether something is in the middle of changing the container.
+func isLocked(containerId int64) bool {
+	count, err := cache.Client.Exists(lockKey(containerId)).Result()
+	if err != nil {
+		// assume it is, leaving it alone is the safer mistake
+		logrus.Errorf("Could not check whether container %d is locked: %s", containerId, err)
+		return true
+	}
+	return count > 0
+}
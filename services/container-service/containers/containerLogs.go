@@ -0,0 +1,179 @@
+package containers
+
+import (
+	"bitbucket.org/smaug-hosting/services/container-service/orchestrator/spec"
+	"bitbucket.org/smaug-hosting/services/idp/collaborators"
+	"bitbucket.org/smaug-hosting/services/idp/tokens"
+	"bitbucket.org/smaug-hosting/services/libhttp"
+	"bitbucket.org/smaug-hosting/services/libws"
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultLogTail = 100
+	maxLogTail     = 10000
+	// longest log line we stream, a server dumping a huge stack trace or NBT blob on one line mustn't end the stream
+	maxLogLineBytes = 1024 * 1024
+)
+
+var errInvalidLogOptions = errors.New("invalid log options")
+
+// logOptionsFromQuery reads tail, since (RFC3339) and follow from the query string.
+func logOptionsFromQuery(query url.Values) (spec.LogOptions, error) {
+	options := spec.LogOptions{Tail: defaultLogTail}
+
+	if tail := query.Get("tail"); tail != "" {
+		t, err := strconv.Atoi(tail)
+		if err != nil || t < 1 || t > maxLogTail {
+			return options, errInvalidLogOptions
+		}
+		options.Tail = t
+	}
+
+	if since := query.Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return options, errInvalidLogOptions
+		}
+		options.Since = t
+	}
+
+	options.Follow = query.Get("follow") == "true"
+
+	return options, nil
+}
+
+// HandleGetContainerLogs downloads the last lines of a container's log as plain text.
+func HandleGetContainerLogs(response http.ResponseWriter, request *http.Request) {
+	container, ok := ContainerForRequest(response, request, collaborators.PermView)
+	if !ok {
+		return
+	}
+
+	options, err := logOptionsFromQuery(request.URL.Query())
+	if err != nil {
+		libhttp.SendError(http.StatusBadRequest, fmt.Sprintf("tail must be between 1 and %d and since must be an RFC3339 timestamp", maxLogTail), response)
+		return
+	}
+	// a download has to end at some point
+	options.Follow = false
+
+	stream, err := Runtime.Logs(getServiceIdForContainer(*container), options)
+	if err == spec.ErrServiceNotFound {
+		libhttp.SendError(http.StatusNotFound, "Container has no logs yet", response)
+		return
+	} else if err != nil {
+		logrus.Errorf("Could not fetch logs for container %d: %s", container.Id, err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not fetch logs", response)
+		return
+	}
+	defer stream.Close()
+
+	response.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	response.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", container.Name+".log"))
+	response.WriteHeader(http.StatusOK)
+
+	_, err = io.Copy(response, stream)
+	if err != nil {
+		logrus.Warnf("Could not send logs for container %d: %s", container.Id, err)
+	}
+}
+
+// HandleContainerLogsWebsocket streams a container's log over a websocket, one message per line.  Browsers can't
+// set headers on websocket requests, so the caller is authenticated from the token in the libws handshake instead
+// of by the usual middleware.
+func HandleContainerLogsWebsocket(response http.ResponseWriter, request *http.Request) {
+	conn, session, err := libws.Upgrade(response, request)
+	if err != nil {
+		logrus.Debugf("Could not upgrade websocket for logs: %s", err)
+		return
+	}
+	defer conn.Close()
+
+	sendError := func(message string) {
+		err := libws.Write(conn, libws.WSMessage{
+			Subject: "error",
+			Body:    map[string]interface{}{"message": message},
+		})
+		if err != nil {
+			logrus.Debugf("Could not send error over websocket: %s", err)
+		}
+	}
+
+	claims := tokens.TokenClaims{}
+	err = tokens.ParseToken(session.Token, &claims)
+	if err != nil {
+		logrus.Debugf("Error parsing token: %s", err)
+		sendError("You are not permitted to perform this request")
+		return
+	}
+
+	container, _, message := loadContainerForUser(claims.UserId, request.Context().Value("containerId").(string), collaborators.PermView)
+	if container == nil {
+		sendError(message)
+		return
+	}
+
+	options, err := logOptionsFromQuery(request.URL.Query())
+	if err != nil {
+		sendError(fmt.Sprintf("tail must be between 1 and %d and since must be an RFC3339 timestamp", maxLogTail))
+		return
+	}
+
+	stream, err := Runtime.Logs(getServiceIdForContainer(*container), options)
+	if err != nil {
+		logrus.Errorf("Could not fetch logs for container %d: %s", container.Id, err)
+		sendError("Could not fetch logs")
+		return
+	}
+	defer stream.Close()
+
+	// we never expect anything from the client after the handshake, so a failed read means it has gone away
+	gone := make(chan struct{})
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				close(gone)
+				_ = stream.Close()
+				return
+			}
+		}
+	}()
+
+	scanner := bufio.NewScanner(stream)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLogLineBytes)
+	for scanner.Scan() {
+		err = libws.Write(conn, libws.WSMessage{
+			Subject: "log",
+			Body:    map[string]interface{}{"line": scanner.Text()},
+		})
+		if err != nil {
+			logrus.Debugf("Stopped streaming logs for container %d: %s", container.Id, err)
+			return
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		select {
+		case <-gone:
+			// we closed the stream ourselves, there is nobody left to tell
+			return
+		default:
+		}
+
+		logrus.Warnf("Could not stream logs for container %d: %s", container.Id, err)
+		if err == bufio.ErrTooLong {
+			sendError(fmt.Sprintf("Stopped streaming logs at a line longer than %d bytes", maxLogLineBytes))
+		} else {
+			sendError("Could not read logs")
+		}
+	}
+}
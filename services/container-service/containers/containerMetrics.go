@@ -0,0 +1,231 @@
+package containers
+
+import (
+	"bitbucket.org/smaug-hosting/services/cache"
+	"bitbucket.org/smaug-hosting/services/container-service/orchestrator/spec"
+	"bitbucket.org/smaug-hosting/services/container-service/tiers"
+	"bitbucket.org/smaug-hosting/services/idp/collaborators"
+	"bitbucket.org/smaug-hosting/services/libhttp"
+	µ "bitbucket.org/smaug-hosting/services/micro"
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// only one replica collects on each tick, whichever takes this lock first
+const metricsLockKey = "metrics.collector"
+
+func metricsKey(containerId int64) string {
+	return fmt.Sprintf("metrics.%d", containerId)
+}
+
+// MetricsSample is one reading of a container's resource usage.
+type MetricsSample struct {
+	Time             time.Time `json:"time"`
+	CPUPercent       float64   `json:"cpu_percent"`
+	MemoryBytes      uint64    `json:"memory_bytes"`
+	MemoryLimitBytes uint64    `json:"memory_limit_bytes"`
+	// totals since the container last started
+	NetworkRxBytes uint64 `json:"network_rx_bytes"`
+	NetworkTxBytes uint64 `json:"network_tx_bytes"`
+	// nil if the volume driver can't tell us
+	VolumeBytes *int64 `json:"volume_bytes"`
+}
+
+type MetricsLimits struct {
+	CPUs           float64 `json:"cpus"`
+	MemoryBytes    int64   `json:"memory_bytes"`
+	DiskQuotaBytes int64   `json:"disk_quota_bytes"`
+}
+
+type ContainerMetrics struct {
+	// nil unless the container is running
+	Current *MetricsSample  `json:"current"`
+	History []MetricsSample `json:"history"`
+	Limits  MetricsLimits   `json:"limits"`
+}
+
+// StartMetricsCollector periodically samples the resource usage of every running container, keeping the last
+// METRICS_HISTORY samples of each.
+func StartMetricsCollector() {
+	interval, err := time.ParseDuration(µ.GetEnvDefault("METRICS_INTERVAL", "1m"))
+	if err != nil {
+		interval = time.Minute
+		logrus.Errorf("Could not parse duration for metrics collector: %s", err)
+	}
+
+	history, err := strconv.ParseInt(µ.GetEnvDefault("METRICS_HISTORY", "60"), 10, 64)
+	if err != nil || history < 1 {
+		history = 60
+		logrus.Errorf("Invalid METRICS_HISTORY, keeping 60 samples")
+	}
+
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		for range ticker.C {
+			locked, err := cache.Client.SetNX(metricsLockKey, 1, interval/2).Result()
+			if err != nil {
+				logrus.Errorf("Could not take metrics collector lock: %s", err)
+				continue
+			}
+			if locked {
+				collectMetrics(interval, history)
+			}
+		}
+	}()
+}
+
+func collectMetrics(interval time.Duration, history int64) {
+	allContainers, err := ContainerRepository{}.All()
+	if err != nil {
+		logrus.Errorf("Could not fetch containers to collect metrics for: %s", err)
+		return
+	}
+
+	// one call for every volume, as working out volume sizes is slow
+	volumeSizes, err := Runtime.VolumeSizes(servicePrefix)
+	if err != nil {
+		logrus.Errorf("Could not get volume sizes: %s", err)
+	}
+
+	for _, c := range allContainers {
+		if c.State != StateRunning {
+			continue
+		}
+
+		sample, err := sampleMetrics(c)
+		if err == spec.ErrNotRunning || err == spec.ErrServiceNotFound {
+			continue
+		} else if err != nil {
+			logrus.Debugf("Could not get stats for container %d: %s", c.Id, err)
+			continue
+		}
+
+		sample.VolumeBytes = volumeBytesForContainer(c, volumeSizes)
+
+		err = recordMetrics(c.Id, sample, history, time.Duration(history)*interval*2)
+		if err != nil {
+			logrus.Errorf("Could not record metrics for container %d: %s", c.Id, err)
+		}
+	}
+}
+
+func sampleMetrics(c Container) (MetricsSample, error) {
+	stats, err := Runtime.Stats(getServiceIdForContainer(c))
+	if err != nil {
+		return MetricsSample{}, err
+	}
+
+	return MetricsSample{
+		Time:             time.Now(),
+		CPUPercent:       stats.CPUPercent,
+		MemoryBytes:      stats.MemoryBytes,
+		MemoryLimitBytes: stats.MemoryLimitBytes,
+		NetworkRxBytes:   stats.NetworkRxBytes,
+		NetworkTxBytes:   stats.NetworkTxBytes,
+	}, nil
+}
+
+// volumeBytesForContainer adds up the sizes of all the container's volumes, or returns nil if any can't be measured.
+func volumeBytesForContainer(c Container, volumeSizes map[string]int64) *int64 {
+	volumes, err := getVolumesForContainer(c)
+	if err != nil || len(volumes) == 0 {
+		return nil
+	}
+
+	var total int64
+	for _, v := range volumes {
+		size, ok := volumeSizes[v]
+		if !ok {
+			return nil
+		}
+		total += size
+	}
+	return &total
+}
+
+func recordMetrics(containerId int64, sample MetricsSample, history int64, expiry time.Duration) error {
+	value, err := json.Marshal(sample)
+	if err != nil {
+		return err
+	}
+
+	key := metricsKey(containerId)
+
+	pipe := cache.Client.TxPipeline()
+	pipe.LPush(key, value)
+	pipe.LTrim(key, 0, history-1)
+	// history of a deleted container goes away on its own
+	pipe.Expire(key, expiry)
+	_, err = pipe.Exec()
+
+	return err
+}
+
+// getMetricsHistory returns the container's recorded samples, oldest first.
+func getMetricsHistory(containerId int64) ([]MetricsSample, error) {
+	values, err := cache.Client.LRange(metricsKey(containerId), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	samples := make([]MetricsSample, len(values))
+	for i, value := range values {
+		// stored newest first
+		err = json.Unmarshal([]byte(value), &samples[len(values)-1-i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return samples, nil
+}
+
+func HandleGetContainerMetrics(response http.ResponseWriter, request *http.Request) {
+	container, ok := ContainerForRequest(response, request, collaborators.PermView)
+	if !ok {
+		return
+	}
+
+	tier, err := tiers.TierRepository{}.FindByTier(container.Tier)
+	if err != nil {
+		logrus.Errorf("Could not find tier %d for container %d: %s", container.Tier, container.Id, err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not fetch metrics", response)
+		return
+	}
+
+	history, err := getMetricsHistory(container.Id)
+	if err != nil {
+		logrus.Errorf("Could not fetch metrics history for container %d: %s", container.Id, err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not fetch metrics", response)
+		return
+	}
+
+	metrics := ContainerMetrics{
+		History: history,
+		Limits: MetricsLimits{
+			CPUs:           tier.CPUs,
+			MemoryBytes:    tier.MemoryBytes(),
+			DiskQuotaBytes: tier.DiskQuotaBytes(),
+		},
+	}
+
+	if container.State == StateRunning {
+		current, err := sampleMetrics(*container)
+		if err == nil {
+			// measuring volumes is too slow to do on request, so the last collected size will have to do
+			if len(history) > 0 {
+				current.VolumeBytes = history[len(history)-1].VolumeBytes
+			}
+			metrics.Current = &current
+		} else if err != spec.ErrNotRunning {
+			logrus.Warnf("Could not get current stats for container %d: %s", container.Id, err)
+		}
+	}
+
+	libhttp.SendJson(metrics, response)
+}
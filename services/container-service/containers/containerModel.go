@@ -1,18 +1,47 @@
 package containers
 
+import (
+	"bitbucket.org/smaug-hosting/services/idp/collaborators"
+	"time"
+)
+
 type ContainerStatus struct {
 	Up    bool   `json:"up"`
 	State string `json:"state"`
+	// only filled in for containers that are up, and only if the game server answered
+	Game *GameStatus `json:"game,omitempty"`
+}
+
+// Release is what a container runs: the image, and the version and flavour of the game the image is told to run.
+// Empty fields stand for the software's current defaults, which is how containers created before they could be
+// chosen run.
+type Release struct {
+	Image   string `json:"image"`
+	Version string `json:"version"`
+	Flavour string `json:"flavour"`
 }
 
 type Container struct {
-	Id        int64           `json:"id"`
-	Name      string          `json:"name"`
-	Tier      int             `json:"tier"`
-	Software  string          `json:"software"`
-	UserId    int64           `json:"-" db:"user_id"`
-	LastError string          `json:"last_error"`
-	Status    ContainerStatus `json:"status"`
-	IP        string          `json:"ip"`
-	Port      uint32             `json:"port"`
+	Id              int64           `json:"id"`
+	Name            string          `json:"name"`
+	Tier            int             `json:"tier"`
+	Software        string          `json:"software"`
+	UserId          int64           `json:"-" db:"user_id"`
+	State           State           `json:"state"`
+	LastError       string          `json:"last_error" db:"last_error"`
+	StateChangedAt  time.Time       `json:"state_changed_at" db:"state_changed_at"`
+	CreatedAt       time.Time       `json:"created_at" db:"created_at"`
+	ConsolePassword string          `json:"-" db:"console_password"`
+	Config          ContainerConfig `json:"-" db:"config"`
+	ServiceName     string          `json:"-" db:"service_name"`
+	AutoStopIdle    bool            `json:"auto_stop_idle" db:"auto_stop_idle"`
+	WakeOnConnect   bool            `json:"wake_on_connect" db:"wake_on_connect"`
+	Subdomain       *string         `json:"subdomain" db:"subdomain"`
+	Hostname        string          `json:"hostname,omitempty" db:"-"`
+	Status          ContainerStatus `json:"status" db:"-"`
+	IP              string          `json:"ip" db:"-"`
+	Port            uint32          `json:"port" db:"-"`
+	// what the user the container was fetched for may do with it
+	Permissions collaborators.Permissions `json:"permissions" db:"-"`
+	Release
 }
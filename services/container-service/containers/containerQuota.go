@@ -0,0 +1,222 @@
+package containers
+
+import (
+	"bitbucket.org/smaug-hosting/services/container-service/quotas"
+	"bitbucket.org/smaug-hosting/services/container-service/tiers"
+	"bitbucket.org/smaug-hosting/services/database"
+	"bitbucket.org/smaug-hosting/services/idp/users"
+	"bitbucket.org/smaug-hosting/services/metrics"
+	µ "bitbucket.org/smaug-hosting/services/micro"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"strconv"
+)
+
+var ErrTierNotAllowed = errors.New("tier is above the user's quota")
+var ErrTooManyContainers = errors.New("user has as many containers as their quota allows")
+var ErrTooManyRunning = errors.New("user has as many containers running as their quota allows")
+var ErrAtCapacity = errors.New("the platform is at capacity")
+var ErrQuotaBusy = errors.New("timed out waiting to check the quota")
+
+// how long to wait for someone else's quota check to finish before giving up on ours
+const quotaLockTimeoutSeconds = 10
+
+// the states in which a container is (or is about to be) taking up resources on the swarm
+var activeStates = []State{StateProvisioning, StateStarting, StateRunning, StateStopping}
+
+// IsActive reports whether a container in the state is taking up resources on the swarm.
+func (s State) IsActive() bool {
+	for _, active := range activeStates {
+		if s == active {
+			return true
+		}
+	}
+	return false
+}
+
+// getCapacityLimit reads one of the platform's capacity limits from the env, 0 meaning there is no limit.
+func getCapacityLimit(name string) int64 {
+	limit, err := strconv.ParseInt(µ.GetEnvDefault(name, "0"), 10, 64)
+	if err != nil {
+		logrus.Errorf("Could not parse %s, not limiting capacity by it: %s", name, err)
+		return 0
+	}
+	return limit
+}
+
+// CheckQuota makes sure the container's owner may have it on its tier and, if it is to be up, that both they and
+// the platform have room for it to run.  The container may be a new one that hasn't been saved yet, or one that is
+// about to be handed over to them.  Containers they already have are let be on the tier they are on, even if their
+// quota has since been lowered.
+func CheckQuota(c Container, up bool) error {
+	quota, err := quotas.QuotaForUser(c.UserId)
+	if err != nil {
+		return err
+	}
+
+	owned, err := ContainerRepository{}.GetContainersForUser(c.UserId)
+	if err != nil {
+		return err
+	}
+
+	// count the container itself whether or not it is theirs yet
+	count, running, isNew, tierChanged := 1, 0, true, true
+	for _, other := range owned {
+		if other.Id == c.Id {
+			isNew = false
+			tierChanged = other.Tier != c.Tier
+			continue
+		}
+		count++
+		if other.State.IsActive() {
+			running++
+		}
+	}
+
+	if tierChanged && !users.WithinLimit(quota.MaxTier, c.Tier) {
+		return ErrTierNotAllowed
+	}
+
+	if isNew && !users.WithinLimit(quota.MaxContainers, count) {
+		return ErrTooManyContainers
+	}
+
+	if up && !users.WithinLimit(quota.MaxRunning, running+1) {
+		return ErrTooManyRunning
+	}
+
+	return checkCapacity(c, up)
+}
+
+// IsQuotaError reports whether the error is one of the ways a container can fail to fit within its owner's quota or
+// the platform's capacity.
+func IsQuotaError(err error) bool {
+	switch err {
+	case ErrTierNotAllowed, ErrTooManyContainers, ErrTooManyRunning, ErrAtCapacity, ErrQuotaBusy:
+		return true
+	}
+	return false
+}
+
+// WithinQuota makes the change to the container (saving it, starting it etc.) if CheckQuota lets it, holding the
+// quota lock so that nothing else can be checked against the same quota until the change has been made.  Without
+// it, two requests could both be let into the last free slot.
+func WithinQuota(c Container, up bool, change func() error) error {
+	unlock, err := lockQuota(c.UserId)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	err = CheckQuota(c, up)
+	if err != nil {
+		return err
+	}
+
+	return change()
+}
+
+// lockQuota takes the quota lock for the user, returning the func that releases it.  While the platform has
+// capacity limits everyone shares them, so there is only the one lock.  It is a MySQL named lock, which belongs to
+// the connection that took it, so that connection is kept out of the pool until the lock is released.
+func lockQuota(userId int64) (func(), error) {
+	name := fmt.Sprintf("whelp_quota_%d", userId)
+	if capacityLimited() {
+		name = "whelp_quota"
+	}
+
+	ctx := context.Background()
+	conn, err := database.Connection.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// NULL if something went wrong, 0 if someone else held on to it for too long
+	var locked sql.NullInt64
+	err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", name, quotaLockTimeoutSeconds).Scan(&locked)
+	if err == nil && (!locked.Valid || locked.Int64 != 1) {
+		err = ErrQuotaBusy
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return func() {
+		var released sql.NullInt64
+		err := conn.QueryRowContext(ctx, "SELECT RELEASE_LOCK(?)", name).Scan(&released)
+		if err != nil {
+			logrus.Errorf("Could not release quota lock %s: %s", name, err)
+		}
+		_ = conn.Close()
+	}, nil
+}
+
+// capacityLimited reports whether any of the platform's capacity limits are set.
+func capacityLimited() bool {
+	for _, name := range []string{"CAPACITY_MAX_CONTAINERS", "CAPACITY_MAX_RUNNING", "CAPACITY_MAX_RESERVED_MEMORY_MB"} {
+		if getCapacityLimit(name) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// checkCapacity makes sure the platform as a whole has room for the container, as limited by CAPACITY_MAX_CONTAINERS,
+// CAPACITY_MAX_RUNNING and CAPACITY_MAX_RESERVED_MEMORY_MB (the memory reserved by the tiers of running containers).
+func checkCapacity(c Container, up bool) error {
+	if maxContainers := getCapacityLimit("CAPACITY_MAX_CONTAINERS"); c.Id == 0 && maxContainers > 0 {
+		count, err := ContainerRepository{}.Count()
+		if err != nil {
+			return err
+		}
+		if int64(count+1) > maxContainers {
+			return ErrAtCapacity
+		}
+	}
+
+	if !up {
+		return nil
+	}
+
+	maxRunning := getCapacityLimit("CAPACITY_MAX_RUNNING")
+	maxReservedMB := getCapacityLimit("CAPACITY_MAX_RESERVED_MEMORY_MB")
+	if maxRunning == 0 && maxReservedMB == 0 {
+		return nil
+	}
+
+	active, err := ContainerRepository{}.FindInStates(activeStates)
+	if err != nil {
+		return err
+	}
+
+	tier, err := tiers.TierRepository{}.FindByTier(c.Tier)
+	if err != nil {
+		return err
+	}
+
+	running, reservedMB := int64(1), tier.ReservedMemoryMB
+	for _, other := range active {
+		if other.Id == c.Id {
+			continue
+		}
+		running++
+
+		otherTier, err := tiers.TierRepository{}.FindByTier(other.Tier)
+		if err != nil {
+			return err
+		}
+		reservedMB += otherTier.ReservedMemoryMB
+	}
+
+	if (maxRunning > 0 && running > maxRunning) || (maxReservedMB > 0 && reservedMB > maxReservedMB) {
+		metrics.Increment("capacity.rejected")
+		logrus.Warnf("Turned away container %d: platform at capacity with %d running, %d MB reserved", c.Id, running-1, reservedMB-tier.ReservedMemoryMB)
+		return ErrAtCapacity
+	}
+
+	return nil
+}
@@ -0,0 +1,236 @@
+package containers
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"os"
+	"testing"
+)
+
+// expectOverriddenQuotaCheck is expectQuotaCheck for a user an admin set some limits for, nil for those left NULL.
+func expectOverriddenQuotaCheck(mock sqlmock.Sqlmock, maxContainers, maxRunning, maxTier interface{}, owned ...Container) {
+	mock.ExpectQuery("FROM quotas WHERE user_id = ?").
+		WithArgs(testUserId).
+		WillReturnRows(sqlmock.NewRows(quotaColumns).AddRow(testUserId, maxContainers, maxRunning, maxTier))
+	expectUser(mock)
+	mock.ExpectQuery("FROM containers WHERE user_id").
+		WithArgs(testUserId).
+		WillReturnRows(containerRows(owned...))
+}
+
+func setEnv(t *testing.T, values map[string]string) func() {
+	for name, value := range values {
+		if err := os.Setenv(name, value); err != nil {
+			t.Fatalf("Could not set %s: %s", name, err)
+		}
+	}
+	return func() {
+		for name := range values {
+			_ = os.Unsetenv(name)
+		}
+	}
+}
+
+func TestCheckQuota(t *testing.T) {
+	running := testContainer(43, StateRunning)
+	stopped := testContainer(44, StateStopped)
+	bigger := testContainer(45, StateStopped)
+	bigger.Tier = 3
+
+	cases := []struct {
+		name   string
+		env    map[string]string
+		expect func(mock sqlmock.Sqlmock)
+		c      Container
+		up     bool
+		want   error
+	}{
+		{
+			name:   "unlimited by default",
+			expect: func(mock sqlmock.Sqlmock) { expectQuotaCheck(mock, running, stopped) },
+			c:      testContainer(0, StateProvisioning),
+			up:     true,
+		},
+		{
+			name:   "role limit on containers",
+			env:    map[string]string{"QUOTA_USER_MAX_CONTAINERS": "2"},
+			expect: func(mock sqlmock.Sqlmock) { expectQuotaCheck(mock, running, stopped) },
+			c:      testContainer(0, StateProvisioning),
+			want:   ErrTooManyContainers,
+		},
+		{
+			name:   "containers they have already don't count twice",
+			env:    map[string]string{"QUOTA_USER_MAX_CONTAINERS": "2"},
+			expect: func(mock sqlmock.Sqlmock) { expectQuotaCheck(mock, running, stopped) },
+			c:      stopped,
+		},
+		{
+			name:   "role limit on running containers",
+			env:    map[string]string{"QUOTA_USER_MAX_RUNNING": "1"},
+			expect: func(mock sqlmock.Sqlmock) { expectQuotaCheck(mock, running, stopped) },
+			c:      stopped,
+			up:     true,
+			want:   ErrTooManyRunning,
+		},
+		{
+			name:   "running limit only applies to starting",
+			env:    map[string]string{"QUOTA_USER_MAX_RUNNING": "1"},
+			expect: func(mock sqlmock.Sqlmock) { expectQuotaCheck(mock, running, stopped) },
+			c:      stopped,
+		},
+		{
+			name:   "role limit on tiers",
+			env:    map[string]string{"QUOTA_USER_MAX_TIER": "0"},
+			expect: func(mock sqlmock.Sqlmock) { expectQuotaCheck(mock) },
+			c:      testContainer(0, StateProvisioning),
+			want:   ErrTierNotAllowed,
+		},
+		{
+			name:   "containers stay on a tier above a since lowered limit",
+			env:    map[string]string{"QUOTA_USER_MAX_TIER": "1"},
+			expect: func(mock sqlmock.Sqlmock) { expectQuotaCheck(mock, bigger) },
+			c:      bigger,
+			up:     true,
+		},
+		{
+			name: "an admin's limit replaces the role's",
+			env:  map[string]string{"QUOTA_USER_MAX_RUNNING": "1"},
+			expect: func(mock sqlmock.Sqlmock) {
+				expectOverriddenQuotaCheck(mock, nil, 2, nil, running, stopped)
+			},
+			c:  stopped,
+			up: true,
+		},
+		{
+			name: "limits the admin left alone follow the role's",
+			env:  map[string]string{"QUOTA_USER_MAX_TIER": "0"},
+			expect: func(mock sqlmock.Sqlmock) {
+				expectOverriddenQuotaCheck(mock, nil, 2, nil)
+			},
+			c:    testContainer(0, StateProvisioning),
+			want: ErrTierNotAllowed,
+		},
+		{
+			name: "an admin can lift a role's limit",
+			env:  map[string]string{"QUOTA_USER_MAX_CONTAINERS": "2"},
+			expect: func(mock sqlmock.Sqlmock) {
+				expectOverriddenQuotaCheck(mock, -1, nil, nil, running, stopped)
+			},
+			c: testContainer(0, StateProvisioning),
+		},
+		{
+			name: "platform limit on containers",
+			env:  map[string]string{"CAPACITY_MAX_CONTAINERS": "10"},
+			expect: func(mock sqlmock.Sqlmock) {
+				expectQuotaCheck(mock)
+				mock.ExpectQuery("SELECT COUNT").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(10))
+			},
+			c:    testContainer(0, StateProvisioning),
+			want: ErrAtCapacity,
+		},
+		{
+			name: "platform limit on running containers",
+			env:  map[string]string{"CAPACITY_MAX_RUNNING": "1"},
+			expect: func(mock sqlmock.Sqlmock) {
+				expectQuotaCheck(mock, stopped)
+				mock.ExpectQuery("FROM containers WHERE state IN").
+					WillReturnRows(containerRows(testContainer(100, StateRunning)))
+			},
+			c:    stopped,
+			up:   true,
+			want: ErrAtCapacity,
+		},
+		{
+			name: "platform limit on reserved memory",
+			// tier 1 reserves 1536 MB, so there is room for one more of them but not two
+			env: map[string]string{"CAPACITY_MAX_RESERVED_MEMORY_MB": "3072"},
+			expect: func(mock sqlmock.Sqlmock) {
+				expectQuotaCheck(mock, stopped)
+				mock.ExpectQuery("FROM containers WHERE state IN").
+					WillReturnRows(containerRows(testContainer(100, StateRunning)))
+			},
+			c:  stopped,
+			up: true,
+		},
+		{
+			name: "platform out of reserved memory",
+			env:  map[string]string{"CAPACITY_MAX_RESERVED_MEMORY_MB": "3071"},
+			expect: func(mock sqlmock.Sqlmock) {
+				expectQuotaCheck(mock, stopped)
+				mock.ExpectQuery("FROM containers WHERE state IN").
+					WillReturnRows(containerRows(testContainer(100, StateRunning)))
+			},
+			c:    stopped,
+			up:   true,
+			want: ErrAtCapacity,
+		},
+	}
+
+	for _, c := range cases {
+		mock, teardown := setupHandlerTest(t)
+		unsetEnv := setEnv(t, c.env)
+
+		c.expect(mock)
+		err := CheckQuota(c.c, c.up)
+		if err != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, err, c.want)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: %s", c.name, err)
+		}
+
+		unsetEnv()
+		teardown()
+	}
+}
+
+func TestWithinQuotaSharesLockWhenCapacityLimited(t *testing.T) {
+	mock, teardown := setupHandlerTest(t)
+	defer teardown()
+	defer setEnv(t, map[string]string{"CAPACITY_MAX_RUNNING": "10"})()
+
+	c := testContainer(42, StateStopped)
+
+	mock.ExpectQuery("SELECT GET_LOCK").
+		WithArgs("whelp_quota", quotaLockTimeoutSeconds).
+		WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(1))
+	expectQuotaCheck(mock, c)
+	mock.ExpectQuery("FROM containers WHERE state IN").WillReturnRows(containerRows())
+	mock.ExpectQuery("SELECT RELEASE_LOCK").
+		WithArgs("whelp_quota").
+		WillReturnRows(sqlmock.NewRows([]string{"released"}).AddRow(1))
+
+	changed := false
+	err := WithinQuota(c, true, func() error {
+		changed = true
+		return nil
+	})
+	if err != nil || !changed {
+		t.Errorf("WithinQuota gave %v and made the change: %v, want it made", err, changed)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWithinQuotaBusy(t *testing.T) {
+	mock, teardown := setupHandlerTest(t)
+	defer teardown()
+
+	// someone else held on to the lock for too long
+	mock.ExpectQuery("SELECT GET_LOCK").
+		WithArgs("whelp_quota_7", quotaLockTimeoutSeconds).
+		WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(0))
+
+	err := WithinQuota(testContainer(42, StateStopped), true, func() error {
+		t.Error("Made the change without the quota lock")
+		return nil
+	})
+	if err != ErrQuotaBusy || !IsQuotaError(err) {
+		t.Errorf("WithinQuota without the lock gave %v, want %v", err, ErrQuotaBusy)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
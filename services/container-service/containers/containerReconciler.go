@@ -0,0 +1,152 @@
+package containers
+
+import (
+	"bitbucket.org/smaug-hosting/services/container-service/orchestrator/spec"
+	"bitbucket.org/smaug-hosting/services/container-service/ports"
+	"bitbucket.org/smaug-hosting/services/metrics"
+	µ "bitbucket.org/smaug-hosting/services/micro"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+const servicePrefix = "whelp-"
+
+// StartReconciler periodically converges the orchestrator's whelp services with the containers table.
+func StartReconciler() {
+	interval, err := time.ParseDuration(µ.GetEnvDefault("RECONCILE_INTERVAL", "1m"))
+	if err != nil {
+		interval = time.Minute
+		logrus.Errorf("Could not parse duration for reconciler: %s", err)
+	}
+
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		for range ticker.C {
+			reconcile(interval)
+		}
+	}()
+}
+
+// reconcile makes one pass over every container.  Anything that changed state more recently than the grace period
+// is left alone, as whatever request changed it is probably still working on it.
+func reconcile(grace time.Duration) {
+	log := logrus.WithField("component", "reconciler")
+	metrics.Increment("reconciler.runs")
+
+	// list services before fetching containers, otherwise a container created in between would look orphaned
+	serviceNames, err := Runtime.List(servicePrefix)
+	if err != nil {
+		log.Errorf("Could not list services: %s", err)
+		metrics.Increment("reconciler.errors")
+		return
+	}
+
+	allContainers, err := ContainerRepository{}.All()
+	if err != nil {
+		log.Errorf("Could not fetch containers: %s", err)
+		metrics.Increment("reconciler.errors")
+		return
+	}
+
+	services := make(map[string]bool, len(serviceNames))
+	for _, name := range serviceNames {
+		services[name] = true
+	}
+
+	expected := make(map[string]bool, len(allContainers))
+
+	for _, c := range allContainers {
+		serviceId := getServiceIdForContainer(c)
+		expected[serviceId] = true
+
+		if time.Since(c.StateChangedAt) < grace {
+			continue
+		}
+
+		if c.State == StateDeleting {
+			log.Infof("Retrying removal of container %d (service %s)", c.Id, serviceId)
+			metrics.Increment("reconciler.removals_retried")
+			removeContainer(c)
+			continue
+		}
+
+		if services[serviceId] {
+			repairPort(c, serviceId)
+			continue
+		}
+
+		// a failed container is left for its owner to deal with, as we can't tell whether it was meant to be up (and
+		// bringing it back would start billing them for it), or whether its data is all there (e.g. a failed clone)
+		if c.State == StateFailed {
+			continue
+		}
+
+		// clones have no service until their data has been copied over, however long that takes
+		if c.State == StateProvisioning && isCloning(c.Id) {
+			continue
+		}
+
+		// whatever holds the lock is in the middle of changing the container, and brings its service up itself
+		if isLocked(c.Id) {
+			continue
+		}
+
+		// only bring the service back up if it's supposed to be up
+		var replicas *uint64
+		if c.State == StateStopped || c.State == StateStopping {
+			zero := uint64(0)
+			replicas = &zero
+		}
+
+		log.Warnf("Service %s for container %d is missing, recreating it", serviceId, c.Id)
+		err = createService(c, replicas)
+		if err != nil && err != spec.ErrServiceExists {
+			log.Errorf("Could not recreate service %s: %s", serviceId, err)
+			metrics.Increment("reconciler.errors")
+			transitionState(&c, StateFailed, "could not recreate service")
+			continue
+		}
+		metrics.Increment("reconciler.services_recreated")
+	}
+
+	for _, name := range serviceNames {
+		if expected[name] {
+			continue
+		}
+
+		log.Warnf("Service %s has no container, removing it", name)
+		err = Runtime.Remove(name)
+		if err != nil && err != spec.ErrServiceNotFound {
+			log.Errorf("Could not remove orphaned service %s: %s", name, err)
+			metrics.Increment("reconciler.errors")
+			continue
+		}
+		metrics.Increment("reconciler.orphans_removed")
+	}
+}
+
+// repairPort makes sure the port allocator agrees with the port the service is actually published on.
+func repairPort(c Container, serviceId string) {
+	_, published, err := Runtime.Endpoint(serviceId)
+	if err != nil {
+		logrus.Errorf("Could not get endpoint for service %s: %s", serviceId, err)
+		metrics.Increment("reconciler.errors")
+		return
+	}
+
+	if published == 0 {
+		return
+	}
+
+	repaired, err := ports.Repair(c.Id, published)
+	if err != nil {
+		logrus.Errorf("Could not repair port binding for container %d: %s", c.Id, err)
+		metrics.Increment("reconciler.errors")
+		return
+	}
+
+	if repaired {
+		metrics.Increment("reconciler.ports_repaired")
+	}
+}
@@ -0,0 +1,242 @@
+package containers
+
+import (
+	"bitbucket.org/smaug-hosting/services/container-service/orchestrator/spec"
+	"bitbucket.org/smaug-hosting/services/container-service/software"
+	"bitbucket.org/smaug-hosting/services/metrics"
+	µ "bitbucket.org/smaug-hosting/services/micro"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+const releasePollInterval = 5 * time.Second
+
+var ErrReleaseNotSettled = errors.New("new release did not settle into running")
+var ErrStoppedDuringUpgrade = errors.New("container was stopped during the upgrade")
+
+// UpgradeError explains why a container didn't come up on its new release, and whether it was put back the way it
+// was before.
+type UpgradeError struct {
+	Err        error
+	RolledBack bool
+}
+
+func (e UpgradeError) Error() string {
+	if e.RolledBack {
+		return fmt.Sprintf("%s, rolled back", e.Err)
+	}
+	return fmt.Sprintf("%s, could not roll back", e.Err)
+}
+
+// NewRelease validates the version and flavour for the container's software and fills in the defaults for any left
+// empty.  The release runs the catalog's current image, pinned to the digest its tag refers to now so that the
+// container keeps running exactly that image; this is also how upgrades pick up a newer one.  The image is pulled
+// to make sure it exists, failing with spec.ErrImageNotFound if it doesn't.
+func NewRelease(softwareName string, version string, flavour string) (Release, error) {
+	sw, err := software.SoftwareRepository{}.FindByName(softwareName)
+	if err != nil {
+		return Release{}, err
+	}
+
+	err = sw.ValidateRelease(version, flavour)
+	if err != nil {
+		return Release{}, err
+	}
+
+	defaultVersion, defaultFlavour := sw.DefaultRelease()
+	if version == "" {
+		version = defaultVersion
+	}
+	if flavour == "" {
+		flavour = defaultFlavour
+	}
+
+	image, err := Runtime.PullImage(sw.Image, registryAuth(sw, sw.Image))
+	if err != nil {
+		return Release{}, err
+	}
+
+	return Release{Image: image, Version: version, Flavour: flavour}, nil
+}
+
+// registryAuth is what the orchestrator should log in with to pull the software's image, nil if it needn't.
+func registryAuth(sw software.Software, image string) *spec.RegistryAuth {
+	credentials := sw.RegistryCredentialsFor(image)
+	if credentials == nil {
+		return nil
+	}
+
+	return &spec.RegistryAuth{
+		ServerAddress: credentials.Host,
+		Username:      credentials.Username,
+		Password:      credentials.Password,
+	}
+}
+
+// imageForContainer is the image the container's release runs, falling back to the catalog's for containers
+// created before releases were recorded.
+func imageForContainer(c Container, sw software.Software) string {
+	if c.Image != "" {
+		return c.Image
+	}
+	return sw.Image
+}
+
+func getUpgradeTimings() (time.Duration, time.Duration) {
+	timeout, err := time.ParseDuration(µ.GetEnvDefault("UPGRADE_TIMEOUT", "10m"))
+	if err != nil {
+		timeout = 10 * time.Minute
+		logrus.Errorf("Could not parse UPGRADE_TIMEOUT: %s", err)
+	}
+
+	settle, err := time.ParseDuration(µ.GetEnvDefault("UPGRADE_SETTLE", "1m"))
+	if err != nil {
+		settle = time.Minute
+		logrus.Errorf("Could not parse UPGRADE_SETTLE: %s", err)
+	}
+
+	return timeout, settle
+}
+
+// UpgradeContainer moves the container onto a new release.  A container that is down just picks it up the next
+// time it starts.  A container that is up is rolled onto it and watched until its task has stayed up for
+// UPGRADE_SETTLE; if that doesn't happen within UPGRADE_TIMEOUT the container is stopped, restore is called to put
+// its data back the way it was, and it goes back up on its previous release.  Either way that is reported as an
+// UpgradeError.
+func UpgradeContainer(c *Container, release Release, restore func() error) error {
+	err := pinServiceName(c)
+	if err != nil {
+		return err
+	}
+
+	previous := c.Release
+
+	err = ContainerRepository{}.SetRelease(c, release)
+	if err != nil {
+		return err
+	}
+
+	if c.State != StateRunning && c.State != StateStarting {
+		return nil
+	}
+
+	timeout, settle := getUpgradeTimings()
+
+	err = scaleContainer(*c, 1)
+	if err == nil {
+		err = waitUntilSettled(*c, timeout, settle)
+	}
+	if err == nil {
+		metrics.Increment("upgrades.applied")
+		return nil
+	}
+
+	logrus.Warnf("Container %d did not come up on %s %s, rolling back: %s", c.Id, release.Flavour, release.Version, err)
+	metrics.Increment("upgrades.failed")
+
+	rollbackErr := rollbackRelease(c, previous, restore, timeout)
+	if rollbackErr != nil {
+		logrus.WithField("severity", "CRITICAL").Errorf("Could not roll container %d back from a failed upgrade: %s", c.Id, rollbackErr)
+		transitionState(c, StateFailed, fmt.Sprintf("upgrade failed and could not be rolled back: %s", rollbackErr))
+		return UpgradeError{Err: rollbackErr}
+	}
+
+	return UpgradeError{Err: err, RolledBack: true}
+}
+
+// waitUntilSettled waits for the container's task to be up for the whole settle period.  Anything short of that
+// (including the old task, which may still be reported for a moment after the update) starts the period again.
+func waitUntilSettled(c Container, timeout time.Duration, settle time.Duration) error {
+	serviceId := getServiceIdForContainer(c)
+	deadline := time.Now().Add(timeout)
+
+	var upSince time.Time
+	for time.Now().Before(deadline) {
+		time.Sleep(releasePollInterval)
+
+		current, err := ContainerRepository{}.FindById(c.Id)
+		if err != nil {
+			return err
+		}
+		if current.State == StateStopping || current.State == StateStopped {
+			return ErrStoppedDuringUpgrade
+		}
+
+		status, err := Runtime.Status(serviceId)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case status.Up:
+			if upSince.IsZero() {
+				upSince = time.Now()
+			}
+			if time.Since(upSince) >= settle {
+				return nil
+			}
+		case status.State == "failed" || status.State == "rejected":
+			return fmt.Errorf("task %s", status.State)
+		default:
+			upSince = time.Time{}
+		}
+	}
+
+	return ErrReleaseNotSettled
+}
+
+// waitUntilDown waits for the container's task to have gone away, so that nothing is using its volumes.
+func waitUntilDown(c Container, timeout time.Duration) error {
+	serviceId := getServiceIdForContainer(c)
+	deadline := time.Now().Add(timeout)
+
+	for time.Now().Before(deadline) {
+		status, err := Runtime.Status(serviceId)
+		if err != nil {
+			return err
+		}
+		if !status.Up && status.State != "starting" && status.State != "preparing" {
+			return nil
+		}
+		time.Sleep(releasePollInterval)
+	}
+
+	return fmt.Errorf("service %s did not stop", serviceId)
+}
+
+// rollbackRelease puts the container's data and release back, then brings it back up unless it has been stopped
+// in the meantime.
+func rollbackRelease(c *Container, previous Release, restore func() error, timeout time.Duration) error {
+	err := Runtime.Scale(getServiceIdForContainer(*c), 0)
+	if err != nil {
+		return err
+	}
+
+	err = waitUntilDown(*c, timeout)
+	if err != nil {
+		return err
+	}
+
+	err = restore()
+	if err != nil {
+		return err
+	}
+
+	err = ContainerRepository{}.SetRelease(c, previous)
+	if err != nil {
+		return err
+	}
+
+	current, err := ContainerRepository{}.FindById(c.Id)
+	if err != nil {
+		return err
+	}
+
+	if current.State == StateStopping || current.State == StateStopped {
+		return nil
+	}
+
+	return scaleContainer(*current, 1)
+}
@@ -2,16 +2,20 @@ package containers
 
 import (
 	"bitbucket.org/smaug-hosting/services/database"
+	"bitbucket.org/smaug-hosting/services/database/helpers"
 	"github.com/Masterminds/squirrel"
 	"github.com/sirupsen/logrus"
+	"time"
 )
 
 type ContainerRepository struct{}
 
 const tableName = "containers"
 
+var containerColumns = []string{"name", "tier", "software", "user_id", "id", "state", "last_error", "state_changed_at", "created_at", "console_password", "config", "service_name", "auto_stop_idle", "wake_on_connect", "subdomain", "image", "version", "flavour"}
+
 func (cr ContainerRepository) FindById(id int64) (*Container, error) {
-	sql, params, err := squirrel.Select("name", "tier", "software", "user_id", "id").From(tableName).Where("id = ?", id).ToSql()
+	sql, params, err := squirrel.Select(containerColumns...).From(tableName).Where("id = ?", id).ToSql()
 	if err != nil {
 		return nil, err
 	}
@@ -31,11 +35,29 @@ func (cr ContainerRepository) FindById(id int64) (*Container, error) {
 func (cr ContainerRepository) Save(container Container) (Container, error) {
 	var result Container // only used if we fail
 
+	now := time.Now()
+	container.State = StateProvisioning
+	container.StateChangedAt = now
+	container.CreatedAt = now
+
 	containerMap := map[string]interface{}{
-		"name":     container.Name,
-		"tier":     container.Tier,
-		"software": container.Software,
-		"user_id":  container.UserId,
+		"name":             container.Name,
+		"tier":             container.Tier,
+		"software":         container.Software,
+		"user_id":          container.UserId,
+		"state":            container.State,
+		"last_error":       container.LastError,
+		"state_changed_at": container.StateChangedAt,
+		"created_at":       container.CreatedAt,
+		"console_password": container.ConsolePassword,
+		"config":           container.Config,
+		"service_name":     container.ServiceName,
+		"auto_stop_idle":   container.AutoStopIdle,
+		"wake_on_connect":  container.WakeOnConnect,
+		"subdomain":        container.Subdomain,
+		"image":            container.Image,
+		"version":          container.Version,
+		"flavour":          container.Flavour,
 	}
 
 	if container.Id > 0 {
@@ -61,9 +83,278 @@ func (cr ContainerRepository) Save(container Container) (Container, error) {
 	return container, err
 }
 
+// TransitionState moves the container to the target state, refusing transitions the state machine doesn't allow.
+// The update only applies if the row is still in the state we last saw, so two concurrent transitions can't both win.
+func (cr ContainerRepository) TransitionState(container *Container, target State, lastError string) error {
+	if !container.State.CanTransitionTo(target) {
+		logrus.Debugf("Refusing to move container %d from %s to %s", container.Id, container.State, target)
+		return ErrIllegalTransition
+	}
+
+	now := time.Now()
+
+	sql, params, err := squirrel.
+		Update(tableName).
+		SetMap(map[string]interface{}{
+			"state":            target,
+			"last_error":       lastError,
+			"state_changed_at": now,
+		}).
+		Where("id = ? AND state = ?", container.Id, container.State).
+		ToSql()
+
+	if err != nil {
+		return err
+	}
+
+	res, err := database.Connection.Exec(sql, params...)
+	if err != nil {
+		return err
+	}
+
+	if rows, err := res.RowsAffected(); err != nil {
+		return err
+	} else if rows < 1 {
+		return ErrStateConflict
+	}
+
+	container.State = target
+	container.LastError = lastError
+	container.StateChangedAt = now
+
+	publishStatus(*container, statusFromState(target))
+
+	return nil
+}
+
+// RecordError saves an error against the container without changing its state.
+func (cr ContainerRepository) RecordError(container *Container, lastError string) error {
+	sql, params, err := squirrel.
+		Update(tableName).
+		Set("last_error", lastError).
+		Where("id = ?", container.Id).
+		ToSql()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = database.Connection.Exec(sql, params...)
+	if err != nil {
+		return err
+	}
+
+	container.LastError = lastError
+
+	return nil
+}
+
+func (cr ContainerRepository) SetConsolePassword(container *Container, password string) error {
+	sql, params, err := squirrel.
+		Update(tableName).
+		Set("console_password", password).
+		Where("id = ?", container.Id).
+		ToSql()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = database.Connection.Exec(sql, params...)
+	if err != nil {
+		return err
+	}
+
+	container.ConsolePassword = password
+
+	return nil
+}
+
+func (cr ContainerRepository) SetConfig(container *Container, config ContainerConfig) error {
+	sql, params, err := squirrel.
+		Update(tableName).
+		Set("config", config).
+		Where("id = ?", container.Id).
+		ToSql()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = database.Connection.Exec(sql, params...)
+	if err != nil {
+		return err
+	}
+
+	container.Config = config
+
+	return nil
+}
+
+func (cr ContainerRepository) setColumn(container *Container, column string, value interface{}) error {
+	sql, params, err := squirrel.
+		Update(tableName).
+		Set(column, value).
+		Where("id = ?", container.Id).
+		ToSql()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = database.Connection.Exec(sql, params...)
+
+	return err
+}
+
+func (cr ContainerRepository) SetTier(container *Container, tier int) error {
+	err := cr.setColumn(container, "tier", tier)
+	if err != nil {
+		return err
+	}
+
+	container.Tier = tier
+
+	return nil
+}
+
+// SaveSettings writes the settings users can change on a container (its name, auto-stop, wake-on-connect and
+// subdomain) in one go, so that a request changing several of them can't leave only some changed.  Subdomains are
+// unique, so ErrSubdomainTaken is returned if another container already has the one given.
+func (cr ContainerRepository) SaveSettings(container *Container, settings Container) error {
+	sql, params, err := squirrel.
+		Update(tableName).
+		SetMap(map[string]interface{}{
+			"name":            settings.Name,
+			"auto_stop_idle":  settings.AutoStopIdle,
+			"wake_on_connect": settings.WakeOnConnect,
+			"subdomain":       settings.Subdomain,
+		}).
+		Where("id = ?", container.Id).
+		ToSql()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = database.Connection.Exec(sql, params...)
+	if helpers.IsDuplicateKey(err) {
+		return ErrSubdomainTaken
+	} else if err != nil {
+		return err
+	}
+
+	container.Name = settings.Name
+	container.AutoStopIdle = settings.AutoStopIdle
+	container.WakeOnConnect = settings.WakeOnConnect
+	container.Subdomain = settings.Subdomain
+
+	return nil
+}
+
+func (cr ContainerRepository) FindBySubdomain(subdomain string) (*Container, error) {
+	sql, params, err := squirrel.Select(containerColumns...).From(tableName).Where("subdomain = ?", subdomain).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	c := new(Container)
+	err = database.Connection.Get(c, sql, params...)
+
+	return c, err
+}
+
+func (cr ContainerRepository) SetServiceName(container *Container, serviceName string) error {
+	err := cr.setColumn(container, "service_name", serviceName)
+	if err != nil {
+		return err
+	}
+
+	container.ServiceName = serviceName
+
+	return nil
+}
+
+func (cr ContainerRepository) SetRelease(container *Container, release Release) error {
+	sql, params, err := squirrel.
+		Update(tableName).
+		SetMap(map[string]interface{}{
+			"image":   release.Image,
+			"version": release.Version,
+			"flavour": release.Flavour,
+		}).
+		Where("id = ?", container.Id).
+		ToSql()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = database.Connection.Exec(sql, params...)
+	if err != nil {
+		return err
+	}
+
+	container.Release = release
+
+	return nil
+}
+
+// SetOwner hands the container over to another user, as long as it still belongs to the user we last saw owning it.
+func (cr ContainerRepository) SetOwner(container *Container, userId int64) error {
+	sql, params, err := squirrel.
+		Update(tableName).
+		Set("user_id", userId).
+		Where("id = ? AND user_id = ?", container.Id, container.UserId).
+		ToSql()
+
+	if err != nil {
+		return err
+	}
+
+	res, err := database.Connection.Exec(sql, params...)
+	if err != nil {
+		return err
+	}
+
+	if rows, err := res.RowsAffected(); err != nil {
+		return err
+	} else if rows < 1 {
+		return ErrStateConflict
+	}
+
+	container.UserId = userId
+
+	return nil
+}
+
+func (cr ContainerRepository) Delete(container Container) error {
+	sql, params, err := squirrel.Delete(tableName).Where("id = ?", container.Id).ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = database.Connection.Exec(sql, params...)
+
+	return err
+}
+
+func (cr ContainerRepository) All() ([]Container, error) {
+	sql, params, err := squirrel.Select(containerColumns...).From(tableName).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	containers := make([]Container, 0)
+
+	err = database.Connection.Select(&containers, sql, params...)
+
+	return containers, err
+}
+
 func (cr ContainerRepository) GetContainersForUser(userId int64) ([]Container, error) {
 	sql, params, err := squirrel.
-		Select("name", "tier", "software", "id", "user_id").
+		Select(containerColumns...).
 		From(tableName).
 		Where("user_id=?", userId).
 		ToSql()
@@ -91,3 +382,44 @@ func (cr ContainerRepository) GetContainersForUser(userId int64) ([]Container, e
 
 	return containers, err
 }
+
+func (cr ContainerRepository) FindByIds(ids []int64) ([]Container, error) {
+	containers := make([]Container, 0, len(ids))
+	if len(ids) == 0 {
+		return containers, nil
+	}
+
+	sql, params, err := squirrel.Select(containerColumns...).From(tableName).Where(squirrel.Eq{"id": ids}).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	err = database.Connection.Select(&containers, sql, params...)
+
+	return containers, err
+}
+
+func (cr ContainerRepository) Count() (int, error) {
+	sql, params, err := squirrel.Select("COUNT(*)").From(tableName).ToSql()
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	err = database.Connection.Get(&count, sql, params...)
+
+	return count, err
+}
+
+func (cr ContainerRepository) FindInStates(states []State) ([]Container, error) {
+	sql, params, err := squirrel.Select(containerColumns...).From(tableName).Where(squirrel.Eq{"state": states}).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	containers := make([]Container, 0)
+
+	err = database.Connection.Select(&containers, sql, params...)
+
+	return containers, err
+}
@@ -0,0 +1,35 @@
+package containers
+
+import (
+	"bitbucket.org/smaug-hosting/services/metrics"
+	"fmt"
+	"github.com/sirupsen/logrus"
+)
+
+// BackupRestorer fills new containers with the data of one of their owner's backups.  It is implemented by the
+// backups package, which depends on this one, and handed over by main.
+type BackupRestorer interface {
+	// CheckRestore makes sure the user can restore the backup into a new container of the software, returning the
+	// status and message to fail the request with if they can't.
+	CheckRestore(userId int64, backupId int64, software string) (int, string)
+	// RestoreInto replaces the data of the container, which has no service yet, with that of the backup.
+	RestoreInto(backupId int64, c Container) error
+}
+
+// Backups, if set, lets new containers be created from a backup.
+var Backups BackupRestorer
+
+// restoreNewContainer restores the backup into a freshly saved container, then spins it up.  The container is
+// expected to be locked already, unlock is called once the data is in place.
+func restoreNewContainer(c Container, backupId int64, unlock func()) {
+	err := Backups.RestoreInto(backupId, c)
+	unlock()
+	if err != nil {
+		logrus.Errorf("Could not restore backup %d into new container %d: %s", backupId, c.Id, err)
+		metrics.Increment("restores.failed")
+		transitionState(&c, StateFailed, fmt.Sprintf("could not restore backup %d", backupId))
+		return
+	}
+
+	spinUpContainer(c)
+}
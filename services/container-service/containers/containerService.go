@@ -1,376 +1,459 @@
 package containers
 
 import (
-	"bitbucket.org/smaug-hosting/services/cache"
-	"context"
+	"bitbucket.org/smaug-hosting/services/billing/pricing"
+	"bitbucket.org/smaug-hosting/services/container-service/orchestrator"
+	"bitbucket.org/smaug-hosting/services/container-service/orchestrator/spec"
+	"bitbucket.org/smaug-hosting/services/container-service/ports"
+	"bitbucket.org/smaug-hosting/services/container-service/software"
+	"bitbucket.org/smaug-hosting/services/container-service/tiers"
+	"bitbucket.org/smaug-hosting/services/idp/collaborators"
+	"bitbucket.org/smaug-hosting/services/idp/users"
+	"errors"
 	"fmt"
-	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/api/types/filters"
-	"github.com/docker/docker/api/types/mount"
-	"github.com/docker/docker/api/types/swarm"
-	"github.com/docker/docker/client"
-	"github.com/go-redis/redis"
 	"github.com/sirupsen/logrus"
-	"sort"
-	"strconv"
+	"os"
 	"strings"
 )
 
-func getImageForSoftware(software string) string {
-	switch software {
-	case "minecraft":
-		// todo: we will eventually be using our own minecraft server images kept on a private registry
-		// but for the POC/MVP we can just pull in someone else's
-		return "itzg/minecraft-server:20190824"
-	default:
-		logrus.Errorf("Invalid software (docker service create is about to break...): %s", software)
-		return ""
+var ErrTierNotApplied = errors.New("could not apply the new tier")
+var ErrUnknownUser = errors.New("user does not exist")
+var ErrUnverifiedUser = errors.New("user has not verified their email address")
+var ErrInsufficientFunds = errors.New("insufficient funds")
+
+// Runtime is the orchestrator every whelp is run on.  It must be set (usually from main) before any of the
+// functions in this file are called.
+var Runtime orchestrator.Orchestrator
+
+// transitionState moves the container to the target state, logging (rather than returning) any failure - for use
+// where there is nothing more useful to do with the error than report it.
+func transitionState(c *Container, target State, lastError string) {
+	err := ContainerRepository{}.TransitionState(c, target, lastError)
+	if err != nil {
+		logrus.Errorf("Could not move container %d from %s to %s: %s", c.Id, c.State, target, err)
 	}
 }
 
+// removeContainer removes the container's service and volumes and only then its row.  If anything goes wrong the
+// container stays in the deleting state, and the reconciler retries the removal later.
 func removeContainer(container Container) {
-	dockerClient, err := client.NewEnvClient()
+	recordError := func(message string, err error) {
+		logrus.WithField("severity", "CRITICAL").Errorf("%s for container %d: %s", message, container.Id, err)
+		err = ContainerRepository{}.RecordError(&container, fmt.Sprintf("%s: %s", strings.ToLower(message), err))
+		if err != nil {
+			logrus.Errorf("Could not record removal error for container %d: %s", container.Id, err)
+		}
+	}
+
+	err := Runtime.Remove(getServiceIdForContainer(container))
+	if err != nil && err != spec.ErrServiceNotFound {
+		recordError("Could not remove service", err)
+		return
+	}
 
+	volumes, err := getVolumesForContainer(container)
 	if err != nil {
-		logrus.WithField("severity", "CRITICAL").Errorf("Could not create docker client: %s", err)
+		recordError("Could not work out volumes", err)
 		return
 	}
 
-	err = dockerClient.ServiceRemove(context.Background(), getServiceIdForContainer(container))
+	for _, volume := range volumes {
+		// this fails while the service's tasks are still shutting down, in which case the next attempt gets it
+		err = Runtime.RemoveVolume(volume)
+		if err != nil {
+			recordError("Could not remove volume", err)
+			return
+		}
+	}
+
+	err = collaborators.CollaboratorRepository{}.DeleteForContainer(container.Id)
 	if err != nil {
-		logrus.WithField("severity", "CRITICAL").Errorf("Could not remove container, docker responded with an error: %s", err)
+		recordError("Could not remove collaborators", err)
 		return
 	}
-}
 
-func getPortForSoftware(software string) uint32 {
-	switch software {
-	case "minecraft":
-		return 25565
+	err = ContainerRepository{}.Delete(container)
+	if err != nil {
+		logrus.WithField("severity", "CRITICAL").Errorf("Removed service but could not delete container %d: %s", container.Id, err)
+		return
 	}
-	// fail
-	logrus.Errorf("Unrecognised software: %s", software)
-	return 0
-}
+	forgetPublishedStatus(container.Id)
 
-func getDataDirForSoftware(software string) string {
-	switch software {
-	case "minecraft":
-		return "/data"
+	err = ports.Release(container.Id)
+	if err != nil {
+		logrus.Errorf("Could not release port for deleted container %d: %s", container.Id, err)
 	}
-	// fail
-	logrus.Errorf("Unrecognised software: %s", software)
-	return ""
 }
 
-func spinUpContainer(c Container) {
-	dockerClient, err := client.NewEnvClient()
+// getServiceSpecForContainer builds the service spec for a container from its software catalog entry and tier.
+// A nil replicas leaves the replica count up to the orchestrator (a single replica on create).
+func getServiceSpecForContainer(c Container, replicas *uint64) (spec.ServiceSpec, error) {
+	var serviceSpec spec.ServiceSpec
 
+	sw, err := software.SoftwareRepository{}.FindByName(c.Software)
 	if err != nil {
-		logrus.WithField("severity", "CRITICAL").Errorf("Could not create docker client: %s", err)
-		return
+		return serviceSpec, err
 	}
 
-	srvcCreateResponse, err := dockerClient.ServiceCreate(context.Background(), swarm.ServiceSpec{
-		Annotations: swarm.Annotations{
-			Name: getServiceIdForContainer(c),
-		},
-		TaskTemplate: swarm.TaskSpec{
-			ContainerSpec: swarm.ContainerSpec{
-				Image: getImageForSoftware(c.Software),
-				Env:   getEnvForSoftware(c.Software),
-				Mounts: []mount.Mount{
-					mount.Mount{
-						Type:   "volume",
-						Source: getServiceIdForContainer(c),
-						Target: getDataDirForSoftware(c.Software), // todo: multiple mounts?
-					},
-				},
-			},
-		},
-		Mode:         swarm.ServiceMode{},
-		UpdateConfig: nil,
-		Networks:     nil,
-		EndpointSpec: &swarm.EndpointSpec{
-			Ports: []swarm.PortConfig{
-				{
-					Name:          "",
-					Protocol:      "tcp",
-					TargetPort:    getPortForSoftware(c.Software),
-					PublishedPort: getUnusedPortForContainer(c),
-					PublishMode:   "",
-				},
-			},
+	tier, err := tiers.TierRepository{}.FindByTier(c.Tier)
+	if err != nil {
+		return serviceSpec, err
+	}
+
+	// the default local volume driver can't enforce a size, so quotas only apply when the operator has configured
+	// a driver that can
+	volumeDriver := os.Getenv("VOLUME_DRIVER")
+	var volumeDriverOptions map[string]string
+	if volumeDriver != "" && tier.DiskQuotaMB > 0 {
+		volumeDriverOptions = map[string]string{"size": fmt.Sprintf("%dM", tier.DiskQuotaMB)}
+	}
+
+	mounts := make([]spec.Mount, 0, len(sw.Mounts))
+	for i, m := range sw.Mounts {
+		mounts = append(mounts, spec.Mount{
+			Source:        getVolumeForMount(c, i, m),
+			Target:        m.Target,
+			Driver:        volumeDriver,
+			DriverOptions: volumeDriverOptions,
+		})
+	}
+
+	servicePorts := make([]spec.Port, 0, len(sw.Ports))
+	for i, p := range sw.Ports {
+		port := spec.Port{
+			Name:     p.Name,
+			Protocol: p.Protocol,
+			Target:   p.Target,
+			Internal: p.Internal,
+		}
+		if i == 0 {
+			// only the primary port gets one of our allocated ports, the orchestrator picks a free one for the rest
+			port.Published, err = ports.Allocate(c.Id)
+			if err != nil {
+				return serviceSpec, err
+			}
+		}
+		servicePorts = append(servicePorts, port)
+	}
+
+	env := append(sw.EnvList(), sw.ReleaseEnv(c.Version, c.Flavour)...)
+	env = append(env, sw.ConfigEnv(c.Config)...)
+	// the tier's player cap is the default, users may choose a lower one through their config
+	if sw.PlayerCapEnv != "" && tier.MaxPlayers > 0 && !hasEnv(env, sw.PlayerCapEnv) {
+		env = append(env, fmt.Sprintf("%s=%d", sw.PlayerCapEnv, tier.MaxPlayers))
+	}
+	// likewise the heap, which the image would otherwise fix at its own default whatever the tier's memory
+	if sw.HeapEnv != "" && tier.MemoryMB > 0 && !hasEnv(env, sw.HeapEnv) {
+		env = append(env, fmt.Sprintf("%s=%dM", sw.HeapEnv, tier.HeapMB()))
+	}
+	if sw.Console != nil {
+		err = ensureConsolePassword(&c)
+		if err != nil {
+			return serviceSpec, err
+		}
+		env = append(env, fmt.Sprintf("%s=%s", sw.Console.PasswordEnv, c.ConsolePassword))
+	}
+
+	image := imageForContainer(c, sw)
+
+	serviceSpec = spec.ServiceSpec{
+		Name:   getServiceIdForContainer(c),
+		Image:  image,
+		Env:    env,
+		Mounts: mounts,
+		Ports:  servicePorts,
+		Resources: spec.Resources{
+			NanoCPUs:            tier.NanoCPUs(),
+			MemoryBytes:         tier.MemoryBytes(),
+			ReservedNanoCPUs:    tier.ReservedNanoCPUs(),
+			ReservedMemoryBytes: tier.ReservedMemoryBytes(),
 		},
-	}, types.ServiceCreateOptions{})
+		Replicas:     replicas,
+		RegistryAuth: registryAuth(sw, image),
+	}
+
+	return serviceSpec, nil
+}
 
+func spinUpContainer(c Container) {
+	err := createService(c, nil)
 	if err != nil {
-		c.LastError = "could not create docker service"
-		logrus.WithField("severity", "CRITICAL").Errorf("Could not create docker service for customer: %s", err)
-		return
+		transitionState(&c, StateFailed, "could not create service")
+	}
+}
+
+func createService(c Container, replicas *uint64) error {
+	serviceSpec, err := getServiceSpecForContainer(c, replicas)
+	if err != nil {
+		logrus.WithField("severity", "CRITICAL").Errorf("Could not build service spec for container %d: %s", c.Id, err)
+		return err
 	}
 
-	for _, warning := range srvcCreateResponse.Warnings {
-		logrus.Warnf("Warning while creating docker service: %s", warning)
+	err = Runtime.Create(serviceSpec)
+	if err != nil {
+		logrus.WithField("severity", "CRITICAL").Errorf("Could not create service for customer: %s", err)
+		return err
 	}
+
+	return nil
 }
 
-func getUnusedPortForContainer(c Container) uint32 {
-	port, err := cache.Client.Get("ports." + getServiceIdForContainer(c)).Result()
-	if err == redis.Nil {
-		nextPort, err := cache.Client.Get("next_port").Result()
-		if err == redis.Nil {
-			cache.Client.Set("next_port", "50000", 0)
-			nextPort = "50000"
-		}
-		port = nextPort
-	} else if err != nil {
-		logrus.Errorf("Could not get free port from redis, returning zero so client gets something at least (err=%s)", err)
-		return 0
+func getVolumeForMount(c Container, index int, m software.Mount) string {
+	if index == 0 {
+		// the first mount keeps the bare service id as its volume name so that existing whelps keep their data
+		return getServiceIdForContainer(c)
 	}
-	res, err := strconv.ParseUint(port, 10, 32)
+	return fmt.Sprintf("%s-%s", getServiceIdForContainer(c), m.Name)
+}
+
+// DataVolumeForContainer is the name of the volume holding the container's primary data mount.
+func DataVolumeForContainer(c Container) string {
+	return getServiceIdForContainer(c)
+}
+
+func getVolumesForContainer(c Container) ([]string, error) {
+	sw, err := software.SoftwareRepository{}.FindByName(c.Software)
 	if err != nil {
-		logrus.Errorf("Invalid port: %s (%s)", port, err)
-		return 0
+		return nil, err
 	}
-	return uint32(res)
-}
 
-func getEnvForSoftware(software string) []string {
-	switch software {
-	case "minecraft":
-		return []string{"EULA=TRUE"}
-	default:
-		return []string{}
+	volumes := make([]string, 0, len(sw.Mounts))
+	for i, m := range sw.Mounts {
+		volumes = append(volumes, getVolumeForMount(c, i, m))
 	}
+	return volumes, nil
 }
 
 func getServiceIdForContainer(c Container) string {
+	if c.ServiceName != "" {
+		return c.ServiceName
+	}
 	return fmt.Sprintf("whelp-%s-%d-%d-%d", c.Software, c.UserId, c.Tier, c.Id)
 }
 
-func GetStatusForContainer(container Container) (ContainerStatus, error) {
-	var containerStatus ContainerStatus
-
-	dockerClient, err := client.NewEnvClient()
+// pinServiceName saves the container's current service name, so that it (and with it the volume names) stays the
+// same when something it is derived from, such as the tier, changes.
+func pinServiceName(c *Container) error {
+	if c.ServiceName != "" {
+		return nil
+	}
+	return ContainerRepository{}.SetServiceName(c, getServiceIdForContainer(*c))
+}
 
+// changeTier moves the container onto a new tier, keeping its service, volume and published port.  A container
+// that is up gets a rolling update onto the new resources; if that fails the old tier is put back, so that we never
+// bill the new price for resources the whelp isn't getting.
+func changeTier(c *Container, tier int) error {
+	err := pinServiceName(c)
 	if err != nil {
-		logrus.WithField("severity", "CRITICAL").Errorf("Could not create docker client: %s", err)
-		return containerStatus, err
+		return err
 	}
 
-	args, err := filters.ParseFlag(fmt.Sprintf("service=%s", getServiceIdForContainer(container)), filters.NewArgs())
+	previous := c.Tier
+
+	err = ContainerRepository{}.SetTier(c, tier)
 	if err != nil {
-		logrus.Errorf("Could not parse args: %s", err)
-		return containerStatus, err
+		return err
 	}
 
-	tasks, err := dockerClient.TaskList(context.Background(), types.TaskListOptions{
-		Filters: args,
-	})
+	if c.State != StateRunning && c.State != StateStarting {
+		// picked up the next time it starts
+		return nil
+	}
 
+	err = scaleContainer(*c, 1)
 	if err != nil {
-		// HACK: the only way to know if the error was "not found"
-		if strings.Contains(err.Error(), "not found") {
-			// try to spin up the container again, we probably are recovering from some kind of crash
-			logrus.Warnf("Container %s (id=%d) not found, trying to spin it up again", container.Name, container.Id)
-			spinUpContainer(container)
-			return containerStatus, err
-		} else {
-			logrus.Errorf("Could not get service info for container %s: %s", getServiceIdForContainer(container), err)
-			return containerStatus, err
+		logrus.Errorf("Could not move container %d onto tier %d: %s", c.Id, tier, err)
+		rollbackErr := ContainerRepository{}.SetTier(c, previous)
+		if rollbackErr != nil {
+			logrus.WithField("severity", "CRITICAL").Errorf("Could not put container %d back on tier %d: %s", c.Id, previous, rollbackErr)
 		}
+		return ErrTierNotApplied
 	}
 
-	sort.Slice(tasks, func(i, j int) bool {
-		return tasks[i].UpdatedAt.After(tasks[j].UpdatedAt)
-	})
+	return nil
+}
 
-	if len(tasks) == 0 {
-		containerStatus.Up = false
-		containerStatus.State = "stopped"
-	} else {
-		task := tasks[0]
-		containerStatus.State = string(task.Status.State)
-		if task.Status.State == swarm.TaskStateRunning {
-			containerStatus.Up = true
-		} else if task.Status.State == swarm.TaskStateShutdown {
-			containerStatus.Up = false
-			containerStatus.State = "stopped"
-		} else if task.Status.State != swarm.TaskStateFailed {
-			// break on non-failed status because we only want to report "failed" if _all_ tasks failed.
-			containerStatus.Up = false
-		}
+// TransferOwnership hands the container over to another user.  The service name (and with it the volume names) is
+// derived from the owner, so it is pinned first: the server carries on under its old name, with its data and
+// published port.
+func TransferOwnership(c *Container, userId int64) error {
+	err := pinServiceName(c)
+	if err != nil {
+		return err
+	}
+
+	return ContainerRepository{}.SetOwner(c, userId)
+}
+
+// GetStatusForContainer asks the orchestrator what the container is doing right now, and moves the persisted state
+// along to match what it observed.
+func GetStatusForContainer(container *Container) (ContainerStatus, error) {
+	var containerStatus ContainerStatus
+
+	status, err := Runtime.Status(getServiceIdForContainer(*container))
+	if err == spec.ErrServiceNotFound {
+		// nothing to observe, the reconciler will bring the service back if it should exist
+		return containerStatus, err
+	} else if err != nil {
+		logrus.Errorf("Could not get service info for container %s: %s", getServiceIdForContainer(*container), err)
+		return containerStatus, err
 	}
 
+	containerStatus.Up = status.Up
+	containerStatus.State = status.State
+
+	observeStatus(container, containerStatus)
+
 	return containerStatus, nil
 }
 
-func StopContainer(c Container) error {
+// observeStatus moves the persisted state along to match what the orchestrator says is happening.  Anything that
+// isn't a settled state (e.g. a task that is still being prepared) leaves the persisted state alone.
+func observeStatus(c *Container, status ContainerStatus) {
+	var target State
+	var lastError string
+
+	switch {
+	case status.Up:
+		target = StateRunning
+	case status.State == "stopped":
+		// a whelp that is still being provisioned has no tasks yet, which looks exactly like a stopped one
+		if c.State == StateProvisioning {
+			return
+		}
+		target = StateStopped
+	case status.State == "failed" || status.State == "rejected":
+		target = StateFailed
+		lastError = fmt.Sprintf("task %s", status.State)
+	default:
+		return
+	}
 
-	dockerClient, err := client.NewEnvClient()
+	if c.State == target || !c.State.CanTransitionTo(target) {
+		return
+	}
 
-	if err != nil {
-		logrus.WithField("severity", "CRITICAL").Errorf("Could not create docker client: %s", err)
-		return err
+	transitionState(c, target, lastError)
+}
+
+// statusFromState is the best guess at a container's status we can make without asking the orchestrator.
+func statusFromState(state State) ContainerStatus {
+	return ContainerStatus{
+		Up:    state == StateRunning,
+		State: string(state),
 	}
+}
 
-	service, _, err := dockerClient.ServiceInspectWithRaw(context.Background(), getServiceIdForContainer(c))
+func StopContainer(c Container) error {
+	if c.State == StateStopped || c.State == StateStopping {
+		return nil
+	}
 
+	unlock, err := LockContainer(c.Id, "stopping")
 	if err != nil {
-		logrus.Errorf("Could not inspect service: %s", err)
 		return err
 	}
+	defer unlock()
 
-	zero := uint64(0)
-
-	serviceUpdateResponse, err := dockerClient.ServiceUpdate(
-		context.Background(),
-		getServiceIdForContainer(c),
-		swarm.Version{
-			Index: service.Version.Index,
-		},
-		swarm.ServiceSpec{
-			TaskTemplate: swarm.TaskSpec{
-				ContainerSpec: swarm.ContainerSpec{
-					Image: getImageForSoftware(c.Software),
-					Env:   getEnvForSoftware(c.Software),
-					Mounts: []mount.Mount{
-						mount.Mount{
-							Type:   "volume",
-							Source: getServiceIdForContainer(c),
-							Target: getDataDirForSoftware(c.Software), // todo: multiple mounts?
-						},
-					},
-				},
-			},
-			Mode: swarm.ServiceMode{
-				Replicated: &swarm.ReplicatedService{
-					Replicas: &zero,
-				},
-			},
-			Annotations: swarm.Annotations{
-				Name: getServiceIdForContainer(c),
-			},
-			EndpointSpec: &swarm.EndpointSpec{
-				Ports: []swarm.PortConfig{
-					{
-						Name:          "",
-						Protocol:      "tcp",
-						TargetPort:    getPortForSoftware(c.Software),
-						PublishedPort: getUnusedPortForContainer(c),
-						PublishMode:   "",
-					},
-				},
-			},
-		},
-		types.ServiceUpdateOptions{},
-	)
+	err = ContainerRepository{}.TransitionState(&c, StateStopping, "")
 	if err != nil {
-		logrus.Errorf("Could not update service: %s", err)
 		return err
 	}
 
-	for _, warning := range serviceUpdateResponse.Warnings {
-		logrus.Warnf("Service update warning: %s", warning)
+	err = scaleContainer(c, 0)
+	if err != nil && err != spec.ErrServiceNotFound {
+		// whatever went wrong, a whelp we can't rebuild must still be stoppable - otherwise we keep billing for it
+		logrus.Warnf("Could not update container %d for stopping, scaling its current spec to zero instead: %s", c.Id, err)
+		err = Runtime.Scale(getServiceIdForContainer(c), 0)
+	}
+
+	if err != nil {
+		transitionState(&c, StateFailed, fmt.Sprintf("could not stop: %s", err))
+		return err
 	}
 
 	return nil
 }
 
-func startContainer(c Container) error {
+// StartContainer brings a stopped container back up.  It has no effect on a container that is already up.
+func StartContainer(c Container) error {
+	if c.State == StateRunning || c.State == StateStarting {
+		return nil
+	}
 
-	dockerClient, err := client.NewEnvClient()
+	unlock, err := LockContainer(c.Id, "starting")
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
+	// running containers count against the owner's quota from the moment they are starting
+	err = WithinQuota(c, true, func() error {
+		return ContainerRepository{}.TransitionState(&c, StateStarting, "")
+	})
 	if err != nil {
-		logrus.WithField("severity", "CRITICAL").Errorf("Could not create docker client: %s", err)
 		return err
 	}
 
-	service, _, err := dockerClient.ServiceInspectWithRaw(context.Background(), getServiceIdForContainer(c))
+	// players get the full idle period to turn up, however long ago it was last seen idle
+	clearIdleSince(c.Id)
 
+	err = scaleContainer(c, 1)
 	if err != nil {
-		logrus.Errorf("Could not inspect service: %s", err)
+		transitionState(&c, StateFailed, fmt.Sprintf("could not start: %s", err))
 		return err
 	}
 
-	one := uint64(1)
+	return nil
+}
 
-	serviceUpdateResponse, err := dockerClient.ServiceUpdate(
-		context.Background(),
-		getServiceIdForContainer(c),
-		swarm.Version{
-			Index: service.Version.Index,
-		},
-		swarm.ServiceSpec{
-			TaskTemplate: swarm.TaskSpec{
-				ContainerSpec: swarm.ContainerSpec{
-					Image: getImageForSoftware(c.Software),
-					Env:   getEnvForSoftware(c.Software),
-					Mounts: []mount.Mount{
-						{
-							Type:   "volume",
-							Source: getServiceIdForContainer(c),
-							Target: getDataDirForSoftware(c.Software), // todo: multiple mounts?
-						},
-					},
-				},
-			},
-			Mode: swarm.ServiceMode{
-				Replicated: &swarm.ReplicatedService{
-					Replicas: &one,
-				},
-			},
-			Annotations: swarm.Annotations{
-				Name: getServiceIdForContainer(c),
-			},
-			EndpointSpec: &swarm.EndpointSpec{
-				Ports: []swarm.PortConfig{
-					{
-						Name:          "",
-						Protocol:      "tcp",
-						TargetPort:    getPortForSoftware(c.Software),
-						PublishedPort: getUnusedPortForContainer(c),
-						PublishMode:   "",
-					},
-				},
-			},
-		},
-		types.ServiceUpdateOptions{},
-	)
+// CheckUserCanAfford makes sure the user is allowed to run the software on the tier, and has the funds to do so.
+func CheckUserCanAfford(userId int64, softwareName string, tier int) error {
+	price, err := pricing.PricingRepository{}.FindPriceBySoftwareAndTier(softwareName, tier)
+	if err != nil {
+		return err
+	}
+
+	user, err := users.UserRepository{}.Find(userId)
 	if err != nil {
-		logrus.Errorf("Could not update service: %s", err)
 		return err
 	}
+	if user == nil {
+		return ErrUnknownUser
+	}
+
+	if !user.Verified {
+		return ErrUnverifiedUser
+	}
 
-	for _, warning := range serviceUpdateResponse.Warnings {
-		logrus.Warnf("Service update warning: %s", warning)
+	if user.Balance <= price.Amount {
+		return ErrInsufficientFunds
 	}
 
 	return nil
 }
 
-func GetIpAndPortForContainer(container Container) (string, uint32, error) {
-	var ip string
-	var port uint32
-
-	dockerClient, err := client.NewEnvClient()
+func scaleContainer(c Container, replicas uint64) error {
+	serviceSpec, err := getServiceSpecForContainer(c, &replicas)
+	if err != nil {
+		logrus.Errorf("Could not build service spec: %s", err)
+		return err
+	}
 
+	err = Runtime.Update(serviceSpec)
 	if err != nil {
-		logrus.WithField("severity", "CRITICAL").Errorf("Could not create docker client: %s", err)
-		return ip, port, err
+		logrus.Errorf("Could not update service: %s", err)
+		return err
 	}
 
-	srvc, _, err := dockerClient.ServiceInspectWithRaw(context.Background(), getServiceIdForContainer(container))
+	return nil
+}
 
+func GetIpAndPortForContainer(container Container) (string, uint32, error) {
+	ip, port, err := Runtime.Endpoint(getServiceIdForContainer(container))
 	if err != nil {
 		logrus.WithField("severity", "CRITICAL").Errorf("Could not get connection information for service: %s", err)
-		return ip, port, err
 	}
-
-	port = srvc.Endpoint.Ports[0].PublishedPort
-	info, _ := dockerClient.Info(context.Background())
-	ip = info.Swarm.NodeAddr
-
 	return ip, port, err
 }
@@ -0,0 +1,41 @@
+package containers
+
+import "errors"
+
+type State string
+
+const (
+	StateProvisioning State = "provisioning"
+	StateStarting     State = "starting"
+	StateRunning      State = "running"
+	StateStopping     State = "stopping"
+	StateStopped      State = "stopped"
+	StateFailed       State = "failed"
+	StateDeleting     State = "deleting"
+)
+
+var (
+	ErrIllegalTransition = errors.New("illegal container state transition")
+	ErrStateConflict     = errors.New("container state was changed by someone else")
+)
+
+// legal transitions, keyed by the state being left.  Some of these (e.g. stopped -> running) can only come from
+// observing what docker is actually doing rather than from anything we asked for.
+var transitions = map[State][]State{
+	StateProvisioning: {StateRunning, StateStopping, StateStopped, StateFailed, StateDeleting},
+	StateStarting:     {StateRunning, StateStopping, StateFailed},
+	StateRunning:      {StateStopping, StateStopped, StateFailed},
+	StateStopping:     {StateStopped, StateFailed},
+	StateStopped:      {StateStarting, StateRunning, StateFailed, StateDeleting},
+	StateFailed:       {StateProvisioning, StateStarting, StateRunning, StateStopping, StateStopped, StateDeleting},
+	StateDeleting:     {},
+}
+
+func (s State) CanTransitionTo(target State) bool {
+	for _, t := range transitions[s] {
+		if t == target {
+			return true
+		}
+	}
+	return false
+}
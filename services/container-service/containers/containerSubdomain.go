@@ -0,0 +1,77 @@
+package containers
+
+import (
+	"bitbucket.org/smaug-hosting/services/container-service/software"
+	µ "bitbucket.org/smaug-hosting/services/micro"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Players connect to <subdomain>.<PROXY_DOMAIN> on the default game port, and the game proxy hands the connection
+// on to whichever port the container was published on.  Only games the proxy can route (i.e. that put the host the
+// player typed into their handshake) can be given a subdomain.
+
+var ErrSubdomainTaken = errors.New("subdomain is already in use")
+
+// a single DNS label, kept short enough that the whole hostname stays readable
+var subdomainPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,30}[a-z0-9])?$`)
+
+// subdomains that could be mistaken for our own
+var reservedSubdomains = map[string]bool{
+	"www":     true,
+	"play":    true,
+	"api":     true,
+	"admin":   true,
+	"mail":    true,
+	"status":  true,
+	"support": true,
+}
+
+func proxyDomain() string {
+	return µ.GetEnvDefault("PROXY_DOMAIN", "play.smaug-hosting.co.uk")
+}
+
+// HostnameForContainer is the address players connect to the container on, or "" if it has no subdomain.
+func HostnameForContainer(c Container) string {
+	if c.Subdomain == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s.%s", *c.Subdomain, proxyDomain())
+}
+
+// SubdomainForHostname is the inverse of HostnameForContainer, false if the hostname isn't one of ours.
+func SubdomainForHostname(hostname string) (string, bool) {
+	subdomain := strings.TrimSuffix(hostname, "."+proxyDomain())
+	if subdomain == hostname || !subdomainPattern.MatchString(subdomain) {
+		return "", false
+	}
+	return subdomain, true
+}
+
+// checkSubdomain returns the status and message to fail the request with, or a zero status if the container can
+// have the subdomain.
+func checkSubdomain(c Container, subdomain string) (int, string) {
+	if !subdomainPattern.MatchString(subdomain) {
+		return http.StatusBadRequest, "Subdomains may only contain lowercase letters, numbers and hyphens, and may not start or end with a hyphen"
+	}
+
+	if reservedSubdomains[subdomain] {
+		return http.StatusBadRequest, "That subdomain is reserved"
+	}
+
+	sw, err := software.SoftwareRepository{}.FindByName(c.Software)
+	if err != nil {
+		logrus.Errorf("Could not look up software in catalog: %s", err)
+		return http.StatusInternalServerError, "Could not look up software"
+	}
+
+	if sw.Status == nil || sw.Status.Protocol != software.StatusProtocolMinecraft {
+		return http.StatusBadRequest, "This software can't be given a subdomain"
+	}
+
+	return 0, ""
+}
@@ -0,0 +1,92 @@
+package containers
+
+import (
+	"bitbucket.org/smaug-hosting/services/database"
+	"github.com/Masterminds/squirrel"
+	"time"
+)
+
+type TemplateRepository struct{}
+
+const templateTableName = "templates"
+
+var templateColumns = []string{"id", "user_id", "name", "software", "tier", "config", "version", "flavour", "auto_stop_idle", "created_at"}
+
+func (tr TemplateRepository) FindById(id int64) (*Template, error) {
+	sql, params, err := squirrel.Select(templateColumns...).From(templateTableName).Where("id = ?", id).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	template := new(Template)
+	err = database.Connection.Get(template, sql, params...)
+	if err != nil {
+		return nil, err
+	}
+
+	return template, nil
+}
+
+func (tr TemplateRepository) Save(template Template) (Template, error) {
+	var result Template // only used if we fail
+
+	template.CreatedAt = time.Now()
+
+	sql, params, err := squirrel.
+		Insert(templateTableName).
+		SetMap(map[string]interface{}{
+			"user_id":        template.UserId,
+			"name":           template.Name,
+			"software":       template.Software,
+			"tier":           template.Tier,
+			"config":         template.Config,
+			"version":        template.Version,
+			"flavour":        template.Flavour,
+			"auto_stop_idle": template.AutoStopIdle,
+			"created_at":     template.CreatedAt,
+		}).
+		ToSql()
+
+	if err != nil {
+		return result, err
+	}
+
+	res, err := database.Connection.Exec(sql, params...)
+	if err != nil {
+		return result, err
+	}
+
+	template.Id, err = res.LastInsertId()
+
+	return template, err
+}
+
+func (tr TemplateRepository) Delete(template Template) error {
+	sql, params, err := squirrel.Delete(templateTableName).Where("id = ?", template.Id).ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = database.Connection.Exec(sql, params...)
+
+	return err
+}
+
+func (tr TemplateRepository) GetTemplatesForUser(userId int64) ([]Template, error) {
+	sql, params, err := squirrel.
+		Select(templateColumns...).
+		From(templateTableName).
+		Where("user_id = ?", userId).
+		OrderBy("name").
+		ToSql()
+
+	if err != nil {
+		return nil, err
+	}
+
+	templates := make([]Template, 0)
+
+	err = database.Connection.Select(&templates, sql, params...)
+
+	return templates, err
+}
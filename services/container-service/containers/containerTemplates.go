@@ -0,0 +1,139 @@
+package containers
+
+import (
+	"bitbucket.org/smaug-hosting/services/idp/collaborators"
+	"bitbucket.org/smaug-hosting/services/idp/tokens"
+	"bitbucket.org/smaug-hosting/services/libhttp"
+	"database/sql"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Template is a container's setup saved by a user to create new containers from.  Templates keep the version and
+// flavour rather than the image, so that containers created from them run the catalog's current image.
+type Template struct {
+	Id           int64           `json:"id"`
+	UserId       int64           `json:"-" db:"user_id"`
+	Name         string          `json:"name"`
+	Software     string          `json:"software"`
+	Tier         int             `json:"tier"`
+	Config       ContainerConfig `json:"config"`
+	Version      string          `json:"version"`
+	Flavour      string          `json:"flavour"`
+	AutoStopIdle bool            `json:"auto_stop_idle" db:"auto_stop_idle"`
+	CreatedAt    time.Time       `json:"created_at" db:"created_at"`
+}
+
+type SaveTemplateRequest struct {
+	// defaults to the container's name
+	Name string `json:"name"`
+}
+
+func (t Template) asRequest(name string) CreateContainerRequest {
+	autoStopIdle := t.AutoStopIdle
+	return CreateContainerRequest{
+		Name:         name,
+		Software:     t.Software,
+		Tier:         t.Tier,
+		Config:       t.Config,
+		Version:      t.Version,
+		Flavour:      t.Flavour,
+		AutoStopIdle: &autoStopIdle,
+	}
+}
+
+// templateForUser fetches the template as long as it belongs to the user, sending an error response (and returning
+// false) if it doesn't.
+func templateForUser(userId int64, templateId int64, response http.ResponseWriter) (*Template, bool) {
+	template, err := TemplateRepository{}.FindById(templateId)
+	if err == sql.ErrNoRows || (err == nil && template.UserId != userId) {
+		libhttp.SendError(http.StatusNotFound, "Template not found", response)
+		return nil, false
+	} else if err != nil {
+		logrus.Errorf("Could not fetch template from db: %s", err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not fetch template", response)
+		return nil, false
+	}
+
+	return template, true
+}
+
+// HandlePostTemplate saves the container's setup as one of the caller's templates.  Like cloning it, that takes the
+// admin permission, as anyone with the template can create a container with the same config.
+func HandlePostTemplate(response http.ResponseWriter, request *http.Request) {
+	container, ok := ContainerForRequest(response, request, collaborators.PermAdmin)
+	if !ok {
+		return
+	}
+
+	body := SaveTemplateRequest{}
+	err := libhttp.UnmarshalBody(request, response, &body)
+	if err != nil {
+		return
+	}
+
+	name := body.Name
+	if name == "" {
+		name = container.Name
+	}
+
+	claims := request.Context().Value("token_claims").(tokens.TokenClaims)
+
+	template, err := TemplateRepository{}.Save(Template{
+		UserId:       claims.UserId,
+		Name:         name,
+		Software:     container.Software,
+		Tier:         container.Tier,
+		Config:       container.Config,
+		Version:      container.Version,
+		Flavour:      container.Flavour,
+		AutoStopIdle: container.AutoStopIdle,
+	})
+	if err != nil {
+		logrus.Errorf("Could not save template of container %d: %s", container.Id, err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not save template", response)
+		return
+	}
+
+	libhttp.SendJsonWithStatus(http.StatusCreated, template, response)
+}
+
+func HandleGetTemplates(response http.ResponseWriter, request *http.Request) {
+	claims := request.Context().Value("token_claims").(tokens.TokenClaims)
+
+	templates, err := TemplateRepository{}.GetTemplatesForUser(claims.UserId)
+	if err != nil {
+		logrus.Errorf("Could not get templates for user: %s", err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not fetch templates", response)
+		return
+	}
+
+	libhttp.SendJson(templates, response)
+}
+
+func HandleDeleteTemplate(response http.ResponseWriter, request *http.Request) {
+	claims := request.Context().Value("token_claims").(tokens.TokenClaims)
+
+	templateId, err := strconv.ParseInt(request.Context().Value("templateId").(string), 10, 64)
+	if err != nil {
+		libhttp.SendError(http.StatusBadRequest, "Invalid template id", response)
+		return
+	}
+
+	template, ok := templateForUser(claims.UserId, templateId, response)
+	if !ok {
+		return
+	}
+
+	err = TemplateRepository{}.Delete(*template)
+	if err != nil {
+		logrus.Errorf("Could not delete template %d: %s", template.Id, err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not delete template", response)
+		return
+	}
+
+	// empty 200 response if all went well
+	libhttp.SendJson(struct{}{}, response)
+}
@@ -0,0 +1,44 @@
+package containers
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"net/http"
+	"testing"
+)
+
+func TestHandlePostTemplate(t *testing.T) {
+	mock, teardown := setupHandlerTest(t)
+	defer teardown()
+
+	c := testContainer(42, StateStopped)
+	expectFindContainer(mock, c)
+	expectCollaborator(mock, c, "admin")
+	mock.ExpectExec("INSERT INTO templates").WillReturnResult(sqlmock.NewResult(5, 1))
+
+	recorder := serveHandlerAs(testCollaboratorId, HandlePostTemplate, "POST", "42", `{"name": "my survival"}`)
+	if recorder.Code != http.StatusCreated {
+		t.Errorf("Saving a template as an admin collaborator gave %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHandlePostTemplateNeedsAdmin(t *testing.T) {
+	mock, teardown := setupHandlerTest(t)
+	defer teardown()
+
+	c := testContainer(42, StateStopped)
+	expectFindContainer(mock, c)
+	expectCollaborator(mock, c, "view")
+
+	recorder := serveHandlerAs(testCollaboratorId, HandlePostTemplate, "POST", "42", `{}`)
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("Saving a template as a view collaborator gave %d, want %d", recorder.Code, http.StatusForbidden)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
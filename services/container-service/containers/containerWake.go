@@ -0,0 +1,79 @@
+package containers
+
+import (
+	"bitbucket.org/smaug-hosting/services/libhttp"
+	"bitbucket.org/smaug-hosting/services/metrics"
+	"database/sql"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+)
+
+// WakeContainerRequest says who the container is being woken for, for the logs.
+type WakeContainerRequest struct {
+	Player  string `json:"player"`
+	Address string `json:"address"`
+}
+
+// HandleWakeContainer starts a stopped container that a player is trying to join, if its owner has turned on wake on
+// connect.  It is called by the game proxy rather than by users, so the owner's balance and quota are checked on
+// their behalf, just as they would be if they had started it themselves.
+func HandleWakeContainer(response http.ResponseWriter, request *http.Request) {
+	containerId, err := strconv.ParseInt(request.Context().Value("containerId").(string), 10, 64)
+	if err != nil {
+		libhttp.SendError(http.StatusBadRequest, "Invalid container id", response)
+		return
+	}
+
+	container, err := ContainerRepository{}.FindById(containerId)
+	if err == sql.ErrNoRows {
+		libhttp.SendError(http.StatusNotFound, "Container not found", response)
+		return
+	} else if err != nil {
+		logrus.Errorf("Could not fetch container from db: %s", err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not fetch container", response)
+		return
+	}
+
+	body := WakeContainerRequest{}
+	err = libhttp.UnmarshalBody(request, response, &body)
+	if err != nil {
+		return
+	}
+
+	if !container.WakeOnConnect {
+		libhttp.SendError(http.StatusForbidden, "This container is not set to wake when players connect", response)
+		return
+	}
+
+	if status, message := checkUserCanAfford(container.UserId, container.Software, container.Tier); status != 0 {
+		logrus.Infof("Not waking container %d for %s (%s): %s", container.Id, body.Player, body.Address, message)
+		libhttp.SendError(status, message, response)
+		return
+	}
+
+	err = StartContainer(*container)
+	if err == ErrIllegalTransition || err == ErrStateConflict {
+		libhttp.SendError(http.StatusConflict, fmt.Sprintf("Could not start container while it is %s", container.State), response)
+		return
+	} else if IsContainerLocked(err) {
+		libhttp.SendError(http.StatusConflict, fmt.Sprintf("Could not start container: %s", err), response)
+		return
+	} else if IsQuotaError(err) {
+		status, message := quotaError(*container, err)
+		logrus.Infof("Not waking container %d for %s (%s): %s", container.Id, body.Player, body.Address, message)
+		libhttp.SendError(status, message, response)
+		return
+	} else if err != nil {
+		logrus.Errorf("Could not wake container %d: %s", container.Id, err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not start container", response)
+		return
+	}
+
+	logrus.Infof("Woke container %d (was %s) for %s connecting from %s", container.Id, container.State, body.Player, body.Address)
+	metrics.Increment("containers.woken")
+
+	// empty 200 response if all went well
+	libhttp.SendJson(struct{}{}, response)
+}
@@ -2,11 +2,22 @@ package main
 
 import (
 	"bitbucket.org/smaug-hosting/services/cache"
+	"bitbucket.org/smaug-hosting/services/container-service/backups"
 	"bitbucket.org/smaug-hosting/services/container-service/containers"
+	"bitbucket.org/smaug-hosting/services/container-service/orchestrator"
+	"bitbucket.org/smaug-hosting/services/container-service/quotas"
+	"bitbucket.org/smaug-hosting/services/container-service/schedules"
+	"bitbucket.org/smaug-hosting/services/container-service/software"
+	"bitbucket.org/smaug-hosting/services/container-service/tiers"
+	"bitbucket.org/smaug-hosting/services/container-service/transfers"
+	"bitbucket.org/smaug-hosting/services/container-service/upgrades"
 	"bitbucket.org/smaug-hosting/services/database"
+	"bitbucket.org/smaug-hosting/services/idp/users"
 	"bitbucket.org/smaug-hosting/services/libhttp"
 	"bitbucket.org/smaug-hosting/services/libhttp/middleware"
+	"bitbucket.org/smaug-hosting/services/libws"
 	"bitbucket.org/smaug-hosting/services/logging"
+	"bitbucket.org/smaug-hosting/services/metrics"
 	"bitbucket.org/smaug-hosting/services/micro"
 	"github.com/sirupsen/logrus"
 	"net/http"
@@ -17,6 +28,28 @@ func main() {
 	logging.Setup()
 	database.Setup()
 	cache.Setup()
+	software.Setup("software/conf/catalog.json")
+	tiers.Setup("tiers/conf/tiers.json")
+
+	runtime, err := orchestrator.GetOrchestratorInstanceFromEnv()
+	if err != nil {
+		logrus.Fatalf("Could not set up orchestrator: %s", err)
+	}
+	containers.Runtime = runtime
+
+	backups.Store, err = backups.GetStorageInstanceFromEnv()
+	if err != nil {
+		logrus.Fatalf("Could not set up backup storage: %s", err)
+	}
+
+	containers.StartReconciler()
+	containers.StartIdleDetector()
+	containers.StartMetricsCollector()
+	containers.StartEventWatcher()
+	schedules.StartScheduler()
+	backups.StartPendingBackupSweeper()
+	upgrades.StartPendingUpgradeSweeper()
+	containers.Backups = backups.Restorer{}
 
 	addr := µ.GetEnvDefault("LISTEN_ADDR", ":35000")
 
@@ -27,6 +60,254 @@ func main() {
 	// In the long run the microframework should either handle this better (by ordering routes by "specificity")
 	// and/or provide a "precedence" option to give the microframework an order "hint".
 
+	libhttp.RegisterEndpoint(libhttp.Endpoint{
+		Handler:     containers.HandleWakeContainer,
+		Pattern:     "/containers/{containerId}/wake/",
+		Middleware:  []libhttp.Middleware{middleware.RequireServiceKey{}},
+		Method:      "POST",
+		Description: "Start a stopped container a player is trying to join, for the game proxy",
+	})
+
+	libhttp.RegisterEndpoint(libhttp.Endpoint{
+		Handler:     schedules.HandleGetScheduleRuns,
+		Pattern:     "/containers/{containerId}/schedules/{scheduleId}/runs/",
+		Middleware:  []libhttp.Middleware{middleware.Cors{}, mildRateLimit, middleware.RequireAuth{}},
+		Method:      "GET",
+		Description: "Get the recent runs of one of a container's schedules, and how they went",
+	})
+
+	libhttp.RegisterEndpoint(libhttp.Endpoint{
+		Handler:     schedules.HandleDeleteSchedule,
+		Pattern:     "/containers/{containerId}/schedules/{scheduleId}/",
+		Middleware:  []libhttp.Middleware{middleware.Cors{}, mildRateLimit, middleware.RequireAuth{}},
+		Method:      "DELETE",
+		Description: "Delete one of a container's schedules",
+	})
+
+	libhttp.RegisterEndpoint(libhttp.Endpoint{
+		Handler:     schedules.HandlePostSchedule,
+		Pattern:     "/containers/{containerId}/schedules/",
+		Middleware:  []libhttp.Middleware{middleware.Cors{}, mildRateLimit, middleware.RequireAuth{}},
+		Method:      "POST",
+		Description: "Add a cron-style schedule that starts or stops a container",
+	})
+
+	libhttp.RegisterEndpoint(libhttp.Endpoint{
+		Handler:     schedules.HandleGetSchedules,
+		Pattern:     "/containers/{containerId}/schedules/",
+		Middleware:  []libhttp.Middleware{middleware.Cors{}, mildRateLimit, middleware.RequireAuth{}},
+		Method:      "GET",
+		Description: "Get a list of a container's schedules",
+	})
+
+	libhttp.RegisterEndpoint(libhttp.Endpoint{
+		Handler:     backups.HandlePostRestore,
+		Pattern:     "/containers/{containerId}/backups/{backupId}/restore/",
+		Middleware:  []libhttp.Middleware{middleware.Cors{}, mildRateLimit, middleware.RequireAuth{}},
+		Method:      "POST",
+		Description: "Restore one of your backups into a stopped container",
+	})
+
+	libhttp.RegisterEndpoint(libhttp.Endpoint{
+		Handler:     backups.HandleDeleteContainerBackup,
+		Pattern:     "/containers/{containerId}/backups/{backupId}/",
+		Middleware:  []libhttp.Middleware{middleware.Cors{}, mildRateLimit, middleware.RequireAuth{}},
+		Method:      "DELETE",
+		Description: "Delete one of a container's backups",
+	})
+
+	libhttp.RegisterEndpoint(libhttp.Endpoint{
+		Handler:     backups.HandlePostBackup,
+		Pattern:     "/containers/{containerId}/backups/",
+		Middleware:  []libhttp.Middleware{middleware.Cors{}, mildRateLimit, middleware.RequireAuth{}},
+		Method:      "POST",
+		Description: "Take a backup of a container's data",
+	})
+
+	libhttp.RegisterEndpoint(libhttp.Endpoint{
+		Handler:     backups.HandleGetContainerBackups,
+		Pattern:     "/containers/{containerId}/backups/",
+		Middleware:  []libhttp.Middleware{middleware.Cors{}, mildRateLimit, middleware.RequireAuth{}},
+		Method:      "GET",
+		Description: "Get a list of a container's backups",
+	})
+
+	libhttp.RegisterEndpoint(libhttp.Endpoint{
+		Handler:     upgrades.HandlePostUpgrade,
+		Pattern:     "/containers/{containerId}/upgrades/",
+		Middleware:  []libhttp.Middleware{middleware.Cors{}, mildRateLimit, middleware.RequireAuth{}},
+		Method:      "POST",
+		Description: "Back up a container and move it onto another game version or flavour, rolling back if it fails",
+	})
+
+	libhttp.RegisterEndpoint(libhttp.Endpoint{
+		Handler:     upgrades.HandleGetUpgrades,
+		Pattern:     "/containers/{containerId}/upgrades/",
+		Middleware:  []libhttp.Middleware{middleware.Cors{}, mildRateLimit, middleware.RequireAuth{}},
+		Method:      "GET",
+		Description: "Get a list of a container's upgrades and how they went",
+	})
+
+	libhttp.RegisterEndpoint(libhttp.Endpoint{
+		Handler:     transfers.HandlePostTransfer,
+		Pattern:     "/containers/{containerId}/transfer/",
+		Middleware:  []libhttp.Middleware{middleware.Cors{}, mildRateLimit, middleware.RequireAuth{}},
+		Method:      "POST",
+		Description: "Offer a container to someone else by email, to become theirs once they accept",
+	})
+
+	libhttp.RegisterEndpoint(libhttp.Endpoint{
+		Handler:     transfers.HandleGetTransfer,
+		Pattern:     "/containers/{containerId}/transfer/",
+		Middleware:  []libhttp.Middleware{middleware.Cors{}, mildRateLimit, middleware.RequireAuth{}},
+		Method:      "GET",
+		Description: "Get a container's pending transfer",
+	})
+
+	libhttp.RegisterEndpoint(libhttp.Endpoint{
+		Handler:     transfers.HandleDeleteTransfer,
+		Pattern:     "/containers/{containerId}/transfer/",
+		Middleware:  []libhttp.Middleware{middleware.Cors{}, mildRateLimit, middleware.RequireAuth{}},
+		Method:      "DELETE",
+		Description: "Withdraw a container's pending transfer",
+	})
+
+	libhttp.RegisterEndpoint(libhttp.Endpoint{
+		Handler:     containers.HandlePostCloneContainer,
+		Pattern:     "/containers/{containerId}/clone/",
+		Middleware:  []libhttp.Middleware{middleware.Cors{}, mildRateLimit, middleware.RequireAuth{}},
+		Method:      "POST",
+		Description: "Create a copy of a container, including its data",
+	})
+
+	libhttp.RegisterEndpoint(libhttp.Endpoint{
+		Handler:     containers.HandlePostTemplate,
+		Pattern:     "/containers/{containerId}/template/",
+		Middleware:  []libhttp.Middleware{middleware.Cors{}, mildRateLimit, middleware.RequireAuth{}},
+		Method:      "POST",
+		Description: "Save a container's setup as a template for new containers",
+	})
+
+	libhttp.RegisterEndpoint(libhttp.Endpoint{
+		Handler:     containers.HandlePatchCollaborator,
+		Pattern:     "/containers/{containerId}/collaborators/{collaboratorId}/",
+		Middleware:  []libhttp.Middleware{middleware.Cors{}, mildRateLimit, middleware.RequireAuth{}},
+		Method:      "PATCH",
+		Description: "Change what a collaborator may do with a container",
+	})
+
+	libhttp.RegisterEndpoint(libhttp.Endpoint{
+		Handler:     containers.HandleDeleteCollaborator,
+		Pattern:     "/containers/{containerId}/collaborators/{collaboratorId}/",
+		Middleware:  []libhttp.Middleware{middleware.Cors{}, mildRateLimit, middleware.RequireAuth{}},
+		Method:      "DELETE",
+		Description: "Remove a collaborator from a container, or withdraw their invite",
+	})
+
+	libhttp.RegisterEndpoint(libhttp.Endpoint{
+		Handler:     containers.HandlePostCollaborator,
+		Pattern:     "/containers/{containerId}/collaborators/",
+		Middleware:  []libhttp.Middleware{middleware.Cors{}, mildRateLimit, middleware.RequireAuth{}},
+		Method:      "POST",
+		Description: "Invite someone by email to help run a container",
+	})
+
+	libhttp.RegisterEndpoint(libhttp.Endpoint{
+		Handler:     containers.HandleGetCollaborators,
+		Pattern:     "/containers/{containerId}/collaborators/",
+		Middleware:  []libhttp.Middleware{middleware.Cors{}, mildRateLimit, middleware.RequireAuth{}},
+		Method:      "GET",
+		Description: "Get a list of a container's collaborators and pending invites",
+	})
+
+	libhttp.RegisterEndpoint(libhttp.Endpoint{
+		Handler:     containers.HandleGetContainerFileContent,
+		Pattern:     "/containers/{containerId}/files/content/",
+		Middleware:  []libhttp.Middleware{middleware.Cors{}, mildRateLimit, middleware.RequireAuth{}},
+		Method:      "GET",
+		Description: "Download a file from a container's data volume",
+	})
+
+	libhttp.RegisterEndpoint(libhttp.Endpoint{
+		Handler:     containers.HandlePutContainerFileContent,
+		Pattern:     "/containers/{containerId}/files/content/",
+		Middleware:  []libhttp.Middleware{middleware.Cors{}, mildRateLimit, middleware.RequireAuth{}},
+		Method:      "PUT",
+		Description: "Upload a file to a stopped container's data volume",
+	})
+
+	libhttp.RegisterEndpoint(libhttp.Endpoint{
+		Handler:     containers.HandlePostContainerFileMove,
+		Pattern:     "/containers/{containerId}/files/move/",
+		Middleware:  []libhttp.Middleware{middleware.Cors{}, mildRateLimit, middleware.RequireAuth{}},
+		Method:      "POST",
+		Description: "Rename or move a file in a stopped container's data volume",
+	})
+
+	libhttp.RegisterEndpoint(libhttp.Endpoint{
+		Handler:     containers.HandleDeleteContainerFile,
+		Pattern:     "/containers/{containerId}/files/",
+		Middleware:  []libhttp.Middleware{middleware.Cors{}, mildRateLimit, middleware.RequireAuth{}},
+		Method:      "DELETE",
+		Description: "Delete a file or directory from a stopped container's data volume",
+	})
+
+	libhttp.RegisterEndpoint(libhttp.Endpoint{
+		Handler:     containers.HandleGetContainerFiles,
+		Pattern:     "/containers/{containerId}/files/",
+		Middleware:  []libhttp.Middleware{middleware.Cors{}, mildRateLimit, middleware.RequireAuth{}},
+		Method:      "GET",
+		Description: "List a directory of a container's data volume",
+	})
+
+	libhttp.RegisterEndpoint(libhttp.Endpoint{
+		Handler:     containers.HandleGetContainerMetrics,
+		Pattern:     "/containers/{containerId}/metrics/",
+		Middleware:  []libhttp.Middleware{middleware.Cors{}, mildRateLimit, middleware.RequireAuth{}},
+		Method:      "GET",
+		Description: "Get a container's current resource usage and recent history, along with its tier's limits",
+	})
+
+	libhttp.RegisterEndpoint(libhttp.Endpoint{
+		Handler:     containers.HandleGetContainerConfig,
+		Pattern:     "/containers/{containerId}/config/",
+		Middleware:  []libhttp.Middleware{middleware.Cors{}, mildRateLimit, middleware.RequireAuth{}},
+		Method:      "GET",
+		Description: "Get a container's settings along with the schema they are validated against",
+	})
+
+	libhttp.RegisterEndpoint(libhttp.Endpoint{
+		Handler:     containers.HandlePutContainerConfig,
+		Pattern:     "/containers/{containerId}/config/",
+		Middleware:  []libhttp.Middleware{middleware.Cors{}, mildRateLimit, middleware.RequireAuth{}},
+		Method:      "PUT",
+		Description: "Replace a container's settings, applying them with a rolling update if it is running",
+	})
+
+	libhttp.RegisterEndpoint(libhttp.Endpoint{
+		Handler:     containers.HandleContainerLogsWebsocket,
+		Pattern:     "/containers/{containerId}/logs/ws/",
+		Middleware:  []libhttp.Middleware{middleware.Cors{}, mildRateLimit},
+		Method:      "GET",
+		Description: "Stream a container's logs over a websocket (authenticated by the websocket handshake)",
+	})
+
+	libhttp.RegisterEndpoint(libhttp.Endpoint{
+		Handler:     containers.HandleGetContainerLogs,
+		Pattern:     "/containers/{containerId}/logs/",
+		Middleware:  []libhttp.Middleware{middleware.Cors{}, mildRateLimit, middleware.RequireAuth{}},
+		Method:      "GET",
+		Description: "Download the last lines of a container's logs",
+	})
+
+	libhttp.RegisterEndpoint(libhttp.Endpoint{
+		Handler:     containers.HandlePostConsoleCommand,
+		Pattern:     "/containers/{containerId}/console/",
+		Middleware:  []libhttp.Middleware{middleware.Cors{}, mildRateLimit, middleware.RequireAuth{}},
+		Method:      "POST",
+		Description: "Run a command on a running container's game console",
+	})
+
 	libhttp.RegisterEndpoint(libhttp.Endpoint{
 		Handler:     containers.HandleStartContainer,
 		Pattern:     "/containers/{containerId}/start/",
@@ -43,6 +324,14 @@ func main() {
 		Description: "Stops a container",
 	})
 
+	libhttp.RegisterEndpoint(libhttp.Endpoint{
+		Handler:     containers.HandlePatchContainer,
+		Pattern:     "/containers/{containerId}/",
+		Middleware:  []libhttp.Middleware{middleware.Cors{}, mildRateLimit, middleware.RequireAuth{}},
+		Method:      "PATCH",
+		Description: "Rename a container, move it onto another tier and/or opt it in or out of auto-stop when idle",
+	})
+
 	libhttp.RegisterEndpoint(libhttp.Endpoint{
 		Handler:     containers.HandleDeleteContainer,
 		Pattern:     "/containers/{containerId}/",
@@ -64,9 +353,97 @@ func main() {
 		Pattern:     "/containers/",
 		Middleware:  []libhttp.Middleware{middleware.Cors{}, mildRateLimit, middleware.RequireAuth{}},
 		Method:      "POST",
-		Description: "Create a new container",
+		Description: "Create a new container, from scratch, a template or one of the user's backups",
+	})
+
+	libhttp.RegisterEndpoint(libhttp.Endpoint{
+		Handler:     backups.HandleDeleteBackup,
+		Pattern:     "/backups/{backupId}/",
+		Middleware:  []libhttp.Middleware{middleware.Cors{}, mildRateLimit, middleware.RequireAuth{}},
+		Method:      "DELETE",
+		Description: "Delete one of your backups, including those of deleted containers",
+	})
+
+	libhttp.RegisterEndpoint(libhttp.Endpoint{
+		Handler:     backups.HandleGetBackups,
+		Pattern:     "/backups/",
+		Middleware:  []libhttp.Middleware{middleware.Cors{}, mildRateLimit, middleware.RequireAuth{}},
+		Method:      "GET",
+		Description: "Get a list of all your backups",
+	})
+
+	libhttp.RegisterEndpoint(libhttp.Endpoint{
+		Handler:     transfers.HandleAcceptTransfer,
+		Pattern:     "/transfers/{token}/accept/",
+		Middleware:  []libhttp.Middleware{middleware.Cors{}, mildRateLimit, middleware.RequireAuth{}},
+		Method:      "POST",
+		Description: "Accept a container someone has offered you, taking over its ownership and bill",
+	})
+
+	libhttp.RegisterEndpoint(libhttp.Endpoint{
+		Handler:     containers.HandleDeleteTemplate,
+		Pattern:     "/templates/{templateId}/",
+		Middleware:  []libhttp.Middleware{middleware.Cors{}, mildRateLimit, middleware.RequireAuth{}},
+		Method:      "DELETE",
+		Description: "Delete one of your templates",
 	})
 
+	libhttp.RegisterEndpoint(libhttp.Endpoint{
+		Handler:     containers.HandleGetTemplates,
+		Pattern:     "/templates/",
+		Middleware:  []libhttp.Middleware{middleware.Cors{}, mildRateLimit, middleware.RequireAuth{}},
+		Method:      "GET",
+		Description: "Get a list of your templates",
+	})
+
+	libhttp.RegisterEndpoint(libhttp.Endpoint{
+		Handler:     quotas.HandleGetQuota,
+		Pattern:     "/admin/users/{userId}/quota/",
+		Middleware:  []libhttp.Middleware{middleware.Cors{}, mildRateLimit, middleware.RequireAuth{}, middleware.RequireRole{Role: users.RoleAdmin}},
+		Method:      "GET",
+		Description: "Get the quota a user's containers are held to (admins only)",
+	})
+
+	libhttp.RegisterEndpoint(libhttp.Endpoint{
+		Handler:     quotas.HandlePutQuota,
+		Pattern:     "/admin/users/{userId}/quota/",
+		Middleware:  []libhttp.Middleware{middleware.Cors{}, mildRateLimit, middleware.RequireAuth{}, middleware.RequireRole{Role: users.RoleAdmin}},
+		Method:      "PUT",
+		Description: "Give a user a quota of their own (admins only)",
+	})
+
+	libhttp.RegisterEndpoint(libhttp.Endpoint{
+		Handler:     quotas.HandleDeleteQuota,
+		Pattern:     "/admin/users/{userId}/quota/",
+		Middleware:  []libhttp.Middleware{middleware.Cors{}, mildRateLimit, middleware.RequireAuth{}, middleware.RequireRole{Role: users.RoleAdmin}},
+		Method:      "DELETE",
+		Description: "Put a user back on the default quota for their roles (admins only)",
+	})
+
+	libhttp.RegisterEndpoint(libhttp.Endpoint{
+		Handler:     software.HandleGetSoftware,
+		Pattern:     "/software/",
+		Middleware:  []libhttp.Middleware{middleware.Cors{}, mildRateLimit},
+		Method:      "GET",
+		Description: "Get the catalog of software available for new containers",
+	})
+
+	libhttp.RegisterEndpoint(libhttp.Endpoint{
+		Handler:     metrics.Handler,
+		Pattern:     "/metrics/",
+		Middleware:  []libhttp.Middleware{middleware.RequireServiceKey{}},
+		Method:      "GET",
+		Description: "Get operational metrics (reconciler actions etc.) for this service, for whatever scrapes them with the service key",
+	})
+
+	ws := libws.SetupWebsocket("/ws/")
+	containers.BroadcastStatusChanges(ws)
+	go func() {
+		// clients have nothing to tell us after their handshake, but the listener blocks until someone reads
+		for range ws.Listen() {
+		}
+	}()
+
 	libhttp.RegisterEndpoint(libhttp.Endpoint{
 		Handler:     libhttp.NoopHandler,
 		Pattern:     ".*",
@@ -0,0 +1,45 @@
+package memory
+
+import (
+	"bitbucket.org/smaug-hosting/services/container-service/orchestrator/spec"
+	"strings"
+	"time"
+)
+
+// enough that a subscriber which is slow to read doesn't miss anything in practice
+const eventBuffer = 64
+
+// Events streams the container start/die events a real backend would produce as services are scaled up and down.
+// The stream never ends.
+func (o *Orchestrator) Events(prefix string) (<-chan spec.Event, <-chan error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	events := make(chan spec.Event, eventBuffer)
+	o.subscribers[events] = prefix
+
+	return events, make(chan error)
+}
+
+// publish must be called with the mutex held.
+func (o *Orchestrator) publish(name string, action string) {
+	event := spec.Event{Service: name, Action: action, Time: time.Now()}
+	for subscriber, prefix := range o.subscribers {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		select {
+		case subscriber <- event:
+		default:
+			// never block the orchestrator on a subscriber that has stopped reading
+		}
+	}
+}
+
+func (o *Orchestrator) publishScale(name string, previous uint64, replicas uint64) {
+	if previous == 0 && replicas > 0 {
+		o.publish(name, "start")
+	} else if previous > 0 && replicas == 0 {
+		o.publish(name, "die")
+	}
+}
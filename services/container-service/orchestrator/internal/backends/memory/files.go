@@ -0,0 +1,168 @@
+package memory
+
+import (
+	"bitbucket.org/smaug-hosting/services/container-service/orchestrator/spec"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Volumes only hold files, directories exist only as far as there are files under them.
+
+func volumeKey(p string) string {
+	return strings.Trim(p, "/")
+}
+
+// under reports whether key is dir itself or inside it
+func under(key string, dir string) bool {
+	return dir == "" || key == dir || strings.HasPrefix(key, dir+"/")
+}
+
+func (v volume) isDir(key string) bool {
+	if key == "" {
+		return true
+	}
+	for k := range v {
+		if strings.HasPrefix(k, key+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *Orchestrator) ListVolumeFiles(name string, dir string) ([]spec.FileInfo, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	files := o.volumes[name]
+	key := volumeKey(dir)
+
+	if _, ok := files[key]; ok && key != "" {
+		return nil, spec.ErrNotADirectory
+	}
+	if !files.isDir(key) {
+		return nil, spec.ErrFileNotFound
+	}
+
+	prefix := ""
+	if key != "" {
+		prefix = key + "/"
+	}
+
+	entries := make(map[string]spec.FileInfo)
+	for k, content := range files {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(k, prefix)
+		if i := strings.Index(rest, "/"); i >= 0 {
+			entries[rest[:i]] = spec.FileInfo{Name: rest[:i], ModTime: time.Unix(0, 0), Dir: true}
+			continue
+		}
+		entries[rest] = spec.FileInfo{Name: rest, Size: int64(len(content)), ModTime: time.Unix(0, 0)}
+	}
+
+	list := make([]spec.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		list = append(list, entry)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	return list, nil
+}
+
+func (o *Orchestrator) ReadVolumeFile(name string, file string) (io.ReadCloser, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	files := o.volumes[name]
+	key := volumeKey(file)
+
+	content, ok := files[key]
+	if !ok {
+		if files.isDir(key) {
+			return nil, spec.ErrIsADirectory
+		}
+		return nil, spec.ErrFileNotFound
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(content)), nil
+}
+
+func (o *Orchestrator) WriteVolumeFile(name string, file string, content io.Reader, size int64) error {
+	data, err := ioutil.ReadAll(io.LimitReader(content, size))
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) != size {
+		return io.ErrUnexpectedEOF
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	files, ok := o.volumes[name]
+	if !ok {
+		files = volume{}
+		o.volumes[name] = files
+	}
+
+	key := volumeKey(file)
+	if files.isDir(key) {
+		return spec.ErrIsADirectory
+	}
+
+	files[key] = data
+	return nil
+}
+
+func (o *Orchestrator) MoveVolumeFile(name string, from string, to string) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	files := o.volumes[name]
+	fromKey, toKey := volumeKey(from), volumeKey(to)
+
+	if _, ok := files[fromKey]; !ok && !files.isDir(fromKey) {
+		return spec.ErrFileNotFound
+	}
+	if _, ok := files[toKey]; ok || files.isDir(toKey) {
+		return spec.ErrFileExists
+	}
+
+	moved := make(map[string][]byte)
+	for k, content := range files {
+		if under(k, fromKey) {
+			delete(files, k)
+			moved[toKey+strings.TrimPrefix(k, fromKey)] = content
+		}
+	}
+	for k, content := range moved {
+		files[k] = content
+	}
+
+	return nil
+}
+
+func (o *Orchestrator) RemoveVolumeFile(name string, file string) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	files := o.volumes[name]
+	key := volumeKey(file)
+
+	if _, ok := files[key]; !ok && !files.isDir(key) {
+		return spec.ErrFileNotFound
+	}
+
+	for k := range files {
+		if under(k, key) {
+			delete(files, k)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,17 @@
+package memory
+
+import (
+	"bitbucket.org/smaug-hosting/services/container-service/orchestrator/spec"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+)
+
+// PullImage has every image available, pinning each to a digest made up from its name.
+func (o *Orchestrator) PullImage(image string, auth *spec.RegistryAuth) (string, error) {
+	if strings.Contains(image, "@") {
+		return image, nil
+	}
+
+	return fmt.Sprintf("%s@sha256:%x", image, sha256.Sum256([]byte(image))), nil
+}
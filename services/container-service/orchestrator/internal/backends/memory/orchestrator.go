@@ -0,0 +1,220 @@
+package memory
+
+import (
+	"bitbucket.org/smaug-hosting/services/container-service/orchestrator/spec"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type service struct {
+	spec     spec.ServiceSpec
+	replicas uint64
+	logs     []string
+}
+
+// log records a line the way a real service would have logged it, so that there is something to read back.
+func (s *service) log(format string, args ...interface{}) {
+	s.logs = append(s.logs, fmt.Sprintf(format, args...))
+}
+
+// Orchestrator keeps services in a map instead of running them anywhere.  Every service is considered to come up
+// the instant it is scaled above zero, which keeps it deterministic enough to test against.
+type Orchestrator struct {
+	mutex    sync.Mutex
+	services map[string]*service
+	volumes  map[string]volume
+	address  string
+	// subscribers to Events, with the prefix each is interested in
+	subscribers map[chan spec.Event]string
+}
+
+func (o *Orchestrator) Setup(args map[string]interface{}) {
+	o.services = make(map[string]*service)
+	o.volumes = make(map[string]volume)
+	o.subscribers = make(map[chan spec.Event]string)
+	o.address = "127.0.0.1"
+	if address, ok := args["address"].(string); ok {
+		o.address = address
+	}
+}
+
+func (o *Orchestrator) Create(s spec.ServiceSpec) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if _, exists := o.services[s.Name]; exists {
+		return spec.ErrServiceExists
+	}
+
+	replicas := uint64(1)
+	if s.Replicas != nil {
+		replicas = *s.Replicas
+	}
+
+	o.services[s.Name] = &service{spec: s, replicas: replicas}
+	o.services[s.Name].log("created with %d replicas", replicas)
+	if replicas > 0 {
+		o.publish(s.Name, "start")
+	}
+	return nil
+}
+
+func (o *Orchestrator) Update(s spec.ServiceSpec) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	existing, exists := o.services[s.Name]
+	if !exists {
+		return spec.ErrServiceNotFound
+	}
+
+	previous := existing.replicas
+	existing.spec = s
+	if s.Replicas != nil {
+		existing.replicas = *s.Replicas
+	}
+	existing.log("updated to %d replicas", existing.replicas)
+	o.publishScale(s.Name, previous, existing.replicas)
+	return nil
+}
+
+func (o *Orchestrator) Scale(name string, replicas uint64) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	existing, exists := o.services[name]
+	if !exists {
+		return spec.ErrServiceNotFound
+	}
+
+	previous := existing.replicas
+	existing.replicas = replicas
+	existing.log("scaled to %d replicas", replicas)
+	o.publishScale(name, previous, replicas)
+	return nil
+}
+
+func (o *Orchestrator) Remove(name string) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if _, exists := o.services[name]; !exists {
+		return spec.ErrServiceNotFound
+	}
+
+	if o.services[name].replicas > 0 {
+		o.publish(name, "die")
+	}
+	delete(o.services, name)
+	return nil
+}
+
+func (o *Orchestrator) Status(name string) (spec.ServiceStatus, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	existing, exists := o.services[name]
+	if !exists {
+		return spec.ServiceStatus{}, spec.ErrServiceNotFound
+	}
+
+	if existing.replicas == 0 {
+		return spec.ServiceStatus{Up: false, State: "stopped"}, nil
+	}
+	return spec.ServiceStatus{Up: true, State: "running"}, nil
+}
+
+func (o *Orchestrator) Endpoint(name string) (string, uint32, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	existing, exists := o.services[name]
+	if !exists {
+		return "", 0, spec.ErrServiceNotFound
+	}
+
+	var port uint32
+	if len(existing.spec.Ports) > 0 {
+		port = existing.spec.Ports[0].Published
+	}
+	return o.address, port, nil
+}
+
+func (o *Orchestrator) EndpointForPort(name string, target uint32) (string, uint32, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	existing, exists := o.services[name]
+	if !exists {
+		return "", 0, spec.ErrServiceNotFound
+	}
+
+	for _, p := range existing.spec.Ports {
+		if p.Target == target {
+			return o.address, p.Published, nil
+		}
+	}
+	return "", 0, fmt.Errorf("service %s does not publish port %d", name, target)
+}
+
+func (o *Orchestrator) InternalEndpoint(name string, target uint32) (string, uint32, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	existing, exists := o.services[name]
+	if !exists {
+		return "", 0, spec.ErrServiceNotFound
+	}
+	if existing.replicas == 0 {
+		return "", 0, spec.ErrNotRunning
+	}
+
+	for _, p := range existing.spec.Ports {
+		if p.Target == target {
+			return o.address, p.Target, nil
+		}
+	}
+	return "", 0, fmt.Errorf("service %s has no port %d", name, target)
+}
+
+func (o *Orchestrator) List(prefix string) ([]string, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	names := make([]string, 0)
+	for name := range o.services {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Logs returns everything logged so far; there is never anything more to follow.
+func (o *Orchestrator) Logs(name string, options spec.LogOptions) (io.ReadCloser, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	existing, exists := o.services[name]
+	if !exists {
+		return nil, spec.ErrServiceNotFound
+	}
+
+	lines := existing.logs
+	if options.Tail > 0 && options.Tail < len(lines) {
+		lines = lines[len(lines)-options.Tail:]
+	}
+
+	var buf bytes.Buffer
+	for _, line := range lines {
+		buf.WriteString(line)
+		buf.WriteString("\n")
+	}
+	return ioutil.NopCloser(&buf), nil
+}
@@ -0,0 +1,41 @@
+package memory
+
+import (
+	"bitbucket.org/smaug-hosting/services/container-service/orchestrator/spec"
+	"strings"
+)
+
+// Stats reports an idle task using none of its resources, as nothing is actually running.
+func (o *Orchestrator) Stats(name string) (spec.Stats, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	s, ok := o.services[name]
+	if !ok {
+		return spec.Stats{}, spec.ErrServiceNotFound
+	}
+
+	if s.replicas == 0 {
+		return spec.Stats{}, spec.ErrNotRunning
+	}
+
+	return spec.Stats{MemoryLimitBytes: uint64(s.spec.Resources.MemoryBytes)}, nil
+}
+
+func (o *Orchestrator) VolumeSizes(prefix string) (map[string]int64, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	sizes := make(map[string]int64)
+	for name, files := range o.volumes {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		var size int64
+		for _, content := range files {
+			size += int64(len(content))
+		}
+		sizes[name] = size
+	}
+	return sizes, nil
+}
@@ -0,0 +1,106 @@
+package memory
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const archiveRoot = "volume"
+
+// volume maps paths (relative to the volume root) to file contents
+type volume map[string][]byte
+
+func (o *Orchestrator) ArchiveVolume(name string) (io.ReadCloser, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	files := o.volumes[name]
+
+	paths := make([]string, 0, len(files))
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	var buf bytes.Buffer
+	writer := tar.NewWriter(&buf)
+
+	err := writer.WriteHeader(&tar.Header{
+		Name:     archiveRoot + "/",
+		Typeflag: tar.TypeDir,
+		Mode:     0755,
+		ModTime:  time.Unix(0, 0),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range paths {
+		err = writer.WriteHeader(&tar.Header{
+			Name:     path.Join(archiveRoot, p),
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+			Size:     int64(len(files[p])),
+			ModTime:  time.Unix(0, 0),
+		})
+		if err != nil {
+			return nil, err
+		}
+		_, err = writer.Write(files[p])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.NopCloser(&buf), nil
+}
+
+func (o *Orchestrator) RestoreVolume(name string, archive io.Reader) error {
+	files := volume{}
+
+	reader := tar.NewReader(archive)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		content, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+
+		files[strings.TrimPrefix(path.Clean(header.Name), archiveRoot+"/")] = content
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.volumes[name] = files
+	return nil
+}
+
+func (o *Orchestrator) RemoveVolume(name string) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	delete(o.volumes, name)
+	return nil
+}
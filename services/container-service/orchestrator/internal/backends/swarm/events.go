@@ -0,0 +1,51 @@
+package swarm
+
+import (
+	"bitbucket.org/smaug-hosting/services/container-service/orchestrator/spec"
+	"context"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"strings"
+	"time"
+)
+
+// swarm labels every task container with the name of its service
+const serviceNameLabel = "com.docker.swarm.service.name"
+
+// Events streams the container events of the services' tasks.  The engine API has no events for services
+// themselves, and only reports on containers on the node we are talking to.
+func (o *Orchestrator) Events(prefix string) (<-chan spec.Event, <-chan error) {
+	args := filters.NewArgs()
+	args.Add("type", "container")
+	args.Add("label", serviceNameLabel)
+
+	messages, dockerErrs := o.dockerClient.Events(context.Background(), types.EventsOptions{
+		Filters: args,
+	})
+
+	events := make(chan spec.Event)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(events)
+		for {
+			select {
+			case message := <-messages:
+				service := message.Actor.Attributes[serviceNameLabel]
+				if !strings.HasPrefix(service, prefix) {
+					continue
+				}
+				events <- spec.Event{
+					Service: service,
+					Action:  message.Action,
+					Time:    time.Unix(0, message.TimeNano),
+				}
+			case err := <-dockerErrs:
+				errs <- err
+				return
+			}
+		}
+	}()
+
+	return events, errs
+}
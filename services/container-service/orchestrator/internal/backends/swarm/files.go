@@ -0,0 +1,248 @@
+package swarm
+
+import (
+	"archive/tar"
+	"bitbucket.org/smaug-hosting/services/container-service/orchestrator/spec"
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+	"io"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// File operations run small shell scripts in a volume helper.  Paths given to them are relative to the volume and
+// already cleaned by the caller; they are passed as arguments rather than spliced into the script, so odd file
+// names can't change what the script does.  Anything (e.g. a symlink) that leads outside the volume only leads
+// into the helper container's own throwaway filesystem.
+
+// exit statuses the scripts use to report why they didn't do anything
+const (
+	exitNotFound     = 3
+	exitNotDirectory = 4
+	exitExists       = 5
+	exitIsDirectory  = 6
+)
+
+// where uploads are staged inside the helper before being moved into the volume
+const uploadStagingDir = "/tmp"
+const uploadStagingName = "upload"
+
+func volumePath(p string) string {
+	return path.Join(volumeMountPoint, p)
+}
+
+func helperError(status int64, cmd []string) error {
+	switch status {
+	case 0:
+		return nil
+	case exitNotFound:
+		return spec.ErrFileNotFound
+	case exitNotDirectory:
+		return spec.ErrNotADirectory
+	case exitExists:
+		return spec.ErrFileExists
+	case exitIsDirectory:
+		return spec.ErrIsADirectory
+	default:
+		return fmt.Errorf("volume helper %v exited with status %d", cmd, status)
+	}
+}
+
+// runScript runs the shell script in a helper against the volume, returning its stdout.
+func (o *Orchestrator) runScript(volume string, script string, args ...string) ([]byte, error) {
+	cmd := append([]string{"sh", "-c", script, "--"}, args...)
+
+	id, err := o.createHelper(volume, cmd)
+	if err != nil {
+		return nil, err
+	}
+	defer o.removeHelper(id)
+
+	status, err := o.startHelper(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := helperError(status, cmd); err != nil {
+		return nil, err
+	}
+
+	logs, err := o.dockerClient.ContainerLogs(context.Background(), id, types.ContainerLogsOptions{ShowStdout: true})
+	if err != nil {
+		return nil, err
+	}
+	defer logs.Close()
+
+	var stdout bytes.Buffer
+	_, err = stdcopy.StdCopy(&stdout, ioutil.Discard, logs)
+
+	return stdout.Bytes(), err
+}
+
+const listScript = `
+[ -e "$1" ] || exit 3
+[ -d "$1" ] || exit 4
+find "$1" -mindepth 1 -maxdepth 1 -exec stat -c '%s|%Y|%F|%n' {} +
+`
+
+func (o *Orchestrator) ListVolumeFiles(volume string, dir string) ([]spec.FileInfo, error) {
+	output, err := o.runScript(volume, listScript, volumePath(dir))
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]spec.FileInfo, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		// the name goes last, so that it can contain the separator
+		fields := strings.SplitN(scanner.Text(), "|", 4)
+		if len(fields) != 4 {
+			continue
+		}
+
+		size, _ := strconv.ParseInt(fields[0], 10, 64)
+		modified, _ := strconv.ParseInt(fields[1], 10, 64)
+
+		files = append(files, spec.FileInfo{
+			Name:    path.Base(fields[3]),
+			Size:    size,
+			ModTime: time.Unix(modified, 0),
+			Dir:     fields[2] == "directory",
+		})
+	}
+
+	return files, scanner.Err()
+}
+
+// fileStream hands out a single file from the tar stream docker copies out of the helper.
+type fileStream struct {
+	io.Reader
+	archive io.Closer
+}
+
+func (f fileStream) Close() error {
+	return f.archive.Close()
+}
+
+// readScript only checks there is a file to read, docker copies it out of the helper once that has exited
+const readScript = `
+[ -e "$1" ] || exit 3
+[ -d "$1" ] && exit 6
+exit 0
+`
+
+func (o *Orchestrator) ReadVolumeFile(volume string, file string) (io.ReadCloser, error) {
+	cmd := []string{"sh", "-c", readScript, "--", volumePath(file)}
+
+	id, err := o.createHelper(volume, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := o.startHelper(id)
+	if err == nil {
+		err = helperError(status, cmd)
+	}
+	if err != nil {
+		o.removeHelper(id)
+		return nil, err
+	}
+
+	archive, _, err := o.dockerClient.CopyFromContainer(context.Background(), id, volumePath(file))
+	if err != nil {
+		o.removeHelper(id)
+		return nil, err
+	}
+
+	stream := helperStream{ReadCloser: archive, orchestrator: o, helperId: id}
+
+	reader := tar.NewReader(stream)
+	if _, err := reader.Next(); err != nil {
+		stream.Close()
+		return nil, err
+	}
+
+	return fileStream{Reader: reader, archive: stream}, nil
+}
+
+// writeScript moves the staged upload into place and gives it to whoever owns the volume, which is the user the
+// game server runs as.
+const writeScript = `
+[ -d "$2" ] && exit 6
+mkdir -p "$(dirname "$2")" || exit 1
+mv -f "$1" "$2" || exit 1
+chown "$(stat -c %u:%g /volume)" "$2"
+`
+
+func (o *Orchestrator) WriteVolumeFile(volume string, file string, content io.Reader, size int64) error {
+	staged := path.Join(uploadStagingDir, uploadStagingName)
+	cmd := []string{"sh", "-c", writeScript, "--", staged, volumePath(file)}
+
+	id, err := o.createHelper(volume, cmd)
+	if err != nil {
+		return err
+	}
+	defer o.removeHelper(id)
+
+	// docker only takes files in as a tar stream, so wrap the content in one on the fly
+	reader, writer := io.Pipe()
+	go func() {
+		archive := tar.NewWriter(writer)
+		err := archive.WriteHeader(&tar.Header{
+			Name:     uploadStagingName,
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+			Size:     size,
+			ModTime:  time.Now(),
+		})
+		if err == nil {
+			_, err = io.CopyN(archive, content, size)
+		}
+		if err == nil {
+			err = archive.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+
+	err = o.dockerClient.CopyToContainer(context.Background(), id, uploadStagingDir, reader, types.CopyToContainerOptions{})
+	reader.CloseWithError(err)
+	if err != nil {
+		return err
+	}
+
+	status, err := o.startHelper(id)
+	if err != nil {
+		return err
+	}
+
+	return helperError(status, cmd)
+}
+
+const moveScript = `
+[ -e "$1" ] || exit 3
+[ -e "$2" ] && exit 5
+mkdir -p "$(dirname "$2")" || exit 1
+mv "$1" "$2"
+`
+
+func (o *Orchestrator) MoveVolumeFile(volume string, from string, to string) error {
+	_, err := o.runScript(volume, moveScript, volumePath(from), volumePath(to))
+	return err
+}
+
+const removeScript = `
+[ -e "$1" ] || exit 3
+rm -rf "$1"
+`
+
+func (o *Orchestrator) RemoveVolumeFile(volume string, file string) error {
+	_, err := o.runScript(volume, removeScript, volumePath(file))
+	return err
+}
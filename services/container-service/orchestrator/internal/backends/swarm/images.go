@@ -0,0 +1,104 @@
+package swarm
+
+import (
+	"bitbucket.org/smaug-hosting/services/container-service/orchestrator/spec"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/sirupsen/logrus"
+	"io"
+)
+
+// encodeRegistryAuth puts the credentials into the form of the X-Registry-Auth header, which is what the docker API
+// expects wherever it takes registry credentials.
+func encodeRegistryAuth(auth *spec.RegistryAuth) (string, error) {
+	if auth == nil {
+		return "", nil
+	}
+
+	authJson, err := json.Marshal(types.AuthConfig{
+		Username:      auth.Username,
+		Password:      auth.Password,
+		ServerAddress: auth.ServerAddress,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return base64.URLEncoding.EncodeToString(authJson), nil
+}
+
+// pullMessage is the part of each message in a pull's progress stream that we care about
+type pullMessage struct {
+	Error string `json:"error"`
+}
+
+func (o *Orchestrator) PullImage(image string, auth *spec.RegistryAuth) (string, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		logrus.Errorf("Could not parse image reference %s: %s", image, err)
+		return "", spec.ErrImageNotFound
+	}
+
+	encodedAuth, err := encodeRegistryAuth(auth)
+	if err != nil {
+		return "", err
+	}
+
+	progress, err := o.dockerClient.ImagePull(context.Background(), image, types.ImagePullOptions{RegistryAuth: encodedAuth})
+	if client.IsErrConnectionFailed(err) {
+		return "", err
+	} else if err != nil {
+		logrus.Errorf("Could not pull image %s: %s", image, err)
+		return "", spec.ErrImageNotFound
+	}
+	defer progress.Close()
+
+	// failures part way through (e.g. a missing tag) are only reported in the progress stream, which also has to be
+	// read to the end for the pull to finish
+	decoder := json.NewDecoder(progress)
+	for {
+		message := pullMessage{}
+		err = decoder.Decode(&message)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return "", err
+		}
+		if message.Error != "" {
+			logrus.Errorf("Could not pull image %s: %s", image, message.Error)
+			return "", spec.ErrImageNotFound
+		}
+	}
+
+	if _, ok := named.(reference.Digested); ok {
+		return image, nil
+	}
+
+	inspect, _, err := o.dockerClient.ImageInspectWithRaw(context.Background(), image)
+	if err != nil {
+		return "", err
+	}
+
+	for _, repoDigest := range inspect.RepoDigests {
+		digested, err := reference.ParseNormalizedNamed(repoDigest)
+		if err != nil {
+			continue
+		}
+		if canonical, ok := digested.(reference.Canonical); ok && canonical.Name() == named.Name() {
+			// keep the tag as well, for the benefit of anyone reading it
+			pinned, err := reference.WithDigest(named, canonical.Digest())
+			if err != nil {
+				return "", err
+			}
+			return reference.FamiliarString(pinned), nil
+		}
+	}
+
+	// images that never came from a registry have no digest to pin them to
+	logrus.Warnf("Image %s has no digest from its registry, using it unpinned", image)
+	return image, nil
+}
@@ -0,0 +1,383 @@
+package swarm
+
+import (
+	"bitbucket.org/smaug-hosting/services/container-service/orchestrator/spec"
+	µ "bitbucket.org/smaug-hosting/services/micro"
+	"context"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type Orchestrator struct {
+	dockerClient *client.Client
+	// small image with a shell, used for helper containers that work on volumes
+	helperImage string
+	// overlay network whelps are attached to, which is how we reach the ports they don't publish
+	network string
+}
+
+func (o *Orchestrator) Setup(args map[string]interface{}) error {
+	dockerClient, err := client.NewEnvClient()
+	if err != nil {
+		logrus.WithField("severity", "CRITICAL").Errorf("Could not create docker client: %s", err)
+		return err
+	}
+
+	o.dockerClient = dockerClient
+	o.helperImage = µ.GetEnvDefault("VOLUME_HELPER_IMAGE", "busybox:1.31")
+	// container-service must be attached to this network as well; without it internal ports can't be reached at all
+	o.network = µ.GetEnvDefault("WHELP_NETWORK", "")
+	return nil
+}
+
+func toSwarmSpec(s spec.ServiceSpec, network string) swarm.ServiceSpec {
+	mounts := make([]mount.Mount, 0, len(s.Mounts))
+	for _, m := range s.Mounts {
+		mnt := mount.Mount{
+			Type:   "volume",
+			Source: m.Source,
+			Target: m.Target,
+		}
+		if m.Driver != "" {
+			mnt.VolumeOptions = &mount.VolumeOptions{
+				DriverConfig: &mount.Driver{
+					Name:    m.Driver,
+					Options: m.DriverOptions,
+				},
+			}
+		}
+		mounts = append(mounts, mnt)
+	}
+
+	ports := make([]swarm.PortConfig, 0, len(s.Ports))
+	for _, p := range s.Ports {
+		if p.Internal {
+			continue
+		}
+		ports = append(ports, swarm.PortConfig{
+			Name:          p.Name,
+			Protocol:      swarm.PortConfigProtocol(p.Protocol),
+			TargetPort:    p.Target,
+			PublishedPort: p.Published,
+		})
+	}
+
+	swarmSpec := swarm.ServiceSpec{
+		Annotations: swarm.Annotations{
+			Name: s.Name,
+		},
+		TaskTemplate: swarm.TaskSpec{
+			ContainerSpec: swarm.ContainerSpec{
+				Image:  s.Image,
+				Env:    s.Env,
+				Mounts: mounts,
+			},
+			Resources: &swarm.ResourceRequirements{
+				Limits: &swarm.Resources{
+					NanoCPUs:    s.Resources.NanoCPUs,
+					MemoryBytes: s.Resources.MemoryBytes,
+				},
+				Reservations: &swarm.Resources{
+					NanoCPUs:    s.Resources.ReservedNanoCPUs,
+					MemoryBytes: s.Resources.ReservedMemoryBytes,
+				},
+			},
+		},
+		EndpointSpec: &swarm.EndpointSpec{
+			Ports: ports,
+		},
+	}
+
+	if network != "" {
+		swarmSpec.TaskTemplate.Networks = []swarm.NetworkAttachmentConfig{{Target: network}}
+	}
+
+	if s.Replicas != nil {
+		swarmSpec.Mode = swarm.ServiceMode{
+			Replicated: &swarm.ReplicatedService{
+				Replicas: s.Replicas,
+			},
+		}
+	}
+
+	return swarmSpec
+}
+
+func (o *Orchestrator) inspect(name string) (swarm.Service, error) {
+	service, _, err := o.dockerClient.ServiceInspectWithRaw(context.Background(), name)
+	if client.IsErrServiceNotFound(err) {
+		return service, spec.ErrServiceNotFound
+	}
+	return service, err
+}
+
+func (o *Orchestrator) Create(s spec.ServiceSpec) error {
+	encodedAuth, err := encodeRegistryAuth(s.RegistryAuth)
+	if err != nil {
+		return err
+	}
+
+	srvcCreateResponse, err := o.dockerClient.ServiceCreate(context.Background(), toSwarmSpec(s, o.network), types.ServiceCreateOptions{
+		EncodedRegistryAuth: encodedAuth,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, warning := range srvcCreateResponse.Warnings {
+		logrus.Warnf("Warning while creating docker service: %s", warning)
+	}
+
+	return nil
+}
+
+// update replaces the service's spec.  Without new registry credentials the service keeps pulling with the ones it
+// was given before.
+func (o *Orchestrator) update(service swarm.Service, swarmSpec swarm.ServiceSpec, auth *spec.RegistryAuth) error {
+	encodedAuth, err := encodeRegistryAuth(auth)
+	if err != nil {
+		return err
+	}
+
+	serviceUpdateResponse, err := o.dockerClient.ServiceUpdate(
+		context.Background(),
+		service.ID,
+		swarm.Version{
+			Index: service.Version.Index,
+		},
+		swarmSpec,
+		types.ServiceUpdateOptions{
+			EncodedRegistryAuth: encodedAuth,
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, warning := range serviceUpdateResponse.Warnings {
+		logrus.Warnf("Service update warning: %s", warning)
+	}
+
+	return nil
+}
+
+func (o *Orchestrator) Update(s spec.ServiceSpec) error {
+	service, err := o.inspect(s.Name)
+	if err != nil {
+		return err
+	}
+
+	return o.update(service, toSwarmSpec(s, o.network), s.RegistryAuth)
+}
+
+func (o *Orchestrator) Scale(name string, replicas uint64) error {
+	service, err := o.inspect(name)
+	if err != nil {
+		return err
+	}
+
+	// scale the service as it currently stands, rather than re-deriving the rest of the spec
+	swarmSpec := service.Spec
+	swarmSpec.Mode = swarm.ServiceMode{
+		Replicated: &swarm.ReplicatedService{
+			Replicas: &replicas,
+		},
+	}
+
+	return o.update(service, swarmSpec, nil)
+}
+
+func (o *Orchestrator) Remove(name string) error {
+	err := o.dockerClient.ServiceRemove(context.Background(), name)
+	if client.IsErrServiceNotFound(err) {
+		return spec.ErrServiceNotFound
+	}
+	return err
+}
+
+func (o *Orchestrator) Status(name string) (spec.ServiceStatus, error) {
+	var status spec.ServiceStatus
+
+	_, err := o.inspect(name)
+	if err != nil {
+		return status, err
+	}
+
+	args, err := filters.ParseFlag(fmt.Sprintf("service=%s", name), filters.NewArgs())
+	if err != nil {
+		return status, err
+	}
+
+	tasks, err := o.dockerClient.TaskList(context.Background(), types.TaskListOptions{
+		Filters: args,
+	})
+	if err != nil {
+		return status, err
+	}
+
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].UpdatedAt.After(tasks[j].UpdatedAt)
+	})
+
+	if len(tasks) == 0 {
+		status.Up = false
+		status.State = "stopped"
+	} else {
+		task := tasks[0]
+		status.State = string(task.Status.State)
+		if task.Status.State == swarm.TaskStateRunning {
+			status.Up = true
+		} else if task.Status.State == swarm.TaskStateShutdown {
+			status.Up = false
+			status.State = "stopped"
+		} else if task.Status.State != swarm.TaskStateFailed {
+			// break on non-failed status because we only want to report "failed" if _all_ tasks failed.
+			status.Up = false
+		}
+	}
+
+	return status, nil
+}
+
+func (o *Orchestrator) Endpoint(name string) (string, uint32, error) {
+	service, err := o.inspect(name)
+	if err != nil {
+		return "", 0, err
+	}
+
+	if len(service.Endpoint.Ports) == 0 {
+		return "", 0, fmt.Errorf("service %s has no published ports", name)
+	}
+
+	return o.endpointFor(service.Endpoint.Ports[0])
+}
+
+func (o *Orchestrator) EndpointForPort(name string, target uint32) (string, uint32, error) {
+	service, err := o.inspect(name)
+	if err != nil {
+		return "", 0, err
+	}
+
+	for _, p := range service.Endpoint.Ports {
+		if p.TargetPort == target {
+			return o.endpointFor(p)
+		}
+	}
+
+	return "", 0, fmt.Errorf("service %s does not publish port %d", name, target)
+}
+
+func (o *Orchestrator) InternalEndpoint(name string, target uint32) (string, uint32, error) {
+	if o.network == "" {
+		return "", 0, fmt.Errorf("no WHELP_NETWORK configured to reach port %d of service %s on", target, name)
+	}
+
+	task, err := o.runningTask(name)
+	if err != nil {
+		return "", 0, err
+	}
+
+	for _, attachment := range task.NetworksAttachments {
+		if attachment.Network.Spec.Name != o.network && attachment.Network.ID != o.network {
+			continue
+		}
+		for _, address := range attachment.Addresses {
+			// addresses come with the network's prefix length on the end
+			ip, _, err := net.ParseCIDR(address)
+			if err == nil {
+				return ip.String(), target, nil
+			}
+		}
+	}
+
+	return "", 0, fmt.Errorf("service %s has no address on network %s", name, o.network)
+}
+
+// endpointFor pairs a published port with this node's address, which the routing mesh will forward from.
+func (o *Orchestrator) endpointFor(port swarm.PortConfig) (string, uint32, error) {
+	info, err := o.dockerClient.Info(context.Background())
+	if err != nil {
+		return "", port.PublishedPort, err
+	}
+
+	return info.Swarm.NodeAddr, port.PublishedPort, nil
+}
+
+func (o *Orchestrator) List(prefix string) ([]string, error) {
+	args, err := filters.ParseFlag(fmt.Sprintf("name=%s", prefix), filters.NewArgs())
+	if err != nil {
+		return nil, err
+	}
+
+	services, err := o.dockerClient.ServiceList(context.Background(), types.ServiceListOptions{
+		Filters: args,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(services))
+	for _, service := range services {
+		// the name filter matches anywhere in the name, we only want the prefix
+		if strings.HasPrefix(service.Spec.Name, prefix) {
+			names = append(names, service.Spec.Name)
+		}
+	}
+	return names, nil
+}
+
+// logStream is the demultiplexed side of a docker log stream, closing it closes the underlying stream too.
+type logStream struct {
+	*io.PipeReader
+	raw io.ReadCloser
+}
+
+func (l logStream) Close() error {
+	_ = l.PipeReader.Close()
+	return l.raw.Close()
+}
+
+func (o *Orchestrator) Logs(name string, options spec.LogOptions) (io.ReadCloser, error) {
+	logOptions := types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Timestamps: true,
+		Follow:     options.Follow,
+		Tail:       "all",
+	}
+
+	if options.Tail > 0 {
+		logOptions.Tail = strconv.Itoa(options.Tail)
+	}
+
+	if !options.Since.IsZero() {
+		logOptions.Since = strconv.FormatInt(options.Since.Unix(), 10)
+	}
+
+	raw, err := o.dockerClient.ServiceLogs(context.Background(), name, logOptions)
+	if client.IsErrServiceNotFound(err) {
+		return nil, spec.ErrServiceNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	// whelps don't run with a TTY, so stdout and stderr come multiplexed into a single stream
+	reader, writer := io.Pipe()
+	go func() {
+		_, err := stdcopy.StdCopy(writer, writer, raw)
+		_ = writer.CloseWithError(err)
+	}()
+
+	return logStream{PipeReader: reader, raw: raw}, nil
+}
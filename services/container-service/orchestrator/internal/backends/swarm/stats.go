@@ -0,0 +1,114 @@
+package swarm
+
+import (
+	"bitbucket.org/smaug-hosting/services/container-service/orchestrator/spec"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/swarm"
+	"strings"
+)
+
+// runningTask finds the service's running task.
+func (o *Orchestrator) runningTask(name string) (swarm.Task, error) {
+	_, err := o.inspect(name)
+	if err != nil {
+		return swarm.Task{}, err
+	}
+
+	args, err := filters.ParseFlag(fmt.Sprintf("service=%s", name), filters.NewArgs())
+	if err != nil {
+		return swarm.Task{}, err
+	}
+	args.Add("desired-state", string(swarm.TaskStateRunning))
+
+	tasks, err := o.dockerClient.TaskList(context.Background(), types.TaskListOptions{
+		Filters: args,
+	})
+	if err != nil {
+		return swarm.Task{}, err
+	}
+
+	for _, task := range tasks {
+		if task.Status.State == swarm.TaskStateRunning && task.Status.ContainerStatus.ContainerID != "" {
+			return task, nil
+		}
+	}
+
+	return swarm.Task{}, spec.ErrNotRunning
+}
+
+// runningContainer finds the container behind the service's running task.  Like the volume helpers, this assumes
+// we are talking to the node the task runs on, as that is the only node that can report on the container.
+func (o *Orchestrator) runningContainer(name string) (string, error) {
+	task, err := o.runningTask(name)
+	if err != nil {
+		return "", err
+	}
+
+	return task.Status.ContainerStatus.ContainerID, nil
+}
+
+func (o *Orchestrator) Stats(name string) (spec.Stats, error) {
+	var stats spec.Stats
+
+	containerId, err := o.runningContainer(name)
+	if err != nil {
+		return stats, err
+	}
+
+	// without streaming, docker samples twice so that the previous cpu stats are filled in for us
+	response, err := o.dockerClient.ContainerStats(context.Background(), containerId, false)
+	if err != nil {
+		return stats, err
+	}
+	defer response.Body.Close()
+
+	var raw types.StatsJSON
+	err = json.NewDecoder(response.Body).Decode(&raw)
+	if err != nil {
+		return stats, err
+	}
+
+	cpuDelta := float64(raw.CPUStats.CPUUsage.TotalUsage) - float64(raw.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(raw.CPUStats.SystemUsage) - float64(raw.PreCPUStats.SystemUsage)
+	if cpuDelta > 0 && systemDelta > 0 {
+		// as a percentage of one cpu, the same way `docker stats` reports it
+		stats.CPUPercent = cpuDelta / systemDelta * float64(len(raw.CPUStats.CPUUsage.PercpuUsage)) * 100
+	}
+
+	// the page cache counts towards usage, but the kernel gives it up under pressure so it isn't really in use
+	stats.MemoryBytes = raw.MemoryStats.Usage
+	if cache := raw.MemoryStats.Stats["cache"]; cache < stats.MemoryBytes {
+		stats.MemoryBytes -= cache
+	}
+	stats.MemoryLimitBytes = raw.MemoryStats.Limit
+
+	for _, network := range raw.Networks {
+		stats.NetworkRxBytes += network.RxBytes
+		stats.NetworkTxBytes += network.TxBytes
+	}
+
+	return stats, nil
+}
+
+// VolumeSizes asks docker's disk usage report for the sizes of the volumes, which it only works out for volumes on
+// the local driver.  The report covers every volume on the node and is slow to produce, hence fetching them all at
+// once.
+func (o *Orchestrator) VolumeSizes(prefix string) (map[string]int64, error) {
+	usage, err := o.dockerClient.DiskUsage(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	sizes := make(map[string]int64)
+	for _, v := range usage.Volumes {
+		if strings.HasPrefix(v.Name, prefix) && v.UsageData != nil && v.UsageData.Size >= 0 {
+			sizes[v.Name] = v.UsageData.Size
+		}
+	}
+
+	return sizes, nil
+}
@@ -0,0 +1,163 @@
+package swarm
+
+import (
+	"context"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/client"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+)
+
+// Volumes are only reachable through a container that mounts them, so everything in here works by creating a
+// short-lived helper container with the volume mounted at volumeMountPoint.  Volumes are local to the node they
+// were created on, so this assumes we are talking to the same node that runs the whelp.
+
+const volumeMountPoint = "/volume"
+
+// archiveRoot is the name everything inside a volume archive lives under (the basename of the mount point)
+const archiveRoot = "volume"
+
+func (o *Orchestrator) ensureHelperImage() error {
+	_, _, err := o.dockerClient.ImageInspectWithRaw(context.Background(), o.helperImage)
+	if err == nil {
+		return nil
+	} else if !client.IsErrImageNotFound(err) {
+		return err
+	}
+
+	progress, err := o.dockerClient.ImagePull(context.Background(), o.helperImage, types.ImagePullOptions{})
+	if err != nil {
+		return err
+	}
+	defer progress.Close()
+
+	// the pull only finishes once the progress stream has been read to the end
+	_, err = io.Copy(ioutil.Discard, progress)
+	return err
+}
+
+func (o *Orchestrator) createHelper(volume string, cmd []string) (string, error) {
+	err := o.ensureHelperImage()
+	if err != nil {
+		return "", err
+	}
+
+	created, err := o.dockerClient.ContainerCreate(
+		context.Background(),
+		&container.Config{
+			Image: o.helperImage,
+			Cmd:   cmd,
+		},
+		&container.HostConfig{
+			Mounts: []mount.Mount{
+				{
+					Type:   "volume",
+					Source: volume,
+					Target: volumeMountPoint,
+				},
+			},
+		},
+		nil,
+		"",
+	)
+	if err != nil {
+		return "", err
+	}
+
+	return created.ID, nil
+}
+
+func (o *Orchestrator) removeHelper(id string) {
+	err := o.dockerClient.ContainerRemove(context.Background(), id, types.ContainerRemoveOptions{Force: true})
+	if err != nil {
+		logrus.Errorf("Could not remove volume helper container %s: %s", id, err)
+	}
+}
+
+// runHelper runs the command against the volume to completion.
+func (o *Orchestrator) runHelper(volume string, cmd ...string) error {
+	id, err := o.createHelper(volume, cmd)
+	if err != nil {
+		return err
+	}
+	defer o.removeHelper(id)
+
+	status, err := o.startHelper(id)
+	if err != nil {
+		return err
+	}
+
+	if status != 0 {
+		return fmt.Errorf("volume helper %v exited with status %d", cmd, status)
+	}
+
+	return nil
+}
+
+// startHelper runs an already created helper to completion, returning its exit status.
+func (o *Orchestrator) startHelper(id string) (int64, error) {
+	err := o.dockerClient.ContainerStart(context.Background(), id, types.ContainerStartOptions{})
+	if err != nil {
+		return 0, err
+	}
+
+	return o.dockerClient.ContainerWait(context.Background(), id)
+}
+
+// helperStream removes the helper container once the stream copied out of it is closed.
+type helperStream struct {
+	io.ReadCloser
+	orchestrator *Orchestrator
+	helperId     string
+}
+
+func (h helperStream) Close() error {
+	err := h.ReadCloser.Close()
+	h.orchestrator.removeHelper(h.helperId)
+	return err
+}
+
+func (o *Orchestrator) ArchiveVolume(volume string) (io.ReadCloser, error) {
+	// the helper never needs to run, docker can copy out of a container that was only created
+	id, err := o.createHelper(volume, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	archive, _, err := o.dockerClient.CopyFromContainer(context.Background(), id, volumeMountPoint)
+	if err != nil {
+		o.removeHelper(id)
+		return nil, err
+	}
+
+	return helperStream{ReadCloser: archive, orchestrator: o, helperId: id}, nil
+}
+
+func (o *Orchestrator) RestoreVolume(volume string, archive io.Reader) error {
+	// clear out the volume first, otherwise files that aren't in the archive would survive the restore
+	err := o.runHelper(volume, "sh", "-c", fmt.Sprintf("rm -rf %s/* %s/.[!.]*", volumeMountPoint, volumeMountPoint))
+	if err != nil {
+		return err
+	}
+
+	id, err := o.createHelper(volume, nil)
+	if err != nil {
+		return err
+	}
+	defer o.removeHelper(id)
+
+	// archives are rooted at the mount point's basename, so extracting at / puts everything back where it was
+	return o.dockerClient.CopyToContainer(context.Background(), id, "/", archive, types.CopyToContainerOptions{})
+}
+
+func (o *Orchestrator) RemoveVolume(volume string) error {
+	err := o.dockerClient.VolumeRemove(context.Background(), volume, false)
+	if client.IsErrVolumeNotFound(err) {
+		return nil
+	}
+	return err
+}
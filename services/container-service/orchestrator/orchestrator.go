@@ -0,0 +1,92 @@
+package orchestrator
+
+import (
+	"bitbucket.org/smaug-hosting/services/container-service/orchestrator/internal/backends/memory"
+	"bitbucket.org/smaug-hosting/services/container-service/orchestrator/internal/backends/swarm"
+	"bitbucket.org/smaug-hosting/services/container-service/orchestrator/spec"
+	µ "bitbucket.org/smaug-hosting/services/micro"
+	"errors"
+	"io"
+)
+
+// Orchestrator is everything container-service needs from whatever is actually running the whelps.
+type Orchestrator interface {
+	Create(service spec.ServiceSpec) error
+	Update(service spec.ServiceSpec) error
+	Scale(name string, replicas uint64) error
+	Remove(name string) error
+	Status(name string) (spec.ServiceStatus, error)
+	Endpoint(name string) (string, uint32, error)
+	// EndpointForPort is like Endpoint, but for whichever port the given target port was published on
+	EndpointForPort(name string, target uint32) (string, uint32, error)
+	// InternalEndpoint is the address and port of the service's running task on the network our own services share
+	// with it, for ports that are not published
+	InternalEndpoint(name string, target uint32) (string, uint32, error)
+	// List returns the names of all services whose name starts with the given prefix
+	List(prefix string) ([]string, error)
+	// Logs returns the service's combined stdout/stderr as plain text, one line per log entry
+	Logs(name string, options spec.LogOptions) (io.ReadCloser, error)
+	// ArchiveVolume returns the contents of a volume as a tar stream, with everything under a single "volume" dir
+	ArchiveVolume(volume string) (io.ReadCloser, error)
+	// RestoreVolume replaces the contents of a volume with those of an archive from ArchiveVolume
+	RestoreVolume(volume string, archive io.Reader) error
+	// RemoveVolume removes a volume, succeeding if it has already gone
+	RemoveVolume(volume string) error
+	// Stats returns the resource usage of the service's running task, or spec.ErrNotRunning if it has none
+	Stats(name string) (spec.Stats, error)
+	// VolumeSizes returns the disk space used by each volume whose name starts with the given prefix, leaving out any
+	// the backend can't measure
+	VolumeSizes(prefix string) (map[string]int64, error)
+	// The volume file operations take cleaned, slash-separated paths rooted at the volume ("/" being the root of
+	// the volume itself).  They fail with spec.ErrFileNotFound etc. where that applies.
+	ListVolumeFiles(volume string, dir string) ([]spec.FileInfo, error)
+	ReadVolumeFile(volume string, file string) (io.ReadCloser, error)
+	// WriteVolumeFile creates or replaces a file with exactly size bytes read from content, creating its directory
+	WriteVolumeFile(volume string, file string, content io.Reader, size int64) error
+	// MoveVolumeFile renames a file or directory, refusing to replace anything already at the destination
+	MoveVolumeFile(volume string, from string, to string) error
+	// RemoveVolumeFile removes a file, or a directory and everything in it
+	RemoveVolumeFile(volume string, file string) error
+	// PullImage makes sure the image exists and can be pulled, returning it pinned to the digest it currently refers
+	// to.  It fails with spec.ErrImageNotFound if the registry doesn't have it (or won't give it to us).
+	PullImage(image string, auth *spec.RegistryAuth) (string, error)
+	// Events streams what happens to the tasks of services whose name starts with the given prefix.  The stream ends
+	// with an error on the second channel, after which the caller must subscribe again.
+	Events(prefix string) (<-chan spec.Event, <-chan error)
+}
+
+type BackendType int
+
+const (
+	BackendSwarm BackendType = iota
+	BackendMemory
+)
+
+var ErrOrchestratorBackendUnavailable = errors.New("orchestrator backend not found")
+
+func GetOrchestratorInstance(backend BackendType, args map[string]interface{}) (Orchestrator, error) {
+	switch backend {
+	case BackendSwarm:
+		orchestrator := swarm.Orchestrator{}
+		err := orchestrator.Setup(args)
+		return &orchestrator, err
+	case BackendMemory:
+		orchestrator := memory.Orchestrator{}
+		orchestrator.Setup(args)
+		return &orchestrator, nil
+	default:
+		return nil, ErrOrchestratorBackendUnavailable
+	}
+}
+
+// GetOrchestratorInstanceFromEnv picks the backend named by ORCHESTRATOR_BACKEND, defaulting to swarm.
+func GetOrchestratorInstanceFromEnv() (Orchestrator, error) {
+	switch µ.GetEnvDefault("ORCHESTRATOR_BACKEND", "swarm") {
+	case "swarm":
+		return GetOrchestratorInstance(BackendSwarm, nil)
+	case "memory":
+		return GetOrchestratorInstance(BackendMemory, nil)
+	default:
+		return nil, ErrOrchestratorBackendUnavailable
+	}
+}
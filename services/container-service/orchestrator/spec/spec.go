@@ -0,0 +1,98 @@
+package spec
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrServiceNotFound = errors.New("service not found")
+	ErrServiceExists   = errors.New("service already exists")
+	ErrNotRunning      = errors.New("service has no running task")
+	ErrFileNotFound    = errors.New("no such file or directory")
+	ErrFileExists      = errors.New("file already exists")
+	ErrNotADirectory   = errors.New("not a directory")
+	ErrIsADirectory    = errors.New("is a directory")
+	ErrImageNotFound   = errors.New("image not found, or not accessible with the registry credentials given")
+)
+
+// RegistryAuth is what the backend logs in to a private image registry with.
+type RegistryAuth struct {
+	ServerAddress string
+	Username      string
+	Password      string
+}
+
+type Mount struct {
+	Source string
+	Target string
+	// optional volume driver (and its options) to use when the volume is first created
+	Driver        string
+	DriverOptions map[string]string
+}
+
+type Port struct {
+	Name      string
+	Protocol  string
+	Target    uint32
+	Published uint32
+	// internal ports are never published, only our own services can reach them (see InternalEndpoint)
+	Internal bool
+}
+
+type Resources struct {
+	NanoCPUs            int64
+	MemoryBytes         int64
+	ReservedNanoCPUs    int64
+	ReservedMemoryBytes int64
+}
+
+type ServiceSpec struct {
+	Name      string
+	Image     string
+	Env       []string
+	Mounts    []Mount
+	Ports     []Port
+	Resources Resources
+	// nil leaves the replica count up to the backend (a single replica on create)
+	Replicas *uint64
+	// nil for images that can be pulled without logging in
+	RegistryAuth *RegistryAuth
+}
+
+type ServiceStatus struct {
+	Up    bool
+	State string
+}
+
+type LogOptions struct {
+	// number of lines from the end of the log to start from, zero for the whole log
+	Tail   int
+	Since  time.Time
+	Follow bool
+}
+
+// Stats is a snapshot of the resources a service's running task is using.  Network counters are totals since the
+// task started.
+type Stats struct {
+	CPUPercent       float64
+	MemoryBytes      uint64
+	MemoryLimitBytes uint64
+	NetworkRxBytes   uint64
+	NetworkTxBytes   uint64
+}
+
+// Event is something happening to one of a service's tasks, e.g. its container starting or dying.
+type Event struct {
+	Service string
+	Action  string
+	Time    time.Time
+}
+
+// FileInfo describes an entry in a directory of a volume.
+type FileInfo struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+	Dir     bool
+}
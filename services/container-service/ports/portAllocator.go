@@ -0,0 +1,224 @@
+package ports
+
+import (
+	"bitbucket.org/smaug-hosting/services/cache"
+	µ "bitbucket.org/smaug-hosting/services/micro"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis"
+	"github.com/sirupsen/logrus"
+	"strconv"
+)
+
+const (
+	// hash of port -> id of the container it is bound to
+	allocatedKey = "ports.allocated"
+	// set of ports that were released and can be handed out again
+	freeKey = "ports.free"
+	// the highest port we have ever handed out
+	nextKey = "ports.next"
+)
+
+var ErrPortRangeExhausted = errors.New("no free ports left in the configured range")
+
+func bindingKey(containerId int64) string {
+	return fmt.Sprintf("ports.container.%d", containerId)
+}
+
+// Everything happens inside lua scripts so that concurrent allocations (from any number of container-service
+// replicas) can never hand out the same port twice.
+
+// KEYS: binding, free, next, allocated.  ARGV: range start, range end, container id
+var allocateScript = redis.NewScript(`
+-- SPOP is random, so before redis 5 a script using it has to be replicated as the writes it makes
+redis.replicate_commands()
+
+local existing = redis.call('GET', KEYS[1])
+if existing then
+	return tonumber(existing)
+end
+
+local rangeStart = tonumber(ARGV[1])
+local rangeEnd = tonumber(ARGV[2])
+local port = nil
+
+while true do
+	port = redis.call('SPOP', KEYS[2])
+	if not port then
+		break
+	end
+	port = tonumber(port)
+	if port >= rangeStart and port <= rangeEnd and redis.call('HEXISTS', KEYS[4], port) == 0 then
+		break
+	end
+end
+
+while not port do
+	local candidate = redis.call('INCR', KEYS[3])
+	if candidate < rangeStart then
+		candidate = rangeStart
+		redis.call('SET', KEYS[3], candidate)
+	end
+	if candidate > rangeEnd then
+		return -1
+	end
+	if redis.call('HEXISTS', KEYS[4], candidate) == 0 then
+		port = candidate
+	end
+end
+
+redis.call('SET', KEYS[1], port)
+redis.call('HSET', KEYS[4], port, ARGV[3])
+return port
+`)
+
+// KEYS: binding, free, allocated
+var releaseScript = redis.NewScript(`
+local port = redis.call('GET', KEYS[1])
+if not port then
+	return 0
+end
+redis.call('DEL', KEYS[1])
+redis.call('HDEL', KEYS[3], port)
+redis.call('SADD', KEYS[2], port)
+return tonumber(port)
+`)
+
+// KEYS: binding, free, allocated, binding of the port's current owner.  ARGV: port, container id, current owner
+// (empty if none).  Returns the id of whichever other container the port was taken from (zero if none), or -1 if
+// the port's owner changed since it was looked up.
+var claimScript = redis.NewScript(`
+local owner = redis.call('HGET', KEYS[3], ARGV[1])
+if (owner or '') ~= ARGV[3] then
+	return -1
+end
+
+local previous = redis.call('GET', KEYS[1])
+if previous and previous ~= ARGV[1] then
+	redis.call('HDEL', KEYS[3], previous)
+	redis.call('SADD', KEYS[2], previous)
+end
+
+if owner and owner ~= ARGV[2] then
+	redis.call('DEL', KEYS[4])
+else
+	owner = 0
+end
+
+redis.call('SET', KEYS[1], ARGV[1])
+redis.call('HSET', KEYS[3], ARGV[1], ARGV[2])
+redis.call('SREM', KEYS[2], ARGV[1])
+return tonumber(owner)
+`)
+
+// how many times Repair looks the port's owner up again when it changes under it
+const claimAttempts = 3
+
+func portRange() (uint64, uint64) {
+	start, err := strconv.ParseUint(µ.GetEnvDefault("PORT_RANGE_START", "50000"), 10, 16)
+	if err != nil {
+		logrus.Errorf("Invalid PORT_RANGE_START, using default: %s", err)
+		start = 50000
+	}
+
+	end, err := strconv.ParseUint(µ.GetEnvDefault("PORT_RANGE_END", "59999"), 10, 16)
+	if err != nil {
+		logrus.Errorf("Invalid PORT_RANGE_END, using default: %s", err)
+		end = 59999
+	}
+
+	return start, end
+}
+
+// Allocate returns the port bound to the container, reserving a new one if it doesn't have one yet.  Once bound,
+// a container keeps its port until it is released.
+func Allocate(containerId int64) (uint32, error) {
+	start, end := portRange()
+
+	port, err := allocateScript.Run(
+		cache.Client,
+		[]string{bindingKey(containerId), freeKey, nextKey, allocatedKey},
+		start, end, containerId,
+	).Int64()
+
+	if err != nil {
+		return 0, err
+	}
+
+	if port < 0 {
+		return 0, ErrPortRangeExhausted
+	}
+
+	return uint32(port), nil
+}
+
+// Lookup returns the port bound to the container, or zero if it doesn't have one.
+func Lookup(containerId int64) (uint32, error) {
+	port, err := cache.Client.Get(bindingKey(containerId)).Uint64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return uint32(port), err
+}
+
+// Release unbinds the container's port and makes it available to be handed out again.
+func Release(containerId int64) error {
+	return releaseScript.Run(
+		cache.Client,
+		[]string{bindingKey(containerId), freeKey, allocatedKey},
+	).Err()
+}
+
+// Repair makes the allocator agree with the port the orchestrator actually published for the container.  If some
+// other container was bound to that port, it loses its binding (and will be given a fresh port the next time its
+// service is built), since the orchestrator is the one actually holding the port.
+func Repair(containerId int64, published uint32) (bool, error) {
+	bound, err := Lookup(containerId)
+	if err != nil {
+		return false, err
+	}
+
+	if bound == published {
+		return false, nil
+	}
+
+	// the owner's binding is looked up here rather than in the script, as a script must declare every key it touches
+	var owner int64 = -1
+	for attempt := 0; attempt < claimAttempts && owner < 0; attempt++ {
+		current, err := cache.Client.HGet(allocatedKey, strconv.FormatUint(uint64(published), 10)).Result()
+		if err != nil && err != redis.Nil {
+			return false, err
+		}
+
+		ownerBinding := bindingKey(containerId)
+		if current != "" {
+			currentId, err := strconv.ParseInt(current, 10, 64)
+			if err != nil {
+				return false, err
+			}
+			ownerBinding = bindingKey(currentId)
+		}
+
+		owner, err = claimScript.Run(
+			cache.Client,
+			[]string{bindingKey(containerId), freeKey, allocatedKey, ownerBinding},
+			published, containerId, current,
+		).Int64()
+
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if owner < 0 {
+		return false, fmt.Errorf("port %d kept changing hands while rebinding it to container %d", published, containerId)
+	}
+
+	if owner != 0 {
+		logrus.Warnf("Port %d was bound to container %d but is published by container %d, rebinding it", published, owner, containerId)
+	} else {
+		logrus.Warnf("Container %d publishes port %d but was bound to %d, rebinding it", containerId, published, bound)
+	}
+
+	return true, nil
+}
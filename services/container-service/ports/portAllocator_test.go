@@ -0,0 +1,174 @@
+package ports
+
+import (
+	"bitbucket.org/smaug-hosting/services/cache"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+	"os"
+	"testing"
+)
+
+func setupAllocatorTest(t *testing.T, start string, end string) func() {
+	redisServer, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Could not start fake redis: %s", err)
+	}
+	cache.Client = redis.NewClient(&redis.Options{Addr: redisServer.Addr()})
+
+	_ = os.Setenv("PORT_RANGE_START", start)
+	_ = os.Setenv("PORT_RANGE_END", end)
+
+	return func() {
+		_ = os.Unsetenv("PORT_RANGE_START")
+		_ = os.Unsetenv("PORT_RANGE_END")
+		_ = cache.Client.Close()
+		redisServer.Close()
+	}
+}
+
+func mustAllocate(t *testing.T, containerId int64) uint32 {
+	port, err := Allocate(containerId)
+	if err != nil {
+		t.Fatalf("Could not allocate a port for container %d: %s", containerId, err)
+	}
+	return port
+}
+
+func expectBound(t *testing.T, containerId int64, want uint32) {
+	port, err := Lookup(containerId)
+	if err != nil {
+		t.Fatalf("Could not look up container %d's port: %s", containerId, err)
+	}
+	if port != want {
+		t.Errorf("Container %d is bound to port %d, want %d", containerId, port, want)
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	defer setupAllocatorTest(t, "50000", "50009")()
+
+	first := mustAllocate(t, 1)
+	second := mustAllocate(t, 2)
+	if first != 50000 || second != 50001 {
+		t.Errorf("Allocated ports %d and %d, want 50000 and 50001", first, second)
+	}
+
+	// a container keeps the port it was given
+	if again := mustAllocate(t, 1); again != first {
+		t.Errorf("Allocating again for container 1 gave %d, want %d", again, first)
+	}
+	expectBound(t, 1, first)
+	expectBound(t, 3, 0)
+}
+
+func TestReleaseReusesPort(t *testing.T) {
+	defer setupAllocatorTest(t, "50000", "50009")()
+
+	released := mustAllocate(t, 1)
+	mustAllocate(t, 2)
+
+	err := Release(1)
+	if err != nil {
+		t.Fatalf("Could not release port: %s", err)
+	}
+	expectBound(t, 1, 0)
+
+	// releasing twice does no harm
+	err = Release(1)
+	if err != nil {
+		t.Fatalf("Could not release port again: %s", err)
+	}
+
+	if port := mustAllocate(t, 3); port != released {
+		t.Errorf("Allocated port %d after releasing %d, want it reused", port, released)
+	}
+	if port := mustAllocate(t, 4); port != 50002 {
+		t.Errorf("Allocated port %d once nothing was free, want 50002", port)
+	}
+}
+
+func TestAllocateExhaustsRange(t *testing.T) {
+	defer setupAllocatorTest(t, "50000", "50001")()
+
+	mustAllocate(t, 1)
+	mustAllocate(t, 2)
+
+	_, err := Allocate(3)
+	if err != ErrPortRangeExhausted {
+		t.Fatalf("Allocating beyond the range gave %v, want %v", err, ErrPortRangeExhausted)
+	}
+
+	// but a released port can still be handed out
+	err = Release(2)
+	if err != nil {
+		t.Fatalf("Could not release port: %s", err)
+	}
+	if port := mustAllocate(t, 3); port != 50001 {
+		t.Errorf("Allocated port %d, want the released 50001", port)
+	}
+}
+
+func TestAllocateSkipsPortsOutsideRange(t *testing.T) {
+	defer setupAllocatorTest(t, "50000", "50009")()
+
+	mustAllocate(t, 1)
+	err := Release(1)
+	if err != nil {
+		t.Fatalf("Could not release port: %s", err)
+	}
+
+	// the range moved on, so the freed 50000 mustn't be handed out again
+	_ = os.Setenv("PORT_RANGE_START", "51000")
+	_ = os.Setenv("PORT_RANGE_END", "51009")
+	if port := mustAllocate(t, 2); port != 51000 {
+		t.Errorf("Allocated port %d, want 51000", port)
+	}
+}
+
+func TestRepairClaimsPortFromOtherContainer(t *testing.T) {
+	defer setupAllocatorTest(t, "50000", "50009")()
+
+	taken := mustAllocate(t, 1)
+	previous := mustAllocate(t, 2)
+
+	// container 2's service turns out to be publishing container 1's port
+	repaired, err := Repair(2, taken)
+	if err != nil {
+		t.Fatalf("Could not repair binding: %s", err)
+	}
+	if !repaired {
+		t.Error("Repair reported nothing changed")
+	}
+
+	expectBound(t, 2, taken)
+	expectBound(t, 1, 0)
+
+	// container 2's old port went back to the pool, and container 1 gets it next
+	if port := mustAllocate(t, 1); port != previous {
+		t.Errorf("Container 1 was given port %d, want the freed %d", port, previous)
+	}
+
+	repaired, err = Repair(2, taken)
+	if err != nil {
+		t.Fatalf("Could not repair binding again: %s", err)
+	}
+	if repaired {
+		t.Error("Repair changed a binding that was already right")
+	}
+}
+
+func TestRepairClaimsUnboundPort(t *testing.T) {
+	defer setupAllocatorTest(t, "50000", "50009")()
+
+	repaired, err := Repair(1, 50005)
+	if err != nil {
+		t.Fatalf("Could not repair binding: %s", err)
+	}
+	if !repaired {
+		t.Error("Repair reported nothing changed")
+	}
+	expectBound(t, 1, 50005)
+	if port := mustAllocate(t, 2); port != 50000 {
+		t.Errorf("Allocated port %d, want 50000", port)
+	}
+}
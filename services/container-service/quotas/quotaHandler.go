@@ -0,0 +1,138 @@
+package quotas
+
+import (
+	"bitbucket.org/smaug-hosting/services/libhttp"
+	"database/sql"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+)
+
+// The quota endpoints are for admins only, and are protected by the RequireRole middleware rather than checking
+// anything here.
+
+type UpdateQuotaRequest struct {
+	// limits left out keep their current value, -1 lifts a limit altogether
+	MaxContainers *int `json:"max_containers"`
+	MaxRunning    *int `json:"max_running"`
+	MaxTier       *int `json:"max_tier"`
+}
+
+// userIdForRequest parses the user id from the request path, sending an error response (and returning false) if it
+// isn't a valid one.
+func userIdForRequest(response http.ResponseWriter, request *http.Request) (int64, bool) {
+	userId, err := strconv.ParseInt(request.Context().Value("userId").(string), 10, 64)
+	if err != nil {
+		libhttp.SendError(http.StatusBadRequest, "Invalid user id", response)
+		return 0, false
+	}
+	return userId, true
+}
+
+// quotaForRequest fetches the quota of the user named in the request path, sending an error response (and returning
+// false) if it can't.
+func quotaForRequest(response http.ResponseWriter, request *http.Request) (UserQuota, bool) {
+	userId, ok := userIdForRequest(response, request)
+	if !ok {
+		return UserQuota{}, false
+	}
+
+	quota, err := QuotaForUser(userId)
+	if err == ErrUserNotFound {
+		libhttp.SendError(http.StatusNotFound, "User not found", response)
+		return UserQuota{}, false
+	} else if err != nil {
+		logrus.Errorf("Could not fetch quota of user %d: %s", userId, err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not fetch quota", response)
+		return UserQuota{}, false
+	}
+
+	return quota, true
+}
+
+func HandleGetQuota(response http.ResponseWriter, request *http.Request) {
+	quota, ok := quotaForRequest(response, request)
+	if !ok {
+		return
+	}
+
+	libhttp.SendJson(quota, response)
+}
+
+// HandlePutQuota sets limits of the user's own in place of those of their roles.  Limits left out keep whatever
+// they were set to before, or keep following the roles' default if they were never set.
+func HandlePutQuota(response http.ResponseWriter, request *http.Request) {
+	quota, ok := quotaForRequest(response, request)
+	if !ok {
+		return
+	}
+
+	body := UpdateQuotaRequest{}
+	err := libhttp.UnmarshalBody(request, response, &body)
+	if err != nil {
+		return
+	}
+
+	for _, limit := range []*int{body.MaxContainers, body.MaxRunning, body.MaxTier} {
+		if limit != nil && *limit < -1 {
+			libhttp.SendError(http.StatusBadRequest, "Quota limits must be at least 0, or -1 for no limit", response)
+			return
+		}
+	}
+
+	override, err := QuotaRepository{}.FindForUser(quota.UserId)
+	if err == sql.ErrNoRows {
+		override = &Override{UserId: quota.UserId}
+	} else if err != nil {
+		logrus.Errorf("Could not fetch quota of user %d: %s", quota.UserId, err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not fetch quota", response)
+		return
+	}
+
+	if body.MaxContainers != nil {
+		override.MaxContainers = body.MaxContainers
+	}
+	if body.MaxRunning != nil {
+		override.MaxRunning = body.MaxRunning
+	}
+	if body.MaxTier != nil {
+		override.MaxTier = body.MaxTier
+	}
+
+	err = QuotaRepository{}.Save(*override)
+	if err != nil {
+		logrus.Errorf("Could not save quota of user %d: %s", quota.UserId, err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not save quota", response)
+		return
+	}
+
+	saved, ok := quotaForRequest(response, request)
+	if !ok {
+		return
+	}
+
+	logrus.Infof("Quota of user %d changed to %+v", saved.UserId, saved.Quota)
+	libhttp.SendJson(saved, response)
+}
+
+// HandleDeleteQuota puts a user back on the default quota for their roles.
+func HandleDeleteQuota(response http.ResponseWriter, request *http.Request) {
+	userId, ok := userIdForRequest(response, request)
+	if !ok {
+		return
+	}
+
+	err := QuotaRepository{}.Delete(userId)
+	if err != nil {
+		logrus.Errorf("Could not remove quota of user %d: %s", userId, err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not remove quota", response)
+		return
+	}
+
+	quota, ok := quotaForRequest(response, request)
+	if !ok {
+		return
+	}
+
+	libhttp.SendJson(quota, response)
+}
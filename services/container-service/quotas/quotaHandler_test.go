@@ -0,0 +1,76 @@
+package quotas
+
+import (
+	"bitbucket.org/smaug-hosting/services/database"
+	"context"
+	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+const testUserId = 7
+
+func expectUser(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery("FROM verified_users WHERE id = ?").
+		WithArgs(testUserId).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "password_hash", "roles", "balance"}).
+			AddRow(testUserId, "player@example.com", []byte("hash"), []byte("user"), 1000000))
+}
+
+func TestHandlePutQuotaKeepsFollowingRoleDefaults(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Could not mock database: %s", err)
+	}
+	defer db.Close()
+	database.Connection = sqlx.NewDb(db, "mysql")
+
+	_ = os.Setenv("QUOTA_USER_MAX_CONTAINERS", "3")
+	defer os.Unsetenv("QUOTA_USER_MAX_CONTAINERS")
+
+	noOverride := func() {
+		mock.ExpectQuery("FROM quotas WHERE user_id = ?").
+			WithArgs(testUserId).
+			WillReturnRows(sqlmock.NewRows(quotaColumns))
+	}
+
+	// the user is on their role's quota
+	noOverride()
+	expectUser(mock)
+	noOverride()
+	// only the limit given is set, the others are left NULL
+	mock.ExpectExec("INSERT INTO quotas").
+		WithArgs(nil, 2, nil, testUserId, nil, 2, nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("FROM quotas WHERE user_id = ?").
+		WithArgs(testUserId).
+		WillReturnRows(sqlmock.NewRows(quotaColumns).AddRow(testUserId, nil, 2, nil))
+	expectUser(mock)
+
+	request := httptest.NewRequest("PUT", "/quotas/7/", strings.NewReader(`{"max_running": 2}`))
+	request = request.WithContext(context.WithValue(request.Context(), "userId", "7"))
+	recorder := httptest.NewRecorder()
+	HandlePutQuota(recorder, request)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Setting a quota gave %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	quota := UserQuota{}
+	err = json.Unmarshal(recorder.Body.Bytes(), &quota)
+	if err != nil {
+		t.Fatalf("Could not parse quota: %s", err)
+	}
+	if quota.MaxContainers != 3 || quota.MaxRunning != 2 || !quota.Custom {
+		t.Errorf("Quota is %+v, want the role's 3 containers and the 2 running that were set", quota)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
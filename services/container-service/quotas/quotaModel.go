@@ -0,0 +1,33 @@
+package quotas
+
+import "bitbucket.org/smaug-hosting/services/idp/users"
+
+// UserQuota is the quota a user's containers are held to, and whether an admin gave them any of it rather than it
+// all coming from their roles.
+type UserQuota struct {
+	UserId int64 `json:"user_id" db:"user_id"`
+	users.Quota
+	Custom bool `json:"custom" db:"-"`
+}
+
+// Override is the limits an admin set for a user.  Those left nil (NULL in the database) come from the user's roles,
+// so that they keep following the role's default if it changes.
+type Override struct {
+	UserId        int64 `json:"user_id" db:"user_id"`
+	MaxContainers *int  `json:"max_containers" db:"max_containers"`
+	MaxRunning    *int  `json:"max_running" db:"max_running"`
+	MaxTier       *int  `json:"max_tier" db:"max_tier"`
+}
+
+// apply sets the limits the override has on the quota.
+func (o Override) apply(quota *users.Quota) {
+	if o.MaxContainers != nil {
+		quota.MaxContainers = *o.MaxContainers
+	}
+	if o.MaxRunning != nil {
+		quota.MaxRunning = *o.MaxRunning
+	}
+	if o.MaxTier != nil {
+		quota.MaxTier = *o.MaxTier
+	}
+}
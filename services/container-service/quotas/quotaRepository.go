@@ -0,0 +1,62 @@
+package quotas
+
+import (
+	"bitbucket.org/smaug-hosting/services/database"
+	"github.com/Masterminds/squirrel"
+)
+
+type QuotaRepository struct{}
+
+const tableName = "quotas"
+
+var quotaColumns = []string{"user_id", "max_containers", "max_running", "max_tier"}
+
+// FindForUser returns the limits an admin set for the user, or sql.ErrNoRows if they haven't set any.
+func (qr QuotaRepository) FindForUser(userId int64) (*Override, error) {
+	sql, params, err := squirrel.Select(quotaColumns...).From(tableName).Where("user_id = ?", userId).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	override := new(Override)
+	err = database.Connection.Get(override, sql, params...)
+	if err != nil {
+		return nil, err
+	}
+
+	return override, nil
+}
+
+// Save replaces the limits set for the user, those left nil are stored as NULL.
+func (qr QuotaRepository) Save(override Override) error {
+	sql, params, err := squirrel.
+		Insert(tableName).
+		SetMap(map[string]interface{}{
+			"user_id":        override.UserId,
+			"max_containers": override.MaxContainers,
+			"max_running":    override.MaxRunning,
+			"max_tier":       override.MaxTier,
+		}).
+		Suffix("ON DUPLICATE KEY UPDATE max_containers = ?, max_running = ?, max_tier = ?", override.MaxContainers, override.MaxRunning, override.MaxTier).
+		ToSql()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = database.Connection.Exec(sql, params...)
+
+	return err
+}
+
+// Delete takes away the user's own quota, putting them back on their roles' one.
+func (qr QuotaRepository) Delete(userId int64) error {
+	sql, params, err := squirrel.Delete(tableName).Where("user_id = ?", userId).ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = database.Connection.Exec(sql, params...)
+
+	return err
+}
@@ -0,0 +1,36 @@
+package quotas
+
+import (
+	"bitbucket.org/smaug-hosting/services/idp/users"
+	"database/sql"
+	"errors"
+)
+
+var ErrUserNotFound = errors.New("user not found")
+
+// QuotaForUser returns the quota the user is held to: the default for their roles, with whatever limits an admin
+// set for them in its place.
+func QuotaForUser(userId int64) (UserQuota, error) {
+	override, err := QuotaRepository{}.FindForUser(userId)
+	if err == sql.ErrNoRows {
+		override = nil
+	} else if err != nil {
+		return UserQuota{}, err
+	}
+
+	user, err := users.UserRepository{}.Find(userId)
+	if err != nil {
+		return UserQuota{}, err
+	}
+	if user == nil {
+		return UserQuota{}, ErrUserNotFound
+	}
+
+	quota := UserQuota{UserId: userId, Quota: user.DefaultQuota()}
+	if override != nil {
+		override.apply(&quota.Quota)
+		quota.Custom = true
+	}
+
+	return quota, nil
+}
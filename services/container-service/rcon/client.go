@@ -0,0 +1,205 @@
+package rcon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// packet types from the Source RCON protocol, which is what Minecraft speaks
+const (
+	typeResponseValue int32 = 0
+	typeExecCommand   int32 = 2
+	typeAuthResponse  int32 = 2
+	typeAuth          int32 = 3
+)
+
+const (
+	// id + type + the two terminating nulls
+	packetHeaderSize = 10
+	// minecraft refuses commands longer than this
+	maxCommandLength = 1446
+	maxBodySize      = 4096
+	maxPacketSize    = maxBodySize + packetHeaderSize
+)
+
+var (
+	ErrAuthFailed      = errors.New("rcon authentication failed")
+	ErrCommandTooLong  = errors.New("rcon command too long")
+	ErrInvalidPacket   = errors.New("invalid rcon packet")
+	ErrUnexpectedReply = errors.New("unexpected rcon reply")
+)
+
+type packet struct {
+	Id   int32
+	Type int32
+	Body string
+}
+
+func writePacket(w io.Writer, p packet) error {
+	var buf bytes.Buffer
+
+	size := int32(len(p.Body) + packetHeaderSize)
+	for _, v := range []int32{size, p.Id, p.Type} {
+		if err := binary.Write(&buf, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+	buf.WriteString(p.Body)
+	buf.Write([]byte{0, 0})
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func readPacket(r io.Reader) (packet, error) {
+	var p packet
+	var size int32
+
+	err := binary.Read(r, binary.LittleEndian, &size)
+	if err != nil {
+		return p, err
+	}
+
+	if size < packetHeaderSize || size > maxPacketSize {
+		return p, ErrInvalidPacket
+	}
+
+	payload := make([]byte, size)
+	_, err = io.ReadFull(r, payload)
+	if err != nil {
+		return p, err
+	}
+
+	p.Id = int32(binary.LittleEndian.Uint32(payload[0:4]))
+	p.Type = int32(binary.LittleEndian.Uint32(payload[4:8]))
+	p.Body = string(bytes.TrimRight(payload[8:], "\x00"))
+
+	return p, nil
+}
+
+type Client struct {
+	conn    net.Conn
+	timeout time.Duration
+	nextId  int32
+}
+
+// Dial connects to an RCON server and authenticates with the given password.
+func Dial(address string, password string, timeout time.Duration) (*Client, error) {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{conn: conn, timeout: timeout, nextId: 1}
+
+	err = c.authenticate(password)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *Client) authenticate(password string) error {
+	id := c.id()
+
+	err := c.send(packet{Id: id, Type: typeAuth, Body: password})
+	if err != nil {
+		return err
+	}
+
+	// some servers send an empty response value before the actual auth response
+	for {
+		reply, err := c.receive()
+		if err != nil {
+			return err
+		}
+
+		if reply.Type == typeResponseValue {
+			continue
+		}
+
+		if reply.Type != typeAuthResponse {
+			return ErrUnexpectedReply
+		}
+
+		// a failed login is signalled by a reply id of -1
+		if reply.Id != id {
+			return ErrAuthFailed
+		}
+
+		return nil
+	}
+}
+
+// Execute runs a console command and returns whatever the server printed in response.
+func (c *Client) Execute(command string) (string, error) {
+	if len(command) > maxCommandLength {
+		return "", ErrCommandTooLong
+	}
+
+	id := c.id()
+
+	err := c.send(packet{Id: id, Type: typeExecCommand, Body: command})
+	if err != nil {
+		return "", err
+	}
+
+	// long output is split over as many packets as it takes, with nothing to mark the last of them.  The server
+	// answers in order though, so we follow the command with a packet it will answer once it is done with it
+	end := c.id()
+	err = c.send(packet{Id: end, Type: typeResponseValue})
+	if err != nil {
+		return "", err
+	}
+
+	var body strings.Builder
+	for {
+		reply, err := c.receive()
+		if err != nil {
+			return "", err
+		}
+
+		if reply.Id == end {
+			return body.String(), nil
+		}
+
+		if reply.Id != id || reply.Type != typeResponseValue {
+			return "", ErrUnexpectedReply
+		}
+
+		body.WriteString(reply.Body)
+	}
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Client) id() int32 {
+	id := c.nextId
+	c.nextId++
+	return id
+}
+
+func (c *Client) send(p packet) error {
+	err := c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	if err != nil {
+		return err
+	}
+	return writePacket(c.conn, p)
+}
+
+func (c *Client) receive() (packet, error) {
+	err := c.conn.SetReadDeadline(time.Now().Add(c.timeout))
+	if err != nil {
+		return packet{}, err
+	}
+	return readPacket(c.conn)
+}
@@ -0,0 +1,252 @@
+package rcon
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testTimeout = time.Second
+
+// fakeServer is a minimal RCON server listening on localhost, which answers every command with whatever the handler
+// returns.  Like Minecraft, it splits long output over several packets and answers packets of types it doesn't know
+// once it has finished with everything sent before them.
+type fakeServer struct {
+	password string
+	handler  func(command string) string
+	// writes every packet a byte at a time, so that none of them arrives in one piece
+	trickle bool
+
+	listener net.Listener
+	wg       sync.WaitGroup
+}
+
+func newFakeServer(t *testing.T, password string, handler func(command string) string) *fakeServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %s", err)
+	}
+
+	s := &fakeServer{
+		password: password,
+		handler:  handler,
+		listener: listener,
+	}
+
+	s.wg.Add(1)
+	go s.serve()
+
+	return s
+}
+
+func (s *fakeServer) address() string {
+	return s.listener.Addr().String()
+}
+
+func (s *fakeServer) close() {
+	_ = s.listener.Close()
+	s.wg.Wait()
+}
+
+func (s *fakeServer) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeServer) handle(conn net.Conn) {
+	defer s.wg.Done()
+	defer conn.Close()
+
+	authenticated := false
+
+	for {
+		p, err := readPacket(conn)
+		if err != nil {
+			return
+		}
+
+		var replies []packet
+		switch {
+		case p.Type == typeAuth:
+			authenticated = p.Body == s.password
+			reply := packet{Id: p.Id, Type: typeAuthResponse}
+			if !authenticated {
+				reply.Id = -1
+			}
+			replies = append(replies, reply)
+		case !authenticated:
+			replies = append(replies, packet{Id: -1, Type: typeResponseValue})
+		case p.Type == typeExecCommand:
+			output := s.handler(p.Body)
+			for len(output) > maxBodySize {
+				replies = append(replies, packet{Id: p.Id, Type: typeResponseValue, Body: output[:maxBodySize]})
+				output = output[maxBodySize:]
+			}
+			replies = append(replies, packet{Id: p.Id, Type: typeResponseValue, Body: output})
+		default:
+			replies = append(replies, packet{Id: p.Id, Type: typeResponseValue, Body: "Unknown request 0"})
+		}
+
+		for _, reply := range replies {
+			if s.write(conn, reply) != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *fakeServer) write(conn net.Conn, p packet) error {
+	if !s.trickle {
+		return writePacket(conn, p)
+	}
+
+	var buf strings.Builder
+	_ = writePacket(&buf, p)
+	for _, b := range []byte(buf.String()) {
+		if _, err := conn.Write([]byte{b}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func echo(command string) string {
+	return "ran " + command
+}
+
+func TestExecuteAfterAuthenticating(t *testing.T) {
+	server := newFakeServer(t, "hunter2", echo)
+	defer server.close()
+
+	client, err := Dial(server.address(), "hunter2", testTimeout)
+	if err != nil {
+		t.Fatalf("Could not authenticate: %s", err)
+	}
+	defer client.Close()
+
+	// each command gets its own output, however many are run on the connection
+	for _, command := range []string{"list", "say hello"} {
+		output, err := client.Execute(command)
+		if err != nil {
+			t.Fatalf("Could not execute %q: %s", command, err)
+		}
+		if output != echo(command) {
+			t.Errorf("Executing %q gave %q, want %q", command, output, echo(command))
+		}
+	}
+}
+
+func TestDialWithWrongPassword(t *testing.T) {
+	server := newFakeServer(t, "hunter2", echo)
+	defer server.close()
+
+	client, err := Dial(server.address(), "hunter3", testTimeout)
+	if err != ErrAuthFailed {
+		t.Errorf("Dialling with the wrong password gave %v, want %v", err, ErrAuthFailed)
+	}
+	if client != nil {
+		_ = client.Close()
+	}
+}
+
+func TestExecuteJoinsOutputSplitOverPackets(t *testing.T) {
+	long := strings.Repeat("a", maxBodySize) + strings.Repeat("b", maxBodySize) + "c"
+	server := newFakeServer(t, "hunter2", func(command string) string {
+		return long
+	})
+	defer server.close()
+
+	client, err := Dial(server.address(), "hunter2", testTimeout)
+	if err != nil {
+		t.Fatalf("Could not authenticate: %s", err)
+	}
+	defer client.Close()
+
+	output, err := client.Execute("help")
+	if err != nil {
+		t.Fatalf("Could not execute: %s", err)
+	}
+	if output != long {
+		t.Errorf("Got %d bytes of output, want %d", len(output), len(long))
+	}
+
+	// nothing of the long output may be left over to be taken for the next command's
+	output, err = client.Execute("help")
+	if err != nil {
+		t.Fatalf("Could not execute again: %s", err)
+	}
+	if output != long {
+		t.Errorf("Got %d bytes of output the second time, want %d", len(output), len(long))
+	}
+}
+
+func TestExecuteReassemblesPacketsSplitOverReads(t *testing.T) {
+	server := newFakeServer(t, "hunter2", echo)
+	server.trickle = true
+	defer server.close()
+
+	client, err := Dial(server.address(), "hunter2", testTimeout)
+	if err != nil {
+		t.Fatalf("Could not authenticate: %s", err)
+	}
+	defer client.Close()
+
+	output, err := client.Execute("list")
+	if err != nil {
+		t.Fatalf("Could not execute: %s", err)
+	}
+	if output != echo("list") {
+		t.Errorf("Got %q, want %q", output, echo("list"))
+	}
+}
+
+func TestExecuteTimesOut(t *testing.T) {
+	// the server never gets round to answering until the test is over
+	stuck := make(chan struct{})
+	server := newFakeServer(t, "hunter2", func(command string) string {
+		<-stuck
+		return ""
+	})
+	defer server.close()
+	defer close(stuck)
+
+	client, err := Dial(server.address(), "hunter2", 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Could not authenticate: %s", err)
+	}
+	defer client.Close()
+
+	started := time.Now()
+	_, err = client.Execute("list")
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Fatalf("Executing against a stuck server gave %v, want a timeout", err)
+	}
+	if elapsed := time.Since(started); elapsed > testTimeout {
+		t.Errorf("Timing out took %s", elapsed)
+	}
+}
+
+func TestExecuteRefusesLongCommands(t *testing.T) {
+	server := newFakeServer(t, "hunter2", echo)
+	defer server.close()
+
+	client, err := Dial(server.address(), "hunter2", testTimeout)
+	if err != nil {
+		t.Fatalf("Could not authenticate: %s", err)
+	}
+	defer client.Close()
+
+	_, err = client.Execute(strings.Repeat("a", maxCommandLength+1))
+	if err != ErrCommandTooLong {
+		t.Errorf("Executing an over-long command gave %v, want %v", err, ErrCommandTooLong)
+	}
+}
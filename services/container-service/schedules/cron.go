@@ -0,0 +1,125 @@
+package schedules
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCron = errors.New("invalid cron expression")
+
+// Cron is a parsed five-field cron expression (minute, hour, day of month, month, day of week).  Each field is a
+// bitmask of the values it matches.
+type Cron struct {
+	minute, hour, dayOfMonth, month, dayOfWeek uint64
+	// whether the day fields were restricted, which changes how they combine (see Matches)
+	dayOfMonthStar, dayOfWeekStar bool
+}
+
+type cronField struct {
+	min, max int
+}
+
+var cronFields = []cronField{
+	{0, 59}, // minute
+	{0, 23}, // hour
+	{1, 31}, // day of month
+	{1, 12}, // month
+	{0, 7},  // day of week, where both 0 and 7 are sunday
+}
+
+// ParseCron understands the usual cron syntax: "*", single values, ranges ("1-5"), lists ("1,15") and steps
+// ("*/15", "0-30/5").  Names (e.g. "mon") and the non-standard extensions (e.g. "L", "@daily") are not supported.
+func ParseCron(expression string) (Cron, error) {
+	var c Cron
+
+	fields := strings.Fields(expression)
+	if len(fields) != len(cronFields) {
+		return c, fmt.Errorf("%w: expected %d fields, got %d", ErrInvalidCron, len(cronFields), len(fields))
+	}
+
+	masks := make([]uint64, len(fields))
+	for i, field := range fields {
+		mask, err := parseCronField(field, cronFields[i])
+		if err != nil {
+			return c, err
+		}
+		masks[i] = mask
+	}
+
+	// fold sunday-as-7 into sunday-as-0
+	if masks[4]&(1<<7) != 0 {
+		masks[4] = masks[4]&^(1<<7) | 1
+	}
+
+	c.minute, c.hour, c.dayOfMonth, c.month, c.dayOfWeek = masks[0], masks[1], masks[2], masks[3], masks[4]
+	c.dayOfMonthStar = strings.HasPrefix(fields[2], "*")
+	c.dayOfWeekStar = strings.HasPrefix(fields[4], "*")
+
+	return c, nil
+}
+
+func parseCronField(field string, bounds cronField) (uint64, error) {
+	var mask uint64
+
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("%w: bad step in %q", ErrInvalidCron, part)
+			}
+			part = part[:i]
+		}
+
+		low, high := bounds.min, bounds.max
+		if part != "*" {
+			var err error
+			if i := strings.Index(part, "-"); i >= 0 {
+				low, err = strconv.Atoi(part[:i])
+				if err == nil {
+					high, err = strconv.Atoi(part[i+1:])
+				}
+			} else {
+				low, err = strconv.Atoi(part)
+				high = low
+				if step > 1 {
+					// "5/15" means from 5 to the end of the range in steps of 15
+					high = bounds.max
+				}
+			}
+			if err != nil {
+				return 0, fmt.Errorf("%w: bad value in %q", ErrInvalidCron, part)
+			}
+		}
+
+		if low < bounds.min || high > bounds.max || low > high {
+			return 0, fmt.Errorf("%w: %q is out of range %d-%d", ErrInvalidCron, part, bounds.min, bounds.max)
+		}
+
+		for v := low; v <= high; v += step {
+			mask |= 1 << uint(v)
+		}
+	}
+
+	return mask, nil
+}
+
+// Matches reports whether the expression fires in the minute containing t, judged in t's location.  As in standard
+// cron, if both day fields are restricted then matching either is enough.
+func (c Cron) Matches(t time.Time) bool {
+	if c.minute&(1<<uint(t.Minute())) == 0 || c.hour&(1<<uint(t.Hour())) == 0 || c.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+
+	dayOfMonth := c.dayOfMonth&(1<<uint(t.Day())) != 0
+	dayOfWeek := c.dayOfWeek&(1<<uint(t.Weekday())) != 0
+
+	if c.dayOfMonthStar || c.dayOfWeekStar {
+		return dayOfMonth && dayOfWeek
+	}
+	return dayOfMonth || dayOfWeek
+}
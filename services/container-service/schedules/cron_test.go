@@ -0,0 +1,79 @@
+package schedules
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronInvalid(t *testing.T) {
+	for _, expression := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"mon * * * *",
+		"@daily",
+	} {
+		if _, err := ParseCron(expression); err == nil {
+			t.Errorf("Parsed invalid cron expression %q", expression)
+		}
+	}
+}
+
+func TestCronMatches(t *testing.T) {
+	// a monday, a sunday and the first of a month (a tuesday)
+	monday := time.Date(2019, time.September, 2, 9, 30, 0, 0, time.UTC)
+	sunday := time.Date(2019, time.September, 8, 9, 30, 0, 0, time.UTC)
+	first := time.Date(2019, time.October, 1, 9, 30, 0, 0, time.UTC)
+
+	cases := []struct {
+		expression string
+		t          time.Time
+		matches    bool
+	}{
+		{"* * * * *", monday, true},
+		{"30 9 * * *", monday, true},
+		{"31 9 * * *", monday, false},
+		{"*/15 * * * *", monday, true},
+		{"*/20 * * * *", monday, false},
+		{"0-30/10 9 * * *", monday, true},
+		{"10,30,50 8-10 * * *", monday, true},
+		{"5/25 * * * *", monday, true},
+		{"30 9 * 9 *", monday, true},
+		{"30 9 * 10 *", monday, false},
+		// both 0 and 7 are sunday
+		{"30 9 * * 0", sunday, true},
+		{"30 9 * * 7", sunday, true},
+		{"30 9 * * 7", monday, false},
+		{"30 9 * * 5-7", sunday, true},
+		{"30 9 * * 1-5", monday, true},
+		{"30 9 * * 1-5", sunday, false},
+		// with one day field left as *, only the other one counts
+		{"30 9 1 * *", first, true},
+		{"30 9 1 * *", monday, false},
+		{"30 9 */2 * *", first, true},
+		{"30 9 * * 1", first, false},
+		// with both restricted, matching either is enough (the 1st of october 2019 is a tuesday)
+		{"30 9 1 * 1", first, true},
+		{"30 9 1 * 1", monday, true},
+		{"30 9 1 * 1", sunday, false},
+		{"30 9 15 * 3", first, false},
+	}
+
+	for _, c := range cases {
+		cron, err := ParseCron(c.expression)
+		if err != nil {
+			t.Errorf("Could not parse %q: %s", c.expression, err)
+			continue
+		}
+		if got := cron.Matches(c.t); got != c.matches {
+			t.Errorf("%q matching %s gave %v, want %v", c.expression, c.t.Format(time.RFC1123), got, c.matches)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package schedules
+
+import (
+	"bitbucket.org/smaug-hosting/services/container-service/containers"
+	"bitbucket.org/smaug-hosting/services/idp/collaborators"
+	"bitbucket.org/smaug-hosting/services/libhttp"
+	"database/sql"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// how many past runs are shown for a schedule
+const runHistoryLength = 50
+
+// scheduleForRequest fetches the schedule named in the request path, sending an error response (and returning
+// false) if it doesn't belong to the container.
+func scheduleForRequest(container containers.Container, response http.ResponseWriter, request *http.Request) (*Schedule, bool) {
+	scheduleId, err := strconv.ParseInt(request.Context().Value("scheduleId").(string), 10, 64)
+	if err != nil {
+		libhttp.SendError(http.StatusBadRequest, "Invalid schedule id", response)
+		return nil, false
+	}
+
+	schedule, err := ScheduleRepository{}.FindById(scheduleId)
+	if err == sql.ErrNoRows || (err == nil && schedule.ContainerId != container.Id) {
+		libhttp.SendError(http.StatusNotFound, "Schedule not found", response)
+		return nil, false
+	} else if err != nil {
+		logrus.Errorf("Could not fetch schedule from db: %s", err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not fetch schedule", response)
+		return nil, false
+	}
+
+	return schedule, true
+}
+
+type CreateScheduleRequest struct {
+	Action   Action `json:"action"`
+	Cron     string `json:"cron"`
+	Timezone string `json:"timezone"`
+	// defaults to true when left out
+	Enabled *bool `json:"enabled"`
+}
+
+func HandlePostSchedule(response http.ResponseWriter, request *http.Request) {
+	container, ok := containers.ContainerForRequest(response, request, collaborators.PermAdmin)
+	if !ok {
+		return
+	}
+
+	body := CreateScheduleRequest{}
+	err := libhttp.UnmarshalBody(request, response, &body)
+	if err != nil {
+		return
+	}
+
+	if body.Action != ActionStart && body.Action != ActionStop {
+		libhttp.SendError(http.StatusBadRequest, "The action must be start or stop", response)
+		return
+	}
+
+	_, err = ParseCron(body.Cron)
+	if err != nil {
+		libhttp.SendError(http.StatusBadRequest, err.Error(), response)
+		return
+	}
+
+	if body.Timezone == "" {
+		body.Timezone = "UTC"
+	}
+	if _, err := time.LoadLocation(body.Timezone); err != nil {
+		libhttp.SendError(http.StatusBadRequest, fmt.Sprintf("Unknown timezone %s", body.Timezone), response)
+		return
+	}
+
+	enabled := body.Enabled == nil || *body.Enabled
+
+	schedule, err := ScheduleRepository{}.Save(Schedule{
+		ContainerId: container.Id,
+		UserId:      container.UserId,
+		Action:      body.Action,
+		Cron:        body.Cron,
+		Timezone:    body.Timezone,
+		Enabled:     enabled,
+	})
+	if err != nil {
+		logrus.Errorf("Could not save schedule for container %d: %s", container.Id, err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not save schedule", response)
+		return
+	}
+
+	libhttp.SendJsonWithStatus(http.StatusCreated, schedule, response)
+}
+
+func HandleGetSchedules(response http.ResponseWriter, request *http.Request) {
+	container, ok := containers.ContainerForRequest(response, request, collaborators.PermView)
+	if !ok {
+		return
+	}
+
+	schedules, err := ScheduleRepository{}.GetSchedulesForContainer(container.Id)
+	if err != nil {
+		logrus.Errorf("Could not fetch schedules for container %d: %s", container.Id, err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not fetch schedules", response)
+		return
+	}
+
+	libhttp.SendJson(schedules, response)
+}
+
+func HandleGetScheduleRuns(response http.ResponseWriter, request *http.Request) {
+	container, ok := containers.ContainerForRequest(response, request, collaborators.PermView)
+	if !ok {
+		return
+	}
+
+	schedule, ok := scheduleForRequest(*container, response, request)
+	if !ok {
+		return
+	}
+
+	runs, err := ScheduleRepository{}.GetRunsForSchedule(schedule.Id, runHistoryLength)
+	if err != nil {
+		logrus.Errorf("Could not fetch runs for schedule %d: %s", schedule.Id, err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not fetch schedule history", response)
+		return
+	}
+
+	libhttp.SendJson(runs, response)
+}
+
+func HandleDeleteSchedule(response http.ResponseWriter, request *http.Request) {
+	container, ok := containers.ContainerForRequest(response, request, collaborators.PermAdmin)
+	if !ok {
+		return
+	}
+
+	schedule, ok := scheduleForRequest(*container, response, request)
+	if !ok {
+		return
+	}
+
+	err := ScheduleRepository{}.Delete(*schedule)
+	if err != nil {
+		logrus.Errorf("Could not delete schedule %d: %s", schedule.Id, err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not delete schedule", response)
+		return
+	}
+
+	// empty 200 response if all went well
+	libhttp.SendJson(struct{}{}, response)
+}
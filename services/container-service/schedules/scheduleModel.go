@@ -0,0 +1,42 @@
+package schedules
+
+import "time"
+
+type Action string
+
+const (
+	ActionStart Action = "start"
+	ActionStop  Action = "stop"
+)
+
+type Outcome string
+
+const (
+	OutcomePending   Outcome = "pending"
+	OutcomeSucceeded Outcome = "succeeded"
+	OutcomeFailed    Outcome = "failed"
+	OutcomeSkipped   Outcome = "skipped"
+)
+
+// Schedule starts or stops a container whenever its cron expression fires in its timezone.
+type Schedule struct {
+	Id          int64     `json:"id"`
+	ContainerId int64     `json:"container_id" db:"container_id"`
+	UserId      int64     `json:"-" db:"user_id"`
+	Action      Action    `json:"action"`
+	Cron        string    `json:"cron"`
+	Timezone    string    `json:"timezone"`
+	Enabled     bool      `json:"enabled"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+
+// Run is a single firing of a schedule.  The (schedule, minute) pair is unique, which is what stops two replicas of
+// the scheduler firing the same schedule twice.
+type Run struct {
+	Id           int64      `json:"id"`
+	ScheduleId   int64      `json:"schedule_id" db:"schedule_id"`
+	ScheduledFor time.Time  `json:"scheduled_for" db:"scheduled_for"`
+	Outcome      Outcome    `json:"outcome"`
+	Error        string     `json:"error"`
+	FinishedAt   *time.Time `json:"finished_at" db:"finished_at"`
+}
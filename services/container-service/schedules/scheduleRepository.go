@@ -0,0 +1,192 @@
+package schedules
+
+import (
+	"bitbucket.org/smaug-hosting/services/database"
+	"bitbucket.org/smaug-hosting/services/database/helpers"
+	"errors"
+	"github.com/Masterminds/squirrel"
+	"time"
+)
+
+var ErrAlreadyClaimed = errors.New("schedule run already claimed")
+
+type ScheduleRepository struct{}
+
+const tableName = "schedules"
+const runsTableName = "schedule_runs"
+
+var scheduleColumns = []string{"id", "container_id", "user_id", "action", "cron", "timezone", "enabled", "created_at"}
+var runColumns = []string{"id", "schedule_id", "scheduled_for", "outcome", "error", "finished_at"}
+
+func (sr ScheduleRepository) FindById(id int64) (*Schedule, error) {
+	sql, params, err := squirrel.Select(scheduleColumns...).From(tableName).Where("id = ?", id).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	s := new(Schedule)
+
+	err = database.Connection.QueryRowx(sql, params...).StructScan(s)
+
+	return s, err
+}
+
+func (sr ScheduleRepository) Save(schedule Schedule) (Schedule, error) {
+	var result Schedule // only used if we fail
+
+	schedule.CreatedAt = time.Now()
+
+	sql, params, err := squirrel.
+		Insert(tableName).
+		SetMap(map[string]interface{}{
+			"container_id": schedule.ContainerId,
+			"user_id":      schedule.UserId,
+			"action":       schedule.Action,
+			"cron":         schedule.Cron,
+			"timezone":     schedule.Timezone,
+			"enabled":      schedule.Enabled,
+			"created_at":   schedule.CreatedAt,
+		}).
+		ToSql()
+
+	if err != nil {
+		return result, err
+	}
+
+	res, err := database.Connection.Exec(sql, params...)
+	if err != nil {
+		return result, err
+	}
+
+	schedule.Id, err = res.LastInsertId()
+
+	return schedule, err
+}
+
+func (sr ScheduleRepository) Delete(schedule Schedule) error {
+	// runs go first so that a failure part way through never leaves runs pointing at a missing schedule
+	sql, params, err := squirrel.Delete(runsTableName).Where("schedule_id = ?", schedule.Id).ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = database.Connection.Exec(sql, params...)
+	if err != nil {
+		return err
+	}
+
+	sql, params, err = squirrel.Delete(tableName).Where("id = ?", schedule.Id).ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = database.Connection.Exec(sql, params...)
+
+	return err
+}
+
+func (sr ScheduleRepository) GetSchedulesForContainer(containerId int64) ([]Schedule, error) {
+	return sr.selectWhere(squirrel.Eq{"container_id": containerId})
+}
+
+func (sr ScheduleRepository) AllEnabled() ([]Schedule, error) {
+	return sr.selectWhere(squirrel.Eq{"enabled": true})
+}
+
+func (sr ScheduleRepository) selectWhere(pred interface{}) ([]Schedule, error) {
+	sql, params, err := squirrel.Select(scheduleColumns...).From(tableName).Where(pred).OrderBy("id").ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	schedules := make([]Schedule, 0)
+
+	err = database.Connection.Select(&schedules, sql, params...)
+
+	return schedules, err
+}
+
+// ClaimRun records that the schedule is firing for the given minute.  If another scheduler already has, it returns
+// ErrAlreadyClaimed and the caller must leave the run alone.
+func (sr ScheduleRepository) ClaimRun(schedule Schedule, scheduledFor time.Time) (Run, error) {
+	run := Run{
+		ScheduleId:   schedule.Id,
+		ScheduledFor: scheduledFor,
+		Outcome:      OutcomePending,
+	}
+
+	sql, params, err := squirrel.
+		Insert(runsTableName).
+		SetMap(map[string]interface{}{
+			"schedule_id":   run.ScheduleId,
+			"scheduled_for": run.ScheduledFor,
+			"outcome":       run.Outcome,
+			"error":         run.Error,
+		}).
+		ToSql()
+
+	if err != nil {
+		return run, err
+	}
+
+	res, err := database.Connection.Exec(sql, params...)
+	if helpers.IsDuplicateKey(err) {
+		return run, ErrAlreadyClaimed
+	} else if err != nil {
+		return run, err
+	}
+
+	run.Id, err = res.LastInsertId()
+
+	return run, err
+}
+
+func (sr ScheduleRepository) FinishRun(run *Run, outcome Outcome, message string) error {
+	now := time.Now()
+
+	sql, params, err := squirrel.
+		Update(runsTableName).
+		SetMap(map[string]interface{}{
+			"outcome":     outcome,
+			"error":       message,
+			"finished_at": now,
+		}).
+		Where("id = ?", run.Id).
+		ToSql()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = database.Connection.Exec(sql, params...)
+	if err != nil {
+		return err
+	}
+
+	run.Outcome = outcome
+	run.Error = message
+	run.FinishedAt = &now
+
+	return nil
+}
+
+// GetRunsForSchedule lists the schedule's most recent runs, newest first.
+func (sr ScheduleRepository) GetRunsForSchedule(scheduleId int64, limit uint64) ([]Run, error) {
+	sql, params, err := squirrel.
+		Select(runColumns...).
+		From(runsTableName).
+		Where("schedule_id = ?", scheduleId).
+		OrderBy("scheduled_for DESC").
+		Limit(limit).
+		ToSql()
+
+	if err != nil {
+		return nil, err
+	}
+
+	runs := make([]Run, 0)
+
+	err = database.Connection.Select(&runs, sql, params...)
+
+	return runs, err
+}
@@ -0,0 +1,167 @@
+package schedules
+
+import (
+	"bitbucket.org/smaug-hosting/services/container-service/containers"
+	"bitbucket.org/smaug-hosting/services/metrics"
+	µ "bitbucket.org/smaug-hosting/services/micro"
+	"database/sql"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"sort"
+	"time"
+)
+
+// StartScheduler fires due schedules at the top of every minute.  Schedules that came due while no scheduler was
+// running (e.g. during a deploy) are still fired if they are no older than SCHEDULE_CATCH_UP.
+func StartScheduler() {
+	catchUp, err := time.ParseDuration(µ.GetEnvDefault("SCHEDULE_CATCH_UP", "5m"))
+	if err != nil {
+		catchUp = 5 * time.Minute
+		logrus.Errorf("Could not parse catch-up duration for scheduler: %s", err)
+	}
+
+	go func() {
+		for {
+			// the time of the next clock minute
+			nextRun := time.Now().Truncate(time.Minute).Add(time.Minute)
+			time.Sleep(time.Until(nextRun))
+			runDueSchedules(nextRun, catchUp)
+		}
+	}()
+}
+
+type dueRun struct {
+	schedule Schedule
+	due      time.Time
+}
+
+// lastDue finds the most recent minute, no later than now and within the catch-up window, that the schedule fires in.
+// Minutes from before the schedule was created don't count, so a new schedule doesn't catch up on firings it was
+// never around for.
+func lastDue(s Schedule, now time.Time, catchUp time.Duration) (time.Time, bool) {
+	cron, err := ParseCron(s.Cron)
+	if err != nil {
+		logrus.Errorf("Schedule %d has an invalid cron expression: %s", s.Id, err)
+		return time.Time{}, false
+	}
+
+	location, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		logrus.Errorf("Schedule %d has an invalid timezone: %s", s.Id, err)
+		return time.Time{}, false
+	}
+
+	now = now.Truncate(time.Minute)
+	created := s.CreatedAt.Truncate(time.Minute)
+	for t := now; now.Sub(t) <= catchUp && !t.Before(created); t = t.Add(-time.Minute) {
+		if cron.Matches(t.In(location)) {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func runDueSchedules(now time.Time, catchUp time.Duration) {
+	log := logrus.WithField("component", "scheduler")
+
+	schedules, err := ScheduleRepository{}.AllEnabled()
+	if err != nil {
+		log.Errorf("Could not fetch schedules: %s", err)
+		return
+	}
+
+	due := make([]dueRun, 0)
+	for _, s := range schedules {
+		if t, ok := lastDue(s, now, catchUp); ok {
+			due = append(due, dueRun{schedule: s, due: t})
+		}
+	}
+
+	// fire in the order they came due, so that catching up on a start and a later stop leaves the container stopped
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].due.Before(due[j].due)
+	})
+
+	for _, d := range due {
+		fire(d.schedule, d.due)
+	}
+}
+
+func fire(s Schedule, due time.Time) {
+	run, err := ScheduleRepository{}.ClaimRun(s, due)
+	if err == ErrAlreadyClaimed {
+		// another scheduler got there first (or we already ran it on an earlier tick)
+		return
+	} else if err != nil {
+		logrus.Errorf("Could not claim run of schedule %d: %s", s.Id, err)
+		return
+	}
+
+	outcome, message := execute(s)
+
+	switch outcome {
+	case OutcomeSucceeded:
+		metrics.Increment("schedules.succeeded")
+	case OutcomeFailed:
+		metrics.Increment("schedules.failed")
+		logrus.Errorf("Schedule %d could not %s container %d: %s", s.Id, s.Action, s.ContainerId, message)
+	case OutcomeSkipped:
+		metrics.Increment("schedules.skipped")
+	}
+
+	err = ScheduleRepository{}.FinishRun(&run, outcome, message)
+	if err != nil {
+		logrus.Errorf("Could not record outcome of schedule %d: %s", s.Id, err)
+	}
+}
+
+func execute(s Schedule) (Outcome, string) {
+	c, err := containers.ContainerRepository{}.FindById(s.ContainerId)
+	if err == sql.ErrNoRows {
+		// the container has been deleted, so its schedules go too
+		if err := (ScheduleRepository{}).Delete(s); err != nil {
+			logrus.Errorf("Could not delete schedule %d of deleted container %d: %s", s.Id, s.ContainerId, err)
+		}
+		return OutcomeSkipped, "container no longer exists"
+	} else if err != nil {
+		return OutcomeFailed, fmt.Sprintf("could not fetch container: %s", err)
+	}
+
+	switch s.Action {
+	case ActionStart:
+		if c.State == containers.StateRunning || c.State == containers.StateStarting {
+			return OutcomeSkipped, "already running"
+		}
+
+		err = containers.CheckUserCanAfford(c.UserId, c.Software, c.Tier)
+		if err == containers.ErrInsufficientFunds || err == containers.ErrUnverifiedUser || err == containers.ErrUnknownUser {
+			return OutcomeSkipped, err.Error()
+		} else if err != nil {
+			return OutcomeFailed, fmt.Sprintf("could not check balance: %s", err)
+		}
+
+		// starting checks the quota itself
+		err = containers.StartContainer(*c)
+		if containers.IsQuotaError(err) {
+			return OutcomeSkipped, err.Error()
+		}
+	case ActionStop:
+		if c.State == containers.StateStopped || c.State == containers.StateStopping {
+			return OutcomeSkipped, "already stopped"
+		}
+
+		err = containers.StopContainer(*c)
+	default:
+		return OutcomeFailed, fmt.Sprintf("unknown action %s", s.Action)
+	}
+
+	if err == containers.ErrIllegalTransition || err == containers.ErrStateConflict {
+		return OutcomeSkipped, fmt.Sprintf("container is %s", c.State)
+	} else if containers.IsContainerLocked(err) {
+		return OutcomeSkipped, err.Error()
+	} else if err != nil {
+		return OutcomeFailed, err.Error()
+	}
+
+	return OutcomeSucceeded, ""
+}
@@ -0,0 +1,112 @@
+package schedules
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLastDue(t *testing.T) {
+	utc := func(month time.Month, day int, hour int, minute int) time.Time {
+		return time.Date(2019, month, day, hour, minute, 0, 0, time.UTC)
+	}
+	longAgo := utc(time.January, 1, 0, 0)
+
+	cases := []struct {
+		name     string
+		schedule Schedule
+		now      time.Time
+		catchUp  time.Duration
+		due      time.Time
+		ok       bool
+	}{
+		{
+			name:     "due this minute",
+			schedule: Schedule{Cron: "0 9 * * *", Timezone: "UTC", CreatedAt: longAgo},
+			now:      utc(time.September, 2, 9, 0).Add(30 * time.Second),
+			catchUp:  5 * time.Minute,
+			due:      utc(time.September, 2, 9, 0),
+			ok:       true,
+		},
+		{
+			name:     "catches up within the window",
+			schedule: Schedule{Cron: "0 9 * * *", Timezone: "UTC", CreatedAt: longAgo},
+			now:      utc(time.September, 2, 9, 4),
+			catchUp:  5 * time.Minute,
+			due:      utc(time.September, 2, 9, 0),
+			ok:       true,
+		},
+		{
+			name:     "missed beyond the window",
+			schedule: Schedule{Cron: "0 9 * * *", Timezone: "UTC", CreatedAt: longAgo},
+			now:      utc(time.September, 2, 9, 6),
+			catchUp:  5 * time.Minute,
+		},
+		{
+			name:     "the latest of several missed firings",
+			schedule: Schedule{Cron: "*/2 * * * *", Timezone: "UTC", CreatedAt: longAgo},
+			now:      utc(time.September, 2, 9, 5),
+			catchUp:  5 * time.Minute,
+			due:      utc(time.September, 2, 9, 4),
+			ok:       true,
+		},
+		{
+			name:     "not caught up from before it was created",
+			schedule: Schedule{Cron: "0 9 * * *", Timezone: "UTC", CreatedAt: utc(time.September, 2, 9, 2)},
+			now:      utc(time.September, 2, 9, 3),
+			catchUp:  5 * time.Minute,
+		},
+		{
+			name:     "created part way through the minute it fires in",
+			schedule: Schedule{Cron: "0 9 * * *", Timezone: "UTC", CreatedAt: utc(time.September, 2, 9, 0).Add(40 * time.Second)},
+			now:      utc(time.September, 2, 9, 1),
+			catchUp:  5 * time.Minute,
+			due:      utc(time.September, 2, 9, 0),
+			ok:       true,
+		},
+		{
+			name:     "judged in the schedule's timezone in summer",
+			schedule: Schedule{Cron: "0 9 * * *", Timezone: "Europe/London", CreatedAt: longAgo},
+			now:      utc(time.July, 1, 8, 0),
+			catchUp:  5 * time.Minute,
+			due:      utc(time.July, 1, 8, 0),
+			ok:       true,
+		},
+		{
+			name:     "judged in the schedule's timezone in winter",
+			schedule: Schedule{Cron: "0 9 * * *", Timezone: "Europe/London", CreatedAt: longAgo},
+			now:      utc(time.December, 1, 9, 0),
+			catchUp:  5 * time.Minute,
+			due:      utc(time.December, 1, 9, 0),
+			ok:       true,
+		},
+		{
+			// clocks in London went from 01:00 straight to 02:00 on the 31st of march 2019
+			name:     "a time skipped by the clocks going forward never fires",
+			schedule: Schedule{Cron: "30 1 * * *", Timezone: "Europe/London", CreatedAt: longAgo},
+			now:      utc(time.March, 31, 1, 30),
+			catchUp:  time.Hour,
+		},
+		{
+			// and from 02:00 back to 01:00 on the 27th of october 2019, so 01:30 happened twice
+			name:     "a time repeated by the clocks going back fires the second time round too",
+			schedule: Schedule{Cron: "30 1 * * *", Timezone: "Europe/London", CreatedAt: longAgo},
+			now:      utc(time.October, 27, 1, 31),
+			catchUp:  5 * time.Minute,
+			due:      utc(time.October, 27, 1, 30),
+			ok:       true,
+		},
+		{
+			name:     "invalid timezone",
+			schedule: Schedule{Cron: "* * * * *", Timezone: "Mars/Olympus_Mons", CreatedAt: longAgo},
+			now:      utc(time.September, 2, 9, 0),
+			catchUp:  5 * time.Minute,
+		},
+	}
+
+	for _, c := range cases {
+		due, ok := lastDue(c.schedule, c.now, c.catchUp)
+		if ok != c.ok || !due.Equal(c.due) {
+			t.Errorf("%s: got %s (%v), want %s (%v)", c.name, due, ok, c.due, c.ok)
+		}
+	}
+}
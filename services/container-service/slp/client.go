@@ -0,0 +1,258 @@
+package slp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Minecraft's Server List Ping (the status query the multiplayer menu uses), as spoken by 1.7 and later.
+
+const (
+	packetHandshake int32 = 0x00
+	packetStatus    int32 = 0x00
+	packetPing      int32 = 0x01
+
+	// protocol version to announce in the handshake, -1 means we don't care which version the server runs
+	protocolUnknown int32 = -1
+
+	// generous, status responses with a player sample and favicon are usually well under this
+	maxPacketSize = 1 << 21
+)
+
+var (
+	ErrInvalidPacket   = errors.New("invalid server list ping packet")
+	ErrUnexpectedReply = errors.New("unexpected server list ping reply")
+)
+
+type Version struct {
+	Name     string `json:"name"`
+	Protocol int    `json:"protocol"`
+}
+
+type Players struct {
+	Online int `json:"online"`
+	Max    int `json:"max"`
+}
+
+// Status is what the server reports about itself, along with how long it took to answer a ping.
+type Status struct {
+	Version Version       `json:"version"`
+	Players Players       `json:"players"`
+	Motd    string        `json:"motd"`
+	Latency time.Duration `json:"latency"`
+}
+
+// the response as sent by the server.  The description is either a plain string or a chat component.
+type statusResponse struct {
+	Version     Version         `json:"version"`
+	Players     Players         `json:"players"`
+	Description json.RawMessage `json:"description"`
+}
+
+type chatComponent struct {
+	Text  string          `json:"text"`
+	Extra []chatComponent `json:"extra,omitempty"`
+}
+
+func (c chatComponent) String() string {
+	var b strings.Builder
+	b.WriteString(c.Text)
+	for _, e := range c.Extra {
+		b.WriteString(e.String())
+	}
+	return b.String()
+}
+
+func parseDescription(raw json.RawMessage) string {
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text
+	}
+
+	var component chatComponent
+	if err := json.Unmarshal(raw, &component); err == nil {
+		return component.String()
+	}
+
+	return ""
+}
+
+// Ping asks the server at address (host:port) for its status.
+func Ping(address string, timeout time.Duration) (Status, error) {
+	var status Status
+
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return status, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return status, err
+	}
+
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return status, err
+	}
+	defer conn.Close()
+
+	err = conn.SetDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return status, err
+	}
+
+	reader := bufio.NewReader(conn)
+
+	var handshake bytes.Buffer
+	writeVarInt(&handshake, protocolUnknown)
+	writeString(&handshake, host)
+	_ = binary.Write(&handshake, binary.BigEndian, uint16(port))
+	writeVarInt(&handshake, NextStateStatus)
+
+	err = writePacket(conn, packetHandshake, handshake.Bytes())
+	if err != nil {
+		return status, err
+	}
+
+	err = writePacket(conn, packetStatus, nil)
+	if err != nil {
+		return status, err
+	}
+
+	id, payload, err := readPacket(reader)
+	if err != nil {
+		return status, err
+	}
+	if id != packetStatus {
+		return status, ErrUnexpectedReply
+	}
+
+	body, err := readString(bytes.NewReader(payload))
+	if err != nil {
+		return status, err
+	}
+
+	var response statusResponse
+	err = json.Unmarshal([]byte(body), &response)
+	if err != nil {
+		return status, err
+	}
+
+	status.Version = response.Version
+	status.Players = response.Players
+	status.Motd = parseDescription(response.Description)
+
+	// the server echoes the ping payload back, which gives us a round trip time
+	sent := time.Now()
+	var ping bytes.Buffer
+	_ = binary.Write(&ping, binary.BigEndian, sent.UnixNano())
+
+	err = writePacket(conn, packetPing, ping.Bytes())
+	if err != nil {
+		return status, err
+	}
+
+	id, payload, err = readPacket(reader)
+	if err != nil {
+		return status, err
+	}
+	if id != packetPing || !bytes.Equal(payload, ping.Bytes()) {
+		return status, ErrUnexpectedReply
+	}
+
+	status.Latency = time.Since(sent)
+
+	return status, nil
+}
+
+func writeVarInt(buf *bytes.Buffer, value int32) {
+	v := uint32(value)
+	for {
+		if v&^0x7f == 0 {
+			buf.WriteByte(byte(v))
+			return
+		}
+		buf.WriteByte(byte(v&0x7f | 0x80))
+		v >>= 7
+	}
+}
+
+func readVarInt(r io.ByteReader) (int32, error) {
+	var value uint32
+	for i := uint(0); i < 5; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		value |= uint32(b&0x7f) << (7 * i)
+		if b&0x80 == 0 {
+			return int32(value), nil
+		}
+	}
+	return 0, ErrInvalidPacket
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	writeVarInt(buf, int32(len(s)))
+	buf.WriteString(s)
+}
+
+func readString(r *bytes.Reader) (string, error) {
+	length, err := readVarInt(r)
+	if err != nil {
+		return "", err
+	}
+	if length < 0 || int(length) > r.Len() {
+		return "", ErrInvalidPacket
+	}
+
+	s := make([]byte, length)
+	_, err = io.ReadFull(r, s)
+	return string(s), err
+}
+
+// packets are a varint length, then a varint id, then the payload
+func writePacket(w io.Writer, id int32, payload []byte) error {
+	var body bytes.Buffer
+	writeVarInt(&body, id)
+	body.Write(payload)
+
+	var buf bytes.Buffer
+	writeVarInt(&buf, int32(body.Len()))
+	buf.Write(body.Bytes())
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func readPacket(r *bufio.Reader) (int32, []byte, error) {
+	length, err := readVarInt(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if length < 1 || length > maxPacketSize {
+		return 0, nil, ErrInvalidPacket
+	}
+
+	body := make([]byte, length)
+	_, err = io.ReadFull(r, body)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	bodyReader := bytes.NewReader(body)
+	id, err := readVarInt(bodyReader)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return id, body[len(body)-bodyReader.Len():], nil
+}
@@ -0,0 +1,167 @@
+package slp
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testTimeout = time.Second
+
+// fakeServer is a minimal server list ping responder listening on localhost, which answers every status request with
+// the status it was given, using the same code the game proxy answers with.
+type fakeServer struct {
+	status Status
+
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu        sync.Mutex
+	handshake Handshake
+}
+
+func newFakeServer(t *testing.T, status Status) *fakeServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %s", err)
+	}
+
+	s := &fakeServer{
+		status:   status,
+		listener: listener,
+	}
+
+	s.wg.Add(1)
+	go s.serve()
+
+	return s
+}
+
+func (s *fakeServer) address() string {
+	return s.listener.Addr().String()
+}
+
+func (s *fakeServer) close() {
+	_ = s.listener.Close()
+	s.wg.Wait()
+}
+
+// lastHandshake is the handshake of the last connection made to the server.
+func (s *fakeServer) lastHandshake() Handshake {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.handshake
+}
+
+func (s *fakeServer) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeServer) handle(conn net.Conn) {
+	defer s.wg.Done()
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+
+	handshake, err := ReadHandshake(reader)
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.handshake = handshake
+	s.mu.Unlock()
+
+	_ = ServeStatus(conn, reader, s.status)
+}
+
+func TestPing(t *testing.T) {
+	want := Status{
+		Version: Version{Name: "1.16.5", Protocol: 754},
+		Players: Players{Online: 3, Max: 20},
+		Motd:    "A Minecraft Server",
+	}
+
+	server := newFakeServer(t, want)
+	defer server.close()
+
+	status, err := Ping(server.address(), testTimeout)
+	if err != nil {
+		t.Fatalf("Could not ping: %s", err)
+	}
+
+	if status.Version != want.Version || status.Players != want.Players || status.Motd != want.Motd {
+		t.Errorf("Pinging gave %+v, want %+v", status, want)
+	}
+	if status.Latency <= 0 {
+		t.Errorf("Pinging gave a latency of %s", status.Latency)
+	}
+
+	handshake := server.lastHandshake()
+	if handshake.NextState != NextStateStatus {
+		t.Errorf("Ping handshake asked for next state %d, want %d", handshake.NextState, NextStateStatus)
+	}
+	if handshake.ProtocolVersion != protocolUnknown {
+		t.Errorf("Ping handshake announced protocol %d, want %d", handshake.ProtocolVersion, protocolUnknown)
+	}
+	if host, _, _ := net.SplitHostPort(server.address()); handshake.Hostname() != host {
+		t.Errorf("Ping handshake was for host %q, want %q", handshake.Hostname(), host)
+	}
+}
+
+func TestPingTimesOut(t *testing.T) {
+	// accepts connections, but never says anything
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %s", err)
+	}
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.Copy(ioutil.Discard, conn)
+	}()
+
+	_, err = Ping(listener.Addr().String(), 100*time.Millisecond)
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Errorf("Pinging a silent server gave %v, want a timeout", err)
+	}
+}
+
+func TestParseDescription(t *testing.T) {
+	cases := []struct {
+		raw  string
+		want string
+	}{
+		// older servers send plain strings
+		{`"A Minecraft Server"`, "A Minecraft Server"},
+		{`{"text":"A Minecraft Server"}`, "A Minecraft Server"},
+		{`{"text":"A ","extra":[{"text":"Minecraft"},{"text":" Server","extra":[{"text":"!"}]}]}`, "A Minecraft Server!"},
+		{`{"text":"","extra":[{"text":"Coloured","color":"gold"}]}`, "Coloured"},
+		{`42`, ""},
+	}
+
+	for _, c := range cases {
+		got := parseDescription(json.RawMessage(c.raw))
+		if got != c.want {
+			t.Errorf("parseDescription(%s) = %q, want %q", c.raw, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package slp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// The server's side of the conversation, for answering in place of a game server that isn't there (yet).
+
+const (
+	NextStateStatus int32 = 1
+	NextStateLogin  int32 = 2
+
+	packetLoginStart int32 = 0x00
+	// the only packet a server can send a client during login that doesn't need encryption to be set up first
+	packetDisconnect int32 = 0x00
+)
+
+// Handshake is the first packet of every connection, which says where the client thinks it is connecting to and
+// what it wants to do there.
+type Handshake struct {
+	ProtocolVersion int32
+	ServerAddress   string
+	ServerPort      uint16
+	NextState       int32
+
+	payload []byte
+}
+
+// ReadHandshake reads the handshake off a new connection.
+func ReadHandshake(r *bufio.Reader) (Handshake, error) {
+	var handshake Handshake
+
+	id, payload, err := readPacket(r)
+	if err != nil {
+		return handshake, err
+	}
+	if id != packetHandshake {
+		return handshake, ErrInvalidPacket
+	}
+
+	payloadReader := bytes.NewReader(payload)
+
+	handshake.ProtocolVersion, err = readVarInt(payloadReader)
+	if err != nil {
+		return handshake, err
+	}
+	handshake.ServerAddress, err = readString(payloadReader)
+	if err != nil {
+		return handshake, err
+	}
+	err = binary.Read(payloadReader, binary.BigEndian, &handshake.ServerPort)
+	if err != nil {
+		return handshake, err
+	}
+	handshake.NextState, err = readVarInt(payloadReader)
+	if err != nil {
+		return handshake, err
+	}
+
+	handshake.payload = payload
+
+	return handshake, nil
+}
+
+// Hostname is the host the client was told to connect to, without the markers some modded clients tack on to the
+// end (e.g. Forge's "\x00FML\x00") or the trailing dot of a fully qualified name.
+func (h Handshake) Hostname() string {
+	host := h.ServerAddress
+	if i := strings.IndexByte(host, 0); i >= 0 {
+		host = host[:i]
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// Forward writes the handshake out exactly as the client sent it, for handing the connection on to the real server.
+func (h Handshake) Forward(w io.Writer) error {
+	return writePacket(w, packetHandshake, h.payload)
+}
+
+// ServeStatus answers the status request (and ping) that follows a handshake with a next state of status.
+func ServeStatus(w io.Writer, r *bufio.Reader, status Status) error {
+	for {
+		id, payload, err := readPacket(r)
+		if err != nil {
+			return err
+		}
+
+		switch id {
+		case packetStatus:
+			description, _ := json.Marshal(chatComponent{Text: status.Motd})
+			body, _ := json.Marshal(statusResponse{
+				Version:     status.Version,
+				Players:     status.Players,
+				Description: description,
+			})
+
+			var buf bytes.Buffer
+			writeString(&buf, string(body))
+			err = writePacket(w, packetStatus, buf.Bytes())
+		case packetPing:
+			// the ping is the last thing the client asks for
+			return writePacket(w, packetPing, payload)
+		default:
+			return ErrInvalidPacket
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// ReadLoginStart reads the name of the player logging in, from the packet that follows a handshake with a next state
+// of login.
+func ReadLoginStart(r *bufio.Reader) (string, error) {
+	id, payload, err := readPacket(r)
+	if err != nil {
+		return "", err
+	}
+	if id != packetLoginStart {
+		return "", ErrInvalidPacket
+	}
+
+	// newer clients follow the name with their UUID, which we have no use for
+	return readString(bytes.NewReader(payload))
+}
+
+// Disconnect turns away a client that is logging in, showing it the message.
+func Disconnect(w io.Writer, message string) error {
+	reason, _ := json.Marshal(chatComponent{Text: message})
+
+	var buf bytes.Buffer
+	writeString(&buf, string(reason))
+	return writePacket(w, packetDisconnect, buf.Bytes())
+}
@@ -0,0 +1,97 @@
+package slp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// handshakePacket builds a handshake as a client would send it.
+func handshakePacket(address string, nextState int32) []byte {
+	var payload bytes.Buffer
+	writeVarInt(&payload, 754)
+	writeString(&payload, address)
+	_ = binary.Write(&payload, binary.BigEndian, uint16(25565))
+	writeVarInt(&payload, nextState)
+
+	var packet bytes.Buffer
+	_ = writePacket(&packet, packetHandshake, payload.Bytes())
+	return packet.Bytes()
+}
+
+func TestReadHandshake(t *testing.T) {
+	sent := handshakePacket("Survival.Example.com.\x00FML\x00", NextStateLogin)
+
+	handshake, err := ReadHandshake(bufio.NewReader(bytes.NewReader(sent)))
+	if err != nil {
+		t.Fatalf("Could not read handshake: %s", err)
+	}
+
+	if handshake.ProtocolVersion != 754 || handshake.ServerPort != 25565 || handshake.NextState != NextStateLogin {
+		t.Errorf("Read handshake %+v", handshake)
+	}
+	if handshake.Hostname() != "survival.example.com" {
+		t.Errorf("Handshake was for host %q, want %q", handshake.Hostname(), "survival.example.com")
+	}
+
+	// the game server gets the handshake exactly as the client sent it, markers and all
+	var forwarded bytes.Buffer
+	err = handshake.Forward(&forwarded)
+	if err != nil {
+		t.Fatalf("Could not forward handshake: %s", err)
+	}
+	if !bytes.Equal(forwarded.Bytes(), sent) {
+		t.Errorf("Forwarded %x, want %x", forwarded.Bytes(), sent)
+	}
+}
+
+func TestReadHandshakeRefusesOtherProtocols(t *testing.T) {
+	// what a web browser pointed at the port would send
+	_, err := ReadHandshake(bufio.NewReader(bytes.NewReader([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"))))
+	if err == nil {
+		t.Errorf("Read a handshake out of an HTTP request")
+	}
+}
+
+func TestReadLoginStart(t *testing.T) {
+	var payload bytes.Buffer
+	writeString(&payload, "Notch")
+	// newer clients follow the name with their UUID
+	payload.Write(make([]byte, 17))
+
+	var packet bytes.Buffer
+	_ = writePacket(&packet, packetLoginStart, payload.Bytes())
+
+	player, err := ReadLoginStart(bufio.NewReader(&packet))
+	if err != nil {
+		t.Fatalf("Could not read login start: %s", err)
+	}
+	if player != "Notch" {
+		t.Errorf("Login was for %q, want %q", player, "Notch")
+	}
+}
+
+func TestDisconnect(t *testing.T) {
+	var sent bytes.Buffer
+	err := Disconnect(&sent, "This server is asleep")
+	if err != nil {
+		t.Fatalf("Could not disconnect: %s", err)
+	}
+
+	id, payload, err := readPacket(bufio.NewReader(&sent))
+	if err != nil {
+		t.Fatalf("Could not read disconnect packet: %s", err)
+	}
+	if id != packetDisconnect {
+		t.Errorf("Disconnect was sent as packet %d, want %d", id, packetDisconnect)
+	}
+
+	reason, err := readString(bytes.NewReader(payload))
+	if err != nil {
+		t.Fatalf("Could not read disconnect reason: %s", err)
+	}
+	if reason != `{"text":"This server is asleep"}` {
+		t.Errorf("Disconnect reason was %s", reason)
+	}
+}
@@ -0,0 +1,150 @@
+package software
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"unicode/utf8"
+)
+
+const (
+	ConfigTypeString = "string"
+	ConfigTypeInt    = "int"
+	ConfigTypeBool   = "bool"
+	ConfigTypeEnum   = "enum"
+)
+
+// ConfigOption is one setting users may change on their containers, passed to the image through an env var.
+type ConfigOption struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Type        string      `json:"type"`
+	Env         string      `json:"env"`
+	Default     interface{} `json:"default,omitempty"`
+	// allowed values of an enum option
+	Options []string `json:"options,omitempty"`
+	// bounds of an int option
+	Min *int64 `json:"min,omitempty"`
+	Max *int64 `json:"max,omitempty"`
+	// name of a per-container limit (e.g. one set by the tier) that caps an int option on top of Max
+	Limit string `json:"limit,omitempty"`
+	// constraints on a string option, the length is in characters rather than bytes
+	MaxLength int    `json:"max_length,omitempty"`
+	Pattern   string `json:"pattern,omitempty"`
+	// appended to the value when it is passed to the image, e.g. "M" for a size in megabytes
+	Suffix string `json:"suffix,omitempty"`
+}
+
+// ConfigError explains why a setting was rejected, in terms we can show the user.
+type ConfigError struct {
+	Option  string
+	Message string
+}
+
+func (e ConfigError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Option, e.Message)
+}
+
+func (s Software) findConfigOption(name string) (ConfigOption, bool) {
+	for _, o := range s.ConfigSchema {
+		if o.Name == name {
+			return o, true
+		}
+	}
+	return ConfigOption{}, false
+}
+
+// ValidateConfig checks every value against the software's config schema, returning a ConfigError for the first
+// one that doesn't fit.  Limits supplies the current value of any named limit the schema refers to.
+func (s Software) ValidateConfig(config map[string]interface{}, limits map[string]int64) error {
+	for name, value := range config {
+		option, ok := s.findConfigOption(name)
+		if !ok {
+			return ConfigError{Option: name, Message: "unknown setting"}
+		}
+
+		err := option.validate(value, limits)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o ConfigOption) validate(value interface{}, limits map[string]int64) error {
+	switch o.Type {
+	case ConfigTypeString:
+		str, ok := value.(string)
+		if !ok {
+			return ConfigError{Option: o.Name, Message: "must be a string"}
+		}
+		if o.MaxLength > 0 && utf8.RuneCountInString(str) > o.MaxLength {
+			return ConfigError{Option: o.Name, Message: fmt.Sprintf("must be at most %d characters", o.MaxLength)}
+		}
+		if o.Pattern != "" && !regexp.MustCompile(o.Pattern).MatchString(str) {
+			return ConfigError{Option: o.Name, Message: "is not in the expected format"}
+		}
+	case ConfigTypeInt:
+		// json gives us every number as a float64
+		f, ok := value.(float64)
+		if !ok || f != math.Trunc(f) {
+			return ConfigError{Option: o.Name, Message: "must be a whole number"}
+		}
+		i := int64(f)
+		if o.Min != nil && i < *o.Min {
+			return ConfigError{Option: o.Name, Message: fmt.Sprintf("must be at least %d", *o.Min)}
+		}
+		if o.Max != nil && i > *o.Max {
+			return ConfigError{Option: o.Name, Message: fmt.Sprintf("must be at most %d", *o.Max)}
+		}
+		if limit, ok := limits[o.Limit]; ok && o.Limit != "" && i > limit {
+			return ConfigError{Option: o.Name, Message: fmt.Sprintf("must be at most %d on this tier", limit)}
+		}
+	case ConfigTypeBool:
+		if _, ok := value.(bool); !ok {
+			return ConfigError{Option: o.Name, Message: "must be true or false"}
+		}
+	case ConfigTypeEnum:
+		str, ok := value.(string)
+		if ok {
+			for _, option := range o.Options {
+				if str == option {
+					return nil
+				}
+			}
+		}
+		return ConfigError{Option: o.Name, Message: fmt.Sprintf("must be one of %v", o.Options)}
+	default:
+		return ConfigError{Option: o.Name, Message: "has an unsupported type"}
+	}
+	return nil
+}
+
+// ConfigEnv turns a (validated) config into env vars in the KEY=VALUE form docker expects, falling back to each
+// option's default.  Options with neither a value nor a default are left for the image to decide.
+func (s Software) ConfigEnv(config map[string]interface{}) []string {
+	env := make([]string, 0, len(s.ConfigSchema))
+	for _, o := range s.ConfigSchema {
+		value, ok := config[o.Name]
+		if !ok {
+			value = o.Default
+		}
+		if value == nil {
+			continue
+		}
+		env = append(env, fmt.Sprintf("%s=%s%s", o.Env, formatConfigValue(value), o.Suffix))
+	}
+	return env
+}
+
+func formatConfigValue(value interface{}) string {
+	switch v := value.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
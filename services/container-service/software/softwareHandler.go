@@ -0,0 +1,10 @@
+package software
+
+import (
+	"bitbucket.org/smaug-hosting/services/libhttp"
+	"net/http"
+)
+
+func HandleGetSoftware(response http.ResponseWriter, request *http.Request) {
+	libhttp.SendJson(SoftwareRepository{}.All(), response)
+}
@@ -0,0 +1,97 @@
+package software
+
+import (
+	"fmt"
+	"sort"
+)
+
+type Port struct {
+	Name     string `json:"name"`
+	Target   uint32 `json:"target"`
+	Protocol string `json:"protocol"`
+	// internal ports (e.g. admin consoles) are not published to the internet
+	Internal bool `json:"internal,omitempty"`
+}
+
+type Mount struct {
+	Name   string `json:"name"`
+	Target string `json:"target"`
+}
+
+// Console describes how to reach a title's remote console, if it has one.
+type Console struct {
+	Protocol string `json:"protocol"`
+	// name of the port (from Ports) the console listens on
+	PortName string `json:"port_name"`
+	// name of the env var the image reads the console password from
+	PasswordEnv string `json:"password_env"`
+}
+
+// StatusProbe describes how to ask a running server how it is doing (players online etc.), if it can be asked.
+type StatusProbe struct {
+	Protocol string `json:"protocol"`
+	// name of the port (from Ports) the status protocol is spoken on
+	PortName string `json:"port_name"`
+}
+
+const StatusProtocolMinecraft = "minecraft-slp"
+
+type Software struct {
+	Name        string            `json:"name"`
+	DisplayName string            `json:"display_name"`
+	Image       string            `json:"image"`
+	Ports       []Port            `json:"ports"`
+	Mounts      []Mount           `json:"mounts"`
+	Env         map[string]string `json:"env"`
+	Tiers       []int             `json:"tiers"`
+	// name of the env var the image reads its player cap from, if it has one
+	PlayerCapEnv string         `json:"player_cap_env"`
+	Console      *Console       `json:"console,omitempty"`
+	Status       *StatusProbe   `json:"status,omitempty"`
+	ConfigSchema []ConfigOption `json:"config_schema"`
+	Versions     *Versions      `json:"versions,omitempty"`
+	Flavours     []Flavour      `json:"flavours,omitempty"`
+	// name of the env var the image reads its Java heap size from (e.g. "768M"), if it has one
+	HeapEnv string `json:"heap_env,omitempty"`
+	// name of the registry credentials to pull the image with, when its registry's aren't the right ones
+	RegistryCredentials string `json:"registry_credentials,omitempty"`
+}
+
+type Catalog struct {
+	Version  int        `json:"version"`
+	Software []Software `json:"software"`
+}
+
+func (s Software) FindPort(name string) (Port, bool) {
+	for _, p := range s.Ports {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Port{}, false
+}
+
+func (s Software) AllowsTier(tier int) bool {
+	for _, t := range s.Tiers {
+		if t == tier {
+			return true
+		}
+	}
+	return false
+}
+
+// EnvList returns the default environment in the KEY=VALUE form docker expects, sorted by key so that the
+// resulting service spec doesn't change (and trigger a redeploy) just because map iteration order did.
+func (s Software) EnvList() []string {
+	keys := make([]string, 0, len(s.Env))
+	for k := range s.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	env := make([]string, 0, len(keys))
+	for _, k := range keys {
+		env = append(env, fmt.Sprintf("%s=%s", k, s.Env[k]))
+	}
+	return env
+}
@@ -0,0 +1,98 @@
+package software
+
+import (
+	µ "bitbucket.org/smaug-hosting/services/micro"
+	"encoding/json"
+	"github.com/docker/distribution/reference"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+)
+
+// the only registry credentials format we currently understand
+const registryCredentialsVersion = 1
+
+// RegistryCredentials log in to a private image registry.  Credentials with a host are used for every image from that
+// registry, and catalog entries can also name the credentials their image is pulled with.
+type RegistryCredentials struct {
+	Name     string `json:"name"`
+	Host     string `json:"host"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type registryCredentialsFile struct {
+	Version     int                   `json:"version"`
+	Credentials []RegistryCredentials `json:"credentials"`
+}
+
+var registryCredentials []RegistryCredentials
+
+// setupRegistryCredentials loads the credentials from REGISTRY_CREDENTIALS_FILE.  They are kept apart from the
+// catalog as they are secret; without the file every image has to be public.
+func setupRegistryCredentials() {
+	credentialsFile := µ.GetEnvDefault("REGISTRY_CREDENTIALS_FILE", "")
+	if credentialsFile == "" {
+		logrus.Infof("No REGISTRY_CREDENTIALS_FILE given, pulling images without logging in")
+		return
+	}
+
+	credentialsBytes, err := ioutil.ReadFile(credentialsFile)
+	if err != nil {
+		logrus.Fatalf("Could not read registry credentials file: %s", err)
+	}
+
+	file := registryCredentialsFile{}
+	err = json.Unmarshal(credentialsBytes, &file)
+	if err != nil {
+		logrus.Fatalf("Could not load registry credentials json: %s", err)
+	}
+
+	if file.Version != registryCredentialsVersion {
+		logrus.Fatalf("Unsupported registry credentials version %d (expected %d)", file.Version, registryCredentialsVersion)
+	}
+
+	for _, c := range file.Credentials {
+		if c.Name == "" && c.Host == "" {
+			logrus.Fatalf("Registry credentials must have a name, a host or both")
+		}
+	}
+
+	registryCredentials = file.Credentials
+	logrus.Infof("Loaded %d sets of registry credentials", len(registryCredentials))
+}
+
+func findRegistryCredentials(name string) (RegistryCredentials, bool) {
+	for _, c := range registryCredentials {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return RegistryCredentials{}, false
+}
+
+// RegistryCredentialsFor returns the credentials to pull the software's image with: those the catalog entry names if
+// it names any, otherwise those for the image's registry, otherwise nil.  The host of the credentials returned is
+// always filled in.  The image is passed in as containers may be running an older one than the catalog's.
+func (s Software) RegistryCredentialsFor(image string) *RegistryCredentials {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return nil
+	}
+	host := reference.Domain(named)
+
+	if s.RegistryCredentials != "" {
+		if c, ok := findRegistryCredentials(s.RegistryCredentials); ok {
+			if c.Host == "" {
+				c.Host = host
+			}
+			return &c
+		}
+	}
+
+	for _, c := range registryCredentials {
+		if c.Host == host {
+			return &c
+		}
+	}
+	return nil
+}
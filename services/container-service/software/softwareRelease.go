@@ -0,0 +1,97 @@
+package software
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// Versions describes how users pick which version of the game a container runs.
+type Versions struct {
+	// name of the env var the image reads the version from
+	Env     string `json:"env"`
+	Default string `json:"default"`
+	Pattern string `json:"pattern"`
+}
+
+// Flavour is a variant of the server (e.g. a modded one) that the image runs when given the flavour's env.
+type Flavour struct {
+	Name        string            `json:"name"`
+	DisplayName string            `json:"display_name"`
+	Env         map[string]string `json:"env"`
+}
+
+func (s Software) FindFlavour(name string) (Flavour, bool) {
+	for _, f := range s.Flavours {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return Flavour{}, false
+}
+
+// DefaultRelease is the version and flavour a container runs when it doesn't choose one, either of which may be
+// empty when the software doesn't offer the choice.
+func (s Software) DefaultRelease() (string, string) {
+	version, flavour := "", ""
+	if s.Versions != nil {
+		version = s.Versions.Default
+	}
+	if len(s.Flavours) > 0 {
+		flavour = s.Flavours[0].Name
+	}
+	return version, flavour
+}
+
+// ValidateRelease checks that the software can run the version and flavour, returning a ConfigError if it can't.
+// Empty values stand for the defaults and are always valid.
+func (s Software) ValidateRelease(version string, flavour string) error {
+	if version != "" {
+		if s.Versions == nil {
+			return ConfigError{Option: "version", Message: "can't be chosen for this software"}
+		}
+		if s.Versions.Pattern != "" && !regexp.MustCompile(s.Versions.Pattern).MatchString(version) {
+			return ConfigError{Option: "version", Message: "is not in the expected format"}
+		}
+	}
+
+	if flavour != "" {
+		if _, ok := s.FindFlavour(flavour); !ok {
+			return ConfigError{Option: "flavour", Message: "is not available for this software"}
+		}
+	}
+
+	return nil
+}
+
+// ReleaseEnv returns the env vars that make the image run the (validated) version and flavour, in the KEY=VALUE
+// form docker expects.
+func (s Software) ReleaseEnv(version string, flavour string) []string {
+	defaultVersion, defaultFlavour := s.DefaultRelease()
+	if version == "" {
+		version = defaultVersion
+	}
+	if flavour == "" {
+		flavour = defaultFlavour
+	}
+
+	env := make([]string, 0)
+
+	if f, ok := s.FindFlavour(flavour); ok {
+		keys := make([]string, 0, len(f.Env))
+		for k := range f.Env {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			env = append(env, fmt.Sprintf("%s=%s", k, f.Env[k]))
+		}
+	}
+
+	if s.Versions != nil && version != "" {
+		env = append(env, fmt.Sprintf("%s=%s", s.Versions.Env, version))
+	}
+
+	return env
+}
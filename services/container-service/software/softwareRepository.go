@@ -0,0 +1,128 @@
+package software
+
+import (
+	µ "bitbucket.org/smaug-hosting/services/micro"
+	"encoding/json"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"regexp"
+)
+
+// the only catalog format we currently understand; bump this (and handle the old one) when the format changes
+const catalogVersion = 1
+
+var ErrSoftwareNotFound = errors.New("software not found in catalog")
+
+var catalog Catalog
+
+// Setup loads the catalog from SOFTWARE_CATALOG_FILE, or from defaultFile if that isn't set.  The catalog lives with
+// container-service, so other services that need it pass the path to it from the directory they run in.
+func Setup(defaultFile string) {
+	setupRegistryCredentials()
+
+	catalogFile := µ.GetEnvDefault("SOFTWARE_CATALOG_FILE", defaultFile)
+
+	catalogBytes, err := ioutil.ReadFile(catalogFile)
+	if err != nil {
+		logrus.Fatalf("Could not read software catalog file (set SOFTWARE_CATALOG_FILE to its location): %s", err)
+	}
+
+	err = json.Unmarshal(catalogBytes, &catalog)
+	if err != nil {
+		logrus.Fatalf("Could not load software catalog json: %s", err)
+	}
+
+	if catalog.Version != catalogVersion {
+		logrus.Fatalf("Unsupported software catalog version %d (expected %d)", catalog.Version, catalogVersion)
+	}
+
+	for _, s := range catalog.Software {
+		if s.Image == "" || len(s.Ports) == 0 {
+			logrus.Fatalf("Software catalog entry %s must have an image and at least one port", s.Name)
+		}
+		if s.RegistryCredentials != "" {
+			if _, ok := findRegistryCredentials(s.RegistryCredentials); !ok {
+				logrus.Fatalf("Software catalog entry %s uses unknown registry credentials %s", s.Name, s.RegistryCredentials)
+			}
+		}
+		if s.Ports[0].Internal {
+			logrus.Fatalf("Software catalog entry %s must publish its first port", s.Name)
+		}
+		if s.Console != nil {
+			port, ok := s.FindPort(s.Console.PortName)
+			if !ok {
+				logrus.Fatalf("Software catalog entry %s has a console on unknown port %s", s.Name, s.Console.PortName)
+			}
+			if !port.Internal {
+				logrus.Fatalf("Software catalog entry %s has a console on port %s, which must be internal", s.Name, s.Console.PortName)
+			}
+		}
+		if s.Status != nil {
+			if _, ok := s.FindPort(s.Status.PortName); !ok {
+				logrus.Fatalf("Software catalog entry %s has a status probe on unknown port %s", s.Name, s.Status.PortName)
+			}
+			if s.Status.Protocol != StatusProtocolMinecraft {
+				logrus.Fatalf("Software catalog entry %s has a status probe with unknown protocol %s", s.Name, s.Status.Protocol)
+			}
+		}
+		for _, o := range s.ConfigSchema {
+			if o.Name == "" || o.Env == "" {
+				logrus.Fatalf("Software catalog entry %s has a config option without a name or env var", s.Name)
+			}
+			switch o.Type {
+			case ConfigTypeString, ConfigTypeInt, ConfigTypeBool, ConfigTypeEnum:
+			default:
+				logrus.Fatalf("Software catalog entry %s has config option %s of unknown type %s", s.Name, o.Name, o.Type)
+			}
+			if o.Pattern != "" {
+				if _, err := regexp.Compile(o.Pattern); err != nil {
+					logrus.Fatalf("Software catalog entry %s has an invalid pattern for config option %s: %s", s.Name, o.Name, err)
+				}
+			}
+			if o.Type == ConfigTypeEnum && len(o.Options) == 0 {
+				logrus.Fatalf("Software catalog entry %s has enum config option %s with no options", s.Name, o.Name)
+			}
+			if o.Default != nil {
+				if err := o.validate(o.Default, nil); err != nil {
+					logrus.Fatalf("Software catalog entry %s has an invalid default: %s", s.Name, err)
+				}
+			}
+		}
+		if s.Versions != nil {
+			if s.Versions.Env == "" {
+				logrus.Fatalf("Software catalog entry %s has versions without an env var", s.Name)
+			}
+			if _, err := regexp.Compile(s.Versions.Pattern); err != nil {
+				logrus.Fatalf("Software catalog entry %s has an invalid version pattern: %s", s.Name, err)
+			}
+		}
+		flavours := make(map[string]bool, len(s.Flavours))
+		for _, f := range s.Flavours {
+			if f.Name == "" || flavours[f.Name] {
+				logrus.Fatalf("Software catalog entry %s has a flavour without a name, or with the same name as another", s.Name)
+			}
+			flavours[f.Name] = true
+		}
+		if err := s.ValidateRelease(s.DefaultRelease()); err != nil {
+			logrus.Fatalf("Software catalog entry %s has an invalid default release: %s", s.Name, err)
+		}
+	}
+
+	logrus.Infof("Loaded %d software titles from catalog", len(catalog.Software))
+}
+
+type SoftwareRepository struct{}
+
+func (sr SoftwareRepository) All() []Software {
+	return catalog.Software
+}
+
+func (sr SoftwareRepository) FindByName(name string) (Software, error) {
+	for _, s := range catalog.Software {
+		if s.Name == name {
+			return s, nil
+		}
+	}
+	return Software{}, ErrSoftwareNotFound
+}
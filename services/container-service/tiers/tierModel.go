@@ -0,0 +1,48 @@
+package tiers
+
+type Tier struct {
+	Tier             int     `json:"tier"`
+	CPUs             float64 `json:"cpus"`
+	MemoryMB         int64   `json:"memory_mb"`
+	ReservedCPUs     float64 `json:"reserved_cpus"`
+	ReservedMemoryMB int64   `json:"reserved_memory_mb"`
+	DiskQuotaMB      int64   `json:"disk_quota_mb"`
+	MaxPlayers       int     `json:"max_players"`
+}
+
+type TierDefinitions struct {
+	Version int    `json:"version"`
+	Tiers   []Tier `json:"tiers"`
+}
+
+const nanoCPUsPerCPU = 1e9
+const bytesPerMB = 1024 * 1024
+
+// the share of a tier's memory a Java game server's heap gets, the rest being left for the JVM's own overhead
+const heapPercent = 75
+
+func (t Tier) NanoCPUs() int64 {
+	return int64(t.CPUs * nanoCPUsPerCPU)
+}
+
+func (t Tier) MemoryBytes() int64 {
+	return t.MemoryMB * bytesPerMB
+}
+
+// HeapMB is the most heap a Java game server on the tier can have without the container being killed for going
+// over its memory limit.
+func (t Tier) HeapMB() int64 {
+	return t.MemoryMB * heapPercent / 100
+}
+
+func (t Tier) ReservedNanoCPUs() int64 {
+	return int64(t.ReservedCPUs * nanoCPUsPerCPU)
+}
+
+func (t Tier) ReservedMemoryBytes() int64 {
+	return t.ReservedMemoryMB * bytesPerMB
+}
+
+func (t Tier) DiskQuotaBytes() int64 {
+	return t.DiskQuotaMB * bytesPerMB
+}
@@ -0,0 +1,58 @@
+package tiers
+
+import (
+	µ "bitbucket.org/smaug-hosting/services/micro"
+	"encoding/json"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+)
+
+const definitionsVersion = 1
+
+var ErrTierNotFound = errors.New("tier not found in tier definitions")
+
+var definitions TierDefinitions
+
+// Setup loads the tier definitions from TIERS_FILE, or from defaultFile if that isn't set.  Like the software
+// catalog, the definitions live with container-service.
+func Setup(defaultFile string) {
+	tiersFile := µ.GetEnvDefault("TIERS_FILE", defaultFile)
+
+	tierBytes, err := ioutil.ReadFile(tiersFile)
+	if err != nil {
+		logrus.Fatalf("Could not read tier definitions file (set TIERS_FILE to its location): %s", err)
+	}
+
+	err = json.Unmarshal(tierBytes, &definitions)
+	if err != nil {
+		logrus.Fatalf("Could not load tier definitions json: %s", err)
+	}
+
+	if definitions.Version != definitionsVersion {
+		logrus.Fatalf("Unsupported tier definitions version %d (expected %d)", definitions.Version, definitionsVersion)
+	}
+
+	for _, t := range definitions.Tiers {
+		if t.ReservedCPUs > t.CPUs || t.ReservedMemoryMB > t.MemoryMB {
+			logrus.Fatalf("Tier %d reserves more resources than its limits allow", t.Tier)
+		}
+	}
+
+	logrus.Infof("Loaded %d tier definitions", len(definitions.Tiers))
+}
+
+type TierRepository struct{}
+
+func (tr TierRepository) All() []Tier {
+	return definitions.Tiers
+}
+
+func (tr TierRepository) FindByTier(tier int) (Tier, error) {
+	for _, t := range definitions.Tiers {
+		if t.Tier == tier {
+			return t, nil
+		}
+	}
+	return Tier{}, ErrTierNotFound
+}
@@ -0,0 +1,152 @@
+package transfers
+
+import (
+	"bitbucket.org/smaug-hosting/services/container-service/containers"
+	"bitbucket.org/smaug-hosting/services/idp/collaborators"
+	"bitbucket.org/smaug-hosting/services/idp/tokens"
+	"bitbucket.org/smaug-hosting/services/idp/users"
+	"bitbucket.org/smaug-hosting/services/libhttp"
+	"database/sql"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+)
+
+type TransferRequest struct {
+	Email string `json:"email"`
+}
+
+// HandlePostTransfer offers the container to someone else by email.  Nothing changes until they accept.
+func HandlePostTransfer(response http.ResponseWriter, request *http.Request) {
+	container, ok := containers.ContainerForRequest(response, request, collaborators.PermOwner)
+	if !ok {
+		return
+	}
+
+	body := TransferRequest{}
+	err := libhttp.UnmarshalBody(request, response, &body)
+	if err != nil {
+		return
+	}
+
+	address := strings.TrimSpace(body.Email)
+	if !strings.Contains(address, "@") {
+		libhttp.SendError(http.StatusBadRequest, "Please provide the email address to transfer the container to", response)
+		return
+	}
+
+	owner, err := users.UserRepository{}.Find(container.UserId)
+	if err != nil || owner == nil {
+		logrus.Errorf("Could not fetch owner of container %d: %s", container.Id, err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not transfer container", response)
+		return
+	}
+
+	transfer, err := OfferTransfer(*container, *owner, address)
+	if err == ErrTransferToSelf {
+		libhttp.SendError(http.StatusBadRequest, "You already own this container", response)
+		return
+	} else if err == ErrEmailNotSent {
+		libhttp.SendError(http.StatusBadGateway, "Could not send the transfer email, please try again", response)
+		return
+	} else if err != nil {
+		logrus.Errorf("Could not offer transfer of container %d: %s", container.Id, err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not transfer container", response)
+		return
+	}
+
+	libhttp.SendJsonWithStatus(http.StatusCreated, transfer, response)
+}
+
+func HandleGetTransfer(response http.ResponseWriter, request *http.Request) {
+	container, ok := containers.ContainerForRequest(response, request, collaborators.PermOwner)
+	if !ok {
+		return
+	}
+
+	transfer, err := TransferRepository{}.FindPendingForContainer(container.Id)
+	if err == sql.ErrNoRows {
+		libhttp.SendError(http.StatusNotFound, "This container isn't being transferred", response)
+		return
+	} else if err != nil {
+		logrus.Errorf("Could not fetch transfer of container %d: %s", container.Id, err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not fetch transfer", response)
+		return
+	}
+
+	libhttp.SendJson(transfer, response)
+}
+
+// HandleDeleteTransfer withdraws the container's pending transfer, if it has one.
+func HandleDeleteTransfer(response http.ResponseWriter, request *http.Request) {
+	container, ok := containers.ContainerForRequest(response, request, collaborators.PermOwner)
+	if !ok {
+		return
+	}
+
+	err := TransferRepository{}.DeletePendingForContainer(container.Id)
+	if err != nil {
+		logrus.Errorf("Could not withdraw transfer of container %d: %s", container.Id, err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not withdraw transfer", response)
+		return
+	}
+
+	// empty 200 response if all went well
+	libhttp.SendJson(struct{}{}, response)
+}
+
+// HandleAcceptTransfer makes the logged in user the owner of the container they were offered.
+func HandleAcceptTransfer(response http.ResponseWriter, request *http.Request) {
+	claims := request.Context().Value("token_claims").(tokens.TokenClaims)
+
+	transfer, err := TransferRepository{}.FindPendingByToken(request.Context().Value("token").(string))
+	if err == sql.ErrNoRows {
+		libhttp.SendError(http.StatusNotFound, "Transfer not found, it may already have been accepted or withdrawn", response)
+		return
+	} else if err != nil {
+		logrus.Errorf("Could not fetch transfer: %s", err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not fetch transfer", response)
+		return
+	}
+
+	user, err := users.UserRepository{}.Find(claims.UserId)
+	if err != nil || user == nil {
+		logrus.Errorf("Could not fetch user %d to accept transfer: %s", claims.UserId, err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not fetch user", response)
+		return
+	}
+
+	container, err := AcceptTransfer(*transfer, *user)
+	switch err {
+	case nil:
+		libhttp.SendJson(container, response)
+	case ErrTransferExpired:
+		libhttp.SendError(http.StatusGone, "This transfer has expired, please ask for a new one", response)
+	case ErrTransferStale:
+		libhttp.SendError(http.StatusGone, "This container has been deleted or has changed hands since it was offered to you", response)
+	case ErrWrongRecipient:
+		logrus.Warnf("User %d tried to accept transfer %d offered to someone else", claims.UserId, transfer.Id)
+		libhttp.SendError(http.StatusForbidden, "This transfer was offered to a different email address", response)
+	case ErrContainerBusy:
+		libhttp.SendError(http.StatusConflict, "This container is busy right now, please try again shortly", response)
+	case containers.ErrUnverifiedUser:
+		libhttp.SendError(http.StatusForbidden, "Please verify your email address before accepting containers", response)
+	case containers.ErrInsufficientFunds:
+		libhttp.SendError(http.StatusPaymentRequired, "You do not have sufficient funds to take over this container while it is running", response)
+	case containers.ErrTierNotAllowed:
+		libhttp.SendError(http.StatusForbidden, "Your quota doesn't allow containers on this container's tier", response)
+	case containers.ErrTooManyContainers:
+		libhttp.SendError(http.StatusForbidden, "You already have as many containers as your quota allows", response)
+	case containers.ErrTooManyRunning:
+		libhttp.SendError(http.StatusConflict, "You already have as many containers running as your quota allows, stop one first", response)
+	case containers.ErrAtCapacity:
+		libhttp.SendError(http.StatusServiceUnavailable, "We're at capacity right now, please try again later", response)
+	case containers.ErrQuotaBusy:
+		libhttp.SendError(http.StatusServiceUnavailable, "We're busy right now, please try again shortly", response)
+	case containers.ErrStateConflict:
+		libhttp.SendError(http.StatusGone, "This container has changed hands since it was offered to you", response)
+	default:
+		logrus.Errorf("Could not accept transfer %d: %s", transfer.Id, err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not accept transfer", response)
+	}
+}
@@ -0,0 +1,16 @@
+package transfers
+
+import "time"
+
+// Transfer is an offer by a container's owner to hand it over to whoever owns the email address it was sent to.  It
+// only takes effect once they accept it.
+type Transfer struct {
+	Id          int64      `json:"id"`
+	ContainerId int64      `json:"container_id" db:"container_id"`
+	FromUserId  int64      `json:"-" db:"from_user_id"`
+	Email       string     `json:"email"`
+	Token       string     `json:"-"`
+	ToUserId    *int64     `json:"-" db:"to_user_id"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	AcceptedAt  *time.Time `json:"accepted_at" db:"accepted_at"`
+}
@@ -0,0 +1,111 @@
+package transfers
+
+import (
+	"bitbucket.org/smaug-hosting/services/database"
+	"github.com/Masterminds/squirrel"
+	"time"
+)
+
+type TransferRepository struct{}
+
+const tableName = "transfers"
+
+var transferColumns = []string{"id", "container_id", "from_user_id", "email", "token", "to_user_id", "created_at", "accepted_at"}
+
+func (tr TransferRepository) findOne(where squirrel.Sqlizer) (*Transfer, error) {
+	sql, params, err := squirrel.Select(transferColumns...).From(tableName).Where(where).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	transfer := new(Transfer)
+	err = database.Connection.Get(transfer, sql, params...)
+	if err != nil {
+		return nil, err
+	}
+
+	return transfer, nil
+}
+
+// FindPendingByToken finds the transfer the token was sent out for, as long as it hasn't been accepted yet.
+func (tr TransferRepository) FindPendingByToken(token string) (*Transfer, error) {
+	return tr.findOne(squirrel.And{squirrel.Eq{"token": token}, squirrel.Eq{"accepted_at": nil}})
+}
+
+func (tr TransferRepository) FindPendingForContainer(containerId int64) (*Transfer, error) {
+	return tr.findOne(squirrel.And{squirrel.Eq{"container_id": containerId}, squirrel.Eq{"accepted_at": nil}})
+}
+
+func (tr TransferRepository) Save(transfer Transfer) (Transfer, error) {
+	var result Transfer // only used if we fail
+
+	transfer.CreatedAt = time.Now()
+
+	sql, params, err := squirrel.
+		Insert(tableName).
+		SetMap(map[string]interface{}{
+			"container_id": transfer.ContainerId,
+			"from_user_id": transfer.FromUserId,
+			"email":        transfer.Email,
+			"token":        transfer.Token,
+			"created_at":   transfer.CreatedAt,
+		}).
+		ToSql()
+
+	if err != nil {
+		return result, err
+	}
+
+	res, err := database.Connection.Exec(sql, params...)
+	if err != nil {
+		return result, err
+	}
+
+	transfer.Id, err = res.LastInsertId()
+
+	return transfer, err
+}
+
+// Accept records who accepted the transfer, after which its token can't be used again.
+func (tr TransferRepository) Accept(transfer *Transfer, userId int64) error {
+	now := time.Now()
+
+	sql, params, err := squirrel.
+		Update(tableName).
+		SetMap(map[string]interface{}{
+			"to_user_id":  userId,
+			"accepted_at": now,
+		}).
+		Where("id = ?", transfer.Id).
+		ToSql()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = database.Connection.Exec(sql, params...)
+	if err != nil {
+		return err
+	}
+
+	transfer.ToUserId = &userId
+	transfer.AcceptedAt = &now
+
+	return nil
+}
+
+// DeletePendingForContainer withdraws any transfer of the container that hasn't been accepted yet.
+func (tr TransferRepository) DeletePendingForContainer(containerId int64) error {
+	sql, params, err := squirrel.
+		Delete(tableName).
+		Where(squirrel.And{squirrel.Eq{"container_id": containerId}, squirrel.Eq{"accepted_at": nil}}).
+		ToSql()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = database.Connection.Exec(sql, params...)
+
+	return err
+}
@@ -0,0 +1,145 @@
+package transfers
+
+import (
+	"bitbucket.org/smaug-hosting/services/container-service/backups"
+	"bitbucket.org/smaug-hosting/services/container-service/containers"
+	"bitbucket.org/smaug-hosting/services/idp/collaborators"
+	"bitbucket.org/smaug-hosting/services/idp/email"
+	"bitbucket.org/smaug-hosting/services/idp/users"
+	"bitbucket.org/smaug-hosting/services/metrics"
+	µ "bitbucket.org/smaug-hosting/services/micro"
+	"database/sql"
+	"errors"
+	"github.com/docker/docker/pkg/stringutils"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+var ErrTransferToSelf = errors.New("container can't be transferred to its owner")
+var ErrEmailNotSent = errors.New("could not send the transfer email")
+var ErrTransferExpired = errors.New("transfer has expired")
+var ErrTransferStale = errors.New("container has been deleted or changed hands since the transfer was offered")
+var ErrWrongRecipient = errors.New("transfer was offered to a different email address")
+var ErrContainerBusy = errors.New("container is being provisioned or deleted")
+
+func getTransferExpiry() time.Duration {
+	expiry, err := time.ParseDuration(µ.GetEnvDefault("TRANSFER_EXPIRY", "168h"))
+	if err != nil {
+		logrus.Errorf("Could not parse TRANSFER_EXPIRY, transfers expire after a week: %s", err)
+		expiry = 7 * 24 * time.Hour
+	}
+	return expiry
+}
+
+// OfferTransfer offers the container to whoever owns the email address, replacing any offer still pending.
+func OfferTransfer(c containers.Container, owner users.User, address string) (Transfer, error) {
+	if strings.EqualFold(owner.Email, address) {
+		return Transfer{}, ErrTransferToSelf
+	}
+
+	err := TransferRepository{}.DeletePendingForContainer(c.Id)
+	if err != nil {
+		return Transfer{}, err
+	}
+
+	transfer, err := TransferRepository{}.Save(Transfer{
+		ContainerId: c.Id,
+		FromUserId:  owner.Id,
+		Email:       address,
+		Token:       stringutils.GenerateRandomAlphaOnlyString(64),
+	})
+	if err != nil {
+		return Transfer{}, err
+	}
+
+	err = email.SendTransferEmail(address, owner.Email, c.Name, transfer.Token)
+	if err != nil {
+		logrus.Errorf("Could not send transfer email for container %d: %s", c.Id, err)
+
+		// an offer nobody was told about is no use, so let it be made again
+		deleteErr := TransferRepository{}.DeletePendingForContainer(c.Id)
+		if deleteErr != nil {
+			logrus.Errorf("Could not remove unsent transfer %d: %s", transfer.Id, deleteErr)
+		}
+
+		return Transfer{}, ErrEmailNotSent
+	}
+
+	return transfer, nil
+}
+
+// AcceptTransfer hands the container over to the user, who must be the (verified) owner of the email address it was
+// offered to.  From then on the container is billed to them and counts towards their quota, so they have to be able
+// to afford it and have room for it.  Its backups go with it; anyone collaborating on it keeps doing so, apart from
+// the new owner themselves, who no longer needs to.
+func AcceptTransfer(transfer Transfer, user users.User) (*containers.Container, error) {
+	if time.Since(transfer.CreatedAt) > getTransferExpiry() {
+		return nil, ErrTransferExpired
+	}
+
+	if !user.Verified {
+		return nil, containers.ErrUnverifiedUser
+	}
+
+	if !strings.EqualFold(user.Email, transfer.Email) {
+		return nil, ErrWrongRecipient
+	}
+
+	c, err := containers.ContainerRepository{}.FindById(transfer.ContainerId)
+	if err == sql.ErrNoRows {
+		return nil, ErrTransferStale
+	} else if err != nil {
+		return nil, err
+	}
+
+	if c.UserId != transfer.FromUserId {
+		return nil, ErrTransferStale
+	}
+
+	if c.State == containers.StateProvisioning || c.State == containers.StateDeleting {
+		return nil, ErrContainerBusy
+	}
+
+	handedOver := *c
+	handedOver.UserId = user.Id
+
+	if c.State.IsActive() {
+		// it starts costing them straight away
+		err = containers.CheckUserCanAfford(user.Id, c.Software, c.Tier)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = containers.WithinQuota(handedOver, c.State.IsActive(), func() error {
+		return containers.TransferOwnership(c, user.Id)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("Container %d transferred from user %d to user %d", c.Id, transfer.FromUserId, user.Id)
+	metrics.Increment("transfers.accepted")
+
+	// the rest is tidying up, the container is theirs whatever happens now
+	err = TransferRepository{}.Accept(&transfer, user.Id)
+	if err != nil {
+		logrus.Errorf("Could not record acceptance of transfer %d: %s", transfer.Id, err)
+	}
+
+	err = backups.BackupRepository{}.SetUserForContainer(c.Id, user.Id)
+	if err != nil {
+		logrus.Errorf("Could not hand backups of container %d over to user %d: %s", c.Id, user.Id, err)
+	}
+
+	collaborator, err := collaborators.CollaboratorRepository{}.FindForContainerAndUser(c.Id, user.Id)
+	if err == nil {
+		err = collaborators.CollaboratorRepository{}.Delete(*collaborator)
+	}
+	if err != nil && err != sql.ErrNoRows {
+		logrus.Errorf("Could not remove new owner %d as a collaborator on container %d: %s", user.Id, c.Id, err)
+	}
+
+	return c, nil
+}
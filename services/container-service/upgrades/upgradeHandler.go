@@ -0,0 +1,76 @@
+package upgrades
+
+import (
+	"bitbucket.org/smaug-hosting/services/container-service/containers"
+	"bitbucket.org/smaug-hosting/services/container-service/orchestrator/spec"
+	"bitbucket.org/smaug-hosting/services/container-service/software"
+	"bitbucket.org/smaug-hosting/services/idp/collaborators"
+	"bitbucket.org/smaug-hosting/services/libhttp"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"net/http"
+)
+
+type UpgradeRequest struct {
+	// the software's defaults when left out
+	Version string `json:"version"`
+	Flavour string `json:"flavour"`
+}
+
+func HandlePostUpgrade(response http.ResponseWriter, request *http.Request) {
+	container, ok := containers.ContainerForRequest(response, request, collaborators.PermAdmin)
+	if !ok {
+		return
+	}
+
+	body := UpgradeRequest{}
+	err := libhttp.UnmarshalBody(request, response, &body)
+	if err != nil {
+		return
+	}
+
+	upgrade, err := StartUpgrade(*container, body.Version, body.Flavour)
+	if configErr, ok := err.(software.ConfigError); ok {
+		libhttp.SendError(http.StatusBadRequest, configErr.Error(), response)
+		return
+	} else if err == spec.ErrImageNotFound {
+		logrus.WithField("severity", "CRITICAL").Errorf("Image for %s is not available: %s", container.Software, err)
+		libhttp.SendError(http.StatusServiceUnavailable, "This software is not available right now, please try again later", response)
+		return
+	} else if err == ErrContainerBusy {
+		libhttp.SendError(http.StatusConflict, fmt.Sprintf("Could not upgrade a container while it is %s", container.State), response)
+		return
+	} else if err == ErrUpgradeInProgress {
+		libhttp.SendError(http.StatusConflict, "This container is already being upgraded", response)
+		return
+	} else if containers.IsContainerLocked(err) {
+		libhttp.SendError(http.StatusConflict, fmt.Sprintf("Could not upgrade container: %s", err), response)
+		return
+	} else if err == ErrSameRelease {
+		libhttp.SendError(http.StatusBadRequest, "This container already runs that version", response)
+		return
+	} else if err != nil {
+		logrus.Errorf("Could not start upgrade of container %d: %s", container.Id, err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not start upgrade", response)
+		return
+	}
+
+	// the upgrade happens in the background, clients poll the upgrade list to see how it went
+	libhttp.SendJsonWithStatus(http.StatusAccepted, upgrade, response)
+}
+
+func HandleGetUpgrades(response http.ResponseWriter, request *http.Request) {
+	container, ok := containers.ContainerForRequest(response, request, collaborators.PermView)
+	if !ok {
+		return
+	}
+
+	upgrades, err := UpgradeRepository{}.GetUpgradesForContainer(container.Id)
+	if err != nil {
+		logrus.Errorf("Could not fetch upgrades for container %d: %s", container.Id, err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not fetch upgrades", response)
+		return
+	}
+
+	libhttp.SendJson(upgrades, response)
+}
@@ -0,0 +1,29 @@
+package upgrades
+
+import "time"
+
+type Status string
+
+const (
+	StatusPending    Status = "pending"
+	StatusComplete   Status = "complete"
+	StatusRolledBack Status = "rolled_back"
+	StatusFailed     Status = "failed"
+)
+
+// Upgrade records a container being moved from one release to another, along with the backup taken beforehand.
+type Upgrade struct {
+	Id          int64      `json:"id"`
+	ContainerId int64      `json:"container_id" db:"container_id"`
+	FromImage   string     `json:"from_image" db:"from_image"`
+	FromVersion string     `json:"from_version" db:"from_version"`
+	FromFlavour string     `json:"from_flavour" db:"from_flavour"`
+	ToImage     string     `json:"to_image" db:"to_image"`
+	ToVersion   string     `json:"to_version" db:"to_version"`
+	ToFlavour   string     `json:"to_flavour" db:"to_flavour"`
+	BackupId    *int64     `json:"backup_id" db:"backup_id"`
+	Status      Status     `json:"status"`
+	Error       string     `json:"error"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	FinishedAt  *time.Time `json:"finished_at" db:"finished_at"`
+}
@@ -0,0 +1,134 @@
+package upgrades
+
+import (
+	"bitbucket.org/smaug-hosting/services/database"
+	"github.com/Masterminds/squirrel"
+	"time"
+)
+
+type UpgradeRepository struct{}
+
+const tableName = "upgrades"
+
+var upgradeColumns = []string{"id", "container_id", "from_image", "from_version", "from_flavour", "to_image", "to_version", "to_flavour", "backup_id", "status", "error", "created_at", "finished_at"}
+
+// Save records a new pending upgrade.
+func (ur UpgradeRepository) Save(upgrade Upgrade) (Upgrade, error) {
+	var result Upgrade // only used if we fail
+
+	upgrade.Status = StatusPending
+	upgrade.CreatedAt = time.Now()
+
+	sql, params, err := squirrel.
+		Insert(tableName).
+		SetMap(map[string]interface{}{
+			"container_id": upgrade.ContainerId,
+			"from_image":   upgrade.FromImage,
+			"from_version": upgrade.FromVersion,
+			"from_flavour": upgrade.FromFlavour,
+			"to_image":     upgrade.ToImage,
+			"to_version":   upgrade.ToVersion,
+			"to_flavour":   upgrade.ToFlavour,
+			"status":       upgrade.Status,
+			"error":        upgrade.Error,
+			"created_at":   upgrade.CreatedAt,
+		}).
+		ToSql()
+
+	if err != nil {
+		return result, err
+	}
+
+	res, err := database.Connection.Exec(sql, params...)
+	if err != nil {
+		return result, err
+	}
+
+	upgrade.Id, err = res.LastInsertId()
+
+	return upgrade, err
+}
+
+func (ur UpgradeRepository) SetBackup(upgrade *Upgrade, backupId int64) error {
+	sql, params, err := squirrel.Update(tableName).Set("backup_id", backupId).Where("id = ?", upgrade.Id).ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = database.Connection.Exec(sql, params...)
+	if err != nil {
+		return err
+	}
+
+	upgrade.BackupId = &backupId
+
+	return nil
+}
+
+func (ur UpgradeRepository) Finish(upgrade *Upgrade, status Status, message string) error {
+	now := time.Now()
+
+	sql, params, err := squirrel.
+		Update(tableName).
+		SetMap(map[string]interface{}{
+			"status":      status,
+			"error":       message,
+			"finished_at": now,
+		}).
+		Where("id = ?", upgrade.Id).
+		ToSql()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = database.Connection.Exec(sql, params...)
+	if err != nil {
+		return err
+	}
+
+	upgrade.Status = status
+	upgrade.Error = message
+	upgrade.FinishedAt = &now
+
+	return nil
+}
+
+// FindPending lists the upgrades that haven't finished yet.
+func (ur UpgradeRepository) FindPending() ([]Upgrade, error) {
+	sql, params, err := squirrel.
+		Select(upgradeColumns...).
+		From(tableName).
+		Where("status = ?", StatusPending).
+		ToSql()
+
+	if err != nil {
+		return nil, err
+	}
+
+	upgrades := make([]Upgrade, 0)
+
+	err = database.Connection.Select(&upgrades, sql, params...)
+
+	return upgrades, err
+}
+
+// GetUpgradesForContainer lists the container's upgrades, newest first.
+func (ur UpgradeRepository) GetUpgradesForContainer(containerId int64) ([]Upgrade, error) {
+	sql, params, err := squirrel.
+		Select(upgradeColumns...).
+		From(tableName).
+		Where("container_id = ?", containerId).
+		OrderBy("created_at DESC").
+		ToSql()
+
+	if err != nil {
+		return nil, err
+	}
+
+	upgrades := make([]Upgrade, 0)
+
+	err = database.Connection.Select(&upgrades, sql, params...)
+
+	return upgrades, err
+}
@@ -0,0 +1,159 @@
+package upgrades
+
+import (
+	"bitbucket.org/smaug-hosting/services/container-service/backups"
+	"bitbucket.org/smaug-hosting/services/container-service/containers"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// what the container's lock is held for while it is being upgraded
+const lockOperation = "upgrading"
+
+var ErrContainerBusy = errors.New("container must be running or stopped to be upgraded")
+var ErrUpgradeInProgress = errors.New("container is already being upgraded")
+var ErrSameRelease = errors.New("container already runs that release")
+
+// StartUpgrade records a pending upgrade of the container onto the version and flavour and carries it out in the
+// background: a backup is taken first, then the container is moved onto the new release (see
+// containers.UpgradeContainer), with the backup restored if it has to be rolled back.
+func StartUpgrade(c containers.Container, version string, flavour string) (Upgrade, error) {
+	if c.State != containers.StateRunning && c.State != containers.StateStopped {
+		return Upgrade{}, ErrContainerBusy
+	}
+
+	release, err := containers.NewRelease(c.Software, version, flavour)
+	if err != nil {
+		return Upgrade{}, err
+	}
+
+	if release == c.Release {
+		return Upgrade{}, ErrSameRelease
+	}
+
+	// the container is locked for the whole upgrade, so that nothing else can start, stop, delete or restore into it
+	// until it is done
+	unlock, err := containers.LockContainer(c.Id, lockOperation)
+	if lockedErr, ok := err.(containers.ContainerLockedError); ok && lockedErr.Operation == lockOperation {
+		return Upgrade{}, ErrUpgradeInProgress
+	} else if err != nil {
+		return Upgrade{}, err
+	}
+
+	upgrade, err := UpgradeRepository{}.Save(Upgrade{
+		ContainerId: c.Id,
+		FromImage:   c.Image,
+		FromVersion: c.Version,
+		FromFlavour: c.Flavour,
+		ToImage:     release.Image,
+		ToVersion:   release.Version,
+		ToFlavour:   release.Flavour,
+	})
+	if err != nil {
+		unlock()
+		return upgrade, err
+	}
+
+	go runUpgrade(upgrade, c.Id, release, unlock)
+
+	return upgrade, nil
+}
+
+func runUpgrade(upgrade Upgrade, containerId int64, release containers.Release, unlock func()) {
+	defer unlock()
+
+	finish := func(status Status, message string) {
+		err := UpgradeRepository{}.Finish(&upgrade, status, message)
+		if err != nil {
+			logrus.Errorf("Could not record outcome of upgrade %d: %s", upgrade.Id, err)
+		}
+	}
+
+	c, err := containers.ContainerRepository{}.FindById(containerId)
+	if err != nil {
+		logrus.Errorf("Could not fetch container %d to upgrade: %s", containerId, err)
+		finish(StatusFailed, "could not fetch container")
+		return
+	}
+
+	backup, err := backups.BackupNow(*c)
+	if err != nil {
+		logrus.Errorf("Could not back up container %d before upgrading it: %s", c.Id, err)
+		finish(StatusFailed, fmt.Sprintf("could not take a backup first: %s", err))
+		return
+	}
+
+	err = UpgradeRepository{}.SetBackup(&upgrade, backup.Id)
+	if err != nil {
+		logrus.Errorf("Could not record backup %d for upgrade %d: %s", backup.Id, upgrade.Id, err)
+	}
+
+	// the backup may have taken a while, the container has to be as it was when the upgrade was asked for
+	c, err = containers.ContainerRepository{}.FindById(containerId)
+	if err != nil {
+		logrus.Errorf("Could not fetch container %d to upgrade: %s", containerId, err)
+		finish(StatusFailed, "could not fetch container")
+		return
+	}
+	if c.State != containers.StateRunning && c.State != containers.StateStopped {
+		finish(StatusFailed, fmt.Sprintf("container was %s by the time the backup was taken", c.State))
+		return
+	}
+
+	volume := containers.DataVolumeForContainer(*c)
+	restore := func() error {
+		return backups.RestoreVolume(backup, volume)
+	}
+
+	err = containers.UpgradeContainer(c, release, restore)
+	if upgradeErr, ok := err.(containers.UpgradeError); ok && upgradeErr.RolledBack {
+		finish(StatusRolledBack, upgradeErr.Error())
+		return
+	} else if err != nil {
+		logrus.Errorf("Could not upgrade container %d: %s", c.Id, err)
+		finish(StatusFailed, err.Error())
+		return
+	}
+
+	logrus.Infof("Upgraded container %d to %s %s", c.Id, release.Flavour, release.Version)
+	finish(StatusComplete, "")
+}
+
+// StartPendingUpgradeSweeper fails the upgrades left pending by a container-service replica that went away part way
+// through them, straight away and then every hour.  An upgrade is only ever pending while its container is locked for
+// it, so any others were interrupted (once the lock has expired, if the replica died holding it).
+func StartPendingUpgradeSweeper() {
+	go func() {
+		for {
+			failStaleUpgrades()
+			time.Sleep(time.Hour)
+		}
+	}()
+}
+
+func failStaleUpgrades() {
+	pending, err := UpgradeRepository{}.FindPending()
+	if err != nil {
+		logrus.Errorf("Could not fetch pending upgrades: %s", err)
+		return
+	}
+
+	for _, upgrade := range pending {
+		operation, err := containers.LockedFor(upgrade.ContainerId)
+		if err != nil {
+			logrus.Errorf("Could not check whether container %d is being upgraded: %s", upgrade.ContainerId, err)
+			continue
+		}
+		if operation == lockOperation {
+			continue
+		}
+
+		logrus.Warnf("Failing upgrade %d of container %d, which was interrupted", upgrade.Id, upgrade.ContainerId)
+		err = UpgradeRepository{}.Finish(&upgrade, StatusFailed, "interrupted before it was complete")
+		if err != nil {
+			logrus.Errorf("Could not fail interrupted upgrade %d: %s", upgrade.Id, err)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package upgrades
+
+import (
+	"bitbucket.org/smaug-hosting/services/cache"
+	"bitbucket.org/smaug-hosting/services/container-service/containers"
+	"bitbucket.org/smaug-hosting/services/container-service/orchestrator"
+	"bitbucket.org/smaug-hosting/services/container-service/software"
+	"bitbucket.org/smaug-hosting/services/container-service/tiers"
+	"bitbucket.org/smaug-hosting/services/database"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+	"github.com/jmoiron/sqlx"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	software.Setup("../software/conf/catalog.json")
+	tiers.Setup("../tiers/conf/tiers.json")
+	os.Exit(m.Run())
+}
+
+func setupUpgradeTest(t *testing.T) (sqlmock.Sqlmock, func()) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Could not mock database: %s", err)
+	}
+	database.Connection = sqlx.NewDb(db, "mysql")
+
+	redisServer, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Could not start fake redis: %s", err)
+	}
+	cache.Client = redis.NewClient(&redis.Options{Addr: redisServer.Addr()})
+
+	containers.Runtime, err = orchestrator.GetOrchestratorInstance(orchestrator.BackendMemory, nil)
+	if err != nil {
+		t.Fatalf("Could not set up orchestrator: %s", err)
+	}
+
+	return mock, func() {
+		_ = cache.Client.Close()
+		redisServer.Close()
+		_ = db.Close()
+	}
+}
+
+func testContainer() containers.Container {
+	return containers.Container{
+		Id:       42,
+		Name:     "survival",
+		Tier:     1,
+		Software: "minecraft",
+		UserId:   7,
+		State:    containers.StateStopped,
+		Release:  containers.Release{Image: "itzg/minecraft-server:20190824", Version: "1.14.4", Flavour: "vanilla"},
+	}
+}
+
+func TestStartUpgradeWhileLocked(t *testing.T) {
+	mock, teardown := setupUpgradeTest(t)
+	defer teardown()
+
+	c := testContainer()
+
+	unlock, err := containers.LockContainer(c.Id, "restoring a backup")
+	if err != nil {
+		t.Fatalf("Could not lock container: %s", err)
+	}
+
+	// nothing is recorded for an upgrade that can't go ahead
+	_, err = StartUpgrade(c, "1.14.4", "paper")
+	if !containers.IsContainerLocked(err) {
+		t.Errorf("Upgrading a container being restored into gave %v, want it locked", err)
+	}
+	unlock()
+
+	unlock, err = containers.LockContainer(c.Id, lockOperation)
+	if err != nil {
+		t.Fatalf("Could not lock container: %s", err)
+	}
+	defer unlock()
+
+	_, err = StartUpgrade(c, "1.14.4", "paper")
+	if err != ErrUpgradeInProgress {
+		t.Errorf("Upgrading a container that is already being upgraded gave %v, want %v", err, ErrUpgradeInProgress)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFailStaleUpgrades(t *testing.T) {
+	mock, teardown := setupUpgradeTest(t)
+	defer teardown()
+
+	// container 42 is still being upgraded, whoever was upgrading 43 went away part way through
+	unlock, err := containers.LockContainer(42, lockOperation)
+	if err != nil {
+		t.Fatalf("Could not lock container: %s", err)
+	}
+	defer unlock()
+
+	rows := sqlmock.NewRows(upgradeColumns)
+	for _, containerId := range []int64{42, 43} {
+		rows.AddRow(containerId+100, containerId, "itzg/minecraft-server:20190824", "1.14.4", "vanilla",
+			"itzg/minecraft-server:20190824", "1.14.4", "paper", nil, string(StatusPending), "", time.Now(), nil)
+	}
+	mock.ExpectQuery("FROM upgrades WHERE status = ?").
+		WithArgs(StatusPending).
+		WillReturnRows(rows)
+	mock.ExpectExec("UPDATE upgrades SET").
+		WithArgs("interrupted before it was complete", sqlmock.AnyArg(), StatusFailed, 143).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	failStaleUpgrades()
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,12 @@
+package helpers
+
+import "github.com/go-sql-driver/mysql"
+
+// mysql's error number for an insert that would break a unique index
+const errDuplicateEntry = 1062
+
+// IsDuplicateKey reports whether err came from inserting a row that clashes with a unique key.
+func IsDuplicateKey(err error) bool {
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	return ok && mysqlErr.Number == errDuplicateEntry
+}
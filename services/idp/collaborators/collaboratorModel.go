@@ -0,0 +1,115 @@
+package collaborators
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type Permission string
+
+const (
+	PermView      Permission = "view"
+	PermStartStop Permission = "start_stop"
+	PermConsole   Permission = "console"
+	PermFiles     Permission = "files"
+	// admin covers every other permission, along with managing the container's settings and collaborators
+	PermAdmin Permission = "admin"
+	// only ever held by the container's owner, never granted to a collaborator
+	PermOwner Permission = "owner"
+)
+
+var grantable = []Permission{PermView, PermStartStop, PermConsole, PermFiles, PermAdmin}
+
+type Permissions []Permission
+
+// ParsePermissions checks every permission can be granted to a collaborator.
+func ParsePermissions(names []string) (Permissions, error) {
+	if len(names) == 0 {
+		return nil, fmt.Errorf("please grant at least one of %v", grantable)
+	}
+
+	permissions := make(Permissions, 0, len(names))
+	for _, name := range names {
+		permission := Permission(name)
+		if !isGrantable(permission) {
+			return nil, fmt.Errorf("unknown permission %s, must be one of %v", name, grantable)
+		}
+		if !permissions.has(permission) {
+			permissions = append(permissions, permission)
+		}
+	}
+	return permissions, nil
+}
+
+func isGrantable(permission Permission) bool {
+	for _, p := range grantable {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+func (p Permissions) has(permission Permission) bool {
+	for _, held := range p {
+		if held == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows reports whether the permissions cover the given one.  Owner includes every other permission, admin all of
+// them except owner, and every permission includes view.
+func (p Permissions) Allows(permission Permission) bool {
+	switch {
+	case p.has(PermOwner):
+		return true
+	case permission == PermOwner:
+		return false
+	case p.has(PermAdmin):
+		return true
+	case permission == PermView:
+		return len(p) > 0
+	default:
+		return p.has(permission)
+	}
+}
+
+func (p *Permissions) Scan(src interface{}) error {
+	*p = make(Permissions, 0)
+
+	b, ok := src.([]uint8)
+	if !ok || len(b) == 0 {
+		return nil
+	}
+
+	for _, permission := range strings.Split(string(b), ",") {
+		*p = append(*p, Permission(permission))
+	}
+	return nil
+}
+
+func (p Permissions) Value() (driver.Value, error) {
+	names := make([]string, 0, len(p))
+	for _, permission := range p {
+		names = append(names, string(permission))
+	}
+	return strings.Join(names, ","), nil
+}
+
+// Collaborator is someone invited to help run a container that belongs to someone else.  Until they accept the
+// invite they are only known by the email address it was sent to.
+type Collaborator struct {
+	Id          int64       `json:"id"`
+	ContainerId int64       `json:"container_id" db:"container_id"`
+	Email       string      `json:"email"`
+	UserId      *int64      `json:"-" db:"user_id"`
+	Permissions Permissions `json:"permissions"`
+	InviteToken string      `json:"-" db:"invite_token"`
+	InvitedBy   int64       `json:"-" db:"invited_by"`
+	CreatedAt   time.Time   `json:"created_at" db:"created_at"`
+	AcceptedAt  *time.Time  `json:"accepted_at" db:"accepted_at"`
+}
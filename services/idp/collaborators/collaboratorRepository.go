@@ -0,0 +1,169 @@
+package collaborators
+
+import (
+	"bitbucket.org/smaug-hosting/services/database"
+	"bitbucket.org/smaug-hosting/services/database/helpers"
+	"errors"
+	"github.com/Masterminds/squirrel"
+	"time"
+)
+
+type CollaboratorRepository struct{}
+
+const tableName = "collaborators"
+
+var collaboratorColumns = []string{"id", "container_id", "email", "user_id", "permissions", "invite_token", "invited_by", "created_at", "accepted_at"}
+
+var ErrAlreadyInvited = errors.New("that email address has already been invited to the container")
+
+func (cr CollaboratorRepository) findOne(where squirrel.Sqlizer) (*Collaborator, error) {
+	sql, params, err := squirrel.Select(collaboratorColumns...).From(tableName).Where(where).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	c := new(Collaborator)
+
+	err = database.Connection.QueryRowx(sql, params...).StructScan(c)
+
+	return c, err
+}
+
+func (cr CollaboratorRepository) FindById(id int64) (*Collaborator, error) {
+	return cr.findOne(squirrel.Eq{"id": id})
+}
+
+// FindByInviteToken finds the collaborator an invite is still outstanding for.
+func (cr CollaboratorRepository) FindByInviteToken(token string) (*Collaborator, error) {
+	return cr.findOne(squirrel.And{
+		squirrel.Eq{"invite_token": token},
+		squirrel.Eq{"user_id": nil},
+	})
+}
+
+// FindForContainerAndUser finds the user's accepted invite to the container, if they have one.
+func (cr CollaboratorRepository) FindForContainerAndUser(containerId int64, userId int64) (*Collaborator, error) {
+	return cr.findOne(squirrel.Eq{"container_id": containerId, "user_id": userId})
+}
+
+// Save records an invite to the container.  Each email address can only be invited to a container once.
+func (cr CollaboratorRepository) Save(collaborator Collaborator) (Collaborator, error) {
+	var result Collaborator // only used if we fail
+
+	collaborator.CreatedAt = time.Now()
+
+	sql, params, err := squirrel.
+		Insert(tableName).
+		SetMap(map[string]interface{}{
+			"container_id": collaborator.ContainerId,
+			"email":        collaborator.Email,
+			"permissions":  collaborator.Permissions,
+			"invite_token": collaborator.InviteToken,
+			"invited_by":   collaborator.InvitedBy,
+			"created_at":   collaborator.CreatedAt,
+		}).
+		ToSql()
+
+	if err != nil {
+		return result, err
+	}
+
+	res, err := database.Connection.Exec(sql, params...)
+	if helpers.IsDuplicateKey(err) {
+		return result, ErrAlreadyInvited
+	} else if err != nil {
+		return result, err
+	}
+
+	collaborator.Id, err = res.LastInsertId()
+
+	return collaborator, err
+}
+
+func (cr CollaboratorRepository) update(collaborator *Collaborator, values map[string]interface{}) error {
+	sql, params, err := squirrel.Update(tableName).SetMap(values).Where("id = ?", collaborator.Id).ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = database.Connection.Exec(sql, params...)
+
+	return err
+}
+
+// Accept ties the invite to the user who accepted it, after which the invite token can't be used again.
+func (cr CollaboratorRepository) Accept(collaborator *Collaborator, userId int64) error {
+	now := time.Now()
+
+	err := cr.update(collaborator, map[string]interface{}{
+		"user_id":      userId,
+		"invite_token": "",
+		"accepted_at":  now,
+	})
+	if err != nil {
+		return err
+	}
+
+	collaborator.UserId = &userId
+	collaborator.InviteToken = ""
+	collaborator.AcceptedAt = &now
+
+	return nil
+}
+
+func (cr CollaboratorRepository) SetPermissions(collaborator *Collaborator, permissions Permissions) error {
+	err := cr.update(collaborator, map[string]interface{}{"permissions": permissions})
+	if err != nil {
+		return err
+	}
+
+	collaborator.Permissions = permissions
+
+	return nil
+}
+
+func (cr CollaboratorRepository) Delete(collaborator Collaborator) error {
+	sql, params, err := squirrel.Delete(tableName).Where("id = ?", collaborator.Id).ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = database.Connection.Exec(sql, params...)
+
+	return err
+}
+
+// DeleteForContainer removes everyone's access to the container, e.g. when it is deleted.
+func (cr CollaboratorRepository) DeleteForContainer(containerId int64) error {
+	sql, params, err := squirrel.Delete(tableName).Where("container_id = ?", containerId).ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = database.Connection.Exec(sql, params...)
+
+	return err
+}
+
+func (cr CollaboratorRepository) selectMany(where squirrel.Sqlizer) ([]Collaborator, error) {
+	sql, params, err := squirrel.Select(collaboratorColumns...).From(tableName).Where(where).OrderBy("created_at").ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	collaborators := make([]Collaborator, 0)
+
+	err = database.Connection.Select(&collaborators, sql, params...)
+
+	return collaborators, err
+}
+
+// GetCollaboratorsForContainer lists everyone invited to the container, whether or not they have accepted.
+func (cr CollaboratorRepository) GetCollaboratorsForContainer(containerId int64) ([]Collaborator, error) {
+	return cr.selectMany(squirrel.Eq{"container_id": containerId})
+}
+
+// GetAcceptedForUser lists the containers the user collaborates on.
+func (cr CollaboratorRepository) GetAcceptedForUser(userId int64) ([]Collaborator, error) {
+	return cr.selectMany(squirrel.Eq{"user_id": userId})
+}
@@ -0,0 +1,70 @@
+package collaborators
+
+import (
+	"bitbucket.org/smaug-hosting/services/idp/tokens"
+	"bitbucket.org/smaug-hosting/services/idp/users"
+	"bitbucket.org/smaug-hosting/services/libhttp"
+	µ "bitbucket.org/smaug-hosting/services/micro"
+	"database/sql"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func getInviteExpiry() time.Duration {
+	expiry, err := time.ParseDuration(µ.GetEnvDefault("INVITE_EXPIRY", "168h"))
+	if err != nil {
+		logrus.Errorf("Could not parse INVITE_EXPIRY, invites expire after a week: %s", err)
+		expiry = 7 * 24 * time.Hour
+	}
+	return expiry
+}
+
+// HandleAcceptInvite makes the logged in user a collaborator on the container they were invited to.  Invites can
+// only be accepted by the (verified) owner of the email address they were sent to.
+func HandleAcceptInvite(response http.ResponseWriter, request *http.Request) {
+	claims := request.Context().Value("token_claims").(tokens.TokenClaims)
+
+	collaborator, err := CollaboratorRepository{}.FindByInviteToken(request.Context().Value("token").(string))
+	if err == sql.ErrNoRows {
+		libhttp.SendError(http.StatusNotFound, "Invite not found, it may already have been accepted", response)
+		return
+	} else if err != nil {
+		logrus.Errorf("Could not fetch invite: %s", err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not fetch invite", response)
+		return
+	}
+
+	if time.Since(collaborator.CreatedAt) > getInviteExpiry() {
+		libhttp.SendError(http.StatusGone, "This invite has expired, please ask for a new one", response)
+		return
+	}
+
+	user, err := users.UserRepository{}.Find(claims.UserId)
+	if err != nil || user == nil {
+		logrus.Errorf("Could not fetch user %d to accept invite: %s", claims.UserId, err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not fetch user", response)
+		return
+	}
+
+	if !user.Verified {
+		libhttp.SendError(http.StatusForbidden, "Please verify your email address before accepting invites", response)
+		return
+	}
+
+	if !strings.EqualFold(user.Email, collaborator.Email) {
+		logrus.Warnf("User %d tried to accept invite %d sent to someone else", claims.UserId, collaborator.Id)
+		libhttp.SendError(http.StatusForbidden, "This invite was sent to a different email address", response)
+		return
+	}
+
+	err = CollaboratorRepository{}.Accept(collaborator, claims.UserId)
+	if err != nil {
+		logrus.Errorf("Could not accept invite %d: %s", collaborator.Id, err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not accept invite", response)
+		return
+	}
+
+	libhttp.SendJson(collaborator, response)
+}
@@ -10,6 +10,7 @@ import (
 	"html/template"
 	"os"
 	"strings"
+	"time"
 )
 
 // todo: i18n
@@ -82,3 +83,199 @@ func SendVerificationEmail(address string, verificationCode string) error {
 	}
 	return err
 }
+
+const idlePlainTextTempl = `
+	Hi,
+
+	Nobody had been playing on your whelp "{{.ContainerName}}" for {{.IdleFor}}, so we stopped it to save you money.
+	You can start it again whenever you like from your dashboard:
+	{{.DashboardUrl}}
+
+	If you would rather it kept running, you can turn off auto-stop in the whelp's settings.
+
+	Yours Sincerely,
+
+	Smaug Hosting
+`
+
+const idleHtmlEmailTempl = `
+<html>
+<body>
+	<p>
+		Hi,
+	</p>
+	<p>
+		Nobody had been playing on your whelp "{{.ContainerName}}" for {{.IdleFor}}, so we stopped it to save you money.
+		You can start it again whenever you like from your <a href="{{.DashboardUrl}}">dashboard</a>.
+	</p>
+	<p>
+		If you would rather it kept running, you can turn off auto-stop in the whelp's settings.
+	</p>
+	<p>
+		Yours Sincerely,
+	</p>
+	<p>
+		Smaug Hosting
+	</p>
+</body>
+</html>
+`
+
+func SendIdleStopEmail(address string, containerName string, idleFor time.Duration) error {
+	frontendBaseUrl := strings.TrimRight(os.Getenv("FRONTEND_BASE_URL"), "/")
+
+	templateVars := struct {
+		ContainerName string
+		IdleFor       string
+		DashboardUrl  string
+	}{
+		ContainerName: containerName,
+		IdleFor:       idleFor.Round(time.Minute).String(),
+		DashboardUrl:  fmt.Sprintf("%s/", frontendBaseUrl),
+	}
+
+	subject := fmt.Sprintf("Your whelp %s was stopped", containerName)
+
+	return sendTemplatedEmail(address, subject, idlePlainTextTempl, idleHtmlEmailTempl, templateVars)
+}
+
+const invitePlainTextTempl = `
+	Hi,
+
+	{{.InvitedBy}} has invited you to help run their whelp "{{.ContainerName}}" on Smaug Hosting.  To accept, copy the
+	following URL into your browser and log in (or sign up) with this email address:
+	{{.InviteUrl}}
+
+	Yours Sincerely,
+
+	Smaug Hosting
+`
+
+const inviteHtmlEmailTempl = `
+<html>
+<body>
+	<p>
+		Hi,
+	</p>
+	<p>
+		{{.InvitedBy}} has invited you to help run their whelp "{{.ContainerName}}" on Smaug Hosting.  To accept, follow
+		the link below and log in (or sign up) with this email address:<br/>
+		<a href="{{.InviteUrl}}">Accept Invite</a><br/>
+	</p>
+	<p>
+		Yours Sincerely,
+	</p>
+	<p>
+		Smaug Hosting
+	</p>
+</body>
+</html>
+`
+
+func SendInviteEmail(address string, invitedBy string, containerName string, inviteToken string) error {
+	frontendBaseUrl := strings.TrimRight(os.Getenv("FRONTEND_BASE_URL"), "/")
+
+	templateVars := struct {
+		InvitedBy     string
+		ContainerName string
+		InviteUrl     string
+	}{
+		InvitedBy:     invitedBy,
+		ContainerName: containerName,
+		InviteUrl:     fmt.Sprintf("%s/invite?token=%s", frontendBaseUrl, inviteToken),
+	}
+
+	subject := fmt.Sprintf("You have been invited to help run %s", containerName)
+
+	return sendTemplatedEmail(address, subject, invitePlainTextTempl, inviteHtmlEmailTempl, templateVars)
+}
+
+const transferPlainTextTempl = `
+	Hi,
+
+	{{.TransferredBy}} would like to hand their whelp "{{.ContainerName}}" over to you on Smaug Hosting.  Once you
+	accept, the whelp is yours, and so is its bill.  To accept, copy the following URL into your browser and log in (or
+	sign up) with this email address:
+	{{.TransferUrl}}
+
+	Yours Sincerely,
+
+	Smaug Hosting
+`
+
+const transferHtmlEmailTempl = `
+<html>
+<body>
+	<p>
+		Hi,
+	</p>
+	<p>
+		{{.TransferredBy}} would like to hand their whelp "{{.ContainerName}}" over to you on Smaug Hosting.  Once you
+		accept, the whelp is yours, and so is its bill.  To accept, follow the link below and log in (or sign up) with
+		this email address:<br/>
+		<a href="{{.TransferUrl}}">Accept Whelp</a><br/>
+	</p>
+	<p>
+		Yours Sincerely,
+	</p>
+	<p>
+		Smaug Hosting
+	</p>
+</body>
+</html>
+`
+
+func SendTransferEmail(address string, transferredBy string, containerName string, transferToken string) error {
+	frontendBaseUrl := strings.TrimRight(os.Getenv("FRONTEND_BASE_URL"), "/")
+
+	templateVars := struct {
+		TransferredBy string
+		ContainerName string
+		TransferUrl   string
+	}{
+		TransferredBy: transferredBy,
+		ContainerName: containerName,
+		TransferUrl:   fmt.Sprintf("%s/transfer?token=%s", frontendBaseUrl, transferToken),
+	}
+
+	subject := fmt.Sprintf("%s would like to give you their whelp %s", transferredBy, containerName)
+
+	return sendTemplatedEmail(address, subject, transferPlainTextTempl, transferHtmlEmailTempl, templateVars)
+}
+
+// sendTemplatedEmail renders both templates with the vars and sends the result to the address.
+func sendTemplatedEmail(address string, subject string, plainTextTempl string, htmlTempl string, templateVars interface{}) error {
+	from := mail.NewEmail("Smaug Hosting", "no-reply@smaug-hosting.co.uk")
+	to := mail.NewEmail("Smaug Hosting User", address)
+
+	plainText, err := template.New("plainTextTemplate").Parse(plainTextTempl)
+	if err != nil {
+		return err
+	}
+	html, err := template.New("htmlTemplate").Parse(htmlTempl)
+	if err != nil {
+		return err
+	}
+
+	var plainTextContent bytes.Buffer
+	var htmlContent bytes.Buffer
+
+	err = plainText.Execute(&plainTextContent, templateVars)
+	if err != nil {
+		return err
+	}
+
+	err = html.Execute(&htmlContent, templateVars)
+	if err != nil {
+		return err
+	}
+
+	message := mail.NewSingleEmail(from, subject, to, plainTextContent.String(), htmlContent.String())
+	client := sendgrid.NewSendClient(os.Getenv("SENDGRID_API_KEY"))
+	response, err := client.Send(message)
+	if err == nil && (response.StatusCode < 200 || response.StatusCode > 299) {
+		logrus.Errorf("Got response %d from sendgrid: %s", response.StatusCode, response.Body)
+		return errors.New("sendgrid response not 200")
+	}
+	return err
+}
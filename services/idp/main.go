@@ -3,6 +3,7 @@ package main
 import (
 	"bitbucket.org/smaug-hosting/services/database"
 	"bitbucket.org/smaug-hosting/services/idp/bge_crypto"
+	"bitbucket.org/smaug-hosting/services/idp/collaborators"
 	"bitbucket.org/smaug-hosting/services/idp/tokens"
 	"bitbucket.org/smaug-hosting/services/idp/users"
 	"bitbucket.org/smaug-hosting/services/idp/verify"
@@ -33,6 +34,14 @@ func main() {
 		Description: "Verifies a user's email address using the given token",
 	})
 
+	libhttp.RegisterEndpoint(libhttp.Endpoint{
+		Handler:     collaborators.HandleAcceptInvite,
+		Pattern:     "/invites/{token}/accept/",
+		Middleware:  []libhttp.Middleware{middleware.Cors{}, middleware.RequireAuth{}},
+		Method:      "POST",
+		Description: "Accept an invite to collaborate on someone else's container",
+	})
+
 	libhttp.RegisterEndpoint(libhttp.Endpoint{
 		Handler:     tokens.TokenHandler,
 		Pattern:     "/token/",
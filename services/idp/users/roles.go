@@ -1,7 +1,10 @@
 package users
 
 import (
+	µ "bitbucket.org/smaug-hosting/services/micro"
 	"database/sql/driver"
+	"github.com/sirupsen/logrus"
+	"strconv"
 	"strings"
 )
 
@@ -53,3 +56,81 @@ func MakeRole(s string) Role {
 		return RoleUser
 	}
 }
+
+func (user User) HasRole(role Role) bool {
+	for _, r := range user.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Unlimited is the value of a quota limit that doesn't limit anything.
+const Unlimited = -1
+
+// Quota limits how much of the platform a user can take up.
+type Quota struct {
+	MaxContainers int `json:"max_containers" db:"max_containers"`
+	MaxRunning    int `json:"max_running" db:"max_running"`
+	MaxTier       int `json:"max_tier" db:"max_tier"`
+}
+
+// roleQuota is the quota the role gets unless an admin has given the user one of their own.  Admins are never
+// limited, and nor is anyone else until QUOTA_<ROLE>_MAX_CONTAINERS, QUOTA_<ROLE>_MAX_RUNNING and
+// QUOTA_<ROLE>_MAX_TIER say otherwise (e.g. QUOTA_USER_MAX_TIER=1).
+func roleQuota(role Role) Quota {
+	if role == RoleAdmin {
+		return Quota{MaxContainers: Unlimited, MaxRunning: Unlimited, MaxTier: Unlimited}
+	}
+
+	prefix := "QUOTA_" + strings.ToUpper(role.String()) + "_"
+	return Quota{
+		MaxContainers: getQuotaLimit(prefix + "MAX_CONTAINERS"),
+		MaxRunning:    getQuotaLimit(prefix + "MAX_RUNNING"),
+		MaxTier:       getQuotaLimit(prefix + "MAX_TIER"),
+	}
+}
+
+// getQuotaLimit reads a quota limit from the env, a missing or broken one not limiting anything.
+func getQuotaLimit(name string) int {
+	limit, err := strconv.Atoi(µ.GetEnvDefault(name, strconv.Itoa(Unlimited)))
+	if err != nil {
+		logrus.Errorf("Could not parse %s, not limiting by it: %s", name, err)
+		return Unlimited
+	}
+	if limit < Unlimited {
+		logrus.Errorf("%s must be at least 0, or %d for no limit, not limiting by it", name, Unlimited)
+		return Unlimited
+	}
+	return limit
+}
+
+// mostGenerous returns whichever of the two limits allows more.
+func mostGenerous(a int, b int) int {
+	if a == Unlimited || b == Unlimited {
+		return Unlimited
+	}
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// DefaultQuota is the most generous quota out of those of the user's roles, everyone getting at least the plain
+// user's.
+func (user User) DefaultQuota() Quota {
+	quota := roleQuota(RoleUser)
+	for _, role := range user.Roles {
+		q := roleQuota(role)
+		quota.MaxContainers = mostGenerous(quota.MaxContainers, q.MaxContainers)
+		quota.MaxRunning = mostGenerous(quota.MaxRunning, q.MaxRunning)
+		quota.MaxTier = mostGenerous(quota.MaxTier, q.MaxTier)
+	}
+	return quota
+}
+
+// WithinLimit reports whether a quota limit lets the user have n of something.
+func WithinLimit(limit int, n int) bool {
+	return limit == Unlimited || n <= limit
+}
@@ -19,6 +19,6 @@ func (c Cors) Run(w http.ResponseWriter, r * http.Request) bool {
 	logrus.Tracef("Adding CORS headers")
 	w.Header().Add("Access-Control-Allow-Origin", "*")
 	w.Header().Add("Access-Control-Allow-Headers", "Content-Type, Authorization")
-	w.Header().Add("Access-Control-Allow-Methods", "GET, POST, DELETE, PATCH, OPTIONS")
+	w.Header().Add("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, PATCH, OPTIONS")
 	return false
 }
\ No newline at end of file
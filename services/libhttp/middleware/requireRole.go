@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"bitbucket.org/smaug-hosting/services/idp/tokens"
+	"bitbucket.org/smaug-hosting/services/idp/users"
+	"bitbucket.org/smaug-hosting/services/libhttp"
+	"github.com/sirupsen/logrus"
+	"net/http"
+)
+
+// RequireRole only lets through users who have the role.  It relies on the token claims RequireAuth leaves on the
+// request, so it must come after RequireAuth.
+type RequireRole struct {
+	Role users.Role
+}
+
+func (rr RequireRole) Run(response http.ResponseWriter, request *http.Request) bool {
+	claims, ok := request.Context().Value("token_claims").(tokens.TokenClaims)
+	if !ok {
+		failAuth(response)
+		return true
+	}
+
+	user, err := users.UserRepository{}.Find(claims.UserId)
+	if err != nil {
+		logrus.Errorf("Could not fetch user %d to check their roles: %s", claims.UserId, err)
+		libhttp.SendError(http.StatusInternalServerError, "Could not check your permissions", response)
+		return true
+	}
+
+	if user == nil || !user.HasRole(rr.Role) {
+		logrus.Warnf("User %d tried to make a request that needs the %s role", claims.UserId, rr.Role)
+		libhttp.SendError(http.StatusForbidden, "You are not permitted to perform this request", response)
+		return true
+	}
+
+	return false
+}
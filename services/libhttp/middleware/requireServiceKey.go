@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"os"
+)
+
+const ServiceKeyHeader = "X-Service-Key"
+
+// RequireServiceKey only lets through requests from our other services, which send the SERVICE_KEY they share with
+// us in the X-Service-Key header.  With no SERVICE_KEY configured nobody gets through.
+type RequireServiceKey struct{}
+
+func (rsk RequireServiceKey) Run(response http.ResponseWriter, request *http.Request) bool {
+	key := os.Getenv("SERVICE_KEY")
+	if key == "" {
+		logrus.Errorf("Refusing service request to %s as no SERVICE_KEY is configured", request.URL.Path)
+		failAuth(response)
+		return true
+	}
+
+	given := request.Header.Get(ServiceKeyHeader)
+	if subtle.ConstantTimeCompare([]byte(given), []byte(key)) != 1 {
+		failAuth(response)
+		return true
+	}
+
+	return false
+}
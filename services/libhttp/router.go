@@ -18,31 +18,33 @@ type Endpoint struct {
 	Middleware  []Middleware `json:"-"`
 	Method      string
 	Description string
+
+	// compiled from Pattern when the endpoint is registered, nil if it wouldn't compile
+	matcher *regexp.Regexp
 }
 
 var endpoints []Endpoint
 
+// each {param} matches exactly one path segment
+var paramPattern = regexp.MustCompile("\\{[^/{}]*\\}")
+
 func RegisterEndpoint(endpoint Endpoint) {
 	if endpoints == nil {
 		endpoints = make([]Endpoint, 0)
 	}
 
+	// anchored, so that a pattern only ever matches whole paths and can't swallow longer ones registered after it
+	matcher, err := regexp.Compile("^" + paramPattern.ReplaceAllString(endpoint.Pattern, "[^/]+") + "$")
+	if err != nil {
+		logrus.Errorf("Could not compile pattern %s, the endpoint will never match: %s", endpoint.Pattern, err)
+	}
+	endpoint.matcher = matcher
+
 	endpoints = append(endpoints, endpoint)
 }
 
-func match(pattern string, path string) bool {
-	re, err := regexp.Compile("/\\{.*\\}/")
-	if err != nil {
-		logrus.Errorf("Could not compile regexp: %s", err)
-		return false
-	}
-	pattern = string(re.ReplaceAll([]byte(pattern), []byte(".*")))
-	matched, err := regexp.Match(pattern, []byte(path))
-	if err != nil {
-		logrus.Errorf("Could not run regexp: %s", err)
-		return false
-	}
-	return matched
+func (ep Endpoint) matches(path string) bool {
+	return ep.matcher != nil && ep.matcher.MatchString(path)
 }
 
 func runAllMiddleware(mwArr []Middleware, w http.ResponseWriter, r *http.Request) bool {
@@ -70,7 +72,7 @@ func ServeMux() http.Handler {
 		logrus.Infof("Received request for %s %s", request.Method, request.URL.Path)
 		for _, ep := range endpoints {
 			if ep.Method == request.Method || ep.Method == "ALL" || ep.Method == "*" || ep.Method == "" {
-				if match(ep.Pattern, request.URL.Path) {
+				if ep.matches(request.URL.Path) {
 					updateRequestContextWithPathParams(ep.Pattern, request)
 					logrus.Infof("Matching to handler with description \"%s\"", ep.Description)
 					if !runAllMiddleware(ep.Middleware, response, request) {
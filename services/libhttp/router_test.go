@@ -0,0 +1,77 @@
+package libhttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// handlerNamed records which endpoint served the request in the response body.
+func handlerNamed(name string) http.HandlerFunc {
+	return func(response http.ResponseWriter, request *http.Request) {
+		_, _ = response.Write([]byte(name))
+	}
+}
+
+func serve(method string, path string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	ServeMux().ServeHTTP(recorder, httptest.NewRequest(method, path, nil))
+	return recorder
+}
+
+func TestRoutesMatchWholePaths(t *testing.T) {
+	endpoints = nil
+	defer func() { endpoints = nil }()
+
+	// a route with two parameters, registered ahead of a shorter one, used to swallow it
+	RegisterEndpoint(Endpoint{Handler: handlerNamed("backup"), Pattern: "/containers/{containerId}/backups/{backupId}/", Method: "DELETE"})
+	RegisterEndpoint(Endpoint{Handler: handlerNamed("container"), Pattern: "/containers/{containerId}/", Method: "DELETE"})
+
+	cases := []struct {
+		path string
+		want string
+	}{
+		{"/containers/5/", "container"},
+		{"/containers/5/backups/7/", "backup"},
+	}
+
+	for _, c := range cases {
+		recorder := serve("DELETE", c.path)
+		if recorder.Body.String() != c.want {
+			t.Errorf("DELETE %s was served by %q, want %q", c.path, recorder.Body.String(), c.want)
+		}
+	}
+}
+
+func TestRoutesDontMatchLongerPaths(t *testing.T) {
+	endpoints = nil
+	defer func() { endpoints = nil }()
+
+	RegisterEndpoint(Endpoint{Handler: handlerNamed("container"), Pattern: "/containers/{containerId}/", Method: "GET"})
+
+	for _, path := range []string{"/containers/5/logs/", "/api/containers/5/", "/containers/"} {
+		recorder := serve("GET", path)
+		if recorder.Code != http.StatusNotFound {
+			t.Errorf("GET %s got status %d, want %d", path, recorder.Code, http.StatusNotFound)
+		}
+	}
+}
+
+func TestRoutesFillInPathParams(t *testing.T) {
+	endpoints = nil
+	defer func() { endpoints = nil }()
+
+	var got string
+	RegisterEndpoint(Endpoint{
+		Handler: func(response http.ResponseWriter, request *http.Request) {
+			got = request.Context().Value("backupId").(string)
+		},
+		Pattern: "/containers/{containerId}/backups/{backupId}/",
+		Method:  "GET",
+	})
+
+	serve("GET", "/containers/5/backups/7/")
+	if got != "7" {
+		t.Errorf("backupId was %q, want %q", got, "7")
+	}
+}
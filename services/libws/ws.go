@@ -3,10 +3,12 @@ package libws
 import (
 	"bitbucket.org/smaug-hosting/services/libhttp"
 	"encoding/json"
+	"errors"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/sirupsen/logrus"
 	"net/http"
+	"sync"
 )
 
 var upgrader = websocket.Upgrader{
@@ -43,9 +45,41 @@ type WebSocketClient struct {
 }
 
 type WebSocket struct {
-	readChan   chan WSMessage
-	writeChan  chan WSMessage
-	AllClients map[string]*WebSocketClient `json:"-"`
+	readChan  chan WSMessage
+	writeChan chan WSMessage
+	clients   *clientSet
+}
+
+// clientSet is shared by every copy of the WebSocket, and is written to from the handler of each connection as it
+// comes and goes, so it has to be locked.
+type clientSet struct {
+	sync.RWMutex
+	bySession map[string]*WebSocketClient
+}
+
+// Clients is a snapshot of the clients connected when it is called, which is safe to range over while clients
+// connect and disconnect.
+func (ws WebSocket) Clients() []*WebSocketClient {
+	ws.clients.RLock()
+	defer ws.clients.RUnlock()
+
+	clients := make([]*WebSocketClient, 0, len(ws.clients.bySession))
+	for _, client := range ws.clients.bySession {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+func (ws WebSocket) addClient(client *WebSocketClient) {
+	ws.clients.Lock()
+	defer ws.clients.Unlock()
+	ws.clients.bySession[client.Session.Id] = client
+}
+
+func (ws WebSocket) removeClient(sessionId string) {
+	ws.clients.Lock()
+	defer ws.clients.Unlock()
+	delete(ws.clients.bySession, sessionId)
 }
 
 func (ws WebSocket) Listen() <-chan WSMessage {
@@ -56,46 +90,80 @@ func (ws WebSocket) Send() chan<- WSMessage {
 	return ws.writeChan
 }
 
+var ErrHandshakeFailed = errors.New("websocket handshake failed")
+
+// Upgrade upgrades the request to a websocket and waits for the client's handshake message, which carries the
+// client's token.  The caller owns the returned connection and must close it.
+func Upgrade(response http.ResponseWriter, request *http.Request) (*websocket.Conn, WebSocketSession, error) {
+	var session WebSocketSession
+
+	conn, err := upgrader.Upgrade(response, request, nil)
+	if err != nil {
+		return nil, session, err
+	}
+
+	messageType, rawMessage, err := conn.ReadMessage()
+	if err != nil {
+		_ = conn.Close()
+		return nil, session, err
+	}
+
+	if messageType != websocket.TextMessage {
+		_ = conn.Close()
+		return nil, session, ErrHandshakeFailed
+	}
+
+	msg := WSMessage{}
+	err = json.Unmarshal(rawMessage, &msg)
+	if err != nil {
+		_ = conn.Close()
+		return nil, session, err
+	}
+
+	if msg.Subject != WSMTHandshake {
+		_ = conn.Close()
+		return nil, session, ErrHandshakeFailed
+	}
+
+	token, ok := msg.Body["token"].(string)
+	if !ok {
+		_ = conn.Close()
+		return nil, session, ErrHandshakeFailed
+	}
+
+	session.Id = uuid.New().String()
+	session.Token = token
+
+	return conn, session, nil
+}
+
+// Write sends a single message straight down the given connection, bypassing the shared send channel.
+func Write(conn *websocket.Conn, msg WSMessage) error {
+	m, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return conn.WriteMessage(websocket.TextMessage, m)
+}
+
 func (ws WebSocket) handler(response http.ResponseWriter, request *http.Request) {
 	logrus.Tracef("Received websocket request")
-	conn, err := upgrader.Upgrade(response, request, nil)
+	conn, session, err := Upgrade(response, request)
 	if err != nil {
 		logrus.Errorf("Could not upgrade websocket: %s", err)
 	} else {
-		sessionId := uuid.New().String()
+		sessionId := session.Id
 		connected := true
 		conn.SetCloseHandler(func(code int, text string) error {
 			connected = false
-			delete(ws.AllClients, sessionId)
+			ws.removeClient(sessionId)
 			return nil
 		})
 
-		messageType, rawMessage, err := conn.ReadMessage()
-		if err != nil {
-			logrus.Errorf("Handshake message failed: %s", err)
-			return
-		}
-		if messageType == websocket.TextMessage {
-			msg := WSMessage{}
-			err := json.Unmarshal(rawMessage, &msg)
-			if err != nil {
-				logrus.Errorf("Could not unmarshal JSON message: %s", err)
-				return
-			}
-
-			if msg.Subject != WSMTHandshake {
-				logrus.Errorf("Did not receive handshake message first")
-				return
-			}
-
-			ws.AllClients[sessionId] = &WebSocketClient{
-				Connection: conn,
-				Session: WebSocketSession{
-					Id:    sessionId,
-					Token: msg.Body["token"].(string),
-				},
-			}
-		}
+		ws.addClient(&WebSocketClient{
+			Connection: conn,
+			Session:    session,
+		})
 
 		logrus.Tracef("Upgraded connection to websocket")
 		go func() {
@@ -105,6 +173,8 @@ func (ws WebSocket) handler(response http.ResponseWriter, request *http.Request)
 				messageType, rawMessage, err := conn.ReadMessage()
 				if err != nil {
 					logrus.Errorf("Could not read message from WS stream: %s", err)
+					// not every client says goodbye before it goes
+					ws.removeClient(sessionId)
 					break
 				}
 				if messageType == websocket.TextMessage {
@@ -124,8 +194,7 @@ func (ws WebSocket) handler(response http.ResponseWriter, request *http.Request)
 			logrus.Tracef("Websocket connected, spinning up sender...")
 			for msg := range ws.writeChan {
 				logrus.Tracef("Sending message: %+v", msg)
-				m, err := json.Marshal(msg)
-				err = msg.Connection.WriteMessage(websocket.TextMessage, m)
+				err := Write(msg.Connection, msg)
 				if err != nil {
 					logrus.Warnf("Could not send JSON message: %s", err)
 				}
@@ -137,9 +206,9 @@ func (ws WebSocket) handler(response http.ResponseWriter, request *http.Request)
 
 func SetupWebsocket(path string) WebSocket {
 	ws := WebSocket{
-		readChan:   make(chan WSMessage),
-		writeChan:  make(chan WSMessage),
-		AllClients: make(map[string]*WebSocketClient),
+		readChan:  make(chan WSMessage),
+		writeChan: make(chan WSMessage),
+		clients:   &clientSet{bySession: make(map[string]*WebSocketClient)},
 	}
 
 	libhttp.RegisterEndpoint(libhttp.Endpoint{
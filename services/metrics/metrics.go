@@ -0,0 +1,17 @@
+package metrics
+
+import (
+	"expvar"
+	"net/http"
+)
+
+var counters = expvar.NewMap("counters")
+
+func Increment(name string) {
+	counters.Add(name, 1)
+}
+
+// Handler serves every published expvar (our counters plus the runtime's memstats) as JSON.
+func Handler(response http.ResponseWriter, request *http.Request) {
+	expvar.Handler().ServeHTTP(response, request)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bitbucket.org/smaug-hosting/services/cache"
+	"bitbucket.org/smaug-hosting/services/database"
+	"bitbucket.org/smaug-hosting/services/libhttp"
+	"bitbucket.org/smaug-hosting/services/libhttp/middleware"
+	"bitbucket.org/smaug-hosting/services/logging"
+	"bitbucket.org/smaug-hosting/services/metrics"
+	µ "bitbucket.org/smaug-hosting/services/micro"
+	"bitbucket.org/smaug-hosting/services/proxy/proxy"
+	"github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+)
+
+func main() {
+	logging.Setup()
+	database.Setup()
+	// the ports containers are published on live in redis
+	cache.Setup()
+	// the proxy asks the container service to wake stopped containers when players try to join them
+	µ.MustGetEnv("SERVICE_KEY")
+
+	listener, err := net.Listen("tcp", µ.GetEnvDefault("GAME_LISTEN_ADDR", ":25565"))
+	if err != nil {
+		logrus.Fatalf("Could not listen for game connections: %s", err)
+	}
+
+	go func() {
+		logrus.Fatal(proxy.Serve(listener))
+	}()
+
+	libhttp.RegisterEndpoint(libhttp.Endpoint{
+		Handler:     metrics.Handler,
+		Pattern:     "/metrics/",
+		Middleware:  []libhttp.Middleware{middleware.RequireServiceKey{}},
+		Method:      "GET",
+		Description: "Get operational metrics (connections proxied etc.) for this service, for whatever scrapes them with the service key",
+	})
+
+	libhttp.RegisterEndpoint(libhttp.Endpoint{
+		Handler:     libhttp.NoopHandler,
+		Pattern:     ".*",
+		Middleware:  []libhttp.Middleware{middleware.Cors{}},
+		Method:      "OPTIONS",
+		Description: "Respond to OPTIONS request with CORS headers",
+	})
+
+	logrus.Fatal(http.ListenAndServe(µ.GetEnvDefault("LISTEN_ADDR", ":55000"), libhttp.ServeMux()))
+}
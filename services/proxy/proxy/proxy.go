@@ -0,0 +1,232 @@
+package proxy
+
+import (
+	"bitbucket.org/smaug-hosting/services/container-service/containers"
+	"bitbucket.org/smaug-hosting/services/container-service/ports"
+	"bitbucket.org/smaug-hosting/services/container-service/slp"
+	"bitbucket.org/smaug-hosting/services/metrics"
+	µ "bitbucket.org/smaug-hosting/services/micro"
+	"bitbucket.org/smaug-hosting/services/services"
+	"bufio"
+	"database/sql"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The game proxy lets players connect to <subdomain>.<PROXY_DOMAIN> on the default port, instead of whichever node
+// and port the container happens to be published on.  Minecraft clients put the host they were told to connect to
+// in their handshake, so that is all we need to work out where the connection should go.
+//
+// Connections are handed on as plain TCP, without the PROXY protocol (vanilla servers don't speak it), so game
+// servers see every player joining through the proxy as coming from the proxy's own address.  IP bans set on the
+// server therefore ban everyone using the proxy, and the player's real address only shows up in our logs.
+
+const (
+	// how long a client gets to say where it is connecting to (and, if it isn't going anywhere, to ask for a status)
+	handshakeTimeout = 10 * time.Second
+	dialTimeout      = 5 * time.Second
+
+	// shown in place of the game version in the server list, for servers we are answering for
+	versionName = "Smaug Hosting"
+)
+
+const (
+	messageUnknown     = "There is no server at this address"
+	messageAsleep      = "This server is asleep, its owner can start it from their dashboard"
+	messageAsleepWake  = "This server is asleep, join it to wake it up"
+	messageWaking      = "This server is starting up, try again in 30 seconds"
+	messageStarting    = "This server is starting up, try again in a minute"
+	messageUnavailable = "This server is unavailable right now, try again later"
+)
+
+// Serve hands on the connections made to the listener until it is closed.
+func Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go handle(conn)
+	}
+}
+
+func handle(conn net.Conn) {
+	defer conn.Close()
+
+	err := conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	if err != nil {
+		return
+	}
+
+	reader := bufio.NewReader(conn)
+
+	handshake, err := slp.ReadHandshake(reader)
+	if err != nil {
+		// port scanners, and anything else that doesn't speak the protocol
+		logrus.Debugf("Could not read handshake from %s: %s", conn.RemoteAddr(), err)
+		return
+	}
+
+	log := logrus.WithField("hostname", handshake.Hostname()).WithField("remote", conn.RemoteAddr().String())
+
+	c, err := findContainer(handshake.Hostname())
+	if err == sql.ErrNoRows {
+		metrics.Increment("proxy.unknown")
+		turnAway(conn, reader, handshake, messageUnknown)
+		return
+	} else if err != nil {
+		log.Errorf("Could not look up container: %s", err)
+		turnAway(conn, reader, handshake, messageUnavailable)
+		return
+	}
+
+	switch c.State {
+	case containers.StateRunning:
+	case containers.StateStopped, containers.StateStopping:
+		metrics.Increment("proxy.asleep")
+		if !c.WakeOnConnect {
+			turnAway(conn, reader, handshake, messageAsleep)
+		} else if handshake.NextState == slp.NextStateLogin {
+			wake(conn, reader, *c, log)
+		} else {
+			turnAway(conn, reader, handshake, messageAsleepWake)
+		}
+		return
+	case containers.StateProvisioning, containers.StateStarting:
+		turnAway(conn, reader, handshake, messageStarting)
+		return
+	default:
+		turnAway(conn, reader, handshake, messageUnavailable)
+		return
+	}
+
+	backend, err := dialContainer(*c)
+	if err != nil {
+		log.Errorf("Could not connect to container %d: %s", c.Id, err)
+		metrics.Increment("proxy.unreachable")
+		turnAway(conn, reader, handshake, messageUnavailable)
+		return
+	}
+	defer backend.Close()
+
+	// from here on the game server decides how long the client may keep quiet
+	err = conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		return
+	}
+
+	err = handshake.Forward(backend)
+	if err != nil {
+		log.Errorf("Could not forward handshake to container %d: %s", c.Id, err)
+		return
+	}
+
+	metrics.Increment("proxy.connections")
+	splice(conn, reader, backend)
+}
+
+func findContainer(hostname string) (*containers.Container, error) {
+	subdomain, ok := containers.SubdomainForHostname(hostname)
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return containers.ContainerRepository{}.FindBySubdomain(subdomain)
+}
+
+// dialContainer connects to the port the container was published on.  The routing mesh publishes every service's
+// ports on every node of the swarm, so PROXY_BACKEND_HOST can be any of them (usually the one the proxy runs on).
+func dialContainer(c containers.Container) (net.Conn, error) {
+	port, err := ports.Lookup(c.Id)
+	if err != nil {
+		return nil, err
+	}
+	if port == 0 {
+		return nil, fmt.Errorf("container %d has no published port", c.Id)
+	}
+
+	host := µ.GetEnvDefault("PROXY_BACKEND_HOST", "127.0.0.1")
+	return net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))), dialTimeout)
+}
+
+// splice copies between the client and the game server until either of them hangs up.  Anything the client sent
+// after its handshake (e.g. the start of its login) is still waiting in the reader, so that is what gets copied.
+func splice(conn net.Conn, reader *bufio.Reader, backend net.Conn) {
+	done := make(chan struct{}, 2)
+
+	go func() {
+		_, _ = io.Copy(backend, reader)
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(conn, backend)
+		done <- struct{}{}
+	}()
+
+	// the deferred closes take care of the other direction
+	<-done
+}
+
+// wake asks the container service to start the container for the player logging in, who is then disconnected and
+// told to come back once it has had time to start.  The owner's balance and quota still apply, so the container
+// service can refuse.
+func wake(conn net.Conn, reader *bufio.Reader, c containers.Container, log *logrus.Entry) {
+	player, err := slp.ReadLoginStart(reader)
+	if err != nil {
+		log.Debugf("Could not read login: %s", err)
+		return
+	}
+
+	log = log.WithField("player", player)
+
+	message := messageWaking
+	containerService, err := services.ContainerService()
+	if err == nil {
+		err = containerService.WakeContainer(c.Id, player, conn.RemoteAddr().String())
+	}
+	if refused, ok := err.(services.ContainerServiceError); ok && refused.Status == http.StatusConflict {
+		// another player got there first (or it is in the middle of something else), all this one can do is wait
+		log.Infof("Container %d is already starting: %s", c.Id, refused.Message)
+		message = messageStarting
+	} else if ok {
+		log.Infof("Container service would not wake container %d: %s", c.Id, refused.Message)
+		metrics.Increment("proxy.wake_refused")
+		message = messageAsleep
+	} else if err != nil {
+		log.Errorf("Could not wake container %d: %s", c.Id, err)
+		message = messageUnavailable
+	} else {
+		log.Infof("Woke container %d", c.Id)
+		metrics.Increment("proxy.woken")
+	}
+
+	err = slp.Disconnect(conn, message)
+	if err != nil {
+		log.Debugf("Could not disconnect: %s", err)
+	}
+}
+
+// turnAway answers for a server that can't take the connection: in the server list with the message as its MOTD,
+// or by disconnecting a player who is trying to join with the message as the reason.
+func turnAway(conn net.Conn, reader *bufio.Reader, handshake slp.Handshake, message string) {
+	var err error
+
+	switch handshake.NextState {
+	case slp.NextStateStatus:
+		err = slp.ServeStatus(conn, reader, slp.Status{
+			// echoing the client's own protocol stops it from flagging the server as the wrong version
+			Version: slp.Version{Name: versionName, Protocol: int(handshake.ProtocolVersion)},
+			Motd:    message,
+		})
+	case slp.NextStateLogin:
+		err = slp.Disconnect(conn, message)
+	}
+
+	if err != nil && err != io.EOF {
+		logrus.Debugf("Could not answer %s: %s", conn.RemoteAddr(), err)
+	}
+}
@@ -0,0 +1,378 @@
+package proxy
+
+import (
+	"bitbucket.org/smaug-hosting/services/cache"
+	"bitbucket.org/smaug-hosting/services/container-service/containers"
+	"bitbucket.org/smaug-hosting/services/database"
+	"bitbucket.org/smaug-hosting/services/libhttp"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+	"github.com/jmoiron/sqlx"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// The proxy is driven over net.Pipe, playing the part of a Minecraft client, against a fake redis holding the port
+// bindings and a mocked database holding the containers.
+
+func setupProxyTest(t *testing.T) (sqlmock.Sqlmock, func()) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Could not mock database: %s", err)
+	}
+	database.Connection = sqlx.NewDb(db, "mysql")
+
+	redisServer, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Could not start fake redis: %s", err)
+	}
+	cache.Client = redis.NewClient(&redis.Options{Addr: redisServer.Addr()})
+
+	return mock, func() {
+		_ = cache.Client.Close()
+		redisServer.Close()
+		_ = db.Close()
+	}
+}
+
+func expectContainer(mock sqlmock.Sqlmock, subdomain string, state containers.State, wakeOnConnect bool) {
+	mock.ExpectQuery("FROM containers WHERE subdomain = ?").
+		WithArgs(subdomain).
+		WillReturnRows(sqlmock.NewRows([]string{
+			"name", "tier", "software", "user_id", "id", "state", "last_error", "state_changed_at", "created_at",
+			"console_password", "config", "service_name", "auto_stop_idle", "wake_on_connect", "subdomain", "image",
+			"version", "flavour",
+		}).AddRow(
+			"survival", 1, "minecraft", 7, 42, string(state), "", time.Now(), time.Now(),
+			"hunter2", []byte("{}"), "whelp-minecraft-7-1-42", false, wakeOnConnect, subdomain, "", "", "",
+		))
+}
+
+// handleInBackground hands the proxy one end of a pipe, returning the other end along with a channel that is closed
+// once the proxy is done with the connection.
+func handleInBackground() (net.Conn, chan struct{}) {
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		handle(server)
+		close(done)
+	}()
+	return client, done
+}
+
+func writeVarInt(buf *bytes.Buffer, value int32) {
+	v := uint32(value)
+	for v >= 0x80 {
+		buf.WriteByte(byte(v) | 0x80)
+		v >>= 7
+	}
+	buf.WriteByte(byte(v))
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	writeVarInt(buf, int32(len(s)))
+	buf.WriteString(s)
+}
+
+func packet(id int32, payload []byte) []byte {
+	var body bytes.Buffer
+	writeVarInt(&body, id)
+	body.Write(payload)
+
+	var buf bytes.Buffer
+	writeVarInt(&buf, int32(body.Len()))
+	buf.Write(body.Bytes())
+	return buf.Bytes()
+}
+
+func handshake(hostname string, nextState int32) []byte {
+	var payload bytes.Buffer
+	writeVarInt(&payload, 754)
+	writeString(&payload, hostname)
+	_ = binary.Write(&payload, binary.BigEndian, uint16(25565))
+	writeVarInt(&payload, nextState)
+	return packet(0x00, payload.Bytes())
+}
+
+func loginStart(player string) []byte {
+	var payload bytes.Buffer
+	writeString(&payload, player)
+	return packet(0x00, payload.Bytes())
+}
+
+// readMessage reads the packet the proxy answered with and returns the text of the chat component in it, which is
+// the reason of a disconnect or the MOTD of a status response.
+func readMessage(t *testing.T, conn net.Conn) string {
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	reader := bufio.NewReader(conn)
+
+	length, err := binary.ReadUvarint(reader)
+	if err != nil {
+		t.Fatalf("Could not read packet length: %s", err)
+	}
+	body := make([]byte, length)
+	_, err = io.ReadFull(reader, body)
+	if err != nil {
+		t.Fatalf("Could not read packet: %s", err)
+	}
+
+	// both are packet 0x00, holding a single string
+	bodyReader := bytes.NewReader(body[1:])
+	size, err := binary.ReadUvarint(bodyReader)
+	if err != nil {
+		t.Fatalf("Could not read string length: %s", err)
+	}
+	text := make([]byte, size)
+	_, _ = io.ReadFull(bodyReader, text)
+
+	message := struct {
+		Text        string `json:"text"`
+		Description struct {
+			Text string `json:"text"`
+		} `json:"description"`
+	}{}
+	err = json.Unmarshal(text, &message)
+	if err != nil {
+		t.Fatalf("Could not parse %q: %s", text, err)
+	}
+	if message.Description.Text != "" {
+		return message.Description.Text
+	}
+	return message.Text
+}
+
+func waitForHangUp(t *testing.T, done chan struct{}) {
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("The proxy did not hang up")
+	}
+}
+
+func TestHandleUnknownHost(t *testing.T) {
+	mock, teardown := setupProxyTest(t)
+	defer teardown()
+
+	// not one of our hostnames at all, so the database isn't asked
+	client, done := handleInBackground()
+	defer client.Close()
+
+	_, _ = client.Write(handshake("example.com", 2))
+	if message := readMessage(t, client); message != messageUnknown {
+		t.Errorf("Joining an unknown host was met with %q, want %q", message, messageUnknown)
+	}
+	waitForHangUp(t, done)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHandleUnknownSubdomain(t *testing.T) {
+	mock, teardown := setupProxyTest(t)
+	defer teardown()
+
+	mock.ExpectQuery("FROM containers WHERE subdomain = ?").
+		WithArgs("nowhere").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	client, done := handleInBackground()
+	defer client.Close()
+
+	// in the server list, the message stands in for the MOTD
+	_, _ = client.Write(handshake("nowhere.play.smaug-hosting.co.uk", 1))
+	_, _ = client.Write(packet(0x00, nil))
+	if message := readMessage(t, client); message != messageUnknown {
+		t.Errorf("Listing an unknown subdomain showed %q, want %q", message, messageUnknown)
+	}
+
+	_, _ = client.Write(packet(0x01, make([]byte, 8)))
+	_, _ = ioutil.ReadAll(client)
+	waitForHangUp(t, done)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHandleTurnsAway(t *testing.T) {
+	cases := []struct {
+		state         containers.State
+		wakeOnConnect bool
+		message       string
+	}{
+		{containers.StateStopped, false, messageAsleep},
+		{containers.StateStarting, false, messageStarting},
+		{containers.StateProvisioning, true, messageStarting},
+		{containers.StateFailed, false, messageUnavailable},
+	}
+
+	for _, c := range cases {
+		mock, teardown := setupProxyTest(t)
+
+		// hostnames are matched whatever their case, and with the trailing dot some clients send
+		expectContainer(mock, "survival", c.state, c.wakeOnConnect)
+
+		client, done := handleInBackground()
+		// in one go, as writes to a pipe block until read and the proxy may answer without reading the login
+		_, _ = client.Write(append(handshake("Survival.Play.Smaug-Hosting.co.uk.", 2), loginStart("Notch")...))
+		if message := readMessage(t, client); message != c.message {
+			t.Errorf("Joining a %s container was met with %q, want %q", c.state, message, c.message)
+		}
+		waitForHangUp(t, done)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+
+		_ = client.Close()
+		teardown()
+	}
+}
+
+func TestHandleSplices(t *testing.T) {
+	mock, teardown := setupProxyTest(t)
+	defer teardown()
+
+	// the game server, published on a port the proxy finds in the container's binding
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %s", err)
+	}
+	defer backend.Close()
+
+	port := backend.Addr().(*net.TCPAddr).Port
+	err = cache.Client.Set(fmt.Sprintf("ports.container.%d", 42), port, 0).Err()
+	if err != nil {
+		t.Fatalf("Could not bind port: %s", err)
+	}
+
+	expectContainer(mock, "survival", containers.StateRunning, false)
+
+	client, done := handleInBackground()
+	defer client.Close()
+
+	// sent in one go, so the login is already buffered by the time the proxy dials the game server
+	sent := append(handshake("survival.play.smaug-hosting.co.uk", 2), loginStart("Notch")...)
+	go func() {
+		_, _ = client.Write(sent)
+	}()
+
+	server, err := backend.Accept()
+	if err != nil {
+		t.Fatalf("Could not accept: %s", err)
+	}
+	defer server.Close()
+	_ = server.SetDeadline(time.Now().Add(time.Second))
+
+	received := make([]byte, len(sent))
+	_, err = io.ReadFull(server, received)
+	if err != nil {
+		t.Fatalf("Game server could not read what the client sent: %s", err)
+	}
+	if !bytes.Equal(received, sent) {
+		t.Errorf("Game server got %x, want %x", received, sent)
+	}
+
+	// and the other way
+	_, _ = server.Write([]byte("welcome"))
+	_ = client.SetReadDeadline(time.Now().Add(time.Second))
+	reply := make([]byte, len("welcome"))
+	_, err = io.ReadFull(client, reply)
+	if err != nil || string(reply) != "welcome" {
+		t.Errorf("Client got %q (%v), want %q", reply, err, "welcome")
+	}
+
+	// the client hanging up ends it
+	_ = client.Close()
+	waitForHangUp(t, done)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHandleWakes(t *testing.T) {
+	cases := []struct {
+		status  int
+		message string
+	}{
+		{http.StatusOK, messageWaking},
+		// already started by another player
+		{http.StatusConflict, messageStarting},
+		// the owner can't afford it, or is over their quota
+		{http.StatusPaymentRequired, messageAsleep},
+		{http.StatusInternalServerError, messageAsleep},
+	}
+
+	_ = os.Setenv("SERVICE_KEY", "secret")
+	defer os.Unsetenv("SERVICE_KEY")
+	defer os.Unsetenv("CONTAINER_SERVICE_URL")
+
+	for _, c := range cases {
+		mock, teardown := setupProxyTest(t)
+
+		woken := ""
+		containerService := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+			woken = request.URL.Path
+			libhttp.SendError(c.status, "refused", response)
+		}))
+		_ = os.Setenv("CONTAINER_SERVICE_URL", containerService.URL)
+
+		expectContainer(mock, "survival", containers.StateStopped, true)
+
+		client, done := handleInBackground()
+		_, _ = client.Write(append(handshake("survival.play.smaug-hosting.co.uk", 2), loginStart("Notch")...))
+		if message := readMessage(t, client); message != c.message {
+			t.Errorf("Container service answering %d was met with %q, want %q", c.status, message, c.message)
+		}
+		waitForHangUp(t, done)
+
+		if woken != "/containers/42/wake/" {
+			t.Errorf("Asked the container service to wake %q", woken)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+
+		_ = client.Close()
+		containerService.Close()
+		teardown()
+	}
+}
+
+func TestHandleWakeWithoutContainerService(t *testing.T) {
+	mock, teardown := setupProxyTest(t)
+	defer teardown()
+
+	// a mistyped URL fails the wake rather than the proxy
+	_ = os.Setenv("CONTAINER_SERVICE_URL", "http://[::1")
+	defer os.Unsetenv("CONTAINER_SERVICE_URL")
+
+	expectContainer(mock, "survival", containers.StateStopped, true)
+
+	client, done := handleInBackground()
+	defer client.Close()
+
+	_, _ = client.Write(append(handshake("survival.play.smaug-hosting.co.uk", 2), loginStart("Notch")...))
+	if message := readMessage(t, client); message != messageUnavailable {
+		t.Errorf("Waking without a container service was met with %q, want %q", message, messageUnavailable)
+	}
+	waitForHangUp(t, done)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"bitbucket.org/smaug-hosting/services/container-service/containers"
+	"bitbucket.org/smaug-hosting/services/libhttp"
+	"bitbucket.org/smaug-hosting/services/libhttp/middleware"
+	µ "bitbucket.org/smaug-hosting/services/micro"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+type containerService struct {
+	baseUrl url.URL
+}
+
+const ContainerServiceUrl = "http://localhost:35000"
+
+// the proxy waits on wake requests with a player connected, so they mustn't hang around when the container service
+// is struggling
+var containerServiceClient = &http.Client{Timeout: 10 * time.Second}
+
+// ContainerServiceError is a request the container service refused, with the reason it gave.
+type ContainerServiceError struct {
+	Status  int
+	Message string
+}
+
+func (e ContainerServiceError) Error() string {
+	return fmt.Sprintf("container service refused with %d: %s", e.Status, e.Message)
+}
+
+// ContainerService is a client for the container service at CONTAINER_SERVICE_URL, failing if that isn't a valid URL.
+func ContainerService() (containerService, error) {
+	baseUrl, err := url.Parse(µ.GetEnvDefault("CONTAINER_SERVICE_URL", ContainerServiceUrl))
+	if err != nil {
+		return containerService{}, fmt.Errorf("could not parse container service URL: %s", err)
+	}
+
+	return containerService{
+		baseUrl: *baseUrl,
+	}, nil
+}
+
+// WakeContainer asks the container service to start a container that the player is trying to join.  It is
+// authenticated with the SERVICE_KEY shared between our services.
+func (cs containerService) WakeContainer(containerId int64, player string, address string) error {
+	wakeUrl, err := cs.baseUrl.Parse(fmt.Sprintf("/containers/%d/wake/", containerId))
+	if err != nil {
+		return err
+	}
+
+	bodyBytes, err := json.Marshal(containers.WakeContainerRequest{
+		Player:  player,
+		Address: address,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", wakeUrl.String(), bytes.NewReader(bodyBytes))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add(middleware.ServiceKeyHeader, µ.MustGetEnv("SERVICE_KEY"))
+
+	response, err := containerServiceClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	responseBytes, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+
+	message := libhttp.StatusMessage{}
+	_ = json.Unmarshal(responseBytes, &message)
+
+	return ContainerServiceError{
+		Status:  response.StatusCode,
+		Message: message.Message,
+	}
+}